@@ -0,0 +1,400 @@
+/*
+Package admin provides an http.Handler exposing the live state of the oxy middlewares as JSON,
+with endpoints to drain a load balancer server and to force-trip or reset a circuit breaker.
+
+The handler gives control over the traffic of the proxy, it must be served on a private listener
+or behind an authenticating middleware, never next to the proxied routes.
+
+The POST endpoints require the Content-Type: application/json header, the body is ignored.
+Browsers can not send such a request to another origin without a CORS preflight, which the
+handler does not answer, so a web page visited by an operator can not trip a breaker or drain
+a server, e.g.:
+
+	curl -X POST -H 'Content-Type: application/json' http://127.0.0.1:9090/admin/breakers/api/trip
+
+Endpoints:
+
+	GET  /                              state of every registered component
+	GET  /balancers[/{name}]            servers with their weights, ratings and drain state
+	POST /balancers/{name}/drain?server=URL
+	POST /balancers/{name}/undrain?server=URL
+	GET  /breakers[/{name}]             circuit breaker states
+	POST /breakers/{name}/trip
+	POST /breakers/{name}/reset
+	GET  /ratelimits[/{name}]           token bucket occupancy per source
+	GET  /connlimits[/{name}]           connections per source
+	GET  /websockets[/{name}]           websocket sessions in flight
+
+Examples of an admin handler:
+
+	h, _ := admin.New(
+		admin.RoundRobin("api", lb),
+		admin.CircuitBreaker("api", cb),
+		admin.Forwarder("api", fwd))
+
+	mux := http.NewServeMux()
+	mux.Handle("/admin/", http.StripPrefix("/admin", h))
+	http.ListenAndServe("127.0.0.1:9090", mux)
+*/
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/vulcand/oxy/cbreaker"
+	"github.com/vulcand/oxy/connlimit"
+	"github.com/vulcand/oxy/forward"
+	"github.com/vulcand/oxy/logging"
+	"github.com/vulcand/oxy/ratelimit"
+	"github.com/vulcand/oxy/roundrobin"
+	"github.com/vulcand/oxy/utils"
+)
+
+// Option is a functional option setter for Handler
+type Option func(*Handler) error
+
+// RoundRobin registers a round-robin load balancer under the name
+func RoundRobin(name string, lb *roundrobin.RoundRobin) Option {
+	return func(h *Handler) error {
+		if lb == nil {
+			return fmt.Errorf("round robin %q can not be nil", name)
+		}
+		return h.addBalancer(name, roundRobinBalancer{lb})
+	}
+}
+
+// Rebalancer registers a rebalancer under the name, its servers are reported with their ratings
+func Rebalancer(name string, rb *roundrobin.Rebalancer) Option {
+	return func(h *Handler) error {
+		if rb == nil {
+			return fmt.Errorf("rebalancer %q can not be nil", name)
+		}
+		return h.addBalancer(name, rebalancerBalancer{rb})
+	}
+}
+
+// CircuitBreaker registers a circuit breaker under the name
+func CircuitBreaker(name string, cb *cbreaker.CircuitBreaker) Option {
+	return func(h *Handler) error {
+		if cb == nil {
+			return fmt.Errorf("circuit breaker %q can not be nil", name)
+		}
+		if err := h.checkName("breaker", name, h.breakers[name] != nil); err != nil {
+			return err
+		}
+		h.breakers[name] = cb
+		return nil
+	}
+}
+
+// RateLimiter registers a token limiter under the name
+func RateLimiter(name string, tl *ratelimit.TokenLimiter) Option {
+	return func(h *Handler) error {
+		if tl == nil {
+			return fmt.Errorf("rate limiter %q can not be nil", name)
+		}
+		if err := h.checkName("rate limiter", name, h.rateLimiters[name] != nil); err != nil {
+			return err
+		}
+		h.rateLimiters[name] = tl
+		return nil
+	}
+}
+
+// ConnLimiter registers a connection limiter under the name
+func ConnLimiter(name string, cl *connlimit.ConnLimiter) Option {
+	return func(h *Handler) error {
+		if cl == nil {
+			return fmt.Errorf("connection limiter %q can not be nil", name)
+		}
+		if err := h.checkName("connection limiter", name, h.connLimiters[name] != nil); err != nil {
+			return err
+		}
+		h.connLimiters[name] = cl
+		return nil
+	}
+}
+
+// Forwarder registers a forwarder under the name, its websocket sessions are reported
+func Forwarder(name string, f *forward.Forwarder) Option {
+	return func(h *Handler) error {
+		if f == nil {
+			return fmt.Errorf("forwarder %q can not be nil", name)
+		}
+		if err := h.checkName("forwarder", name, h.forwarders[name] != nil); err != nil {
+			return err
+		}
+		h.forwarders[name] = f
+		return nil
+	}
+}
+
+// ReadOnly disables the mutating endpoints, they answer 403
+func ReadOnly() Option {
+	return func(h *Handler) error {
+		h.readOnly = true
+		return nil
+	}
+}
+
+// Logger defines the logger the admin handler will use, the mutations are logged at the info level.
+//
+// It defaults to logging.Default().
+func Logger(l logging.Logger) Option {
+	return func(h *Handler) error {
+		h.log = l
+		return nil
+	}
+}
+
+// Handler serves the state of the registered components
+type Handler struct {
+	balancers    map[string]balancer
+	breakers     map[string]*cbreaker.CircuitBreaker
+	rateLimiters map[string]*ratelimit.TokenLimiter
+	connLimiters map[string]*connlimit.ConnLimiter
+	forwarders   map[string]*forward.Forwarder
+
+	readOnly bool
+	log      logging.Logger
+}
+
+// New creates a new admin handler
+func New(opts ...Option) (*Handler, error) {
+	h := &Handler{
+		balancers:    make(map[string]balancer),
+		breakers:     make(map[string]*cbreaker.CircuitBreaker),
+		rateLimiters: make(map[string]*ratelimit.TokenLimiter),
+		connLimiters: make(map[string]*connlimit.ConnLimiter),
+		forwarders:   make(map[string]*forward.Forwarder),
+		log:          logging.Default(),
+	}
+	for _, o := range opts {
+		if err := o(h); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+func (h *Handler) addBalancer(name string, b balancer) error {
+	if err := h.checkName("balancer", name, h.balancers[name] != nil); err != nil {
+		return err
+	}
+	h.balancers[name] = b
+	return nil
+}
+
+func (h *Handler) checkName(kind, name string, taken bool) error {
+	if name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("invalid %s name %q", kind, name)
+	}
+	if taken {
+		return fmt.Errorf("duplicate %s name %q", kind, name)
+	}
+	return nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(req.URL.Path, "/")
+	if path == "" {
+		if h.allowMethod(w, req, http.MethodGet) {
+			h.writeJSON(w, http.StatusOK, h.state())
+		}
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	switch parts[0] {
+	case "balancers":
+		h.serveBalancers(w, req, parts[1:])
+	case "breakers":
+		h.serveBreakers(w, req, parts[1:])
+	case "ratelimits":
+		h.serveCollection(w, req, parts[1:], func() interface{} { return h.rateLimitViews() }, func(name string) (interface{}, bool) {
+			tl, ok := h.rateLimiters[name]
+			if !ok {
+				return nil, false
+			}
+			return newSourceViews(tl.Sources()), true
+		})
+	case "connlimits":
+		h.serveCollection(w, req, parts[1:], func() interface{} { return h.connLimitViews() }, func(name string) (interface{}, bool) {
+			cl, ok := h.connLimiters[name]
+			if !ok {
+				return nil, false
+			}
+			return newConnLimitView(cl.Status()), true
+		})
+	case "websockets":
+		h.serveCollection(w, req, parts[1:], func() interface{} { return h.websocketViews() }, func(name string) (interface{}, bool) {
+			f, ok := h.forwarders[name]
+			if !ok {
+				return nil, false
+			}
+			return newSessionViews(f.WebsocketSessions()), true
+		})
+	default:
+		h.writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// serveCollection serves the read-only collections: all the components or the one named
+func (h *Handler) serveCollection(w http.ResponseWriter, req *http.Request, parts []string, all func() interface{}, one func(string) (interface{}, bool)) {
+	if len(parts) > 1 {
+		h.writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !h.allowMethod(w, req, http.MethodGet) {
+		return
+	}
+	if len(parts) == 0 {
+		h.writeJSON(w, http.StatusOK, all())
+		return
+	}
+	v, ok := one(parts[0])
+	if !ok {
+		h.writeError(w, http.StatusNotFound, fmt.Sprintf("unknown name %q", parts[0]))
+		return
+	}
+	h.writeJSON(w, http.StatusOK, v)
+}
+
+func (h *Handler) serveBalancers(w http.ResponseWriter, req *http.Request, parts []string) {
+	if len(parts) < 2 {
+		h.serveCollection(w, req, parts, func() interface{} { return h.balancerViews() }, func(name string) (interface{}, bool) {
+			b, ok := h.balancers[name]
+			if !ok {
+				return nil, false
+			}
+			return b.view(), true
+		})
+		return
+	}
+	if len(parts) > 2 || (parts[1] != "drain" && parts[1] != "undrain") {
+		h.writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !h.allowMutation(w, req) {
+		return
+	}
+	b, ok := h.balancers[parts[0]]
+	if !ok {
+		h.writeError(w, http.StatusNotFound, fmt.Sprintf("unknown balancer %q", parts[0]))
+		return
+	}
+	u, err := parseServer(req.URL.Query().Get("server"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	msg := "vulcand/oxy/admin: server drained"
+	if parts[1] == "drain" {
+		err = b.DrainServer(u)
+	} else {
+		msg = "vulcand/oxy/admin: server undrained"
+		err = b.UndrainServer(u)
+	}
+	if err != nil {
+		h.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	logging.Info(utils.RequestLogger(h.log, req), msg, logging.String("balancer", parts[0]), logging.String("server", u.String()))
+	h.writeJSON(w, http.StatusOK, b.view())
+}
+
+func (h *Handler) serveBreakers(w http.ResponseWriter, req *http.Request, parts []string) {
+	if len(parts) < 2 {
+		h.serveCollection(w, req, parts, func() interface{} { return h.breakerViews() }, func(name string) (interface{}, bool) {
+			cb, ok := h.breakers[name]
+			if !ok {
+				return nil, false
+			}
+			return newBreakerView(cb.Status()), true
+		})
+		return
+	}
+	if len(parts) > 2 || (parts[1] != "trip" && parts[1] != "reset") {
+		h.writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !h.allowMutation(w, req) {
+		return
+	}
+	cb, ok := h.breakers[parts[0]]
+	if !ok {
+		h.writeError(w, http.StatusNotFound, fmt.Sprintf("unknown breaker %q", parts[0]))
+		return
+	}
+
+	msg := "vulcand/oxy/admin: circuit breaker tripped"
+	if parts[1] == "trip" {
+		cb.Trip()
+	} else {
+		msg = "vulcand/oxy/admin: circuit breaker reset"
+		cb.Reset()
+	}
+	logging.Info(utils.RequestLogger(h.log, req), msg, logging.String("breaker", parts[0]))
+	h.writeJSON(w, http.StatusOK, newBreakerView(cb.Status()))
+}
+
+func (h *Handler) allowMethod(w http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method == method || (method == http.MethodGet && req.Method == http.MethodHead) {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	h.writeError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+	return false
+}
+
+func (h *Handler) allowMutation(w http.ResponseWriter, req *http.Request) bool {
+	if !h.allowMethod(w, req, http.MethodPost) {
+		return false
+	}
+	// a bare POST is a CORS simple request, any web page could send it cross-origin
+	if mt, _, err := mime.ParseMediaType(req.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+		h.writeError(w, http.StatusUnsupportedMediaType, "Content-Type should be application/json")
+		return false
+	}
+	if h.readOnly {
+		h.writeError(w, http.StatusForbidden, "admin handler is read-only")
+		return false
+	}
+	return true
+}
+
+func (h *Handler) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		logging.Debug(h.log, "vulcand/oxy/admin: failed to write response", logging.Err(err))
+	}
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, code int, msg string) {
+	h.writeJSON(w, code, errorView{Error: msg})
+}
+
+// parseServer parses the server URL of a drain request, it must be absolute
+func parseServer(raw string) (*url.URL, error) {
+	if raw == "" {
+		return nil, fmt.Errorf("missing server parameter")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server %q: %v", raw, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid server %q: an absolute URL is expected", raw)
+	}
+	return u, nil
+}
@@ -0,0 +1,255 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/cbreaker"
+	"github.com/vulcand/oxy/connlimit"
+	"github.com/vulcand/oxy/forward"
+	"github.com/vulcand/oxy/ratelimit"
+	"github.com/vulcand/oxy/roundrobin"
+	"github.com/vulcand/oxy/testutils"
+	"github.com/vulcand/oxy/utils"
+)
+
+var hello = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	w.Write([]byte("hello"))
+})
+
+func TestNew(t *testing.T) {
+	lb, err := roundrobin.New(hello)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		desc string
+		opts []Option
+	}{
+		{desc: "nil component", opts: []Option{CircuitBreaker("api", nil)}},
+		{desc: "empty name", opts: []Option{RoundRobin("", lb)}},
+		{desc: "name with a slash", opts: []Option{RoundRobin("a/b", lb)}},
+		{desc: "duplicate name", opts: []Option{RoundRobin("api", lb), RoundRobin("api", lb)}},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := New(test.opts...)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestBalancers(t *testing.T) {
+	a, b := testutils.NewResponder("a"), testutils.NewResponder("b")
+	defer a.Close()
+	defer b.Close()
+
+	fwd, err := forward.New()
+	require.NoError(t, err)
+
+	lb, err := roundrobin.New(fwd)
+	require.NoError(t, err)
+	require.NoError(t, lb.UpsertServer(testutils.ParseURI(a.URL)))
+	require.NoError(t, lb.UpsertServer(testutils.ParseURI(b.URL), roundrobin.Weight(2)))
+
+	rb, err := roundrobin.NewRebalancer(lb)
+	require.NoError(t, err)
+	require.NoError(t, rb.UpsertServer(testutils.ParseURI(a.URL)))
+
+	h, err := New(RoundRobin("rr", lb), Rebalancer("rb", rb))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	var balancers map[string]balancerView
+	getJSON(t, srv.URL+"/balancers", http.StatusOK, &balancers)
+	require.Len(t, balancers, 2)
+	assert.Equal(t, "roundrobin", balancers["rr"].Type)
+	assert.Equal(t, "rebalancer", balancers["rb"].Type)
+	require.Len(t, balancers["rb"].Servers, 1)
+	assert.NotNil(t, balancers["rb"].Servers[0].Rating)
+
+	var rr balancerView
+	getJSON(t, srv.URL+"/balancers/rr", http.StatusOK, &rr)
+	assert.Equal(t, balancerView{Type: "roundrobin", Servers: []serverView{
+		{URL: a.URL, OriginalWeight: 1, CurrentWeight: 1},
+		{URL: b.URL, OriginalWeight: 2, CurrentWeight: 2},
+	}}, rr)
+
+	postJSON(t, srv.URL+"/balancers/rr/drain?server="+url.QueryEscape(b.URL), http.StatusOK, &rr)
+	assert.True(t, rr.Servers[1].Drained)
+	assert.True(t, lb.ServerStatuses()[1].Drained)
+
+	postJSON(t, srv.URL+"/balancers/rr/undrain?server="+url.QueryEscape(b.URL), http.StatusOK, &rr)
+	assert.False(t, rr.Servers[1].Drained)
+	assert.False(t, lb.ServerStatuses()[1].Drained)
+
+	var e errorView
+	postJSON(t, srv.URL+"/balancers/rr/drain", http.StatusBadRequest, &e)
+	assert.Equal(t, "missing server parameter", e.Error)
+	postJSON(t, srv.URL+"/balancers/rr/drain?server=localhost", http.StatusBadRequest, &e)
+	postJSON(t, srv.URL+"/balancers/rr/drain?server="+url.QueryEscape("http://localhost:1"), http.StatusNotFound, &e)
+	postJSON(t, srv.URL+"/balancers/unknown/drain?server="+url.QueryEscape(b.URL), http.StatusNotFound, &e)
+	getJSON(t, srv.URL+"/balancers/unknown", http.StatusNotFound, &e)
+
+	re, _, err := testutils.Get(srv.URL + "/balancers/rr/drain?server=" + url.QueryEscape(b.URL))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, re.StatusCode)
+	assert.Equal(t, http.MethodPost, re.Header.Get("Allow"))
+}
+
+func TestBreakers(t *testing.T) {
+	clock := testutils.GetClock()
+
+	cb, err := cbreaker.New(hello, "NetworkErrorRatio() > 0.5", cbreaker.Clock(clock), cbreaker.FallbackDuration(time.Minute), cbreaker.Name("backend"))
+	require.NoError(t, err)
+
+	h, err := New(CircuitBreaker("api", cb))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	var breakers map[string]breakerView
+	getJSON(t, srv.URL+"/breakers", http.StatusOK, &breakers)
+	assert.Equal(t, map[string]breakerView{"api": {Name: "backend", State: "standby"}}, breakers)
+
+	var breaker breakerView
+	postJSON(t, srv.URL+"/breakers/api/trip", http.StatusOK, &breaker)
+	assert.Equal(t, "tripped", breaker.State)
+	require.NotNil(t, breaker.Until)
+	assert.True(t, clock.UtcNow().Add(time.Minute).Equal(*breaker.Until))
+
+	getJSON(t, srv.URL+"/breakers/api", http.StatusOK, &breaker)
+	assert.Equal(t, "tripped", breaker.State)
+
+	var reset breakerView
+	postJSON(t, srv.URL+"/breakers/api/reset", http.StatusOK, &reset)
+	assert.Equal(t, breakerView{Name: "backend", State: "standby"}, reset)
+	assert.Equal(t, "standby", cb.Status().State)
+
+	var e errorView
+	postJSON(t, srv.URL+"/breakers/unknown/trip", http.StatusNotFound, &e)
+	postJSON(t, srv.URL+"/breakers/api/open", http.StatusNotFound, &e)
+
+	// the simple requests a web page can send cross-origin are refused
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+		re, _, err := testutils.Post(srv.URL+"/breakers/api/trip", testutils.Header("Content-Type", contentType))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnsupportedMediaType, re.StatusCode, contentType)
+	}
+	assert.Equal(t, "standby", cb.Status().State)
+
+	postJSON(t, srv.URL+"/breakers/api/trip", http.StatusOK, &breaker)
+	assert.Equal(t, "tripped", breaker.State)
+}
+
+func TestReadOnly(t *testing.T) {
+	cb, err := cbreaker.New(hello, "NetworkErrorRatio() > 0.5", cbreaker.Name("api"))
+	require.NoError(t, err)
+
+	h, err := New(CircuitBreaker("api", cb), ReadOnly())
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	var e errorView
+	postJSON(t, srv.URL+"/breakers/api/trip", http.StatusForbidden, &e)
+	assert.Equal(t, "standby", cb.Status().State)
+
+	var breaker breakerView
+	getJSON(t, srv.URL+"/breakers/api", http.StatusOK, &breaker)
+	assert.Equal(t, "standby", breaker.State)
+}
+
+func TestLimiters(t *testing.T) {
+	rates := ratelimit.NewRateSet()
+	require.NoError(t, rates.Add(time.Second, 1, 2))
+
+	tl, err := ratelimit.New(hello, utils.ExtractorFunc(sourceHeader), rates, ratelimit.Clock(testutils.GetClock()))
+	require.NoError(t, err)
+
+	cl, err := connlimit.New(hello, utils.ExtractorFunc(sourceHeader), 10)
+	require.NoError(t, err)
+
+	h, err := New(RateLimiter("api", tl), ConnLimiter("api", cl))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(tl)
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL, testutils.Header("Source", "a"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	var sources []sourceView
+	getJSON(t, srv.URL+"/ratelimits/api", http.StatusOK, &sources)
+	assert.Equal(t, []sourceView{{Source: "a", Buckets: []bucketView{{Period: "1s", Average: 1, Burst: 2, Available: 1}}}}, sources)
+
+	var conns connLimitView
+	getJSON(t, srv.URL+"/connlimits/api", http.StatusOK, &conns)
+	assert.Equal(t, connLimitView{MaxConnections: 10, Connections: map[string]int64{}}, conns)
+}
+
+func TestState(t *testing.T) {
+	fwd, err := forward.New()
+	require.NoError(t, err)
+
+	cb, err := cbreaker.New(hello, "NetworkErrorRatio() > 0.5", cbreaker.Name("api"))
+	require.NoError(t, err)
+
+	h, err := New(Forwarder("api", fwd), CircuitBreaker("api", cb))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	var state stateView
+	getJSON(t, srv.URL, http.StatusOK, &state)
+	assert.Equal(t, map[string]breakerView{"api": {Name: "api", State: "standby"}}, state.Breakers)
+	assert.Equal(t, map[string][]sessionView{"api": {}}, state.Websockets)
+	assert.Empty(t, state.Balancers)
+
+	var sessions []sessionView
+	getJSON(t, srv.URL+"/websockets/api", http.StatusOK, &sessions)
+	assert.Empty(t, sessions)
+
+	var e errorView
+	getJSON(t, srv.URL+"/unknown", http.StatusNotFound, &e)
+	postJSON(t, srv.URL+"/", http.StatusMethodNotAllowed, &e)
+}
+
+func getJSON(t *testing.T, u string, code int, v interface{}) {
+	t.Helper()
+
+	re, body, err := testutils.Get(u)
+	require.NoError(t, err)
+	require.Equal(t, code, re.StatusCode, string(body))
+	assert.Equal(t, "application/json", re.Header.Get("Content-Type"))
+	require.NoError(t, json.Unmarshal(body, v))
+}
+
+func postJSON(t *testing.T, u string, code int, v interface{}) {
+	t.Helper()
+
+	re, body, err := testutils.Post(u, testutils.Header("Content-Type", "application/json"))
+	require.NoError(t, err)
+	require.Equal(t, code, re.StatusCode, string(body))
+	require.NoError(t, json.Unmarshal(body, v))
+}
+
+func sourceHeader(req *http.Request) (string, int64, error) {
+	return req.Header.Get("Source"), 1, nil
+}
@@ -0,0 +1,215 @@
+package admin
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/vulcand/oxy/cbreaker"
+	"github.com/vulcand/oxy/connlimit"
+	"github.com/vulcand/oxy/forward"
+	"github.com/vulcand/oxy/ratelimit"
+	"github.com/vulcand/oxy/roundrobin"
+)
+
+// balancer is implemented by the load balancers registered in the handler
+type balancer interface {
+	view() balancerView
+	DrainServer(u *url.URL) error
+	UndrainServer(u *url.URL) error
+}
+
+type roundRobinBalancer struct {
+	*roundrobin.RoundRobin
+}
+
+func (b roundRobinBalancer) view() balancerView {
+	statuses := b.ServerStatuses()
+	v := balancerView{Type: "roundrobin", Servers: make([]serverView, len(statuses))}
+	for i, s := range statuses {
+		v.Servers[i] = serverView{
+			URL:            s.URL.String(),
+			OriginalWeight: s.Weight,
+			CurrentWeight:  s.Weight,
+			Drained:        s.Drained,
+		}
+	}
+	return v
+}
+
+type rebalancerBalancer struct {
+	*roundrobin.Rebalancer
+}
+
+func (b rebalancerBalancer) view() balancerView {
+	statuses := b.ServerStatuses()
+	v := balancerView{Type: "rebalancer", Servers: make([]serverView, len(statuses))}
+	for i, s := range statuses {
+		rating, ready, good := s.Rating, s.Ready, s.Good
+		v.Servers[i] = serverView{
+			URL:            s.URL.String(),
+			OriginalWeight: s.OriginalWeight,
+			CurrentWeight:  s.CurrentWeight,
+			Drained:        s.Drained,
+			Rating:         &rating,
+			Ready:          &ready,
+			Good:           &good,
+		}
+	}
+	return v
+}
+
+type stateView struct {
+	Balancers  map[string]balancerView  `json:"balancers"`
+	Breakers   map[string]breakerView   `json:"breakers"`
+	RateLimits map[string][]sourceView  `json:"ratelimits"`
+	ConnLimits map[string]connLimitView `json:"connlimits"`
+	Websockets map[string][]sessionView `json:"websockets"`
+}
+
+type balancerView struct {
+	Type    string       `json:"type"`
+	Servers []serverView `json:"servers"`
+}
+
+// serverView reports the rating fields for the rebalancers only
+type serverView struct {
+	URL            string   `json:"url"`
+	OriginalWeight int      `json:"original_weight"`
+	CurrentWeight  int      `json:"current_weight"`
+	Drained        bool     `json:"drained"`
+	Rating         *float64 `json:"rating,omitempty"`
+	Ready          *bool    `json:"ready,omitempty"`
+	Good           *bool    `json:"good,omitempty"`
+}
+
+type breakerView struct {
+	Name  string     `json:"name,omitempty"`
+	State string     `json:"state"`
+	Until *time.Time `json:"until,omitempty"`
+}
+
+func newBreakerView(s cbreaker.Status) breakerView {
+	v := breakerView{Name: s.Name, State: s.State}
+	if !s.Until.IsZero() {
+		until := s.Until
+		v.Until = &until
+	}
+	return v
+}
+
+type sourceView struct {
+	Source  string       `json:"source"`
+	Buckets []bucketView `json:"buckets"`
+}
+
+type bucketView struct {
+	Period    string `json:"period"`
+	Average   int64  `json:"average"`
+	Burst     int64  `json:"burst"`
+	Available int64  `json:"available"`
+}
+
+func newSourceViews(sources []ratelimit.SourceStatus) []sourceView {
+	out := make([]sourceView, len(sources))
+	for i, s := range sources {
+		buckets := make([]bucketView, len(s.Buckets))
+		for j, b := range s.Buckets {
+			buckets[j] = bucketView{Period: b.Period.String(), Average: b.Average, Burst: b.Burst, Available: b.Available}
+		}
+		out[i] = sourceView{Source: s.Source, Buckets: buckets}
+	}
+	return out
+}
+
+type connLimitView struct {
+	MaxConnections   int64            `json:"max_connections"`
+	TotalConnections int64            `json:"total_connections"`
+	Connections      map[string]int64 `json:"connections"`
+}
+
+func newConnLimitView(s connlimit.Status) connLimitView {
+	return connLimitView{
+		MaxConnections:   s.MaxConnections,
+		TotalConnections: s.TotalConnections,
+		Connections:      s.Connections,
+	}
+}
+
+type sessionView struct {
+	Backend    string    `json:"backend"`
+	Path       string    `json:"path"`
+	RemoteAddr string    `json:"remote_addr"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Start      time.Time `json:"start"`
+	Duration   string    `json:"duration"`
+}
+
+func newSessionViews(sessions []forward.WebsocketSession) []sessionView {
+	now := time.Now().UTC()
+	out := make([]sessionView, len(sessions))
+	for i, s := range sessions {
+		out[i] = sessionView{
+			Backend:    s.Backend,
+			Path:       s.Path,
+			RemoteAddr: s.RemoteAddr,
+			RequestID:  s.RequestID,
+			Start:      s.Start,
+			Duration:   now.Sub(s.Start).Round(time.Millisecond).String(),
+		}
+	}
+	return out
+}
+
+type errorView struct {
+	Error string `json:"error"`
+}
+
+func (h *Handler) state() stateView {
+	return stateView{
+		Balancers:  h.balancerViews(),
+		Breakers:   h.breakerViews(),
+		RateLimits: h.rateLimitViews(),
+		ConnLimits: h.connLimitViews(),
+		Websockets: h.websocketViews(),
+	}
+}
+
+func (h *Handler) balancerViews() map[string]balancerView {
+	out := make(map[string]balancerView, len(h.balancers))
+	for name, b := range h.balancers {
+		out[name] = b.view()
+	}
+	return out
+}
+
+func (h *Handler) breakerViews() map[string]breakerView {
+	out := make(map[string]breakerView, len(h.breakers))
+	for name, cb := range h.breakers {
+		out[name] = newBreakerView(cb.Status())
+	}
+	return out
+}
+
+func (h *Handler) rateLimitViews() map[string][]sourceView {
+	out := make(map[string][]sourceView, len(h.rateLimiters))
+	for name, tl := range h.rateLimiters {
+		out[name] = newSourceViews(tl.Sources())
+	}
+	return out
+}
+
+func (h *Handler) connLimitViews() map[string]connLimitView {
+	out := make(map[string]connLimitView, len(h.connLimiters))
+	for name, cl := range h.connLimiters {
+		out[name] = newConnLimitView(cl.Status())
+	}
+	return out
+}
+
+func (h *Handler) websocketViews() map[string][]sessionView {
+	out := make(map[string][]sessionView, len(h.forwarders))
+	for name, f := range h.forwarders {
+		out[name] = newSessionViews(f.WebsocketSessions())
+	}
+	return out
+}
@@ -0,0 +1,146 @@
+package buffer
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vulcand/oxy/logging"
+	"github.com/vulcand/oxy/utils"
+	"github.com/vulcand/predicate"
+)
+
+// DefaultMaxRetryAfter caps the delay requested by the Retry-After header of a response before retrying
+const DefaultMaxRetryAfter = 10 * time.Second
+
+// backoff returns the delay before the retry, retry is 1 before the first retry
+type backoff func(retry int) time.Duration
+
+// Parses the backoff expression, e.g. Jitter(Exponential(100, 5000), 0.5)
+func parseBackoff(in string) (backoff, error) {
+	p, err := predicate.NewParser(predicate.Def{
+		Functions: map[string]interface{}{
+			"Fixed":       fixedBackoff,
+			"Exponential": exponentialBackoff,
+			"Jitter":      jitterBackoff,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	out, err := p.Parse(in)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := out.(backoff)
+	if !ok {
+		return nil, fmt.Errorf("expected backoff, got %T", out)
+	}
+	return b, nil
+}
+
+// Fixed waits the same delay in milliseconds between the attempts
+func fixedBackoff(delayMS int) (backoff, error) {
+	if delayMS < 0 {
+		return nil, fmt.Errorf("delay should be >= 0 got %d", delayMS)
+	}
+	delay := time.Duration(delayMS) * time.Millisecond
+	return func(int) time.Duration {
+		return delay
+	}, nil
+}
+
+// Exponential doubles the delay in milliseconds between the attempts up to the max delay
+func exponentialBackoff(initialMS, maxMS int) (backoff, error) {
+	if initialMS <= 0 || maxMS < initialMS {
+		return nil, fmt.Errorf("expected 0 < initial delay <= max delay, got %d and %d", initialMS, maxMS)
+	}
+	initial, max := time.Duration(initialMS)*time.Millisecond, time.Duration(maxMS)*time.Millisecond
+	return func(retry int) time.Duration {
+		delay := initial
+		for i := 1; i < retry && delay < max; i++ {
+			delay *= 2
+		}
+		if delay > max {
+			return max
+		}
+		return delay
+	}, nil
+}
+
+// Jitter randomly shortens the delays of the backoff by up to the fraction of the delay, between 0 and 1
+func jitterBackoff(b backoff, fraction interface{}) (backoff, error) {
+	var f float64
+	switch v := fraction.(type) {
+	case int:
+		f = float64(v)
+	case float64:
+		f = v
+	default:
+		return nil, fmt.Errorf("expected number, got %T", fraction)
+	}
+	if f < 0 || f > 1 {
+		return nil, fmt.Errorf("jitter should be between 0 and 1 got %v", f)
+	}
+	return func(retry int) time.Duration {
+		delay := b(retry)
+		return delay - time.Duration(f*rand.Float64()*float64(delay))
+	}, nil
+}
+
+// retryAfter returns the delay requested by the Retry-After header, in seconds or as a date
+func retryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	value := h.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if delay := date.Sub(now); delay > 0 {
+		return delay, true
+	}
+	return 0, true
+}
+
+// retryDelay returns the delay before the retry, the Retry-After header of the response
+// overrides the backoff when longer
+func (b *Buffer) retryDelay(retry int, h http.Header) time.Duration {
+	delay := b.retryBackoff(retry)
+	if after, ok := retryAfter(h, time.Now()); ok && after > delay {
+		delay = after
+		if delay > DefaultMaxRetryAfter {
+			delay = DefaultMaxRetryAfter
+		}
+	}
+	return delay
+}
+
+// waitBeforeRetry waits for the delay before the retry, it returns false if the request is canceled meanwhile
+func (b *Buffer) waitBeforeRetry(req *http.Request, retry int, h http.Header) bool {
+	delay := b.retryDelay(retry, h)
+	if delay <= 0 {
+		return true
+	}
+	if b.log.Enabled(logging.DebugLevel) {
+		logging.Debug(utils.RequestLogger(b.log, req), "vulcand/oxy/buffer: waiting before retrying request",
+			logging.Duration("delay", delay), logging.String("method", req.Method), logging.Any("url", req.URL))
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-req.Context().Done():
+		return false
+	}
+}
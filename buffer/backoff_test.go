@@ -0,0 +1,97 @@
+package buffer
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBackoff(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		expr     string
+		expected []time.Duration
+	}{
+		{
+			desc:     "fixed",
+			expr:     `Fixed(100)`,
+			expected: []time.Duration{100 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond},
+		},
+		{
+			desc:     "exponential",
+			expr:     `Exponential(100, 300)`,
+			expected: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond},
+		},
+		{
+			desc:     "no jitter",
+			expr:     `Jitter(Fixed(100), 0)`,
+			expected: []time.Duration{100 * time.Millisecond, 100 * time.Millisecond},
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			b, err := parseBackoff(test.expr)
+			require.NoError(t, err)
+
+			for i, expected := range test.expected {
+				assert.Equal(t, expected, b(i+1))
+			}
+		})
+	}
+}
+
+func TestParseBackoffJitter(t *testing.T) {
+	b, err := parseBackoff(`Jitter(Exponential(100, 1000), 0.5)`)
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		delay := b(2)
+		assert.True(t, delay > 100*time.Millisecond && delay <= 200*time.Millisecond, delay)
+	}
+}
+
+func TestParseBackoffInvalid(t *testing.T) {
+	for _, expr := range []string{`Fixed(-1)`, `Exponential(100, 10)`, `Jitter(Fixed(100), 2)`, `Linear(100)`, `Fixed("a")`} {
+		_, err := parseBackoff(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		desc     string
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{desc: "missing"},
+		{desc: "seconds", value: "3", expected: 3 * time.Second, ok: true},
+		{desc: "date", value: now.Add(5 * time.Second).Format(http.TimeFormat), expected: 5 * time.Second, ok: true},
+		{desc: "past date", value: now.Add(-5 * time.Second).Format(http.TimeFormat), ok: true},
+		{desc: "invalid", value: "soon"},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			h := http.Header{}
+			if test.value != "" {
+				h.Set("Retry-After", test.value)
+			}
+			delay, ok := retryAfter(h, now)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.expected, delay)
+		})
+	}
+}
@@ -4,6 +4,12 @@ Package buffer provides http.Handler middleware that solves several problems whe
 Reads the entire request and response into buffer, optionally buffering it to disk for large requests.
 Checks the limits for the requests and responses, rejecting in case if the limit was exceeded.
 Changes request content-transfer-encoding from chunked and provides total size to the handlers.
+Optionally decodes the gzip, deflate and br encoded request bodies, enforcing the limits on the decoded size.
+Optionally streams the bodies larger than a threshold instead of buffering them, the requests with a streamed body are not retried.
+Serves the ranges requested with the Range header from the buffered response when the handler ignored them,
+the partial responses of the handler are streamed and the partial transfers are only retried for idempotent methods.
+Spools the bodies that don't fit in memory to temporary files, in a configurable directory and within a disk quota
+shared by all the in-flight requests (see Spooler).
 
 Examples of a buffering middleware:
 
@@ -32,6 +38,18 @@ Examples of a buffering middleware:
   // before returning the response
   buffer.New(handler, buffer.Retry(`IsNetworkError() && Attempts() <= 2`))
 
+  // Buffer will buffer and retry the requests up to 64KB, the larger requests are
+  // streamed to the handler and not retried, the responses larger than 1MB are streamed to the client
+  buffer.New(handler,
+    buffer.Retry(`IsNetworkError() && Attempts() <= 2`),
+    buffer.RequestStreamThreshold(64 * 1024),
+    buffer.ResponseStreamThreshold(1024 * 1024))
+
+  // Buffer will spool the bodies larger than 1MB to /var/spool/oxy, up to 1GB for all the in-flight requests,
+  // the requests exceeding the quota are rejected with 503
+  spooler, _ := buffer.NewSpooler(buffer.SpoolDir("/var/spool/oxy"), buffer.DiskQuota(1024 * 1024 * 1024))
+  buffer.New(handler, buffer.Spool(spooler))
+
 */
 package buffer
 
@@ -43,10 +61,15 @@ import (
 	"net"
 	"net/http"
 	"reflect"
+	"time"
 
 	"github.com/mailgun/multibuf"
-	log "github.com/sirupsen/logrus"
+	"github.com/vulcand/oxy/logging"
+	"github.com/vulcand/oxy/metrics"
+	"github.com/vulcand/oxy/tracing"
 	"github.com/vulcand/oxy/utils"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -66,15 +89,25 @@ type Buffer struct {
 	maxRequestBodyBytes int64
 	memRequestBodyBytes int64
 
+	decodeRequestBody          bool
+	maxDecodedRequestBodyBytes int64
+
 	maxResponseBodyBytes int64
 	memResponseBodyBytes int64
 
+	requestStreamThreshold  int64
+	responseStreamThreshold int64
+
 	retryPredicate hpredicate
+	retryBackoff   backoff
+
+	spooler *Spooler
 
 	next       http.Handler
 	errHandler utils.ErrorHandler
+	observer   metrics.Observer
 
-	log *log.Logger
+	log logging.Logger
 }
 
 // New returns a new buffer middleware. New() function supports optional functional arguments
@@ -88,7 +121,9 @@ func New(next http.Handler, setters ...optSetter) (*Buffer, error) {
 		maxResponseBodyBytes: DefaultMaxBodyBytes,
 		memResponseBodyBytes: DefaultMemBodyBytes,
 
-		log: log.StandardLogger(),
+		spooler: defaultSpooler,
+
+		log: logging.Default(),
 	}
 	for _, s := range setters {
 		if err := s(strm); err != nil {
@@ -104,8 +139,8 @@ func New(next http.Handler, setters ...optSetter) (*Buffer, error) {
 
 // Logger defines the logger the buffer will use.
 //
-// It defaults to logrus.StandardLogger(), the global logger used by logrus.
-func Logger(l *log.Logger) optSetter {
+// It defaults to logging.Default().
+func Logger(l logging.Logger) optSetter {
 	return func(b *Buffer) error {
 		b.log = l
 		return nil
@@ -130,13 +165,24 @@ func CondSetter(condition bool, setter optSetter) optSetter {
 // if it matches certain condition, e.g. returns special error code. Available functions are:
 //
 // Attempts() - limits the amount of retry attempts
+// ElapsedMS() - returns the milliseconds elapsed since the first attempt
+// RequestMethod() - returns the request method
+// RequestPath() - returns the request path
+// RequestHeader("name") - returns the value of the request header
 // ResponseCode() - returns http response code
+// ResponseHeader("name") - returns the value of the response header
 // IsNetworkError() - tests if response code is related to networking error
+// IsTimeoutError() - tests if the attempt timed out
+// IsConnectionRefused() - tests if the backend refused the connection
+// Matches(RequestPath(), "regexp") - tests if a value matches the regular expression
+// HasPrefix(RequestPath(), "prefix") - tests if a value starts with the prefix
 //
 // Example of the predicate:
 //
 // `Attempts() <= 2 && ResponseCode() == 502`
 //
+// `Attempts() <= 3 && IsConnectionRefused() && HasPrefix(RequestPath(), "/api/")`
+//
 func Retry(predicate string) optSetter {
 	return func(b *Buffer) error {
 		p, err := parseExpression(predicate)
@@ -148,6 +194,29 @@ func Retry(predicate string) optSetter {
 	}
 }
 
+// RetryBackoff sets the delay between the attempts of the retried requests. Available functions are:
+//
+// Fixed(ms) - waits the same delay between the attempts
+// Exponential(initialMS, maxMS) - doubles the delay after each attempt up to the max delay
+// Jitter(backoff, fraction) - randomly shortens the delays of the backoff by up to the fraction of the delay
+//
+// Example of the backoff:
+//
+// `Jitter(Exponential(100, 5000), 0.5)`
+//
+// A Retry-After header in the response lengthens the delay, up to DefaultMaxRetryAfter.
+// There is no delay by default.
+func RetryBackoff(expr string) optSetter {
+	return func(b *Buffer) error {
+		bo, err := parseBackoff(expr)
+		if err != nil {
+			return err
+		}
+		b.retryBackoff = bo
+		return nil
+	}
+}
+
 // ErrorHandler sets error handler of the server
 func ErrorHandler(h utils.ErrorHandler) optSetter {
 	return func(b *Buffer) error {
@@ -156,6 +225,15 @@ func ErrorHandler(h utils.ErrorHandler) optSetter {
 	}
 }
 
+// Metrics sets the observer of the retries and the bytes spooled to disk,
+// it defaults to the observer of the metrics middleware handling the request.
+func Metrics(o metrics.Observer) optSetter {
+	return func(b *Buffer) error {
+		b.observer = o
+		return nil
+	}
+}
+
 // MaxRequestBodyBytes sets the maximum request body size in bytes
 func MaxRequestBodyBytes(m int64) optSetter {
 	return func(b *Buffer) error {
@@ -179,6 +257,28 @@ func MemRequestBodyBytes(m int64) optSetter {
 	}
 }
 
+// DecodeRequestBody decodes the gzip, deflate and br encoded request bodies before passing them to the next handler,
+// without their Content-Encoding header. MaxRequestBodyBytes limits the size of the encoded body
+// and MaxDecodedRequestBodyBytes the size of the decoded body.
+func DecodeRequestBody() optSetter {
+	return func(b *Buffer) error {
+		b.decodeRequestBody = true
+		return nil
+	}
+}
+
+// MaxDecodedRequestBodyBytes sets the maximum size in bytes of a request body decoded with DecodeRequestBody,
+// it defaults to the limit set with MaxRequestBodyBytes.
+func MaxDecodedRequestBodyBytes(m int64) optSetter {
+	return func(b *Buffer) error {
+		if m < 0 {
+			return fmt.Errorf("max bytes should be >= 0 got %d", m)
+		}
+		b.maxDecodedRequestBodyBytes = m
+		return nil
+	}
+}
+
 // MaxResponseBodyBytes sets the maximum request body size in bytes
 func MaxResponseBodyBytes(m int64) optSetter {
 	return func(b *Buffer) error {
@@ -202,6 +302,42 @@ func MemResponseBodyBytes(m int64) optSetter {
 	}
 }
 
+// Spool sets the spooler managing the temporary files of the bodies that don't fit in memory,
+// it can be shared by several buffers to enforce a disk quota across all of them.
+// It defaults to a spooler writing to os.TempDir() without quota.
+func Spool(s *Spooler) optSetter {
+	return func(b *Buffer) error {
+		b.spooler = s
+		return nil
+	}
+}
+
+// RequestStreamThreshold sets the size in bytes of the largest request body to buffer,
+// the larger bodies are streamed to the next handler and the requests are not retried.
+// It defaults to 0, the request bodies are always buffered.
+func RequestStreamThreshold(m int64) optSetter {
+	return func(b *Buffer) error {
+		if m < 0 {
+			return fmt.Errorf("threshold should be >= 0 got %d", m)
+		}
+		b.requestStreamThreshold = m
+		return nil
+	}
+}
+
+// ResponseStreamThreshold sets the size in bytes of the largest response body to buffer,
+// the larger bodies are streamed to the client and the requests are not retried.
+// It defaults to 0, the response bodies are always buffered.
+func ResponseStreamThreshold(m int64) optSetter {
+	return func(b *Buffer) error {
+		if m < 0 {
+			return fmt.Errorf("threshold should be >= 0 got %d", m)
+		}
+		b.responseStreamThreshold = m
+		return nil
+	}
+}
+
 // Wrap sets the next handler to be called by buffer handler.
 func (b *Buffer) Wrap(next http.Handler) error {
 	b.next = next
@@ -209,14 +345,14 @@ func (b *Buffer) Wrap(next http.Handler) error {
 }
 
 func (b *Buffer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	if b.log.Level >= log.DebugLevel {
-		logEntry := b.log.WithField("Request", utils.DumpHttpRequest(req))
-		logEntry.Debug("vulcand/oxy/buffer: begin ServeHttp on request")
-		defer logEntry.Debug("vulcand/oxy/buffer: completed ServeHttp on request")
+	if b.log.Enabled(logging.DebugLevel) {
+		logger := utils.RequestLogger(b.log, req).With(utils.RequestField(req))
+		logging.Debug(logger, "vulcand/oxy/buffer: begin ServeHttp on request")
+		defer logging.Debug(logger, "vulcand/oxy/buffer: completed ServeHttp on request")
 	}
 
 	if err := b.checkLimit(req); err != nil {
-		b.log.Errorf("vulcand/oxy/buffer: request body over limit, err: %v", err)
+		logging.Error(utils.RequestLogger(b.log, req), "vulcand/oxy/buffer: request body over limit", logging.Err(err))
 		b.errHandler.ServeHTTP(w, req, err)
 		return
 	}
@@ -225,102 +361,153 @@ func (b *Buffer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// to read into memory and disk. This reader returns an error if the total request size exceeds the
 	// predefined MaxSizeBytes. This can occur if we got chunked request, in this case ContentLength would be set to -1
 	// and the reader would be unbounded bufio in the http.Server
-	body, err := multibuf.New(req.Body, multibuf.MaxBytes(b.maxRequestBodyBytes), multibuf.MemBytes(b.memRequestBodyBytes))
-	if err != nil || body == nil {
-		b.log.Errorf("vulcand/oxy/buffer: error when reading request body, err: %v", err)
+	reqBody, maxBytes, decoded, err := b.requestBody(req)
+	if err != nil {
+		logging.Error(utils.RequestLogger(b.log, req), "vulcand/oxy/buffer: error when decoding request body", logging.Err(err))
 		b.errHandler.ServeHTTP(w, req, err)
 		return
 	}
 
-	// Set request body to buffered reader that can replay the read and execute Seek
+	if b.requestStreamThreshold > 0 {
+		head, large, err := b.peekRequestBody(req, reqBody, decoded)
+		if err != nil {
+			logging.Error(utils.RequestLogger(b.log, req), "vulcand/oxy/buffer: error when reading request body", logging.Err(err))
+			b.errHandler.ServeHTTP(w, req, err)
+			return
+		}
+		if large {
+			b.serveStreamedRequest(w, req, head, maxBytes, decoded)
+			return
+		}
+		reqBody = head
+	}
+
 	// Note that we don't change the original request body as it's handled by the http server
 	// and we don'w want to mess with standard library
+	spooled := b.spooler.newSpool(b.memRequestBodyBytes, maxBytes)
 	defer func() {
-		if body != nil {
-			errClose := body.Close()
-			if errClose != nil {
-				b.log.Errorf("vulcand/oxy/buffer: failed to close body, err: %v", errClose)
-			}
+		metrics.Resolve(req.Context(), b.observer).SpooledToDisk("request", spooled.diskBytes())
+		if errClose := spooled.Close(); errClose != nil {
+			logging.Error(utils.RequestLogger(b.log, req), "vulcand/oxy/buffer: failed to close body", logging.Err(errClose))
 		}
 	}()
+	if _, err := io.Copy(spooled, reqBody); err != nil {
+		logging.Error(utils.RequestLogger(b.log, req), "vulcand/oxy/buffer: error when reading request body", logging.Err(err))
+		b.errHandler.ServeHTTP(w, req, err)
+		return
+	}
 
 	// We need to set ContentLength based on known request size. The incoming request may have been
 	// set without content length or using chunked TransferEncoding
-	totalSize, err := body.Size()
-	if err != nil {
-		b.log.Errorf("vulcand/oxy/buffer: failed to get request size, err: %v", err)
-		b.errHandler.ServeHTTP(w, req, err)
-		return
+	totalSize := spooled.size
+
+	// Set request body to buffered reader that can replay the read and execute Seek
+	var body io.ReadSeeker
+	if totalSize > 0 {
+		body = spooled.Reader()
 	}
 
-	if totalSize == 0 {
-		body = nil
+	if decoded {
+		// the next handler receives the decoded body
+		req = req.Clone(req.Context())
+		req.Header.Del("Content-Encoding")
 	}
 
+	// the error record tells the retry predicate why an attempt failed
+	ctx, errRecord := utils.ContextWithErrorRecord(req.Context())
+	req = req.WithContext(ctx)
+	start := time.Now()
+
 	outreq := b.copyRequest(req, body, totalSize)
 
 	attempt := 1
 	for {
-		// We create a special writer that will limit the response size, buffer it to disk if necessary
-		writer, err := multibuf.NewWriterOnce(multibuf.MaxBytes(b.maxResponseBodyBytes), multibuf.MemBytes(b.memResponseBodyBytes))
-		if err != nil {
-			b.log.Errorf("vulcand/oxy/buffer: failed create response writer, err: %v", err)
-			b.errHandler.ServeHTTP(w, req, err)
-			return
-		}
+		errRecord.Set(nil)
 
 		// We are mimicking http.ResponseWriter to replace writer with our special writer
-		bw := &bufferWriter{
-			header:         make(http.Header),
-			buffer:         writer,
-			responseWriter: w,
-			log:            b.log,
-		}
+		// that will limit the response size, buffer it to disk if necessary
+		bw := b.newBufferWriter(w, req)
 		defer bw.Close()
 
-		b.next.ServeHTTP(bw, outreq)
+		attemptCtx, span := tracing.StartSpan(req.Context(), "attempt", trace.WithAttributes(tracing.AttemptKey.Int(attempt)))
+		b.next.ServeHTTP(bw, outreq.WithContext(attemptCtx))
+		span.SetAttributes(semconv.HTTPStatusCode(bw.code))
+		if ue := errRecord.Get(); ue != nil {
+			span.SetAttributes(tracing.ErrorKindKey.String(string(ue.Kind)))
+			tracing.SetError(span, ue)
+		}
+		span.End()
+
 		if bw.hijacked {
-			b.log.Debugf("vulcand/oxy/buffer: connection was hijacked downstream. Not taking any action in buffer.")
+			if b.log.Enabled(logging.DebugLevel) {
+				logging.Debug(utils.RequestLogger(b.log, req), "vulcand/oxy/buffer: connection was hijacked downstream, not taking any action in buffer")
+			}
+			return
+		}
+		if bw.streaming {
+			bw.finishStreaming()
 			return
 		}
 
-		var reader multibuf.MultiReader
-		if bw.expectBody(outreq) {
-			rdr, err := writer.Reader()
-			if err != nil {
-				b.log.Errorf("vulcand/oxy/buffer: failed to read response, err: %v", err)
-				b.errHandler.ServeHTTP(w, req, err)
-				return
-			}
-			defer rdr.Close()
-			reader = rdr
+		if bw.err != nil {
+			logging.Error(utils.RequestLogger(b.log, req), "vulcand/oxy/buffer: failed to buffer response", logging.Err(bw.err))
+			b.errHandler.ServeHTTP(w, req, bw.err)
+			return
 		}
 
-		if (b.retryPredicate == nil || attempt > DefaultMaxRetryAttempts) ||
-			!b.retryPredicate(&context{r: req, attempt: attempt, responseCode: bw.code}) {
-			utils.CopyHeaders(w.Header(), bw.Header())
-			w.WriteHeader(bw.code)
-			if reader != nil {
-				io.Copy(w, reader)
-			}
+		retry := b.retryPredicate != nil && attempt <= DefaultMaxRetryAttempts && retryable(req) &&
+			b.retryPredicate(&context{
+				r:              req,
+				attempt:        attempt,
+				responseCode:   bw.code,
+				responseHeader: bw.header,
+				start:          start,
+				errRecord:      errRecord,
+			})
+		if retry && b.retryBackoff != nil {
+			retry = b.waitBeforeRetry(req, attempt, bw.header)
+		}
+		if !retry {
+			b.writeResponse(w, outreq, bw)
 			return
 		}
 
+		// releases the buffered response before the next attempt
+		bw.Close()
+
+		metrics.Resolve(req.Context(), b.observer).Retry("buffer")
+		utils.RecordRetry(req)
 		attempt++
 		if body != nil {
-			if _, err := body.Seek(0, 0); err != nil {
-				b.log.Errorf("vulcand/oxy/buffer: failed to rewind response body, err: %v", err)
+			if _, err := body.Seek(0, io.SeekStart); err != nil {
+				logging.Error(utils.RequestLogger(b.log, req), "vulcand/oxy/buffer: failed to rewind response body", logging.Err(err))
 				b.errHandler.ServeHTTP(w, req, err)
 				return
 			}
 		}
 
 		outreq = b.copyRequest(req, body, totalSize)
-		b.log.Debugf("vulcand/oxy/buffer: retry Request(%v %v) attempt %v", req.Method, req.URL, attempt)
+		if b.log.Enabled(logging.DebugLevel) {
+			logging.Debug(utils.RequestLogger(b.log, req), "vulcand/oxy/buffer: retrying request",
+				logging.String("method", req.Method), logging.Any("url", req.URL), logging.Int("attempt", attempt))
+		}
+	}
+}
+
+// writeResponse writes the buffered response, or the requested ranges of it if the next handler ignored the Range header
+func (b *Buffer) writeResponse(w http.ResponseWriter, req *http.Request, bw *bufferWriter) {
+	if servesRange(req, bw.code, bw.header) {
+		serveRange(w, req, bw)
+		return
+	}
+	utils.CopyHeaders(w.Header(), bw.Header())
+	w.WriteHeader(bw.code)
+	if bw.expectBody(req) {
+		io.Copy(w, bw.buffer.Reader())
 	}
 }
 
-func (b *Buffer) copyRequest(req *http.Request, body io.ReadCloser, bodySize int64) *http.Request {
+func (b *Buffer) copyRequest(req *http.Request, body io.Reader, bodySize int64) *http.Request {
 	o := *req
 	o.URL = utils.CopyURL(req.URL)
 	o.Header = make(http.Header)
@@ -332,11 +519,38 @@ func (b *Buffer) copyRequest(req *http.Request, body io.ReadCloser, bodySize int
 	if body == nil {
 		o.Body = ioutil.NopCloser(req.Body)
 	} else {
-		o.Body = ioutil.NopCloser(body.(io.Reader))
+		o.Body = ioutil.NopCloser(body)
 	}
 	return &o
 }
 
+// requestBody returns the reader of the request body to buffer along with its size limit,
+// decoded tells if the body is decoded
+func (b *Buffer) requestBody(req *http.Request) (io.Reader, int64, bool, error) {
+	if !b.decodeRequestBody {
+		return req.Body, b.maxRequestBodyBytes, false, nil
+	}
+	encodings, ok := requestEncodings(req.Header)
+	if !ok || len(encodings) == 0 {
+		return req.Body, b.maxRequestBodyBytes, false, nil
+	}
+
+	var body io.Reader = req.Body
+	if b.maxRequestBodyBytes > 0 {
+		body = &limitReader{r: body, max: b.maxRequestBodyBytes}
+	}
+	decodedBody, err := decodeBody(body, encodings)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	maxBytes := b.maxDecodedRequestBodyBytes
+	if maxBytes == 0 {
+		maxBytes = b.maxRequestBodyBytes
+	}
+	return decodedBody, maxBytes, true, nil
+}
+
 func (b *Buffer) checkLimit(req *http.Request) error {
 	if b.maxRequestBodyBytes <= 0 {
 		return nil
@@ -350,47 +564,95 @@ func (b *Buffer) checkLimit(req *http.Request) error {
 type bufferWriter struct {
 	header         http.Header
 	code           int
-	buffer         multibuf.WriterOnce
+	buffer         *spool
+	// err is the error that occurred while buffering the response
+	err            error
 	responseWriter http.ResponseWriter
 	hijacked       bool
-	log            *log.Logger
+	log            logging.Logger
+	metrics        metrics.Observer
+
+	// streamThreshold is the size of the largest response to buffer, the larger ones are streamed
+	streamThreshold int64
+	maxBytes        int64
+	length          int64
+	streaming       bool
+	aborted         bool
+}
+
+func (b *Buffer) newBufferWriter(w http.ResponseWriter, req *http.Request) *bufferWriter {
+	return &bufferWriter{
+		header:          make(http.Header),
+		code:            http.StatusOK,
+		buffer:          b.spooler.newSpool(b.memResponseBodyBytes, b.maxResponseBodyBytes),
+		responseWriter:  w,
+		log:             b.log,
+		metrics:         metrics.Resolve(req.Context(), b.observer),
+		streamThreshold: b.responseStreamThreshold,
+		maxBytes:        b.maxResponseBodyBytes,
+	}
 }
 
-// RFC2616 #4.4
 func (b *bufferWriter) expectBody(r *http.Request) bool {
+	return expectBody(r, b.code, b.header)
+}
+
+// RFC2616 #4.4
+func expectBody(r *http.Request, code int, header http.Header) bool {
 	if r.Method == "HEAD" {
 		return false
 	}
-	if (b.code >= 100 && b.code < 200) || b.code == 204 || b.code == 304 {
+	if (code >= 100 && code < 200) || code == 204 || code == 304 {
 		return false
 	}
 	// refer to https://github.com/vulcand/oxy/issues/113
-	// if b.header.Get("Content-Length") == "" && b.header.Get("Transfer-Encoding") == "" {
+	// if header.Get("Content-Length") == "" && header.Get("Transfer-Encoding") == "" {
 	// 	return false
 	// }
-	if b.header.Get("Content-Length") == "0" {
+	if header.Get("Content-Length") == "0" {
 		return false
 	}
 	return true
 }
 
 func (b *bufferWriter) Close() error {
+	b.metrics.SpooledToDisk("response", b.buffer.diskBytes())
 	return b.buffer.Close()
 }
 
 func (b *bufferWriter) Header() http.Header {
+	if b.streaming {
+		return b.responseWriter.Header()
+	}
 	return b.header
 }
 
 func (b *bufferWriter) Write(buf []byte) (int, error) {
-	length, err := b.buffer.Write(buf)
-	if err != nil {
+	if b.err != nil {
+		// the response is replaced with an error
+		return len(buf), nil
+	}
+	if !b.streaming && (b.code == http.StatusPartialContent || b.streamThreshold > 0 &&
+		(b.length+int64(len(buf)) > b.streamThreshold || b.contentLength() > b.streamThreshold)) {
+		// the partial responses are streamed, they are not retried
+		b.startStreaming()
+	}
+	if b.streaming {
+		return b.stream(buf)
+	}
+
+	b.length += int64(len(buf))
+	if _, err := b.buffer.Write(buf); err != nil {
 		// Since go1.11 (https://github.com/golang/go/commit/8f38f28222abccc505b9a1992deecfe3e2cb85de)
 		// if the writer returns an error, the reverse proxy panics
-		b.log.Error(err)
-		length = len(buf)
+		logging.Error(b.log, "vulcand/oxy/buffer: failed to buffer response", logging.Err(err))
+		b.err = err
+		if _, ok := err.(*multibuf.MaxSizeReachedError); ok {
+			// the request is not too large, the response is
+			b.err = &ResponseSizeError{MaxSize: b.maxBytes}
+		}
 	}
-	return length, nil
+	return len(buf), nil
 }
 
 // WriteHeader sets rw.Code.
@@ -403,7 +665,8 @@ func (b *bufferWriter) CloseNotify() <-chan bool {
 	if cn, ok := b.responseWriter.(http.CloseNotifier); ok {
 		return cn.CloseNotify()
 	}
-	b.log.Warningf("Upstream ResponseWriter of type %v does not implement http.CloseNotifier. Returning dummy channel.", reflect.TypeOf(b.responseWriter))
+	logging.Warn(b.log, "vulcand/oxy/buffer: upstream ResponseWriter does not implement http.CloseNotifier, returning dummy channel",
+		logging.Any("type", reflect.TypeOf(b.responseWriter)))
 	return make(<-chan bool)
 }
 
@@ -416,18 +679,52 @@ func (b *bufferWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 		}
 		return conn, rw, err
 	}
-	b.log.Warningf("Upstream ResponseWriter of type %v does not implement http.Hijacker. Returning dummy channel.", reflect.TypeOf(b.responseWriter))
+	logging.Warn(b.log, "vulcand/oxy/buffer: upstream ResponseWriter does not implement http.Hijacker",
+		logging.Any("type", reflect.TypeOf(b.responseWriter)))
 	return nil, nil, fmt.Errorf("the response writer wrapped in this proxy does not implement http.Hijacker. Its type is: %v", reflect.TypeOf(b.responseWriter))
 }
 
+// ResponseSizeError is returned when the response body is larger than the maximum response body size
+type ResponseSizeError struct {
+	MaxSize int64
+}
+
+func (e *ResponseSizeError) Error() string {
+	return fmt.Sprintf("response body exceeds the maximum size of %d bytes", e.MaxSize)
+}
+
+// ErrorKind classifies the error as an upstream response too large, served as 502 like SizeErrHandler does
+func (e *ResponseSizeError) ErrorKind() utils.ErrorKind {
+	return utils.ErrorKindResponseTooLarge
+}
+
 // SizeErrHandler Size error handler
 type SizeErrHandler struct{}
 
 func (e *SizeErrHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, err error) {
 	if _, ok := err.(*multibuf.MaxSizeReachedError); ok {
+		utils.RecordError(req, utils.ClassifyError(err))
 		w.WriteHeader(http.StatusRequestEntityTooLarge)
 		w.Write([]byte(http.StatusText(http.StatusRequestEntityTooLarge)))
 		return
 	}
+	if _, ok := err.(*ResponseSizeError); ok {
+		utils.RecordError(req, utils.ClassifyError(err))
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(http.StatusText(http.StatusBadGateway)))
+		return
+	}
+	if _, ok := err.(*DiskQuotaExceededError); ok {
+		utils.RecordError(req, utils.ClassifyError(err))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(http.StatusText(http.StatusServiceUnavailable)))
+		return
+	}
+	if _, ok := err.(*DecodeError); ok {
+		utils.RecordError(req, utils.ClassifyError(err))
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		return
+	}
 	utils.DefaultHandler.ServeHTTP(w, req, err)
 }
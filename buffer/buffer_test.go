@@ -9,11 +9,16 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/vulcand/oxy/forward"
+	"github.com/vulcand/oxy/metrics"
+	"github.com/vulcand/oxy/metrics/prommetrics"
 	"github.com/vulcand/oxy/testutils"
 	"github.com/vulcand/oxy/utils"
 )
@@ -202,7 +207,38 @@ func TestResponseLimitReached(t *testing.T) {
 
 	re, _, err := testutils.Get(proxy.URL)
 	require.NoError(t, err)
-	assert.Equal(t, http.StatusInternalServerError, re.StatusCode)
+	assert.Equal(t, http.StatusBadGateway, re.StatusCode)
+}
+
+func TestResponseLimitReachedErrorKind(t *testing.T) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello, this response is too large"))
+	})
+	defer srv.Close()
+
+	fwd, err := forward.New()
+	require.NoError(t, err)
+
+	rdr := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		fwd.ServeHTTP(w, req)
+	})
+
+	var kind utils.ErrorKind
+	errHandler := utils.ErrorHandlerFunc(func(w http.ResponseWriter, req *http.Request, err error) {
+		kind = utils.ClassifyError(err).Kind
+		(&SizeErrHandler{}).ServeHTTP(w, req, err)
+	})
+	st, err := New(rdr, MaxResponseBodyBytes(4), ErrorHandler(errHandler))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(st)
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, re.StatusCode)
+	assert.Equal(t, utils.ErrorKindResponseTooLarge, kind)
 }
 
 func TestFileStreamingResponse(t *testing.T) {
@@ -392,3 +428,48 @@ func TestNotNilBody(t *testing.T) {
 	assert.Equal(t, http.StatusOK, re.StatusCode)
 	assert.Equal(t, "hello", string(body))
 }
+
+func TestSpoolMetrics(t *testing.T) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		w.Write(body)
+	})
+	defer srv.Close()
+
+	// forwarder will proxy the request to whatever destination
+	fwd, err := forward.New()
+	require.NoError(t, err)
+
+	// this is our redirect to server
+	rdr := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		fwd.ServeHTTP(w, req)
+	})
+
+	// stream handler will forward requests to redirect
+	st, err := New(rdr, MemRequestBodyBytes(4), MemResponseBodyBytes(8))
+	require.NoError(t, err)
+
+	registry := prometheus.NewRegistry()
+	m, err := prommetrics.NewMetrics(registry)
+	require.NoError(t, err)
+	mw, err := metrics.New(st, m)
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(mw)
+	defer proxy.Close()
+
+	re, body, err := testutils.Post(proxy.URL, testutils.Body("hello, this body is too large to fit in memory"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "hello, this body is too large to fit in memory", string(body))
+
+	expected := `
+		# HELP oxy_buffer_spooled_bytes_total Number of bytes of the buffered bodies spooled to disk by body, request or response.
+		# TYPE oxy_buffer_spooled_bytes_total counter
+		oxy_buffer_spooled_bytes_total{body="request"} 42
+		oxy_buffer_spooled_bytes_total{body="response"} 38
+	`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "oxy_buffer_spooled_bytes_total"))
+}
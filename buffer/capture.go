@@ -0,0 +1,150 @@
+package buffer
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/mailgun/multibuf"
+	"github.com/vulcand/oxy/utils"
+)
+
+// Response is a response captured from a handler, its body is buffered in memory and on disk.
+// It can be written to several response writers concurrently.
+type Response struct {
+	// Code is the status code of the response
+	Code int
+	// Header is the header of the response
+	Header http.Header
+	// Size is the size of the body in bytes
+	Size int64
+
+	body *spool
+}
+
+// Capture serves the request with next and buffers the response, up to memBytes in memory and up to maxBytes in total.
+// A *multibuf.MaxSizeReachedError is returned if the body is larger than maxBytes, a maxBytes <= 0 means no limit.
+// The response must be closed to release the buffer.
+func Capture(next http.Handler, req *http.Request, memBytes, maxBytes int64) (*Response, error) {
+	resp, _, err := capture(next, nil, req, memBytes, maxBytes)
+	return resp, err
+}
+
+// CaptureOrStream is Capture falling back to streaming: when the body is larger than maxBytes, the buffered part
+// and the rest of the response are written to w instead of failing, streamed is true and the response is nil.
+func CaptureOrStream(next http.Handler, w http.ResponseWriter, req *http.Request, memBytes, maxBytes int64) (resp *Response, streamed bool, err error) {
+	return capture(next, w, req, memBytes, maxBytes)
+}
+
+func capture(next http.Handler, w http.ResponseWriter, req *http.Request, memBytes, maxBytes int64) (*Response, bool, error) {
+	cw := &captureWriter{
+		header: make(http.Header),
+		body:   defaultSpooler.newSpool(memBytes, maxBytes),
+		stream: w,
+	}
+	next.ServeHTTP(cw, req)
+	if cw.streaming {
+		return nil, true, nil
+	}
+	if cw.err != nil {
+		cw.body.Close()
+		return nil, false, cw.err
+	}
+	if cw.code == 0 {
+		cw.code = http.StatusOK
+	}
+
+	r := &Response{Code: cw.code, Header: cw.header}
+	if !expectBody(req, cw.code, cw.header) {
+		cw.body.Close()
+		return r, false, nil
+	}
+	r.body, r.Size = cw.body, cw.body.size
+	return r, false, nil
+}
+
+// WriteTo writes the response to w
+func (r *Response) WriteTo(w http.ResponseWriter) error {
+	utils.CopyHeaders(w.Header(), r.Header)
+	w.WriteHeader(r.Code)
+	if r.body == nil {
+		return nil
+	}
+	_, err := io.Copy(w, r.body.Reader())
+	return err
+}
+
+// Close releases the buffered body
+func (r *Response) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	return r.body.Close()
+}
+
+// captureWriter buffers the response of a handler, it switches to streaming the response
+// to stream, if set, once the body is over limit
+type captureWriter struct {
+	header http.Header
+	code   int
+	body   *spool
+	err    error
+
+	stream    http.ResponseWriter
+	streaming bool
+}
+
+func (c *captureWriter) Header() http.Header {
+	return c.header
+}
+
+func (c *captureWriter) Write(buf []byte) (int, error) {
+	if c.code == 0 {
+		c.code = http.StatusOK
+	}
+	if c.streaming {
+		return c.stream.Write(buf)
+	}
+	if c.err != nil {
+		return len(buf), nil
+	}
+	if _, err := c.body.Write(buf); err != nil {
+		var maxSizeErr *multibuf.MaxSizeReachedError
+		if c.stream != nil && errors.As(err, &maxSizeErr) {
+			if err := c.startStreaming(); err != nil {
+				return 0, err
+			}
+			return c.stream.Write(buf)
+		}
+		// the reverse proxy panics if the writer returns an error, see bufferWriter
+		c.err = err
+	}
+	return len(buf), nil
+}
+
+// startStreaming sends the header and the buffered body, the body is not buffered anymore
+func (c *captureWriter) startStreaming() error {
+	c.streaming = true
+	defer c.body.Close()
+
+	utils.CopyHeaders(c.stream.Header(), c.header)
+	c.stream.WriteHeader(c.code)
+	_, err := io.Copy(c.stream, c.body.Reader())
+	return err
+}
+
+// Flush flushes the streamed response, the buffered responses are complete once the handler returns
+func (c *captureWriter) Flush() {
+	if !c.streaming {
+		return
+	}
+	if f, ok := c.stream.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *captureWriter) WriteHeader(code int) {
+	if c.code == 0 {
+		c.code = code
+	}
+}
@@ -0,0 +1,114 @@
+package buffer
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mailgun/multibuf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapture(t *testing.T) {
+	content := strings.Repeat("hello", 100)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Test", "value")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(content))
+	})
+
+	resp, err := Capture(handler, httptest.NewRequest(http.MethodGet, "/", nil), 10, -1)
+	require.NoError(t, err)
+	defer resp.Close()
+
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, "value", resp.Header.Get("X-Test"))
+	assert.EqualValues(t, len(content), resp.Size)
+
+	// the response can be written several times concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			rw := httptest.NewRecorder()
+			assert.NoError(t, resp.WriteTo(rw))
+			assert.Equal(t, http.StatusCreated, rw.Code)
+			assert.Equal(t, "value", rw.Header().Get("X-Test"))
+			body, err := ioutil.ReadAll(rw.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, content, string(body))
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCaptureNoBody(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	resp, err := Capture(handler, httptest.NewRequest(http.MethodGet, "/", nil), 10, -1)
+	require.NoError(t, err)
+	defer resp.Close()
+
+	rw := httptest.NewRecorder()
+	require.NoError(t, resp.WriteTo(rw))
+	assert.Equal(t, http.StatusNoContent, rw.Code)
+	assert.Empty(t, rw.Body.String())
+}
+
+func TestCaptureOrStream(t *testing.T) {
+	content := strings.Repeat("hello", 100)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Test", "value")
+		w.WriteHeader(http.StatusCreated)
+		for i := 0; i < 100; i++ {
+			_, _ = w.Write([]byte("hello"))
+		}
+	})
+
+	testCases := []struct {
+		desc     string
+		maxBytes int64
+		streamed bool
+	}{
+		{desc: "captured", maxBytes: -1},
+		{desc: "streamed", maxBytes: 12, streamed: true},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			resp, streamed, err := CaptureOrStream(handler, rw, httptest.NewRequest(http.MethodGet, "/", nil), 4, test.maxBytes)
+			require.NoError(t, err)
+			assert.Equal(t, test.streamed, streamed)
+
+			if !test.streamed {
+				require.NotNil(t, resp)
+				defer resp.Close()
+				assert.Equal(t, 0, rw.Body.Len())
+				require.NoError(t, resp.WriteTo(rw))
+			}
+			assert.Equal(t, http.StatusCreated, rw.Code)
+			assert.Equal(t, "value", rw.Header().Get("X-Test"))
+			assert.Equal(t, content, rw.Body.String())
+		})
+	}
+}
+
+func TestCaptureLimitReached(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte("hello, this response is too large"))
+	})
+
+	_, err := Capture(handler, httptest.NewRequest(http.MethodGet, "/", nil), 2, 4)
+	require.Error(t, err)
+	assert.IsType(t, &multibuf.MaxSizeReachedError{}, err)
+}
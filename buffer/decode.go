@@ -0,0 +1,155 @@
+package buffer
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/mailgun/multibuf"
+	"github.com/vulcand/oxy/utils"
+)
+
+// DecodeError is returned when a request body can't be decoded
+type DecodeError struct {
+	Encoding string
+	Err      error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode %s request body: %v", e.Encoding, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorKind classifies the error as a bad request, the client sent a malformed body
+func (e *DecodeError) ErrorKind() utils.ErrorKind {
+	return utils.ErrorKindBadRequest
+}
+
+// decoders lists the supported request content codings
+var decoders = map[string]func(io.Reader) (io.Reader, error){
+	"gzip": func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	},
+	"x-gzip": func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	},
+	"deflate": newDeflateReader,
+	"br": func(r io.Reader) (io.Reader, error) {
+		return brotli.NewReader(r), nil
+	},
+}
+
+// requestEncodings returns the codings of the request body in the order they were applied,
+// ok is false if one of them is not supported
+func requestEncodings(h http.Header) (encodings []string, ok bool) {
+	for _, value := range h["Content-Encoding"] {
+		for _, encoding := range strings.Split(value, ",") {
+			encoding = strings.ToLower(strings.TrimSpace(encoding))
+			if encoding == "" || encoding == "identity" {
+				continue
+			}
+			if _, supported := decoders[encoding]; !supported {
+				return nil, false
+			}
+			encodings = append(encodings, encoding)
+		}
+	}
+	return encodings, true
+}
+
+// decodeBody returns a reader of the decoded body, the codings are removed in the reverse order they were applied
+func decodeBody(body io.Reader, encodings []string) (io.Reader, error) {
+	src := &sourceReader{r: body}
+	body = src
+	for i := len(encodings) - 1; i >= 0; i-- {
+		decoded, err := decoders[encodings[i]](body)
+		if err != nil {
+			return nil, decodeErr(encodings[i], err, src)
+		}
+		body = &decodeReader{r: decoded, encoding: encodings[i], src: src}
+	}
+	return body, nil
+}
+
+// newDeflateReader reads the "deflate" coding, which is the zlib format, some clients send raw deflate data instead
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// decodeReader tells the decoding errors apart from the errors of the underlying reader
+type decodeReader struct {
+	r        io.Reader
+	encoding string
+	src      *sourceReader
+}
+
+func (d *decodeReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = decodeErr(d.encoding, err, d.src)
+	}
+	return n, err
+}
+
+// sourceReader remembers the error of the encoded body, e.g. the client closed the connection,
+// the decompressors pass it through as is
+type sourceReader struct {
+	r   io.Reader
+	err error
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return n, err
+}
+
+// decodeErr wraps the errors of the decompressor in a DecodeError, the errors of the encoded body are returned unchanged
+func decodeErr(encoding string, err error, src *sourceReader) error {
+	var maxSizeErr *multibuf.MaxSizeReachedError
+	var decodeErr *DecodeError
+	if errors.As(err, &maxSizeErr) || errors.As(err, &decodeErr) {
+		return err
+	}
+	if src.err != nil && errors.Is(err, src.err) {
+		return err
+	}
+	return &DecodeError{Encoding: encoding, Err: err}
+}
+
+// limitReader returns a *multibuf.MaxSizeReachedError when more than max bytes are read
+type limitReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max {
+		return n, &multibuf.MaxSizeReachedError{MaxSize: l.max}
+	}
+	return n, err
+}
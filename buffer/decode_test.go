@@ -0,0 +1,264 @@
+package buffer
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/utils"
+)
+
+func encode(t *testing.T, encoding string, data string) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(buf)
+	case "deflate":
+		w = zlib.NewWriter(buf)
+	case "raw-deflate":
+		fw, err := flate.NewWriter(buf, flate.DefaultCompression)
+		require.NoError(t, err)
+		w = fw
+	case "br":
+		w = brotli.NewWriter(buf)
+	}
+	_, err := w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+// echoRequest is a handler answering with the request body, its length and encoding
+func echoRequest(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Content-Length", strconv.FormatInt(req.ContentLength, 10))
+	w.Header().Set("X-Content-Encoding", req.Header.Get("Content-Encoding"))
+	_, _ = w.Write(body)
+}
+
+func TestDecodeRequestBody(t *testing.T) {
+	content := strings.Repeat("hello, decoded world! ", 100)
+
+	testCases := []struct {
+		desc           string
+		encoding       string
+		header         string
+		expectedBody   string
+		expectedHeader string
+	}{
+		{
+			desc:         "gzip",
+			encoding:     "gzip",
+			header:       "gzip",
+			expectedBody: content,
+		},
+		{
+			desc:         "deflate",
+			encoding:     "deflate",
+			header:       "deflate",
+			expectedBody: content,
+		},
+		{
+			desc:         "raw deflate",
+			encoding:     "raw-deflate",
+			header:       "Deflate",
+			expectedBody: content,
+		},
+		{
+			desc:         "brotli",
+			encoding:     "br",
+			header:       "br",
+			expectedBody: content,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			b, err := New(http.HandlerFunc(echoRequest), DecodeRequestBody())
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(encode(t, test.encoding, content)))
+			req.Header.Set("Content-Encoding", test.header)
+			rw := httptest.NewRecorder()
+			b.ServeHTTP(rw, req)
+
+			assert.Equal(t, http.StatusOK, rw.Code)
+			assert.Equal(t, test.expectedBody, rw.Body.String())
+			assert.Equal(t, strconv.Itoa(len(content)), rw.Header().Get("X-Content-Length"))
+			assert.Empty(t, rw.Header().Get("X-Content-Encoding"))
+		})
+	}
+}
+
+func TestDecodeRequestBodyMultipleEncodings(t *testing.T) {
+	content := "hello"
+	encoded := encode(t, "br", string(encode(t, "gzip", content)))
+
+	b, err := New(http.HandlerFunc(echoRequest), DecodeRequestBody())
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(encoded))
+	req.Header.Set("Content-Encoding", "gzip, br")
+	rw := httptest.NewRecorder()
+	b.ServeHTTP(rw, req)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, content, rw.Body.String())
+}
+
+func TestDecodeRequestBodyLimits(t *testing.T) {
+	// a small body expanding a lot once decoded
+	bomb := encode(t, "gzip", strings.Repeat("a", 1024*1024))
+	require.True(t, len(bomb) < 10*1024)
+
+	testCases := []struct {
+		desc     string
+		options  []optSetter
+		expected int
+	}{
+		{
+			desc:     "decoded limit",
+			options:  []optSetter{MaxDecodedRequestBodyBytes(64 * 1024)},
+			expected: http.StatusRequestEntityTooLarge,
+		},
+		{
+			desc:     "inherited limit",
+			options:  []optSetter{MaxRequestBodyBytes(64 * 1024)},
+			expected: http.StatusRequestEntityTooLarge,
+		},
+		{
+			desc:     "encoded limit",
+			options:  []optSetter{MaxRequestBodyBytes(1024), MaxDecodedRequestBodyBytes(2 * 1024 * 1024)},
+			expected: http.StatusRequestEntityTooLarge,
+		},
+		{
+			desc:     "within limits",
+			options:  []optSetter{MaxRequestBodyBytes(64 * 1024), MaxDecodedRequestBodyBytes(2 * 1024 * 1024)},
+			expected: http.StatusOK,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			b, err := New(http.HandlerFunc(echoRequest), append([]optSetter{DecodeRequestBody()}, test.options...)...)
+			require.NoError(t, err)
+
+			// the chunked request has no content length to check beforehand
+			req := httptest.NewRequest(http.MethodPost, "/", ioutil.NopCloser(bytes.NewReader(bomb)))
+			req.ContentLength = -1
+			req.Header.Set("Content-Encoding", "gzip")
+			rw := httptest.NewRecorder()
+			b.ServeHTTP(rw, req)
+
+			assert.Equal(t, test.expected, rw.Code)
+		})
+	}
+}
+
+func TestDecodeRequestBodyInvalid(t *testing.T) {
+	b, err := New(http.HandlerFunc(echoRequest), DecodeRequestBody())
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("not gzipped"))
+	req.Header.Set("Content-Encoding", "gzip")
+	rw := httptest.NewRecorder()
+	b.ServeHTTP(rw, req)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+
+	ue := utils.ClassifyError(&DecodeError{Encoding: "gzip", Err: gzip.ErrHeader})
+	assert.Equal(t, utils.ErrorKindBadRequest, ue.Kind)
+	assert.Equal(t, http.StatusBadRequest, ue.StatusCode())
+}
+
+func TestDecodeRequestBodySourceError(t *testing.T) {
+	closed := errors.New("client closed the connection")
+
+	for _, encoding := range []string{"gzip", "deflate", "br"} {
+		encoded := encode(t, encoding, strings.Repeat("hello", 1000))
+
+		// the connection is closed in the middle of the compressed stream
+		body := io.MultiReader(bytes.NewReader(encoded[:len(encoded)/2]), &errReader{err: closed})
+		r, err := decodeBody(body, []string{encoding})
+		require.NoError(t, err, encoding)
+
+		_, err = ioutil.ReadAll(r)
+		assert.Equal(t, closed, err, encoding)
+	}
+
+	// the error happens while reading the header
+	_, err := decodeBody(&errReader{err: closed}, []string{"gzip"})
+	assert.Equal(t, closed, err)
+}
+
+type errReader struct {
+	err error
+}
+
+func (e *errReader) Read([]byte) (int, error) {
+	return 0, e.err
+}
+
+func TestDecodeRequestBodyPassThrough(t *testing.T) {
+	encoded := encode(t, "gzip", "hello")
+
+	testCases := []struct {
+		desc     string
+		options  []optSetter
+		encoding string
+	}{
+		{
+			desc:     "decoding disabled",
+			encoding: "gzip",
+		},
+		{
+			desc:     "unsupported encoding",
+			options:  []optSetter{DecodeRequestBody()},
+			encoding: "gzip, compress",
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			b, err := New(http.HandlerFunc(echoRequest), test.options...)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(encoded))
+			req.Header.Set("Content-Encoding", test.encoding)
+			rw := httptest.NewRecorder()
+			b.ServeHTTP(rw, req)
+
+			assert.Equal(t, http.StatusOK, rw.Code)
+			assert.Equal(t, encoded, rw.Body.Bytes())
+			assert.Equal(t, test.encoding, rw.Header().Get("X-Content-Encoding"))
+		})
+	}
+}
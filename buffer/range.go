@@ -0,0 +1,51 @@
+package buffer
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/vulcand/oxy/utils"
+)
+
+// retryable tells if the request can be retried, the partial transfers are only retried for idempotent methods
+func retryable(req *http.Request) bool {
+	if req.Header.Get("Range") == "" {
+		return true
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// servesRange tells if the buffer serves the Range of the request from the buffered response,
+// that is when the next handler ignored it and sent the whole representation
+func servesRange(req *http.Request, code int, h http.Header) bool {
+	if req.Method != http.MethodGet || req.Header.Get("Range") == "" || code != http.StatusOK {
+		return false
+	}
+	if h.Get("Content-Range") != "" || strings.EqualFold(h.Get("Accept-Ranges"), "none") {
+		return false
+	}
+	return true
+}
+
+// serveRange writes the ranges of the buffered response requested with the Range header,
+// the whole response is written if the If-Range condition does not match the response
+func serveRange(w http.ResponseWriter, req *http.Request, bw *bufferWriter) {
+	h := w.Header()
+	utils.CopyHeaders(h, bw.Header())
+	h.Del("Content-Length")
+	modtime, _ := http.ParseTime(h.Get("Last-Modified"))
+
+	// the next handler already evaluated the other conditional headers
+	rangeReq := *req
+	rangeReq.Header = http.Header{}
+	for _, name := range []string{"Range", "If-Range"} {
+		if values, ok := req.Header[name]; ok {
+			rangeReq.Header[name] = values
+		}
+	}
+	http.ServeContent(w, &rangeReq, "", modtime, bw.buffer.Reader())
+}
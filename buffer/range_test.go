@@ -0,0 +1,187 @@
+package buffer
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/testutils"
+)
+
+const rangeContent = "0123456789abcdefghij"
+
+func TestServeRange(t *testing.T) {
+	// the handler ignores the Range header
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(rangeContent))
+	})
+
+	st, err := New(handler)
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(st)
+	defer proxy.Close()
+
+	testCases := []struct {
+		desc                 string
+		header               http.Header
+		expectedCode         int
+		expectedBody         string
+		expectedContentRange string
+	}{
+		{
+			desc:                 "range",
+			header:               http.Header{"Range": {"bytes=2-5"}},
+			expectedCode:         http.StatusPartialContent,
+			expectedBody:         "2345",
+			expectedContentRange: "bytes 2-5/20",
+		},
+		{
+			desc:                 "suffix range",
+			header:               http.Header{"Range": {"bytes=-3"}},
+			expectedCode:         http.StatusPartialContent,
+			expectedBody:         "hij",
+			expectedContentRange: "bytes 17-19/20",
+		},
+		{
+			desc:                 "matching If-Range",
+			header:               http.Header{"Range": {"bytes=10-"}, "If-Range": {`"v1"`}},
+			expectedCode:         http.StatusPartialContent,
+			expectedBody:         "abcdefghij",
+			expectedContentRange: "bytes 10-19/20",
+		},
+		{
+			desc:         "mismatching If-Range",
+			header:       http.Header{"Range": {"bytes=10-"}, "If-Range": {`"v0"`}},
+			expectedCode: http.StatusOK,
+			expectedBody: rangeContent,
+		},
+		{
+			desc:                 "unsatisfiable range",
+			header:               http.Header{"Range": {"bytes=30-40"}},
+			expectedCode:         http.StatusRequestedRangeNotSatisfiable,
+			expectedContentRange: "bytes */20",
+		},
+		{
+			desc:         "no range",
+			expectedCode: http.StatusOK,
+			expectedBody: rangeContent,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			re, body, err := testutils.Get(proxy.URL, testutils.Headers(test.header))
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedCode, re.StatusCode)
+			assert.Equal(t, test.expectedContentRange, re.Header.Get("Content-Range"))
+			if test.expectedCode != http.StatusRequestedRangeNotSatisfiable {
+				assert.Equal(t, test.expectedBody, string(body))
+				assert.Equal(t, "text/plain", re.Header.Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestServeMultipleRanges(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(rangeContent))
+	})
+
+	st, err := New(handler)
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(st)
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL, testutils.Header("Range", "bytes=0-1,5-6"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusPartialContent, re.StatusCode)
+	assert.True(t, strings.HasPrefix(re.Header.Get("Content-Type"), "multipart/byteranges"))
+	assert.Contains(t, string(body), "Content-Range: bytes 0-1/20\r\n")
+	assert.Contains(t, string(body), "\r\n\r\n01\r\n")
+	assert.Contains(t, string(body), "Content-Range: bytes 5-6/20\r\n")
+	assert.Contains(t, string(body), "\r\n\r\n56\r\n")
+}
+
+func TestPartialContentPassThrough(t *testing.T) {
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "bytes=2-5", req.Header.Get("Range"))
+		assert.Equal(t, `"v1"`, req.Header.Get("If-Range"))
+		w.Header().Set("Content-Range", "bytes 2-5/20")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte("23"))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("45"))
+	})
+
+	st, err := New(handler)
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(st)
+	defer proxy.Close()
+
+	req, err := http.NewRequest(http.MethodGet, proxy.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Range", "bytes=2-5")
+	req.Header.Set("If-Range", `"v1"`)
+
+	// the partial response is streamed, the header is received before the handler completes
+	re, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer re.Body.Close()
+	assert.Equal(t, http.StatusPartialContent, re.StatusCode)
+	assert.Equal(t, "bytes 2-5/20", re.Header.Get("Content-Range"))
+
+	close(release)
+	body, err := ioutil.ReadAll(re.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "2345", string(body))
+}
+
+func TestRangeRetry(t *testing.T) {
+	var attempts int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(http.StatusText(http.StatusBadGateway)))
+	})
+
+	st, err := New(handler, Retry(`IsNetworkError() && Attempts() <= 2`))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(st)
+	defer proxy.Close()
+
+	testCases := []struct {
+		desc             string
+		method           string
+		expectedAttempts int32
+	}{
+		{desc: "idempotent", method: http.MethodGet, expectedAttempts: 3},
+		{desc: "non idempotent", method: http.MethodPost, expectedAttempts: 1},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			atomic.StoreInt32(&attempts, 0)
+
+			re, _, err := testutils.MakeRequest(proxy.URL, testutils.Method(test.method), testutils.Header("Range", "bytes=0-1"))
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusBadGateway, re.StatusCode)
+			assert.Equal(t, test.expectedAttempts, atomic.LoadInt32(&attempts))
+		})
+	}
+}
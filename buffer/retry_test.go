@@ -3,13 +3,23 @@ package buffer
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/vulcand/oxy/forward"
+	"github.com/vulcand/oxy/metrics"
+	"github.com/vulcand/oxy/metrics/prommetrics"
 	"github.com/vulcand/oxy/roundrobin"
 	"github.com/vulcand/oxy/testutils"
+	"github.com/vulcand/oxy/tracing"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestSuccess(t *testing.T) {
@@ -72,6 +82,63 @@ func TestRetryExceedAttempts(t *testing.T) {
 	assert.Equal(t, http.StatusBadGateway, re.StatusCode)
 }
 
+func TestRetryConnectionRefusedWithBackoff(t *testing.T) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	fwd, err := forward.New()
+	require.NoError(t, err)
+
+	lb, err := roundrobin.New(fwd)
+	require.NoError(t, err)
+
+	rt, err := New(lb, Retry(`IsConnectionRefused() && Attempts() <= 2`), RetryBackoff(`Fixed(50)`))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(rt)
+	defer proxy.Close()
+
+	require.NoError(t, lb.UpsertServer(testutils.ParseURI("http://localhost:64321")))
+	require.NoError(t, lb.UpsertServer(testutils.ParseURI(srv.URL)))
+
+	start := time.Now()
+	re, body, err := testutils.Get(proxy.URL, testutils.Body("some request parameters"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "hello", string(body))
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+}
+
+func TestRetryAfterHeader(t *testing.T) {
+	attempts := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(http.StatusText(http.StatusServiceUnavailable)))
+			return
+		}
+		w.Write([]byte("hello"))
+	})
+
+	rt, err := New(handler, Retry(`ResponseCode() == 503 && ResponseHeader("Retry-After") != "" && Attempts() <= 2`), RetryBackoff(`Fixed(0)`))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(rt)
+	defer proxy.Close()
+
+	start := time.Now()
+	re, body, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, 2, attempts)
+	assert.True(t, time.Since(start) >= time.Second)
+}
+
 func newBufferMiddleware(t *testing.T, p string) (*roundrobin.RoundRobin, *Buffer) {
 	// forwarder will proxy the request to whatever destination
 	fwd, err := forward.New()
@@ -87,3 +154,89 @@ func newBufferMiddleware(t *testing.T, p string) (*roundrobin.RoundRobin, *Buffe
 
 	return lb, st
 }
+
+func TestRetryTracing(t *testing.T) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	lb, rt := newBufferMiddleware(t, `IsNetworkError() && Attempts() <= 2`)
+
+	exporter := tracetest.NewInMemoryExporter()
+	tr, err := tracing.New(rt, tracing.TracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(tr)
+	defer proxy.Close()
+
+	require.NoError(t, lb.UpsertServer(testutils.ParseURI("http://localhost:64321")))
+	require.NoError(t, lb.UpsertServer(testutils.ParseURI(srv.URL)))
+
+	re, body, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "hello", string(body))
+
+	spans := exporter.GetSpans()
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	require.Equal(t, []string{"balancer", "upstream", "attempt", "balancer", "upstream", "attempt", "HTTP GET"}, names)
+
+	server := spans[6]
+	for i, attempt := range []tracetest.SpanStub{spans[2], spans[5]} {
+		assert.Equal(t, server.SpanContext.SpanID(), attempt.Parent.SpanID())
+		assert.Contains(t, attempt.Attributes, tracing.AttemptKey.Int(i+1))
+	}
+	assert.Equal(t, codes.Error, spans[2].Status.Code)
+	assert.Contains(t, spans[2].Attributes, tracing.ErrorKindKey.String("connection_refused"))
+	assert.Equal(t, codes.Unset, spans[5].Status.Code)
+
+	assert.Equal(t, spans[2].SpanContext.SpanID(), spans[0].Parent.SpanID())
+	assert.Contains(t, spans[0].Attributes, tracing.BackendKey.String("http://localhost:64321"))
+	assert.Equal(t, spans[5].SpanContext.SpanID(), spans[4].Parent.SpanID())
+	assert.Contains(t, spans[3].Attributes, tracing.BackendKey.String(srv.URL))
+}
+
+func TestRetryMetrics(t *testing.T) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	lb, rt := newBufferMiddleware(t, `IsNetworkError() && Attempts() <= 2`)
+
+	registry := prometheus.NewRegistry()
+	m, err := prommetrics.NewMetrics(registry)
+	require.NoError(t, err)
+	mw, err := metrics.New(rt, m)
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(mw)
+	defer proxy.Close()
+
+	require.NoError(t, lb.UpsertServer(testutils.ParseURI("http://localhost:64321")))
+	require.NoError(t, lb.UpsertServer(testutils.ParseURI(srv.URL)))
+
+	re, body, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "hello", string(body))
+
+	expected := `
+		# HELP oxy_retries_total Number of retried requests by middleware.
+		# TYPE oxy_retries_total counter
+		oxy_retries_total{middleware="buffer"} 1
+		# HELP oxy_upstream_requests_total Number of requests forwarded upstream by backend, code and method.
+		# TYPE oxy_upstream_requests_total counter
+		oxy_upstream_requests_total{backend="http://localhost:64321",code="502",method="GET"} 1
+		oxy_upstream_requests_total{backend="` + srv.URL + `",code="200",method="GET"} 1
+	`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "oxy_retries_total", "oxy_upstream_requests_total"))
+
+	count, err := testutil.GatherAndCount(registry, "oxy_upstream_request_duration_seconds")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
@@ -0,0 +1,219 @@
+package buffer
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+
+	"github.com/mailgun/multibuf"
+	"github.com/vulcand/oxy/utils"
+)
+
+// DiskQuotaExceededError is returned when spooling a body would exceed the disk quota of the Spooler
+type DiskQuotaExceededError struct {
+	Quota int64
+}
+
+func (e *DiskQuotaExceededError) Error() string {
+	return fmt.Sprintf("spooling the body would exceed the disk quota of %d bytes", e.Quota)
+}
+
+// ErrorKind classifies the error as a disk quota exceeded, served as 503 like SizeErrHandler does
+func (e *DiskQuotaExceededError) ErrorKind() utils.ErrorKind {
+	return utils.ErrorKindDiskQuotaExceeded
+}
+
+// SpoolerOption is a functional option setter for Spooler
+type SpoolerOption func(*Spooler) error
+
+// SpoolDir sets the directory of the temporary files, it defaults to os.TempDir().
+func SpoolDir(dir string) SpoolerOption {
+	return func(s *Spooler) error {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		s.dir = dir
+		return nil
+	}
+}
+
+// DiskQuota sets the maximum size in bytes of the temporary files of all the in-flight bodies,
+// a body that would exceed it is rejected. There is no quota by default.
+func DiskQuota(bytes int64) SpoolerOption {
+	return func(s *Spooler) error {
+		if bytes < 0 {
+			return fmt.Errorf("quota should be >= 0 got %d", bytes)
+		}
+		s.quota = bytes
+		return nil
+	}
+}
+
+// SpoolStats holds the metrics of a Spooler
+type SpoolStats struct {
+	// MemoryBytes is the total number of bytes spooled in memory
+	MemoryBytes int64
+	// DiskBytes is the total number of bytes spooled to disk
+	DiskBytes int64
+	// DiskInUse is the number of bytes currently on disk
+	DiskInUse int64
+	// QuotaExceeded is the number of bodies rejected because of the disk quota
+	QuotaExceeded int64
+}
+
+// Spooler manages the temporary files of the buffered bodies that don't fit in memory:
+// their directory and a disk quota shared by all the in-flight bodies.
+// A Spooler can be shared by several middlewares.
+type Spooler struct {
+	// the counters are first to be 64-bit aligned
+	memoryBytes   int64
+	diskBytes     int64
+	diskInUse     int64
+	quotaExceeded int64
+
+	dir   string
+	quota int64
+}
+
+// defaultSpooler writes to os.TempDir() without quota
+var defaultSpooler = &Spooler{}
+
+// NewSpooler returns a new Spooler
+func NewSpooler(opts ...SpoolerOption) (*Spooler, error) {
+	s := &Spooler{}
+	for _, o := range opts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Stats returns the metrics of the spooler
+func (s *Spooler) Stats() SpoolStats {
+	return SpoolStats{
+		MemoryBytes:   atomic.LoadInt64(&s.memoryBytes),
+		DiskBytes:     atomic.LoadInt64(&s.diskBytes),
+		DiskInUse:     atomic.LoadInt64(&s.diskInUse),
+		QuotaExceeded: atomic.LoadInt64(&s.quotaExceeded),
+	}
+}
+
+// reserve reserves n bytes of the disk quota, it returns false if the quota would be exceeded
+func (s *Spooler) reserve(n int64) bool {
+	for {
+		inUse := atomic.LoadInt64(&s.diskInUse)
+		if s.quota > 0 && inUse+n > s.quota {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&s.diskInUse, inUse, inUse+n) {
+			return true
+		}
+	}
+}
+
+func (s *Spooler) release(n int64) {
+	atomic.AddInt64(&s.diskInUse, -n)
+}
+
+// newSpool returns a spool keeping up to memBytes in memory and up to maxBytes in total, a maxBytes <= 0 means no limit
+func (s *Spooler) newSpool(memBytes, maxBytes int64) *spool {
+	return &spool{spooler: s, memBytes: memBytes, maxBytes: maxBytes}
+}
+
+// spool keeps up to memBytes in memory and the rest in a temporary file, it can be read concurrently
+type spool struct {
+	spooler  *Spooler
+	memBytes int64
+	maxBytes int64
+
+	mem      []byte
+	file     *os.File
+	size     int64
+	reserved int64
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	if s.maxBytes > 0 && s.size+int64(len(p)) > s.maxBytes {
+		return 0, &multibuf.MaxSizeReachedError{MaxSize: s.maxBytes}
+	}
+	n := 0
+	if left := s.memBytes - int64(len(s.mem)); left > 0 {
+		if int64(len(p)) < left {
+			left = int64(len(p))
+		}
+		s.mem = append(s.mem, p[:left]...)
+		n = int(left)
+		s.size += left
+		atomic.AddInt64(&s.spooler.memoryBytes, left)
+	}
+	if n == len(p) {
+		return n, nil
+	}
+
+	if !s.spooler.reserve(int64(len(p) - n)) {
+		atomic.AddInt64(&s.spooler.quotaExceeded, 1)
+		return n, &DiskQuotaExceededError{Quota: s.spooler.quota}
+	}
+	s.reserved += int64(len(p) - n)
+	if s.file == nil {
+		file, err := ioutil.TempFile(s.spooler.dir, "oxy-buffer-")
+		if err != nil {
+			return n, err
+		}
+		s.file = file
+	}
+	written, err := s.file.Write(p[n:])
+	s.size += int64(written)
+	atomic.AddInt64(&s.spooler.diskBytes, int64(written))
+	return n + written, err
+}
+
+func (s *spool) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	if off < int64(len(s.mem)) {
+		n = copy(p, s.mem[off:])
+		if n == len(p) {
+			return n, nil
+		}
+	}
+	if s.file == nil {
+		return n, io.EOF
+	}
+	read, err := s.file.ReadAt(p[n:], off+int64(n)-int64(len(s.mem)))
+	return n + read, err
+}
+
+// diskBytes returns the number of bytes spooled to disk, 0 once closed
+func (s *spool) diskBytes() int64 {
+	if s.file == nil {
+		return 0
+	}
+	return s.size - int64(len(s.mem))
+}
+
+// Reader returns a reader of the spooled data, several readers can be used concurrently
+func (s *spool) Reader() *io.SectionReader {
+	return io.NewSectionReader(s, 0, s.size)
+}
+
+func (s *spool) Close() error {
+	s.mem = nil
+	if s.reserved > 0 {
+		s.spooler.release(s.reserved)
+		s.reserved = 0
+	}
+	if s.file == nil {
+		return nil
+	}
+	file := s.file
+	s.file = nil
+	file.Close()
+	return os.Remove(file.Name())
+}
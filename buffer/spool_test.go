@@ -0,0 +1,166 @@
+package buffer
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/testutils"
+	"github.com/vulcand/oxy/utils"
+)
+
+func TestSpool(t *testing.T) {
+	spooler, err := NewSpooler()
+	require.NoError(t, err)
+
+	s := spooler.newSpool(4, 0)
+	_, err = s.Write([]byte("hello, "))
+	require.NoError(t, err)
+	_, err = s.Write([]byte("world"))
+	require.NoError(t, err)
+
+	content, err := ioutil.ReadAll(s.Reader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(content))
+
+	assert.Equal(t, SpoolStats{MemoryBytes: 4, DiskBytes: 8, DiskInUse: 8}, spooler.Stats())
+
+	name := s.file.Name()
+	require.NoError(t, s.Close())
+	_, err = os.Stat(name)
+	assert.True(t, os.IsNotExist(err))
+	assert.EqualValues(t, 0, spooler.Stats().DiskInUse)
+}
+
+func TestSpoolDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oxy-spool-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	spooler, err := NewSpooler(SpoolDir(dir))
+	require.NoError(t, err)
+
+	var files []os.FileInfo
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		files, err = ioutil.ReadDir(dir)
+		require.NoError(t, err)
+		body, _ := ioutil.ReadAll(req.Body)
+		w.Write(body)
+	})
+
+	st, err := New(handler, Spool(spooler), MemRequestBodyBytes(4))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(st)
+	defer proxy.Close()
+
+	re, body, err := testutils.Post(proxy.URL, testutils.Body("this request is spooled to disk"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "this request is spooled to disk", string(body))
+
+	// the request body is in the spool directory while the request is served, and removed once done
+	assert.Len(t, files, 1)
+	files, err = ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestSpoolDirInvalid(t *testing.T) {
+	_, err := NewSpooler(SpoolDir("/does/not/exist"))
+	assert.Error(t, err)
+
+	file, err := ioutil.TempFile("", "oxy-spool-")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+	file.Close()
+
+	_, err = NewSpooler(SpoolDir(file.Name()))
+	assert.Error(t, err)
+}
+
+func TestDiskQuota(t *testing.T) {
+	spooler, err := NewSpooler(DiskQuota(20))
+	require.NoError(t, err)
+
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("block") != "" {
+			<-release
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		w.Write(body)
+	})
+
+	// the quota is shared by the buffers
+	first, err := New(handler, Spool(spooler), MemRequestBodyBytes(4), MemResponseBodyBytes(100))
+	require.NoError(t, err)
+	second, err := New(handler, Spool(spooler), MemRequestBodyBytes(4), MemResponseBodyBytes(100))
+	require.NoError(t, err)
+
+	firstProxy := httptest.NewServer(first)
+	defer firstProxy.Close()
+	secondProxy := httptest.NewServer(second)
+	defer secondProxy.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		re, body, err := testutils.Post(firstProxy.URL+"?block=1", testutils.Body(strings.Repeat("a", 20)))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, re.StatusCode)
+		assert.Equal(t, strings.Repeat("a", 20), string(body))
+	}()
+
+	assert.Eventually(t, func() bool {
+		return spooler.Stats().DiskInUse == 16
+	}, 5*time.Second, time.Millisecond)
+
+	re, _, err := testutils.Post(secondProxy.URL, testutils.Body(strings.Repeat("b", 20)))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, re.StatusCode)
+
+	close(release)
+	<-done
+
+	re, body, err := testutils.Post(secondProxy.URL, testutils.Body(strings.Repeat("b", 20)))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, strings.Repeat("b", 20), string(body))
+
+	stats := spooler.Stats()
+	assert.EqualValues(t, 0, stats.DiskInUse)
+	assert.EqualValues(t, 1, stats.QuotaExceeded)
+}
+
+func TestDiskQuotaErrorKind(t *testing.T) {
+	ue := utils.ClassifyError(&DiskQuotaExceededError{Quota: 20})
+	assert.Equal(t, utils.ErrorKindDiskQuotaExceeded, ue.Kind)
+	assert.Equal(t, http.StatusServiceUnavailable, ue.StatusCode())
+}
+
+func TestDiskQuotaResponse(t *testing.T) {
+	spooler, err := NewSpooler(DiskQuota(10))
+	require.NoError(t, err)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("this response does not fit in the quota"))
+	})
+
+	st, err := New(handler, Spool(spooler), MemResponseBodyBytes(4))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(st)
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, re.StatusCode)
+	assert.EqualValues(t, 0, spooler.Stats().DiskInUse)
+}
@@ -0,0 +1,159 @@
+package buffer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/mailgun/multibuf"
+	"github.com/vulcand/oxy/logging"
+	"github.com/vulcand/oxy/utils"
+)
+
+// peekRequestBody reads the request body up to the stream threshold, large tells if the body is larger
+// than the threshold and has to be streamed, the returned reader reads the whole body in any case
+func (b *Buffer) peekRequestBody(req *http.Request, body io.Reader, decoded bool) (io.Reader, bool, error) {
+	if !decoded && req.ContentLength > b.requestStreamThreshold {
+		return body, true, nil
+	}
+	head := &bytes.Buffer{}
+	_, err := io.CopyN(head, body, b.requestStreamThreshold+1)
+	if err == io.EOF {
+		return head, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return io.MultiReader(head, body), true, nil
+}
+
+// serveStreamedRequest passes the request to the next handler without buffering its body,
+// the limits are enforced while the body is read and the request is not retried
+func (b *Buffer) serveStreamedRequest(w http.ResponseWriter, req *http.Request, body io.Reader, maxBytes int64, decoded bool) {
+	if maxBytes > 0 {
+		body = &limitReader{r: body, max: maxBytes}
+	}
+	sb := &streamedBody{r: body}
+
+	size := req.ContentLength
+	if decoded {
+		// the next handler receives the decoded body
+		req = req.Clone(req.Context())
+		req.Header.Del("Content-Encoding")
+		size = -1
+	}
+	outreq := b.copyRequest(req, ioutil.NopCloser(sb), size)
+
+	bw := b.newBufferWriter(w, req)
+	defer bw.Close()
+
+	b.next.ServeHTTP(bw, outreq)
+	if bw.hijacked {
+		if b.log.Enabled(logging.DebugLevel) {
+			logging.Debug(utils.RequestLogger(b.log, req), "vulcand/oxy/buffer: connection was hijacked downstream, not taking any action in buffer")
+		}
+		return
+	}
+	if bw.streaming {
+		bw.finishStreaming()
+		return
+	}
+	if err := sb.error(); err != nil {
+		logging.Error(utils.RequestLogger(b.log, req), "vulcand/oxy/buffer: error when reading request body", logging.Err(err))
+		b.errHandler.ServeHTTP(w, req, err)
+		return
+	}
+	if bw.err != nil {
+		logging.Error(utils.RequestLogger(b.log, req), "vulcand/oxy/buffer: failed to buffer response", logging.Err(bw.err))
+		b.errHandler.ServeHTTP(w, req, bw.err)
+		return
+	}
+
+	b.writeResponse(w, outreq, bw)
+}
+
+// streamedBody records the errors reported to the client: the limits and decoding errors.
+// The body is read by the transport goroutines, hence the lock.
+type streamedBody struct {
+	r io.Reader
+
+	mu  sync.Mutex
+	err error
+}
+
+func (s *streamedBody) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && err != io.EOF {
+		var maxSizeErr *multibuf.MaxSizeReachedError
+		var decodeErr *DecodeError
+		if errors.As(err, &maxSizeErr) || errors.As(err, &decodeErr) {
+			s.mu.Lock()
+			if s.err == nil {
+				s.err = err
+			}
+			s.mu.Unlock()
+		}
+	}
+	return n, err
+}
+
+func (s *streamedBody) error() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// contentLength returns the Content-Length of the response, -1 if unknown
+func (b *bufferWriter) contentLength() int64 {
+	length, err := strconv.ParseInt(b.header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return length
+}
+
+// startStreaming sends the header and the buffered body to the client, the rest of the response is streamed
+func (b *bufferWriter) startStreaming() {
+	b.streaming = true
+	utils.CopyHeaders(b.responseWriter.Header(), b.header)
+	b.responseWriter.WriteHeader(b.code)
+	if _, err := io.Copy(b.responseWriter, b.buffer.Reader()); err != nil {
+		logging.Debug(b.log, "vulcand/oxy/buffer: failed to write response", logging.Err(err))
+	}
+}
+
+func (b *bufferWriter) stream(buf []byte) (int, error) {
+	if b.aborted {
+		return len(buf), nil
+	}
+	if b.maxBytes > 0 && b.length+int64(len(buf)) > b.maxBytes {
+		logging.Error(b.log, "vulcand/oxy/buffer: response body over limit, aborting", logging.Int64("limit", b.maxBytes))
+		b.aborted = true
+		return len(buf), nil
+	}
+	n, err := b.responseWriter.Write(buf)
+	b.length += int64(n)
+	return n, err
+}
+
+// finishStreaming aborts the connection if the streamed response was truncated,
+// the client must not mistake it for a complete one
+func (b *bufferWriter) finishStreaming() {
+	if b.aborted {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// Flush sends the streamed response to the client, the buffered responses are sent once complete.
+func (b *bufferWriter) Flush() {
+	if !b.streaming {
+		return
+	}
+	if f, ok := b.responseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
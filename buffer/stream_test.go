@@ -0,0 +1,188 @@
+package buffer
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/testutils"
+)
+
+func TestRequestStreamThreshold(t *testing.T) {
+	var attempts int32
+	var contentLength int64
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		atomic.StoreInt64(&contentLength, req.ContentLength)
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(http.StatusText(http.StatusBadGateway)))
+			return
+		}
+		w.Write(body)
+	})
+
+	st, err := New(handler, Retry(`IsNetworkError() && Attempts() <= 2`), RequestStreamThreshold(10))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(st)
+	defer proxy.Close()
+
+	testCases := []struct {
+		desc          string
+		body          string
+		chunked       bool
+		expectedCode  int
+		expectedCalls int32
+	}{
+		{
+			desc:          "buffered and retried",
+			body:          "small",
+			expectedCode:  http.StatusOK,
+			expectedCalls: 2,
+		},
+		{
+			desc:          "chunked, buffered and retried",
+			body:          "small",
+			chunked:       true,
+			expectedCode:  http.StatusOK,
+			expectedCalls: 2,
+		},
+		{
+			desc:          "streamed",
+			body:          "this request is streamed",
+			expectedCode:  http.StatusBadGateway,
+			expectedCalls: 1,
+		},
+		{
+			desc:          "chunked and streamed",
+			body:          "this request is streamed",
+			chunked:       true,
+			expectedCode:  http.StatusBadGateway,
+			expectedCalls: 1,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			atomic.StoreInt32(&attempts, 0)
+
+			body := ioutil.NopCloser(strings.NewReader(test.body))
+			req, err := http.NewRequest(http.MethodPost, proxy.URL, body)
+			require.NoError(t, err)
+			if !test.chunked {
+				req.ContentLength = int64(len(test.body))
+			}
+
+			re, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer re.Body.Close()
+
+			assert.Equal(t, test.expectedCode, re.StatusCode)
+			assert.Equal(t, test.expectedCalls, atomic.LoadInt32(&attempts))
+			if test.expectedCode == http.StatusOK {
+				respBody, err := ioutil.ReadAll(re.Body)
+				require.NoError(t, err)
+				assert.Equal(t, test.body, string(respBody))
+				assert.EqualValues(t, len(test.body), atomic.LoadInt64(&contentLength))
+			}
+		})
+	}
+}
+
+func TestRequestStreamThresholdLimitReached(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if _, err := ioutil.ReadAll(req.Body); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("hello"))
+	})
+
+	st, err := New(handler, RequestStreamThreshold(4), MaxRequestBodyBytes(10))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(st)
+	defer proxy.Close()
+
+	re, err := http.Post(proxy.URL, "text/plain", ioutil.NopCloser(strings.NewReader("this request is too long")))
+	require.NoError(t, err)
+	re.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, re.StatusCode)
+
+	re, body, err := testutils.Post(proxy.URL, testutils.Body("streamed"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestResponseStreamThreshold(t *testing.T) {
+	release := make(chan struct{})
+	var attempts int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadGateway)
+		if req.URL.Query().Get("large") == "" {
+			w.Write([]byte("small"))
+			return
+		}
+		w.Write([]byte("this response is streamed"))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte(" to the client"))
+	})
+
+	st, err := New(handler, Retry(`IsNetworkError() && Attempts() <= 2`), ResponseStreamThreshold(10))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(st)
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, re.StatusCode)
+	assert.Equal(t, "small", string(body))
+	assert.EqualValues(t, 3, atomic.LoadInt32(&attempts))
+
+	// the header is received before the handler completes
+	atomic.StoreInt32(&attempts, 0)
+	re, err = http.Get(proxy.URL + "?large=1")
+	require.NoError(t, err)
+	defer re.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, re.StatusCode)
+
+	close(release)
+	body, err = ioutil.ReadAll(re.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "this response is streamed to the client", string(body))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&attempts))
+}
+
+func TestResponseStreamThresholdLimitReached(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("this response is streamed"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte(" and too large"))
+	})
+
+	st, err := New(handler, ResponseStreamThreshold(10), MaxResponseBodyBytes(30))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(st)
+	defer proxy.Close()
+
+	re, err := http.Get(proxy.URL)
+	require.NoError(t, err)
+	defer re.Body.Close()
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+
+	_, err = ioutil.ReadAll(re.Body)
+	assert.Error(t, err)
+}
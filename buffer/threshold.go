@@ -3,7 +3,11 @@ package buffer
 import (
 	"fmt"
 	"net/http"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/vulcand/oxy/utils"
 	"github.com/vulcand/predicate"
 )
 
@@ -14,9 +18,25 @@ func IsValidExpression(expr string) bool {
 }
 
 type context struct {
-	r            *http.Request
-	attempt      int
-	responseCode int
+	r              *http.Request
+	attempt        int
+	responseCode   int
+	responseHeader http.Header
+	// start is the time of the first attempt
+	start time.Time
+	// errRecord holds the error of the last attempt, if any
+	errRecord *utils.ErrorRecord
+}
+
+// errorKind returns the kind of the error of the last attempt, or an empty string
+func (c *context) errorKind() utils.ErrorKind {
+	if c.errRecord == nil {
+		return ""
+	}
+	if err := c.errRecord.Get(); err != nil {
+		return err.Kind
+	}
+	return ""
 }
 
 type hpredicate func(*context) bool
@@ -35,10 +55,18 @@ func parseExpression(in string) (hpredicate, error) {
 			GE:  ge,
 		},
 		Functions: map[string]interface{}{
-			"RequestMethod":  requestMethod,
-			"IsNetworkError": isNetworkError,
-			"Attempts":       attempts,
-			"ResponseCode":   responseCode,
+			"RequestMethod":       requestMethod,
+			"RequestPath":         requestPath,
+			"RequestHeader":       requestHeader,
+			"ResponseHeader":      responseHeader,
+			"IsNetworkError":      isNetworkError,
+			"IsTimeoutError":      isTimeoutError,
+			"IsConnectionRefused": isConnectionRefused,
+			"Attempts":            attempts,
+			"ResponseCode":        responseCode,
+			"ElapsedMS":           elapsedMS,
+			"Matches":             matches,
+			"HasPrefix":           hasPrefix,
 		},
 	})
 	if err != nil {
@@ -65,6 +93,52 @@ func requestMethod() toString {
 	}
 }
 
+// RequestPath returns mapper of the request to its path e.g. /api/users
+func requestPath() toString {
+	return func(c *context) string {
+		return c.r.URL.Path
+	}
+}
+
+// RequestHeader returns mapper of the request to the value of its header
+func requestHeader(name string) toString {
+	return func(c *context) string {
+		return c.r.Header.Get(name)
+	}
+}
+
+// ResponseHeader returns mapper of the request to the value of the header of the last response
+func responseHeader(name string) toString {
+	return func(c *context) string {
+		return c.responseHeader.Get(name)
+	}
+}
+
+// ElapsedMS returns mapper of the request to the milliseconds elapsed since the first attempt
+func elapsedMS() toInt {
+	return func(c *context) int {
+		return int(time.Since(c.start) / time.Millisecond)
+	}
+}
+
+// Matches returns a predicate that returns true if the value of the mapper matches the regular expression
+func matches(m toString, expr string) (hpredicate, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return func(c *context) bool {
+		return re.MatchString(m(c))
+	}, nil
+}
+
+// HasPrefix returns a predicate that returns true if the value of the mapper starts with the prefix
+func hasPrefix(m toString, prefix string) hpredicate {
+	return func(c *context) bool {
+		return strings.HasPrefix(m(c), prefix)
+	}
+}
+
 // Attempts returns mapper of the request to the number of proxy attempts
 func attempts() toInt {
 	return func(c *context) int {
@@ -86,6 +160,23 @@ func isNetworkError() hpredicate {
 	}
 }
 
+// IsTimeoutError returns a predicate that returns true if last attempt timed out.
+func isTimeoutError() hpredicate {
+	return func(c *context) bool {
+		if kind := c.errorKind(); kind != "" {
+			return kind.StatusCode() == http.StatusGatewayTimeout
+		}
+		return c.responseCode == http.StatusGatewayTimeout
+	}
+}
+
+// IsConnectionRefused returns a predicate that returns true if the connection to the backend was refused in the last attempt.
+func isConnectionRefused() hpredicate {
+	return func(c *context) bool {
+		return c.errorKind() == utils.ErrorKindConnectionRefused
+	}
+}
+
 // and returns predicate by joining the passed predicates with logical 'and'
 func and(fns ...hpredicate) hpredicate {
 	return func(c *context) bool {
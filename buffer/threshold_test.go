@@ -0,0 +1,113 @@
+package buffer
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/utils"
+)
+
+func TestRetryPredicates(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/api/users", nil)
+	req.Header.Set("X-Retry", "yes")
+
+	refused := &utils.ErrorRecord{}
+	refused.Set(&utils.UpstreamError{Kind: utils.ErrorKindConnectionRefused, Err: errors.New("refused")})
+	timeout := &utils.ErrorRecord{}
+	timeout.Set(&utils.UpstreamError{Kind: utils.ErrorKindResponseHeaderTimeout, Err: errors.New("timeout")})
+
+	testCases := []struct {
+		desc     string
+		expr     string
+		ctx      context
+		expected bool
+	}{
+		{
+			desc:     "request path",
+			expr:     `RequestPath() == "/api/users"`,
+			expected: true,
+		},
+		{
+			desc:     "request path matches",
+			expr:     `Matches(RequestPath(), "^/api/[a-z]+$")`,
+			expected: true,
+		},
+		{
+			desc:     "request path has prefix",
+			expr:     `HasPrefix(RequestPath(), "/admin/")`,
+			expected: false,
+		},
+		{
+			desc:     "request header",
+			expr:     `RequestHeader("X-Retry") == "yes"`,
+			expected: true,
+		},
+		{
+			desc:     "response header",
+			expr:     `ResponseHeader("X-Backend") != "down"`,
+			ctx:      context{responseHeader: http.Header{"X-Backend": {"down"}}},
+			expected: false,
+		},
+		{
+			desc:     "connection refused",
+			expr:     `IsConnectionRefused()`,
+			ctx:      context{responseCode: http.StatusBadGateway, errRecord: refused},
+			expected: true,
+		},
+		{
+			desc:     "not connection refused",
+			expr:     `IsConnectionRefused()`,
+			ctx:      context{responseCode: http.StatusBadGateway, errRecord: &utils.ErrorRecord{}},
+			expected: false,
+		},
+		{
+			desc:     "timeout error",
+			expr:     `IsTimeoutError()`,
+			ctx:      context{responseCode: http.StatusGatewayTimeout, errRecord: timeout},
+			expected: true,
+		},
+		{
+			desc:     "timeout error without record",
+			expr:     `IsTimeoutError()`,
+			ctx:      context{responseCode: http.StatusGatewayTimeout},
+			expected: true,
+		},
+		{
+			desc:     "timeout error with a different error",
+			expr:     `IsTimeoutError()`,
+			ctx:      context{responseCode: http.StatusBadGateway, errRecord: refused},
+			expected: false,
+		},
+		{
+			desc:     "elapsed",
+			expr:     `ElapsedMS() < 1000`,
+			ctx:      context{start: time.Now().Add(-2 * time.Second)},
+			expected: false,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := parseExpression(test.expr)
+			require.NoError(t, err)
+
+			ctx := test.ctx
+			ctx.r = req
+			assert.Equal(t, test.expected, p(&ctx))
+		})
+	}
+}
+
+func TestRetryPredicatesInvalid(t *testing.T) {
+	assert.False(t, IsValidExpression(`Matches(RequestPath(), "[")`))
+	assert.False(t, IsValidExpression(`RequestHeader() == "a"`))
+	assert.False(t, IsValidExpression(`IsConnectionRefused() == 1`))
+}
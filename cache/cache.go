@@ -0,0 +1,557 @@
+/*
+Package cache provides http.Handler middleware caching the responses of the next handler
+following the HTTP caching semantics of RFC 9111:
+
+Freshness is computed from the Cache-Control (s-maxage, max-age), Expires and Last-Modified headers.
+Stale responses are revalidated with conditional requests using ETag and Last-Modified.
+The request directives no-cache, no-store, max-age, max-stale, min-fresh and only-if-cached are honored.
+Responses are served stale while being revalidated in the background (stale-while-revalidate)
+or when the backend fails (stale-if-error).
+Variants selected by the Vary header are stored separately.
+Unsafe requests (POST, PUT, DELETE...) invalidate the cached responses of their URL.
+
+The responses are kept in a pluggable Storage, an in-memory LRU and a disk storage are provided.
+
+Examples of a caching middleware:
+
+	// caches up to 64MB of responses in memory
+	storage, _ := cache.NewLRU(64 * 1024 * 1024)
+	cache.New(handler, cache.Store(storage))
+
+	// caches up to 1GB of responses on disk, capturing the bodies in memory up to 1MB
+	storage, _ := cache.NewDisk("/var/cache/oxy", 1024 * 1024 * 1024)
+	cache.New(handler, cache.Store(storage), cache.MemBodyBytes(1024 * 1024))
+*/
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mailgun/multibuf"
+	"github.com/mailgun/timetools"
+	"github.com/vulcand/oxy/buffer"
+	"github.com/vulcand/oxy/logging"
+	"github.com/vulcand/oxy/utils"
+)
+
+// XCache is the header telling how the response was served
+const XCache = "X-Cache"
+
+// Values of the X-Cache header
+const (
+	StatusHit         = "HIT"
+	StatusMiss        = "MISS"
+	StatusStale       = "STALE"
+	StatusRevalidated = "REVALIDATED"
+)
+
+// Option is a functional option setter for Cache
+type Option func(*Cache) error
+
+// Store sets the storage of the responses, it is required
+func Store(s Storage) Option {
+	return func(c *Cache) error {
+		c.storage = s
+		return nil
+	}
+}
+
+// Private makes the cache private to a single user, the responses with Cache-Control: private are stored
+// and s-maxage is ignored. The cache is shared by default, a shared cache does not store the responses setting cookies.
+func Private() Option {
+	return func(c *Cache) error {
+		c.shared = false
+		return nil
+	}
+}
+
+// Key sets the function computing the cache key of a request, it defaults to the scheme, host and request URI
+func Key(fn func(*http.Request) string) Option {
+	return func(c *Cache) error {
+		c.key = fn
+		return nil
+	}
+}
+
+// MemBodyBytes sets the maximum size of a response body captured in memory before storing it,
+// the excess is captured in a temporary file. It defaults to buffer.DefaultMemBodyBytes.
+func MemBodyBytes(m int64) Option {
+	return func(c *Cache) error {
+		if m < 0 {
+			return fmt.Errorf("mem bytes should be >= 0 got %d", m)
+		}
+		c.memBodyBytes = m
+		return nil
+	}
+}
+
+// MaxBodyBytes sets the maximum size of a stored response body, larger responses are not stored.
+// It defaults to buffer.DefaultMaxBodyBytes, no limit.
+func MaxBodyBytes(m int64) Option {
+	return func(c *Cache) error {
+		if m < 0 {
+			return fmt.Errorf("max bytes should be >= 0 got %d", m)
+		}
+		c.maxBodyBytes = m
+		return nil
+	}
+}
+
+// Clock sets the clock
+func Clock(clock timetools.TimeProvider) Option {
+	return func(c *Cache) error {
+		c.clock = clock
+		return nil
+	}
+}
+
+// Logger defines the logger the cache will use.
+//
+// It defaults to logging.Default().
+func Logger(l logging.Logger) Option {
+	return func(c *Cache) error {
+		c.log = l
+		return nil
+	}
+}
+
+// Cache is a middleware caching the responses of the next handler
+type Cache struct {
+	next    http.Handler
+	storage Storage
+	shared  bool
+	key     func(*http.Request) string
+	clock   timetools.TimeProvider
+
+	memBodyBytes int64
+	maxBodyBytes int64
+
+	mu           sync.Mutex
+	revalidating map[string]bool
+
+	log logging.Logger
+}
+
+// New creates a new caching middleware
+func New(next http.Handler, opts ...Option) (*Cache, error) {
+	c := &Cache{
+		next:         next,
+		shared:       true,
+		key:          defaultKey,
+		clock:        &timetools.RealTime{},
+		memBodyBytes: buffer.DefaultMemBodyBytes,
+		maxBodyBytes: buffer.DefaultMaxBodyBytes,
+		revalidating: make(map[string]bool),
+
+		log: logging.Default(),
+	}
+	for _, o := range opts {
+		if err := o(c); err != nil {
+			return nil, err
+		}
+	}
+	if c.storage == nil {
+		return nil, errors.New("a storage is required")
+	}
+	return c, nil
+}
+
+// Wrap sets the next handler to be called by cache handler.
+func (c *Cache) Wrap(next http.Handler) error {
+	c.next = next
+	return nil
+}
+
+func (c *Cache) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if c.log.Enabled(logging.DebugLevel) {
+		logger := utils.RequestLogger(c.log, req).With(utils.RequestField(req))
+		logging.Debug(logger, "vulcand/oxy/cache: begin ServeHttp on request")
+		defer logging.Debug(logger, "vulcand/oxy/cache: completed ServeHttp on request")
+	}
+
+	key := c.key(req)
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		c.serveUnsafe(w, req, key)
+		return
+	}
+
+	entry, body, err := c.lookup(key, req)
+	if err != nil {
+		if err != ErrNotFound {
+			logging.Error(utils.RequestLogger(c.log, req), "vulcand/oxy/cache: failed to read entry", logging.String("key", key), logging.Err(err))
+		}
+		if parseCacheControl(req.Header).has("only-if-cached") {
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		c.fetch(w, req, key)
+		return
+	}
+	defer body.Close()
+
+	now := c.clock.UtcNow()
+	f := newFreshness(req, entry, c.shared, now)
+	revalidate := mustRevalidate(req, entry)
+
+	switch {
+	case f.fresh && !revalidate:
+		c.serve(w, req, entry, body, f.age, StatusHit)
+	case !revalidate && canServeStale(req, entry, f, c.shared):
+		c.serve(w, req, entry, body, f.age, StatusStale)
+	case parseCacheControl(req.Header).has("only-if-cached"):
+		w.WriteHeader(http.StatusGatewayTimeout)
+	case !revalidate && staleWithin(req, entry, f, "stale-while-revalidate", c.shared):
+		c.serve(w, req, entry, body, f.age, StatusStale)
+		c.revalidateInBackground(req, key, entry)
+	default:
+		c.revalidate(w, req, key, entry, body, f)
+	}
+}
+
+// serveUnsafe forwards the request and invalidates the stored responses of the URL on success, see RFC 9111 #4.4
+func (c *Cache) serveUnsafe(w http.ResponseWriter, req *http.Request, key string) {
+	pw := utils.NewProxyWriterWithLogger(w, c.log)
+	c.next.ServeHTTP(pw, req)
+	if code := pw.StatusCode(); code < 400 {
+		if err := c.storage.Delete(key); err != nil {
+			logging.Error(utils.RequestLogger(c.log, req), "vulcand/oxy/cache: failed to invalidate", logging.String("key", key), logging.Err(err))
+		}
+	}
+}
+
+// fetch forwards the request, writing the response through to the client while storing it
+func (c *Cache) fetch(w http.ResponseWriter, req *http.Request, key string) {
+	requestTime := c.clock.UtcNow()
+	rec := newTeeRecorder(w, c.memBodyBytes, c.maxBodyBytes,
+		func(code int, header http.Header) bool {
+			return storable(req, code, header, c.shared)
+		},
+		func(header http.Header) {
+			header.Set(XCache, StatusMiss)
+		})
+	defer rec.close()
+
+	c.next.ServeHTTP(rec, req)
+	if rec.captured() {
+		c.store(key, req, rec, requestTime)
+	}
+}
+
+// revalidate validates the stored response with the next handler, see RFC 9111 #4.3
+func (c *Cache) revalidate(w http.ResponseWriter, req *http.Request, key string, entry *Entry, body io.Reader, f freshness) {
+	requestTime := c.clock.UtcNow()
+	rec := c.validate(req, entry)
+	defer rec.close()
+
+	if rec.code == http.StatusNotModified && hasValidators(entry) {
+		// the stored body is both stored again and served
+		spooled, err := multibuf.New(body, multibuf.MemBytes(c.memBodyBytes))
+		if err != nil {
+			logging.Error(utils.RequestLogger(c.log, req), "vulcand/oxy/cache: failed to read entry", logging.String("key", key), logging.Err(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		defer spooled.Close()
+
+		updated := c.refresh(key, req, entry, spooled, rec.header, requestTime)
+		if _, err := spooled.Seek(0, io.SeekStart); err != nil {
+			logging.Error(utils.RequestLogger(c.log, req), "vulcand/oxy/cache: failed to rewind entry", logging.String("key", key), logging.Err(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		c.serve(w, req, updated, spooled, currentAge(updated, c.clock.UtcNow()), StatusRevalidated)
+		return
+	}
+
+	if isServerError(rec.code) && staleWithin(req, entry, f, "stale-if-error", c.shared) {
+		if c.log.Enabled(logging.DebugLevel) {
+			logging.Debug(utils.RequestLogger(c.log, req), "vulcand/oxy/cache: serving stale entry on error",
+				logging.String("key", key), logging.Int("code", rec.code))
+		}
+		c.serve(w, req, entry, body, f.age, StatusStale)
+		return
+	}
+
+	c.serveRecorded(w, req, key, rec, requestTime, StatusMiss)
+}
+
+// revalidateInBackground refreshes the stored response, only one revalidation per key runs at once
+func (c *Cache) revalidateInBackground(req *http.Request, key string, entry *Entry) {
+	c.mu.Lock()
+	if c.revalidating[key] {
+		c.mu.Unlock()
+		return
+	}
+	c.revalidating[key] = true
+	c.mu.Unlock()
+
+	outReq := req.Clone(context.Background())
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.revalidating, key)
+			c.mu.Unlock()
+		}()
+
+		requestTime := c.clock.UtcNow()
+		rec := c.validate(outReq, entry)
+		defer rec.close()
+
+		if rec.code == http.StatusNotModified && hasValidators(entry) {
+			_, body, err := c.lookup(key, outReq)
+			if err != nil {
+				return
+			}
+			defer body.Close()
+			c.refresh(key, outReq, entry, body, rec.header, requestTime)
+			return
+		}
+		if rec.captured() && storable(outReq, rec.code, rec.header, c.shared) {
+			c.store(key, outReq, rec, requestTime)
+		}
+	}()
+}
+
+// validate sends a conditional request to the next handler and records the response
+func (c *Cache) validate(req *http.Request, entry *Entry) *recorder {
+	outReq := req.Clone(req.Context())
+	outReq.Method = http.MethodGet
+	if etag := entry.Header.Get("ETag"); etag != "" {
+		outReq.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+		outReq.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	rec := newRecorder(c.memBodyBytes)
+	c.next.ServeHTTP(rec, outReq)
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	return rec
+}
+
+// serveRecorded writes a recorded response to the client and stores it if possible
+func (c *Cache) serveRecorded(w http.ResponseWriter, req *http.Request, key string, rec *recorder, requestTime time.Time, status string) {
+	body, size, err := rec.body()
+	if err != nil {
+		logging.Error(utils.RequestLogger(c.log, req), "vulcand/oxy/cache: failed to read response", logging.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer body.Close()
+
+	if storable(req, rec.code, rec.header, c.shared) && (c.maxBodyBytes <= 0 || size <= c.maxBodyBytes) {
+		c.storeBody(key, req, rec.code, rec.header, body, size, requestTime)
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			logging.Error(utils.RequestLogger(c.log, req), "vulcand/oxy/cache: failed to rewind response", logging.Err(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if req.Method == http.MethodHead {
+		body = emptyBody{}
+	}
+	if err := rec.replay(w, http.Header{XCache: {status}}, body); err != nil {
+		logging.Error(utils.RequestLogger(c.log, req), "vulcand/oxy/cache: failed to write response", logging.Err(err))
+	}
+}
+
+// store saves the captured response
+func (c *Cache) store(key string, req *http.Request, rec *recorder, requestTime time.Time) {
+	body, size, err := rec.body()
+	if err != nil {
+		logging.Error(utils.RequestLogger(c.log, req), "vulcand/oxy/cache: failed to read response", logging.Err(err))
+		return
+	}
+	defer body.Close()
+	c.storeBody(key, req, rec.code, rec.header, body, size, requestTime)
+}
+
+func (c *Cache) storeBody(key string, req *http.Request, code int, header http.Header, body io.Reader, size int64, requestTime time.Time) {
+	entry := &Entry{
+		StatusCode:   code,
+		Header:       header.Clone(),
+		RequestTime:  requestTime,
+		ResponseTime: c.clock.UtcNow(),
+		Size:         size,
+	}
+	entry.Header.Del(XCache)
+	c.put(key, req, entry, body)
+}
+
+// refresh updates the stored response with the header of a 304 response, see RFC 9111 #4.3.4
+func (c *Cache) refresh(key string, req *http.Request, entry *Entry, body io.Reader, header http.Header, requestTime time.Time) *Entry {
+	updated := *entry
+	updated.Header = entry.Header.Clone()
+	for k, vv := range header {
+		if k == "Content-Length" || k == XCache || (c.shared && k == "Set-Cookie") {
+			continue
+		}
+		updated.Header[k] = vv
+	}
+	updated.RequestTime = requestTime
+	updated.ResponseTime = c.clock.UtcNow()
+	c.put(key, req, &updated, body)
+	return &updated
+}
+
+// put stores the entry, under a variant key if the response varies on request headers
+func (c *Cache) put(key string, req *http.Request, entry *Entry, body io.Reader) {
+	if vary := varyFields(entry.Header); len(vary) > 0 {
+		marker, err := c.varyMarker(key, vary)
+		if err != nil {
+			logging.Error(utils.RequestLogger(c.log, req), "vulcand/oxy/cache: failed to store entry", logging.String("key", key), logging.Err(err))
+			return
+		}
+		key = variantKey(key, marker, req)
+	}
+	if err := c.storage.Set(key, entry, body); err != nil {
+		logging.Error(utils.RequestLogger(c.log, req), "vulcand/oxy/cache: failed to store entry", logging.String("key", key), logging.Err(err))
+	}
+}
+
+// varyMarker returns the entry pointing to the variants of key, it is created if missing or if the
+// header list changed. The variants of a previous marker are not reachable anymore.
+func (c *Cache) varyMarker(key string, vary []string) (*Entry, error) {
+	marker, body, err := c.storage.Get(key)
+	if err == nil {
+		body.Close()
+		if strings.Join(marker.Vary, ",") == strings.Join(vary, ",") {
+			return marker, nil
+		}
+	} else if err != ErrNotFound {
+		return nil, err
+	}
+	marker = &Entry{Vary: vary, ResponseTime: c.clock.UtcNow()}
+	if err := c.storage.Set(key, marker, emptyBody{}); err != nil {
+		return nil, err
+	}
+	return marker, nil
+}
+
+// lookup returns the stored response matching the request
+func (c *Cache) lookup(key string, req *http.Request) (*Entry, io.ReadCloser, error) {
+	entry, body, err := c.storage.Get(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(entry.Vary) > 0 {
+		body.Close()
+		entry, body, err = c.storage.Get(variantKey(key, entry, req))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return entry, body, nil
+}
+
+// serve writes a stored response to the client
+func (c *Cache) serve(w http.ResponseWriter, req *http.Request, entry *Entry, body io.Reader, age time.Duration, status string) {
+	utils.CopyHeaders(w.Header(), entry.Header)
+	w.Header().Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	w.Header().Set(XCache, status)
+
+	if notModified(req, entry) {
+		w.Header().Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(entry.StatusCode)
+	if req.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		logging.Error(utils.RequestLogger(c.log, req), "vulcand/oxy/cache: failed to write response", logging.Err(err))
+	}
+}
+
+// notModified evaluates the conditional headers of the client request against the stored response
+func notModified(req *http.Request, entry *Entry) bool {
+	if entry.StatusCode != http.StatusOK {
+		return false
+	}
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(entry.Header.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims, err := http.ParseTime(req.Header.Get("If-Modified-Since")); err == nil {
+		lastModified, err := http.ParseTime(entry.Header.Get("Last-Modified"))
+		return err == nil && !lastModified.After(ims)
+	}
+	return false
+}
+
+func hasValidators(entry *Entry) bool {
+	return entry.Header.Get("ETag") != "" || entry.Header.Get("Last-Modified") != ""
+}
+
+func isServerError(code int) bool {
+	switch code {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// varyFields returns the sorted canonical names of the headers listed in Vary
+func varyFields(header http.Header) []string {
+	var fields []string
+	for _, value := range header["Vary"] {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				fields = append(fields, http.CanonicalHeaderKey(field))
+			}
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// variantKey returns the key of the variant matching the request
+func variantKey(key string, marker *Entry, req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(key)
+	b.WriteString("\x00")
+	b.WriteString(strconv.FormatInt(marker.ResponseTime.UnixNano(), 10))
+	for _, field := range marker.Vary {
+		b.WriteString("\x00")
+		b.WriteString(field)
+		b.WriteString(":")
+		values := make([]string, 0, len(req.Header[field]))
+		for _, v := range req.Header[field] {
+			values = append(values, strings.Join(strings.Fields(v), " "))
+		}
+		b.WriteString(strings.Join(values, ","))
+	}
+	return b.String()
+}
+
+func defaultKey(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + req.URL.RequestURI()
+}
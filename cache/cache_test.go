@@ -0,0 +1,438 @@
+package cache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/testutils"
+)
+
+func newTestCache(t *testing.T, handler http.HandlerFunc, opts ...Option) (*httptest.Server, *Cache) {
+	t.Helper()
+
+	storage, err := NewLRU(1024 * 1024)
+	require.NoError(t, err)
+
+	c, err := New(handler, append([]Option{Store(storage), Clock(testutils.GetClock())}, opts...)...)
+	require.NoError(t, err)
+
+	return httptest.NewServer(c), c
+}
+
+func advance(c *Cache, d time.Duration) {
+	c.clock.Sleep(d)
+}
+
+func TestCacheHit(t *testing.T) {
+	var calls int32
+	srv, c := newTestCache(t, func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	re, body, err := testutils.Get(srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, StatusMiss, re.Header.Get(XCache))
+	assert.Equal(t, "hello", string(body))
+
+	advance(c, 10*time.Second)
+
+	re, body, err = testutils.Get(srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, StatusHit, re.Header.Get(XCache))
+	assert.Equal(t, "10", re.Header.Get("Age"))
+	assert.Equal(t, "hello", string(body))
+
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+}
+
+func TestCacheHead(t *testing.T) {
+	var calls int32
+	srv, _ := newTestCache(t, func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	_, _, err := testutils.Get(srv.URL)
+	require.NoError(t, err)
+
+	re, body, err := testutils.MakeRequest(srv.URL, testutils.Method(http.MethodHead))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, StatusHit, re.Header.Get(XCache))
+	assert.Empty(t, body)
+
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+}
+
+func TestCacheNotStored(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		header  string
+		cookie  string
+		request []testutils.ReqOption
+		status  int
+	}{
+		{
+			desc:   "no-store",
+			header: "no-store",
+			status: http.StatusOK,
+		},
+		{
+			desc:   "private",
+			header: "private, max-age=60",
+			status: http.StatusOK,
+		},
+		{
+			desc:   "no freshness information",
+			status: http.StatusCreated,
+		},
+		{
+			desc:    "request no-store",
+			header:  "max-age=60",
+			request: []testutils.ReqOption{testutils.Header("Cache-Control", "no-store")},
+			status:  http.StatusOK,
+		},
+		{
+			desc:    "authorization",
+			header:  "max-age=60",
+			request: []testutils.ReqOption{testutils.BasicAuth("user", "pass")},
+			status:  http.StatusOK,
+		},
+		{
+			desc:   "set-cookie",
+			header: "max-age=60",
+			cookie: "session=abc",
+			status: http.StatusOK,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			var calls int32
+			srv, _ := newTestCache(t, func(w http.ResponseWriter, req *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				if test.header != "" {
+					w.Header().Set("Cache-Control", test.header)
+				}
+				if test.cookie != "" {
+					w.Header().Set("Set-Cookie", test.cookie)
+				}
+				w.WriteHeader(test.status)
+				_, _ = w.Write([]byte("hello"))
+			})
+			defer srv.Close()
+
+			for i := 0; i < 2; i++ {
+				re, body, err := testutils.Get(srv.URL, test.request...)
+				require.NoError(t, err)
+				assert.Equal(t, test.status, re.StatusCode)
+				assert.Equal(t, StatusMiss, re.Header.Get(XCache))
+				assert.Equal(t, "hello", string(body))
+			}
+
+			assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+		})
+	}
+}
+
+func TestCacheRevalidate(t *testing.T) {
+	var calls, notModified int32
+	srv, c := newTestCache(t, func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=10")
+		w.Header().Set("ETag", `"v1"`)
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	_, _, err := testutils.Get(srv.URL)
+	require.NoError(t, err)
+
+	advance(c, 20*time.Second)
+
+	re, body, err := testutils.Get(srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, StatusRevalidated, re.Header.Get(XCache))
+	assert.Equal(t, "0", re.Header.Get("Age"))
+	assert.Equal(t, "hello", string(body))
+
+	// the refreshed response is fresh again
+	re, body, err = testutils.Get(srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, StatusHit, re.Header.Get(XCache))
+	assert.Equal(t, "hello", string(body))
+
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&notModified))
+}
+
+func TestCacheRevalidateChanged(t *testing.T) {
+	var version int32 = 1
+	srv, c := newTestCache(t, func(w http.ResponseWriter, req *http.Request) {
+		v := atomic.LoadInt32(&version)
+		etag := fmt.Sprintf(`"v%d"`, v)
+		w.Header().Set("Cache-Control", "max-age=10")
+		w.Header().Set("ETag", etag)
+		if req.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "version %d", v)
+	})
+	defer srv.Close()
+
+	_, _, err := testutils.Get(srv.URL)
+	require.NoError(t, err)
+
+	advance(c, 20*time.Second)
+	atomic.StoreInt32(&version, 2)
+
+	re, body, err := testutils.Get(srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, StatusMiss, re.Header.Get(XCache))
+	assert.Equal(t, "version 2", string(body))
+
+	re, body, err = testutils.Get(srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, StatusHit, re.Header.Get(XCache))
+	assert.Equal(t, "version 2", string(body))
+}
+
+func TestCacheNoCache(t *testing.T) {
+	var calls int32
+	srv, _ := newTestCache(t, func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	_, _, err := testutils.Get(srv.URL)
+	require.NoError(t, err)
+
+	re, _, err := testutils.Get(srv.URL, testutils.Header("Cache-Control", "no-cache"))
+	require.NoError(t, err)
+	assert.Equal(t, StatusMiss, re.Header.Get(XCache))
+
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+}
+
+func TestCacheVary(t *testing.T) {
+	var calls int32
+	srv, _ := newTestCache(t, func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		_, _ = w.Write([]byte(req.Header.Get("Accept-Language")))
+	})
+	defer srv.Close()
+
+	for _, lang := range []string{"en", "fr", "en", "fr"} {
+		re, body, err := testutils.Get(srv.URL, testutils.Header("Accept-Language", lang))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, re.StatusCode)
+		assert.Equal(t, lang, string(body))
+	}
+
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	var calls int32
+	srv, c := newTestCache(t, func(w http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=60")
+		_, _ = fmt.Fprintf(w, "call %d", n)
+	})
+	defer srv.Close()
+
+	_, _, err := testutils.Get(srv.URL)
+	require.NoError(t, err)
+
+	advance(c, 20*time.Second)
+
+	re, body, err := testutils.Get(srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, StatusStale, re.Header.Get(XCache))
+	assert.Equal(t, "call 1", string(body))
+
+	assert.Eventually(t, func() bool {
+		re, body, err := testutils.Get(srv.URL)
+		return err == nil && re.Header.Get(XCache) == StatusHit && string(body) == "call 2"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestCacheStaleIfError(t *testing.T) {
+	var failing int32
+	srv, c := newTestCache(t, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=10, stale-if-error=60")
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	_, _, err := testutils.Get(srv.URL)
+	require.NoError(t, err)
+
+	atomic.StoreInt32(&failing, 1)
+	advance(c, 20*time.Second)
+
+	re, body, err := testutils.Get(srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, StatusStale, re.Header.Get(XCache))
+	assert.Equal(t, "hello", string(body))
+
+	// past the stale-if-error window the error is returned
+	advance(c, time.Minute)
+
+	re, _, err = testutils.Get(srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, re.StatusCode)
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	var calls int32
+	srv, _ := newTestCache(t, func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte(req.Method))
+	})
+	defer srv.Close()
+
+	_, _, err := testutils.Get(srv.URL)
+	require.NoError(t, err)
+
+	re, body, err := testutils.Post(srv.URL, testutils.Body("data"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, http.MethodPost, string(body))
+
+	re, _, err = testutils.Get(srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, StatusMiss, re.Header.Get(XCache))
+
+	assert.EqualValues(t, 3, atomic.LoadInt32(&calls))
+}
+
+func TestCacheOnlyIfCached(t *testing.T) {
+	srv, _ := newTestCache(t, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL, testutils.Header("Cache-Control", "only-if-cached"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusGatewayTimeout, re.StatusCode)
+
+	_, _, err = testutils.Get(srv.URL)
+	require.NoError(t, err)
+
+	re, body, err := testutils.Get(srv.URL, testutils.Header("Cache-Control", "only-if-cached"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestCacheClientConditional(t *testing.T) {
+	srv, _ := newTestCache(t, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	_, _, err := testutils.Get(srv.URL)
+	require.NoError(t, err)
+
+	re, body, err := testutils.Get(srv.URL, testutils.Header("If-None-Match", `W/"v1"`))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotModified, re.StatusCode)
+	assert.Equal(t, StatusHit, re.Header.Get(XCache))
+	assert.Empty(t, body)
+}
+
+func TestCacheMaxBodyBytes(t *testing.T) {
+	var calls int32
+	srv, _ := newTestCache(t, func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte(strings.Repeat("a", 100)))
+	}, MemBodyBytes(10), MaxBodyBytes(50))
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		re, body, err := testutils.Get(srv.URL)
+		require.NoError(t, err)
+		assert.Equal(t, StatusMiss, re.Header.Get(XCache))
+		assert.Len(t, body, 100)
+	}
+
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+}
+
+func TestCacheDiskLargeBody(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oxy-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	storage, err := NewDisk(dir, 1024*1024)
+	require.NoError(t, err)
+
+	var calls int32
+	content := strings.Repeat("hello", 1000)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte(content))
+	})
+
+	c, err := New(handler, Store(storage), MemBodyBytes(100))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	for _, status := range []string{StatusMiss, StatusHit} {
+		re, body, err := testutils.Get(srv.URL)
+		require.NoError(t, err)
+		assert.Equal(t, status, re.Header.Get(XCache))
+		assert.Equal(t, content, string(body))
+	}
+
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+	assert.Equal(t, 1, storage.Len())
+}
+
+func TestNewWithoutStorage(t *testing.T) {
+	_, err := New(http.NotFoundHandler())
+	assert.Error(t, err)
+}
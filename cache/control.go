@@ -0,0 +1,249 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheControl holds the directives of a Cache-Control header, the names are lower cased
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, value := range h[http.CanonicalHeaderKey("Cache-Control")] {
+		for _, directive := range splitDirectives(value) {
+			name, arg := directive, ""
+			if i := strings.Index(directive, "="); i >= 0 {
+				name, arg = directive[:i], strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+			}
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			cc[name] = arg
+		}
+	}
+	return cc
+}
+
+// splitDirectives splits a Cache-Control value on the commas not enclosed in quotes
+func splitDirectives(value string) []string {
+	var out []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				out = append(out, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(out, value[start:])
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds returns the delta-seconds argument of a directive
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	arg, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	s, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || s < 0 {
+		// invalid values are treated as stale, see RFC 9111 #4.2.1
+		return 0, true
+	}
+	return time.Duration(s) * time.Second, true
+}
+
+// statusCacheableByDefault lists the status codes heuristically cacheable, see RFC 9110 #15.1
+var statusCacheableByDefault = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// storable tells if the response can be stored, see RFC 9111 #3
+func storable(req *http.Request, statusCode int, header http.Header, shared bool) bool {
+	if req.Method != http.MethodGet {
+		return false
+	}
+	if statusCode == http.StatusPartialContent || statusCode == http.StatusNotModified {
+		return false
+	}
+
+	reqCC := parseCacheControl(req.Header)
+	respCC := parseCacheControl(header)
+	if reqCC.has("no-store") || respCC.has("no-store") {
+		return false
+	}
+	if shared && respCC.has("private") {
+		return false
+	}
+	// the cookies set for a client must not be replayed to the others
+	if shared && header.Get("Set-Cookie") != "" {
+		return false
+	}
+	if shared && req.Header.Get("Authorization") != "" &&
+		!respCC.has("public") && !respCC.has("s-maxage") && !respCC.has("must-revalidate") {
+		return false
+	}
+	if strings.TrimSpace(header.Get("Vary")) == "*" {
+		return false
+	}
+
+	if header.Get("Expires") != "" || respCC.has("max-age") || respCC.has("public") ||
+		(shared && respCC.has("s-maxage")) {
+		return true
+	}
+	return statusCacheableByDefault[statusCode]
+}
+
+// freshnessLifetime returns how long the response is fresh, see RFC 9111 #4.2.1
+func freshnessLifetime(e *Entry, shared bool) time.Duration {
+	cc := parseCacheControl(e.Header)
+	if shared {
+		if d, ok := cc.seconds("s-maxage"); ok {
+			return d
+		}
+	}
+	if d, ok := cc.seconds("max-age"); ok {
+		return d
+	}
+
+	date := dateValue(e)
+	if v := e.Header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil || expires.Before(date) {
+			return 0
+		}
+		return expires.Sub(date)
+	}
+
+	// heuristic freshness, 10% of the time since the last modification, see RFC 9111 #4.2.2
+	if lastModified, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil &&
+		statusCacheableByDefault[e.StatusCode] && lastModified.Before(date) {
+		heuristic := date.Sub(lastModified) / 10
+		if heuristic > maxHeuristicFreshness {
+			heuristic = maxHeuristicFreshness
+		}
+		return heuristic
+	}
+	return 0
+}
+
+const maxHeuristicFreshness = 24 * time.Hour
+
+// currentAge returns the age of the response, see RFC 9111 #4.2.3
+func currentAge(e *Entry, now time.Time) time.Duration {
+	apparentAge := e.ResponseTime.Sub(dateValue(e))
+	if apparentAge < 0 {
+		apparentAge = 0
+	}
+	var ageValue time.Duration
+	if s, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && s > 0 {
+		ageValue = time.Duration(s) * time.Second
+	}
+	correctedAgeValue := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	correctedInitialAge := apparentAge
+	if correctedAgeValue > correctedInitialAge {
+		correctedInitialAge = correctedAgeValue
+	}
+	return correctedInitialAge + now.Sub(e.ResponseTime)
+}
+
+func dateValue(e *Entry) time.Time {
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		return date
+	}
+	return e.ResponseTime
+}
+
+// freshness describes the state of a stored response for a request
+type freshness struct {
+	age       time.Duration
+	lifetime  time.Duration
+	staleness time.Duration
+	fresh     bool
+}
+
+func newFreshness(req *http.Request, e *Entry, shared bool, now time.Time) freshness {
+	f := freshness{
+		age:      currentAge(e, now),
+		lifetime: freshnessLifetime(e, shared),
+	}
+	f.staleness = f.age - f.lifetime
+
+	reqCC := parseCacheControl(req.Header)
+	lifetime := f.lifetime
+	if maxAge, ok := reqCC.seconds("max-age"); ok && maxAge < lifetime {
+		lifetime = maxAge
+	}
+	if minFresh, ok := reqCC.seconds("min-fresh"); ok {
+		lifetime -= minFresh
+	}
+	f.fresh = f.age < lifetime
+	return f
+}
+
+// mustRevalidate tells if the response can't be used without a successful validation
+func mustRevalidate(req *http.Request, e *Entry) bool {
+	reqCC := parseCacheControl(req.Header)
+	respCC := parseCacheControl(e.Header)
+	return reqCC.has("no-cache") || respCC.has("no-cache") ||
+		(strings.Contains(req.Header.Get("Pragma"), "no-cache") && !reqCC.has("max-age"))
+}
+
+// canServeStale tells if a stale response can be served without validation, the client tolerates it with
+// max-stale and the response does not forbid it
+func canServeStale(req *http.Request, e *Entry, f freshness, shared bool) bool {
+	if forbidsStale(e, shared) {
+		return false
+	}
+	maxStale, ok := parseCacheControl(req.Header)["max-stale"]
+	if !ok {
+		return false
+	}
+	if maxStale == "" {
+		return true
+	}
+	s, err := strconv.ParseInt(maxStale, 10, 64)
+	return err == nil && f.staleness <= time.Duration(s)*time.Second
+}
+
+// forbidsStale tells if the response must not be served stale
+func forbidsStale(e *Entry, shared bool) bool {
+	cc := parseCacheControl(e.Header)
+	return cc.has("must-revalidate") || (shared && (cc.has("proxy-revalidate") || cc.has("s-maxage")))
+}
+
+// staleWithin tells if the staleness is within the window of the directive of the response or the request
+func staleWithin(req *http.Request, e *Entry, f freshness, directive string, shared bool) bool {
+	if forbidsStale(e, shared) {
+		return false
+	}
+	for _, cc := range []cacheControl{parseCacheControl(req.Header), parseCacheControl(e.Header)} {
+		if window, ok := cc.seconds(directive); ok && f.staleness <= window {
+			return true
+		}
+	}
+	return false
+}
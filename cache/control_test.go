@@ -0,0 +1,251 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	h := http.Header{}
+	h.Add("Cache-Control", `Max-Age=60, no-cache="Set-Cookie, X-Foo", public`)
+	h.Add("Cache-Control", "s-maxage=10")
+
+	cc := parseCacheControl(h)
+	assert.Equal(t, cacheControl{
+		"max-age":  "60",
+		"no-cache": "Set-Cookie, X-Foo",
+		"public":   "",
+		"s-maxage": "10",
+	}, cc)
+
+	d, ok := cc.seconds("max-age")
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, d)
+
+	_, ok = cc.seconds("min-fresh")
+	assert.False(t, ok)
+}
+
+func TestFreshnessLifetime(t *testing.T) {
+	now := time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	testCases := []struct {
+		desc     string
+		status   int
+		header   http.Header
+		shared   bool
+		expected time.Duration
+	}{
+		{
+			desc:     "max-age",
+			header:   http.Header{"Cache-Control": {"max-age=60"}},
+			expected: time.Minute,
+		},
+		{
+			desc:     "s-maxage in shared cache",
+			header:   http.Header{"Cache-Control": {"max-age=60, s-maxage=10"}},
+			shared:   true,
+			expected: 10 * time.Second,
+		},
+		{
+			desc:     "s-maxage in private cache",
+			header:   http.Header{"Cache-Control": {"max-age=60, s-maxage=10"}},
+			expected: time.Minute,
+		},
+		{
+			desc:     "invalid max-age",
+			header:   http.Header{"Cache-Control": {"max-age=abc"}},
+			expected: 0,
+		},
+		{
+			desc: "expires",
+			header: http.Header{
+				"Date":    {now.Format(http.TimeFormat)},
+				"Expires": {now.Add(time.Hour).Format(http.TimeFormat)},
+			},
+			expected: time.Hour,
+		},
+		{
+			desc:     "invalid expires",
+			header:   http.Header{"Expires": {"0"}},
+			expected: 0,
+		},
+		{
+			desc:     "heuristic",
+			status:   http.StatusOK,
+			header:   http.Header{"Last-Modified": {now.Add(-10 * time.Hour).Format(http.TimeFormat)}},
+			expected: time.Hour,
+		},
+		{
+			desc:     "capped heuristic",
+			status:   http.StatusOK,
+			header:   http.Header{"Last-Modified": {now.Add(-100 * 24 * time.Hour).Format(http.TimeFormat)}},
+			expected: 24 * time.Hour,
+		},
+		{
+			desc:     "no heuristic for non cacheable status",
+			status:   http.StatusCreated,
+			header:   http.Header{"Last-Modified": {now.Add(-10 * time.Hour).Format(http.TimeFormat)}},
+			expected: 0,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			e := &Entry{StatusCode: test.status, Header: test.header, RequestTime: now, ResponseTime: now}
+			assert.Equal(t, test.expected, freshnessLifetime(e, test.shared))
+		})
+	}
+}
+
+func TestCurrentAge(t *testing.T) {
+	now := time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	e := &Entry{
+		Header:       http.Header{"Age": {"30"}},
+		RequestTime:  now.Add(-2 * time.Second),
+		ResponseTime: now,
+	}
+	assert.Equal(t, 42*time.Second, currentAge(e, now.Add(10*time.Second)))
+}
+
+func TestStorable(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		method   string
+		reqH     http.Header
+		status   int
+		header   http.Header
+		shared   bool
+		expected bool
+	}{
+		{
+			desc:     "cacheable by default",
+			status:   http.StatusOK,
+			shared:   true,
+			expected: true,
+		},
+		{
+			desc:     "not cacheable by default",
+			status:   http.StatusCreated,
+			shared:   true,
+			expected: false,
+		},
+		{
+			desc:     "explicit freshness",
+			status:   http.StatusCreated,
+			header:   http.Header{"Cache-Control": {"max-age=10"}},
+			shared:   true,
+			expected: true,
+		},
+		{
+			desc:     "post",
+			method:   http.MethodPost,
+			status:   http.StatusOK,
+			header:   http.Header{"Cache-Control": {"max-age=10"}},
+			shared:   true,
+			expected: false,
+		},
+		{
+			desc:     "partial content",
+			status:   http.StatusPartialContent,
+			header:   http.Header{"Cache-Control": {"max-age=10"}},
+			shared:   true,
+			expected: false,
+		},
+		{
+			desc:     "private in shared cache",
+			status:   http.StatusOK,
+			header:   http.Header{"Cache-Control": {"private"}},
+			shared:   true,
+			expected: false,
+		},
+		{
+			desc:     "private in private cache",
+			status:   http.StatusOK,
+			header:   http.Header{"Cache-Control": {"private"}},
+			expected: true,
+		},
+		{
+			desc:     "authorization",
+			reqH:     http.Header{"Authorization": {"Bearer token"}},
+			status:   http.StatusOK,
+			header:   http.Header{"Cache-Control": {"max-age=10"}},
+			shared:   true,
+			expected: false,
+		},
+		{
+			desc:     "authorization with public",
+			reqH:     http.Header{"Authorization": {"Bearer token"}},
+			status:   http.StatusOK,
+			header:   http.Header{"Cache-Control": {"public, max-age=10"}},
+			shared:   true,
+			expected: true,
+		},
+		{
+			desc:     "set-cookie in shared cache",
+			status:   http.StatusOK,
+			header:   http.Header{"Cache-Control": {"max-age=10"}, "Set-Cookie": {"session=abc"}},
+			shared:   true,
+			expected: false,
+		},
+		{
+			desc:     "set-cookie in private cache",
+			status:   http.StatusOK,
+			header:   http.Header{"Cache-Control": {"max-age=10"}, "Set-Cookie": {"session=abc"}},
+			expected: true,
+		},
+		{
+			desc:     "vary star",
+			status:   http.StatusOK,
+			header:   http.Header{"Vary": {"*"}},
+			shared:   true,
+			expected: false,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := &http.Request{Method: method, Header: test.reqH}
+			if req.Header == nil {
+				req.Header = http.Header{}
+			}
+			header := test.header
+			if header == nil {
+				header = http.Header{}
+			}
+			assert.Equal(t, test.expected, storable(req, test.status, header, test.shared))
+		})
+	}
+}
+
+func TestServeStale(t *testing.T) {
+	e := &Entry{Header: http.Header{"Cache-Control": {"max-age=10, stale-if-error=30"}}}
+	f := freshness{staleness: 20 * time.Second}
+
+	req := &http.Request{Header: http.Header{"Cache-Control": {"max-stale=30"}}}
+	assert.True(t, canServeStale(req, e, f, true))
+	assert.True(t, staleWithin(req, e, f, "stale-if-error", true))
+	assert.False(t, staleWithin(req, e, f, "stale-while-revalidate", true))
+
+	req = &http.Request{Header: http.Header{"Cache-Control": {"max-stale=5"}}}
+	assert.False(t, canServeStale(req, e, f, true))
+
+	e.Header.Set("Cache-Control", "max-age=10, must-revalidate, stale-if-error=30")
+	req = &http.Request{Header: http.Header{"Cache-Control": {"max-stale"}}}
+	assert.False(t, canServeStale(req, e, f, true))
+	assert.False(t, staleWithin(req, e, f, "stale-if-error", true))
+}
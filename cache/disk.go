@@ -0,0 +1,240 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	metaSuffix = ".meta"
+	bodySuffix = ".body"
+)
+
+// Disk is a Storage keeping the responses in a directory, evicting the least recently used entries.
+// The entries found in the directory are loaded on creation.
+type Disk struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+type diskItem struct {
+	name string
+	size int64
+}
+
+type diskMeta struct {
+	Key   string `json:"key"`
+	Entry *Entry `json:"entry"`
+}
+
+// NewDisk creates a storage keeping up to maxBytes of responses in dir, the directory is created if needed
+func NewDisk(dir string, maxBytes int64) (*Disk, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("max bytes should be > 0 got %d", maxBytes)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	d := &Disk{
+		dir:      dir,
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Get returns the entry stored under key
+func (d *Disk) Get(key string) (*Entry, io.ReadCloser, error) {
+	name := fileName(key)
+
+	// the metadata and the body are opened together, a concurrent Set replaces both under the lock
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	el, ok := d.entries[name]
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	d.ll.MoveToFront(el)
+
+	meta, err := d.readMeta(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meta.Key != key {
+		// hash collision
+		return nil, nil, ErrNotFound
+	}
+	body, err := os.Open(d.path(name, bodySuffix))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, err
+	}
+	return meta.Entry, body, nil
+}
+
+// Set stores the entry under key, the entries that don't fit in the storage are not stored
+func (d *Disk) Set(key string, entry *Entry, body io.Reader) error {
+	name := fileName(key)
+
+	bodyFile, err := ioutil.TempFile(d.dir, name+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(bodyFile.Name())
+	size, err := io.Copy(bodyFile, io.LimitReader(body, d.maxBytes+1))
+	if errClose := bodyFile.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return err
+	}
+
+	meta, err := json.Marshal(&diskMeta{Key: key, Entry: entry})
+	if err != nil {
+		return err
+	}
+	metaFile := d.path(name, metaSuffix+".tmp")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.remove(name)
+	total := size + int64(len(meta))
+	if total > d.maxBytes {
+		return nil
+	}
+	if err := ioutil.WriteFile(metaFile, meta, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(bodyFile.Name(), d.path(name, bodySuffix)); err != nil {
+		os.Remove(metaFile)
+		return err
+	}
+	// the metadata is written last so that an entry is only loaded once complete
+	if err := os.Rename(metaFile, d.path(name, metaSuffix)); err != nil {
+		return err
+	}
+	d.add(name, total)
+	for d.size > d.maxBytes {
+		d.remove(d.ll.Back().Value.(*diskItem).name)
+	}
+	return nil
+}
+
+// Delete removes the entry stored under key
+func (d *Disk) Delete(key string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.remove(fileName(key))
+	return nil
+}
+
+// Len returns the number of entries
+func (d *Disk) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.ll.Len()
+}
+
+// Size returns the size of the entries in bytes
+func (d *Disk) Size() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.size
+}
+
+func (d *Disk) load() error {
+	files, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+		if strings.Contains(fi.Name(), ".tmp") {
+			// left over by an interrupted Set
+			os.Remove(filepath.Join(d.dir, fi.Name()))
+			continue
+		}
+		if !strings.HasSuffix(fi.Name(), metaSuffix) {
+			continue
+		}
+		name := strings.TrimSuffix(fi.Name(), metaSuffix)
+		body, err := os.Stat(d.path(name, bodySuffix))
+		if err != nil {
+			os.Remove(d.path(name, metaSuffix))
+			continue
+		}
+		d.add(name, fi.Size()+body.Size())
+	}
+	for d.size > d.maxBytes {
+		d.remove(d.ll.Back().Value.(*diskItem).name)
+	}
+	return nil
+}
+
+func (d *Disk) readMeta(name string) (*diskMeta, error) {
+	data, err := ioutil.ReadFile(d.path(name, metaSuffix))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	meta := &diskMeta{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (d *Disk) add(name string, size int64) {
+	d.entries[name] = d.ll.PushFront(&diskItem{name: name, size: size})
+	d.size += size
+}
+
+func (d *Disk) remove(name string) {
+	el, ok := d.entries[name]
+	if !ok {
+		return
+	}
+	d.ll.Remove(el)
+	delete(d.entries, name)
+	d.size -= el.Value.(*diskItem).size
+	os.Remove(d.path(name, metaSuffix))
+	os.Remove(d.path(name, bodySuffix))
+}
+
+func (d *Disk) path(name, suffix string) string {
+	return filepath.Join(d.dir, name+suffix)
+}
+
+func fileName(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
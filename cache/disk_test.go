@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oxy-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	d, err := NewDisk(dir, 1024*1024)
+	require.NoError(t, err)
+
+	_, _, err = d.Get("a")
+	assert.Equal(t, ErrNotFound, err)
+
+	now := time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC)
+	in := &Entry{StatusCode: http.StatusOK, Header: http.Header{"A": {"b"}}, ResponseTime: now, Size: 5}
+	require.NoError(t, d.Set("a", in, strings.NewReader("hello")))
+
+	entry, body, err := d.Get("a")
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	assert.Equal(t, "hello", string(data))
+	assert.Equal(t, in, entry)
+
+	require.NoError(t, d.Delete("a"))
+	_, _, err = d.Get("a")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, 0, d.Len())
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestDiskConcurrentSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oxy-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	d, err := NewDisk(dir, 1024*1024)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			version := strconv.Itoa(i)
+			for j := 0; j < 50; j++ {
+				entry := &Entry{StatusCode: http.StatusOK, Header: http.Header{"Version": {version}}}
+				assert.NoError(t, d.Set("a", entry, strings.NewReader(version)))
+			}
+		}(i)
+	}
+
+	// the metadata always matches the body
+	for i := 0; i < 200; i++ {
+		entry, body, err := d.Get("a")
+		if err == ErrNotFound {
+			continue
+		}
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(body)
+		require.NoError(t, err)
+		require.NoError(t, body.Close())
+		assert.Equal(t, entry.Header.Get("Version"), string(data))
+	}
+	wg.Wait()
+}
+
+func TestDiskEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oxy-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	d, err := NewDisk(dir, 600)
+	require.NoError(t, err)
+
+	content := strings.Repeat("a", 100)
+	require.NoError(t, d.Set("a", &Entry{}, strings.NewReader(content)))
+	require.NoError(t, d.Set("b", &Entry{}, strings.NewReader(content)))
+
+	_, body, err := d.Get("a")
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+
+	require.NoError(t, d.Set("c", &Entry{}, strings.NewReader(content)))
+
+	_, _, err = d.Get("b")
+	assert.Equal(t, ErrNotFound, err)
+	_, body, err = d.Get("a")
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	assert.Equal(t, 2, d.Len())
+	assert.True(t, d.Size() <= 600)
+}
+
+func TestDiskReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oxy-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	d, err := NewDisk(dir, 1024*1024)
+	require.NoError(t, err)
+	require.NoError(t, d.Set("a", &Entry{StatusCode: http.StatusOK}, strings.NewReader("hello")))
+
+	// a leftover of an interrupted write is cleaned up
+	require.NoError(t, ioutil.WriteFile(d.path("x", metaSuffix+".tmp"), []byte("{}"), 0600))
+
+	reloaded, err := NewDisk(dir, 1024*1024)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reloaded.Len())
+	assert.Equal(t, d.Size(), reloaded.Size())
+
+	entry, body, err := reloaded.Get("a")
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	assert.Equal(t, "hello", string(data))
+	assert.Equal(t, http.StatusOK, entry.StatusCode)
+
+	_, err = os.Stat(d.path("x", metaSuffix+".tmp"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// LRU is an in-memory Storage evicting the least recently used entries
+type LRU struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+type lruItem struct {
+	key   string
+	entry Entry
+	body  []byte
+}
+
+func (i *lruItem) size() int64 {
+	return int64(len(i.key)+len(i.body)) + headerSize(i.entry)
+}
+
+// NewLRU creates an in-memory storage holding up to maxBytes of responses
+func NewLRU(maxBytes int64) (*LRU, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("max bytes should be > 0 got %d", maxBytes)
+	}
+	return &LRU{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		entries:  make(map[string]*list.Element),
+	}, nil
+}
+
+// Get returns the entry stored under key
+func (l *LRU) Get(key string) (*Entry, io.ReadCloser, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.entries[key]
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	l.ll.MoveToFront(el)
+	item := el.Value.(*lruItem)
+	entry := item.entry
+	entry.Header = item.entry.Header.Clone()
+	return &entry, ioutil.NopCloser(bytes.NewReader(item.body)), nil
+}
+
+// Set stores the entry under key, the entries that don't fit in the storage are not stored
+func (l *LRU) Set(key string, entry *Entry, body io.Reader) error {
+	data, err := ioutil.ReadAll(io.LimitReader(body, l.maxBytes+1))
+	if err != nil {
+		return err
+	}
+	item := &lruItem{key: key, entry: *entry, body: data}
+	item.entry.Header = entry.Header.Clone()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.remove(key)
+	if item.size() > l.maxBytes {
+		return nil
+	}
+	l.entries[key] = l.ll.PushFront(item)
+	l.size += item.size()
+	for l.size > l.maxBytes {
+		l.remove(l.ll.Back().Value.(*lruItem).key)
+	}
+	return nil
+}
+
+// Delete removes the entry stored under key
+func (l *LRU) Delete(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.remove(key)
+	return nil
+}
+
+// Len returns the number of entries
+func (l *LRU) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.ll.Len()
+}
+
+// Size returns the size of the entries in bytes
+func (l *LRU) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.size
+}
+
+func (l *LRU) remove(key string) {
+	el, ok := l.entries[key]
+	if !ok {
+		return
+	}
+	l.ll.Remove(el)
+	delete(l.entries, key)
+	l.size -= el.Value.(*lruItem).size()
+}
+
+// headerSize approximates the memory used by the entry metadata
+func headerSize(e Entry) int64 {
+	var size int64
+	for k, vv := range e.Header {
+		size += int64(len(k))
+		for _, v := range vv {
+			size += int64(len(v))
+		}
+	}
+	for _, v := range e.Vary {
+		size += int64(len(v))
+	}
+	return size
+}
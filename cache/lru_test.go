@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRU(t *testing.T) {
+	l, err := NewLRU(1024)
+	require.NoError(t, err)
+
+	_, _, err = l.Get("a")
+	assert.Equal(t, ErrNotFound, err)
+
+	require.NoError(t, l.Set("a", &Entry{StatusCode: http.StatusOK, Header: http.Header{"A": {"b"}}}, strings.NewReader("hello")))
+
+	entry, body, err := l.Get("a")
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	assert.Equal(t, http.StatusOK, entry.StatusCode)
+
+	// the returned entry doesn't alias the stored one
+	entry.Header.Set("A", "c")
+	entry, _, err = l.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "b", entry.Header.Get("A"))
+
+	require.NoError(t, l.Delete("a"))
+	_, _, err = l.Get("a")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, 0, l.Len())
+	assert.EqualValues(t, 0, l.Size())
+}
+
+func TestLRUEviction(t *testing.T) {
+	l, err := NewLRU(30)
+	require.NoError(t, err)
+
+	require.NoError(t, l.Set("a", &Entry{}, strings.NewReader("0123456789")))
+	require.NoError(t, l.Set("b", &Entry{}, strings.NewReader("0123456789")))
+
+	// a is now the most recently used
+	_, _, err = l.Get("a")
+	require.NoError(t, err)
+
+	require.NoError(t, l.Set("c", &Entry{}, strings.NewReader("0123456789")))
+
+	_, _, err = l.Get("b")
+	assert.Equal(t, ErrNotFound, err)
+	_, _, err = l.Get("a")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, l.Len())
+	assert.EqualValues(t, 22, l.Size())
+
+	// too large to be stored
+	require.NoError(t, l.Set("d", &Entry{}, strings.NewReader(strings.Repeat("a", 100))))
+	_, _, err = l.Get("d")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, 2, l.Len())
+}
+
+func TestNewLRUInvalid(t *testing.T) {
+	_, err := NewLRU(0)
+	assert.Error(t, err)
+}
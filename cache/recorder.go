@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/mailgun/multibuf"
+	"github.com/vulcand/oxy/utils"
+)
+
+// recorder captures the response of the next handler, optionally writing it through to the client
+type recorder struct {
+	header      http.Header
+	code        int
+	wroteHeader bool
+
+	// rw is the client response writer, nil when the response is only recorded
+	rw http.ResponseWriter
+	// capture decides when the header is written if the body must be captured
+	capture func(code int, header http.Header) bool
+	// beforeWrite is called before writing the header through
+	beforeWrite func(header http.Header)
+
+	memBytes int64
+	maxBytes int64
+	buffer   multibuf.WriterOnce
+	overflow bool
+	err      error
+}
+
+// newTeeRecorder creates a recorder writing the response to rw and capturing its body if capture returns true
+func newTeeRecorder(rw http.ResponseWriter, memBytes, maxBytes int64, capture func(int, http.Header) bool, beforeWrite func(http.Header)) *recorder {
+	return &recorder{
+		header:      rw.Header(),
+		rw:          rw,
+		capture:     capture,
+		beforeWrite: beforeWrite,
+		memBytes:    memBytes,
+		maxBytes:    maxBytes,
+	}
+}
+
+// newRecorder creates a recorder capturing the whole response
+func newRecorder(memBytes int64) *recorder {
+	return &recorder{
+		header:   make(http.Header),
+		capture:  func(int, http.Header) bool { return true },
+		memBytes: memBytes,
+		maxBytes: -1,
+	}
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+	r.code = code
+
+	if r.capture(code, r.header) {
+		r.buffer, r.err = multibuf.NewWriterOnce(multibuf.MemBytes(r.memBytes), multibuf.MaxBytes(r.maxBytes))
+	}
+	if r.rw != nil {
+		if r.beforeWrite != nil {
+			r.beforeWrite(r.header)
+		}
+		r.rw.WriteHeader(code)
+	}
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	if r.buffer != nil && !r.overflow {
+		if _, err := r.buffer.Write(p); err != nil {
+			// the body is too large to be stored, it is still written to the client
+			r.overflow = true
+		}
+	}
+	if r.rw != nil {
+		return r.rw.Write(p)
+	}
+	return len(p), nil
+}
+
+// Flush sends the buffered data to the client
+func (r *recorder) Flush() {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	if f, ok := r.rw.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// captured tells if the whole body was captured
+func (r *recorder) captured() bool {
+	return r.wroteHeader && r.buffer != nil && !r.overflow && r.err == nil
+}
+
+// bodyReader is a reader of the captured body that can be rewound
+type bodyReader interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// body returns a reader of the captured body, it can only be called once and the reader must be closed
+func (r *recorder) body() (bodyReader, int64, error) {
+	if r.buffer == nil {
+		return emptyBody{}, 0, nil
+	}
+	reader, err := r.buffer.Reader()
+	if err != nil {
+		// nothing was written
+		return emptyBody{}, 0, nil
+	}
+	size, err := reader.Size()
+	if err != nil {
+		reader.Close()
+		return nil, 0, err
+	}
+	return reader, size, nil
+}
+
+// close releases the captured body
+func (r *recorder) close() {
+	if r.buffer != nil {
+		r.buffer.Close()
+	}
+}
+
+// replay writes the recorded response to rw, with the body read from body
+func (r *recorder) replay(rw http.ResponseWriter, extra http.Header, body io.Reader) error {
+	utils.CopyHeaders(rw.Header(), r.header)
+	for k, vv := range extra {
+		rw.Header()[k] = vv
+	}
+	code := r.code
+	if !r.wroteHeader {
+		code = http.StatusOK
+	}
+	rw.WriteHeader(code)
+
+	_, err := io.Copy(rw, body)
+	return err
+}
+
+type emptyBody struct{}
+
+func (emptyBody) Read([]byte) (int, error)       { return 0, io.EOF }
+func (emptyBody) Seek(int64, int) (int64, error) { return 0, nil }
+func (emptyBody) Close() error                   { return nil }
@@ -0,0 +1,39 @@
+package cache
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ErrNotFound is returned by the storages when there is no entry for a key
+var ErrNotFound = errors.New("cache entry not found")
+
+// Entry is the metadata of a cached response
+type Entry struct {
+	// StatusCode is the status code of the response
+	StatusCode int `json:"status_code"`
+	// Header is the header of the response
+	Header http.Header `json:"header"`
+	// RequestTime is the time the request that produced the response was sent
+	RequestTime time.Time `json:"request_time"`
+	// ResponseTime is the time the response was received
+	ResponseTime time.Time `json:"response_time"`
+	// Vary lists the request headers selecting the response variants, when set the entry only points to the variants
+	Vary []string `json:"vary,omitempty"`
+	// Size is the size of the body in bytes
+	Size int64 `json:"size"`
+}
+
+// Storage stores the cached responses.
+// Implementations must be safe for concurrent use.
+type Storage interface {
+	// Get returns the entry stored under key along with a reader of its body, which must be closed by the caller.
+	// It returns ErrNotFound if there is no entry for the key.
+	Get(key string) (*Entry, io.ReadCloser, error)
+	// Set stores the entry under key, the body is read until EOF.
+	Set(key string, entry *Entry, body io.Reader) error
+	// Delete removes the entry stored under key, it is not an error if there is no entry.
+	Delete(key string) error
+}
@@ -29,11 +29,13 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mailgun/timetools"
-	log "github.com/sirupsen/logrus"
+	"github.com/vulcand/oxy/logging"
 	"github.com/vulcand/oxy/memmetrics"
+	"github.com/vulcand/oxy/metrics"
 	"github.com/vulcand/oxy/utils"
 )
 
@@ -43,6 +45,8 @@ type CircuitBreaker struct {
 	metrics *memmetrics.RTMetrics
 
 	condition hpredicate
+	name      string
+	observer  metrics.Observer
 
 	fallbackDuration time.Duration
 	recoveryDuration time.Duration
@@ -63,7 +67,7 @@ type CircuitBreaker struct {
 
 	clock timetools.TimeProvider
 
-	log *log.Logger
+	log logging.Logger
 }
 
 // New creates a new CircuitBreaker middleware
@@ -77,7 +81,7 @@ func New(next http.Handler, expression string, options ...CircuitBreakerOption)
 		fallbackDuration: defaultFallbackDuration,
 		recoveryDuration: defaultRecoveryDuration,
 		fallback:         defaultFallback,
-		log:              log.StandardLogger(),
+		log:              logging.Default(),
 	}
 
 	for _, s := range options {
@@ -91,6 +95,9 @@ func New(next http.Handler, expression string, options ...CircuitBreakerOption)
 		return nil, err
 	}
 	cb.condition = condition
+	if cb.name == "" {
+		cb.name = fmt.Sprintf("cbreaker-%d", atomic.AddInt64(&lastID, 1))
+	}
 
 	mt, err := memmetrics.NewRTMetrics()
 	if err != nil {
@@ -103,8 +110,8 @@ func New(next http.Handler, expression string, options ...CircuitBreakerOption)
 
 // Logger defines the logger the circuit breaker will use.
 //
-// It defaults to logrus.StandardLogger(), the global logger used by logrus.
-func Logger(l *log.Logger) CircuitBreakerOption {
+// It defaults to logging.Default().
+func Logger(l logging.Logger) CircuitBreakerOption {
 	return func(c *CircuitBreaker) error {
 		c.log = l
 		return nil
@@ -112,10 +119,15 @@ func Logger(l *log.Logger) CircuitBreakerOption {
 }
 
 func (c *CircuitBreaker) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	if c.log.Level >= log.DebugLevel {
-		logEntry := c.log.WithField("Request", utils.DumpHttpRequest(req))
-		logEntry.Debug("vulcand/oxy/circuitbreaker: begin ServeHttp on request")
-		defer logEntry.Debug("vulcand/oxy/circuitbreaker: completed ServeHttp on request")
+	if c.log.Enabled(logging.DebugLevel) {
+		logger := utils.RequestLogger(c.log, req).With(utils.RequestField(req))
+		logging.Debug(logger, "vulcand/oxy/circuitbreaker: begin ServeHttp on request")
+		defer logging.Debug(logger, "vulcand/oxy/circuitbreaker: completed ServeHttp on request")
+	}
+	if m := metrics.Resolve(req.Context(), c.observer); m != metrics.Nop {
+		defer func() {
+			m.SetCircuitBreakerState(c.name, c.currentState().String())
+		}()
 	}
 	if c.activateFallback(w, req) {
 		c.fallback.ServeHTTP(w, req)
@@ -144,7 +156,7 @@ func (c *CircuitBreaker) activateFallback(w http.ResponseWriter, req *http.Reque
 	c.m.Lock()
 	defer c.m.Unlock()
 
-	c.log.Warnf("%v is in error state", c)
+	logging.Warn(utils.RequestLogger(c.log, req), "circuit breaker is in error state", logging.String("circuit_breaker", c.String()))
 
 	switch c.state {
 	case stateStandby:
@@ -176,22 +188,84 @@ func (c *CircuitBreaker) serve(w http.ResponseWriter, req *http.Request) {
 	start := c.clock.UtcNow()
 	p := utils.NewProxyWriterWithLogger(w, c.log)
 
-	c.next.ServeHTTP(p, req)
+	ctx, errRecord := utils.ContextWithErrorRecord(req.Context())
+	c.next.ServeHTTP(p, req.WithContext(ctx))
 
 	latency := c.clock.UtcNow().Sub(start)
 	c.metrics.Record(p.StatusCode(), latency)
+	if ue := errRecord.Get(); ue != nil {
+		c.metrics.RecordErrorKind(string(ue.Kind))
+	}
 
 	// Note that this call is less expensive than it looks -- checkCondition only performs the real check
 	// periodically. Because of that we can afford to call it here on every single response.
 	c.checkAndSet()
 }
 
+func (c *CircuitBreaker) currentState() cbState {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.state
+}
+
 func (c *CircuitBreaker) isStandby() bool {
 	c.m.RLock()
 	defer c.m.RUnlock()
 	return c.state == stateStandby
 }
 
+// Status is the state of the circuit breaker at a point in time
+type Status struct {
+	// Name is the name set with the Name option
+	Name string
+	// State is standby, tripped or recovering
+	State string
+	// Until is the end of the tripped or recovering state, zero in standby
+	Until time.Time
+}
+
+// Status returns the current state of the circuit breaker
+func (c *CircuitBreaker) Status() Status {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	s := Status{Name: c.name, State: c.state.String()}
+	if c.state != stateStandby {
+		s.Until = c.until
+	}
+	return s
+}
+
+// Trip forces the circuit breaker in the tripped state for the fallback duration,
+// it then recovers as if the condition had tripped it
+func (c *CircuitBreaker) Trip() {
+	c.m.Lock()
+	c.setState(stateTripped, c.clock.UtcNow().Add(c.fallbackDuration))
+	c.metrics.Reset()
+	c.m.Unlock()
+
+	c.reportState()
+}
+
+// Reset forces the circuit breaker back in the standby state and resets its metrics
+func (c *CircuitBreaker) Reset() {
+	c.m.Lock()
+	c.setState(stateStandby, c.clock.UtcNow())
+	c.metrics.Reset()
+	c.lastCheck = c.clock.UtcNow().Add(c.checkPeriod)
+	c.m.Unlock()
+
+	c.reportState()
+}
+
+// reportState reports the state to the observer set with the Metrics option, if any,
+// the observers found in the request context only learn the state changes on the next request
+func (c *CircuitBreaker) reportState() {
+	if c.observer != nil {
+		c.observer.SetCircuitBreakerState(c.name, c.currentState().String())
+	}
+}
+
 // String returns log-friendly representation of the circuit breaker state
 func (c *CircuitBreaker) String() string {
 	switch c.state {
@@ -209,13 +283,16 @@ func (c *CircuitBreaker) exec(s SideEffect) {
 	}
 	go func() {
 		if err := s.Exec(); err != nil {
-			c.log.Errorf("%v side effect failure: %v", c, err)
+			logging.Error(c.log, "side effect failure", logging.String("circuit_breaker", c.String()), logging.Err(err))
 		}
 	}()
 }
 
 func (c *CircuitBreaker) setState(new cbState, until time.Time) {
-	c.log.Debugf("%v setting state to %v, until %v", c, new, until)
+	if c.log.Enabled(logging.DebugLevel) {
+		logging.Debug(c.log, "setting circuit breaker state", logging.String("circuit_breaker", c.String()),
+			logging.String("state", new.String()), logging.Any("until", until))
+	}
 	c.state = new
 	c.until = until
 	switch new {
@@ -248,7 +325,9 @@ func (c *CircuitBreaker) checkAndSet() {
 	c.lastCheck = c.clock.UtcNow().Add(c.checkPeriod)
 
 	if c.state == stateTripped {
-		c.log.Debugf("%v skip set tripped", c)
+		if c.log.Enabled(logging.DebugLevel) {
+			logging.Debug(c.log, "skip set tripped", logging.String("circuit_breaker", c.String()))
+		}
 		return
 	}
 
@@ -323,6 +402,28 @@ func OnStandby(s SideEffect) CircuitBreakerOption {
 	}
 }
 
+// Name sets the name of the CircuitBreaker in the metrics and the status,
+// it defaults to a name unique to the process, e.g. cbreaker-1.
+//
+// The state reported to an observer found in the request context is only refreshed
+// when a request passes through the CircuitBreaker, in particular the transition
+// from recovering to standby is not reported until the next request.
+func Name(name string) CircuitBreakerOption {
+	return func(c *CircuitBreaker) error {
+		c.name = name
+		return nil
+	}
+}
+
+// Metrics sets the observer of the state of the CircuitBreaker,
+// it defaults to the observer of the metrics middleware handling the request.
+func Metrics(o metrics.Observer) CircuitBreakerOption {
+	return func(c *CircuitBreaker) error {
+		c.observer = o
+		return nil
+	}
+}
+
 // Fallback defines the http.Handler that the CircuitBreaker should route
 // requests to when it prevents a request from taking its normal path.
 func Fallback(h http.Handler) CircuitBreakerOption {
@@ -364,6 +465,9 @@ const (
 
 var defaultFallback = &fallback{}
 
+// lastID numbers the circuit breakers created without a name
+var lastID int64
+
 type fallback struct{}
 
 func (f *fallback) ServeHTTP(w http.ResponseWriter, req *http.Request) {
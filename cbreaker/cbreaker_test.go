@@ -6,12 +6,17 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/vulcand/oxy/memmetrics"
+	"github.com/vulcand/oxy/metrics"
+	"github.com/vulcand/oxy/metrics/prommetrics"
 	"github.com/vulcand/oxy/testutils"
 )
 
@@ -281,6 +286,53 @@ func TestSideEffects(t *testing.T) {
 	}
 }
 
+func TestMetrics(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	clock := testutils.GetClock()
+
+	cb, err := New(handler, triggerNetRatio, Clock(clock), Name("backend"))
+	require.NoError(t, err)
+
+	registry := prometheus.NewRegistry()
+	m, err := prommetrics.NewMetrics(registry)
+	require.NoError(t, err)
+	mw, err := metrics.New(cb, m)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(mw)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+
+	expected := `
+		# HELP oxy_circuit_breaker_state State of the circuit breakers, 1 for the current state of the instance and 0 for the others.
+		# TYPE oxy_circuit_breaker_state gauge
+		oxy_circuit_breaker_state{instance="backend",state="recovering"} 0
+		oxy_circuit_breaker_state{instance="backend",state="standby"} 1
+		oxy_circuit_breaker_state{instance="backend",state="tripped"} 0
+	`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "oxy_circuit_breaker_state"))
+
+	cb.metrics = statsNetErrors(0.6)
+	clock.CurrentTime = clock.CurrentTime.Add(defaultCheckPeriod + time.Millisecond)
+	_, _, err = testutils.Get(srv.URL)
+	require.NoError(t, err)
+
+	expected = `
+		# HELP oxy_circuit_breaker_state State of the circuit breakers, 1 for the current state of the instance and 0 for the others.
+		# TYPE oxy_circuit_breaker_state gauge
+		oxy_circuit_breaker_state{instance="backend",state="recovering"} 0
+		oxy_circuit_breaker_state{instance="backend",state="standby"} 0
+		oxy_circuit_breaker_state{instance="backend",state="tripped"} 1
+	`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "oxy_circuit_breaker_state"))
+}
+
 func statsOK() *memmetrics.RTMetrics {
 	m, err := memmetrics.NewRTMetrics()
 	if err != nil {
@@ -330,3 +382,52 @@ type statusCode struct {
 	Code  int
 	Count int64
 }
+
+func TestTripAndReset(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	clock := testutils.GetClock()
+
+	cb, err := New(handler, triggerNetRatio, Clock(clock), Name("backend"))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(cb)
+	defer srv.Close()
+
+	assert.Equal(t, Status{Name: "backend", State: "standby"}, cb.Status())
+
+	cb.Trip()
+	assert.Equal(t, Status{Name: "backend", State: "tripped", Until: clock.UtcNow().Add(defaultFallbackDuration)}, cb.Status())
+
+	re, _, err := testutils.Get(srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, re.StatusCode)
+
+	// the forced trip recovers as a regular one
+	clock.CurrentTime = clock.CurrentTime.Add(defaultFallbackDuration + time.Millisecond)
+	_, _, err = testutils.Get(srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "recovering", cb.Status().State)
+
+	cb.Reset()
+	assert.Equal(t, Status{Name: "backend", State: "standby"}, cb.Status())
+
+	re, body, err := testutils.Get(srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestDefaultName(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+
+	cb1, err := New(handler, triggerNetRatio)
+	require.NoError(t, err)
+	cb2, err := New(handler, triggerNetRatio)
+	require.NoError(t, err)
+
+	assert.Regexp(t, `^cbreaker-\d+$`, cb1.Status().Name)
+	assert.NotEqual(t, cb1.Status().Name, cb2.Status().Name)
+}
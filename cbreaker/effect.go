@@ -9,7 +9,7 @@ import (
 	"net/url"
 	"strings"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/vulcand/oxy/logging"
 	"github.com/vulcand/oxy/utils"
 )
 
@@ -31,11 +31,11 @@ type Webhook struct {
 type WebhookSideEffect struct {
 	w Webhook
 
-	log *log.Logger
+	log logging.Logger
 }
 
 // NewWebhookSideEffectsWithLogger creates a new WebhookSideEffect
-func NewWebhookSideEffectsWithLogger(w Webhook, l *log.Logger) (*WebhookSideEffect, error) {
+func NewWebhookSideEffectsWithLogger(w Webhook, l logging.Logger) (*WebhookSideEffect, error) {
 	if w.Method == "" {
 		return nil, fmt.Errorf("Supply method")
 	}
@@ -49,7 +49,7 @@ func NewWebhookSideEffectsWithLogger(w Webhook, l *log.Logger) (*WebhookSideEffe
 
 // NewWebhookSideEffect creates a new WebhookSideEffect
 func NewWebhookSideEffect(w Webhook) (*WebhookSideEffect, error) {
-	return NewWebhookSideEffectsWithLogger(w, log.StandardLogger())
+	return NewWebhookSideEffectsWithLogger(w, logging.Default())
 }
 
 func (w *WebhookSideEffect) getBody() io.Reader {
@@ -85,6 +85,9 @@ func (w *WebhookSideEffect) Exec() error {
 	if err != nil {
 		return err
 	}
-	w.log.Debugf("%v got response: (%s): %s", w, re.Status, string(body))
+	if w.log.Enabled(logging.DebugLevel) {
+		logging.Debug(w.log, "webhook got response",
+			logging.String("url", w.w.URL), logging.String("status", re.Status), logging.String("body", string(body)))
+	}
 	return nil
 }
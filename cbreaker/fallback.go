@@ -6,7 +6,7 @@ import (
 	"net/url"
 	"strconv"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/vulcand/oxy/logging"
 	"github.com/vulcand/oxy/utils"
 )
 
@@ -21,11 +21,11 @@ type Response struct {
 type ResponseFallback struct {
 	r Response
 
-	log *log.Logger
+	log logging.Logger
 }
 
 // NewResponseFallbackWithLogger creates a new ResponseFallback
-func NewResponseFallbackWithLogger(r Response, l *log.Logger) (*ResponseFallback, error) {
+func NewResponseFallbackWithLogger(r Response, l logging.Logger) (*ResponseFallback, error) {
 	if r.StatusCode == 0 {
 		return nil, fmt.Errorf("response code should not be 0")
 	}
@@ -34,14 +34,14 @@ func NewResponseFallbackWithLogger(r Response, l *log.Logger) (*ResponseFallback
 
 // NewResponseFallback creates a new ResponseFallback
 func NewResponseFallback(r Response) (*ResponseFallback, error) {
-	return NewResponseFallbackWithLogger(r, log.StandardLogger())
+	return NewResponseFallbackWithLogger(r, logging.Default())
 }
 
 func (f *ResponseFallback) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	if f.log.Level >= log.DebugLevel {
-		logEntry := f.log.WithField("Request", utils.DumpHttpRequest(req))
-		logEntry.Debug("vulcand/oxy/fallback/response: begin ServeHttp on request")
-		defer logEntry.Debug("vulcand/oxy/fallback/response: completed ServeHttp on request")
+	if f.log.Enabled(logging.DebugLevel) {
+		logger := utils.RequestLogger(f.log, req).With(utils.RequestField(req))
+		logging.Debug(logger, "vulcand/oxy/fallback/response: begin ServeHttp on request")
+		defer logging.Debug(logger, "vulcand/oxy/fallback/response: completed ServeHttp on request")
 	}
 
 	if f.r.ContentType != "" {
@@ -51,7 +51,7 @@ func (f *ResponseFallback) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(f.r.StatusCode)
 	_, err := w.Write(f.r.Body)
 	if err != nil {
-		f.log.Errorf("vulcand/oxy/fallback/response: failed to write response, err: %v", err)
+		logging.Error(utils.RequestLogger(f.log, req), "vulcand/oxy/fallback/response: failed to write response", logging.Err(err))
 	}
 }
 
@@ -67,11 +67,11 @@ type RedirectFallback struct {
 
 	u *url.URL
 
-	log *log.Logger
+	log logging.Logger
 }
 
 // NewRedirectFallbackWithLogger creates a new RedirectFallback
-func NewRedirectFallbackWithLogger(r Redirect, l *log.Logger) (*RedirectFallback, error) {
+func NewRedirectFallbackWithLogger(r Redirect, l logging.Logger) (*RedirectFallback, error) {
 	u, err := url.ParseRequestURI(r.URL)
 	if err != nil {
 		return nil, err
@@ -81,14 +81,14 @@ func NewRedirectFallbackWithLogger(r Redirect, l *log.Logger) (*RedirectFallback
 
 // NewRedirectFallback creates a new RedirectFallback
 func NewRedirectFallback(r Redirect) (*RedirectFallback, error) {
-	return NewRedirectFallbackWithLogger(r, log.StandardLogger())
+	return NewRedirectFallbackWithLogger(r, logging.Default())
 }
 
 func (f *RedirectFallback) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	if f.log.Level >= log.DebugLevel {
-		logEntry := f.log.WithField("Request", utils.DumpHttpRequest(req))
-		logEntry.Debug("vulcand/oxy/fallback/redirect: begin ServeHttp on request")
-		defer logEntry.Debug("vulcand/oxy/fallback/redirect: completed ServeHttp on request")
+	if f.log.Enabled(logging.DebugLevel) {
+		logger := utils.RequestLogger(f.log, req).With(utils.RequestField(req))
+		logging.Debug(logger, "vulcand/oxy/fallback/redirect: begin ServeHttp on request")
+		defer logging.Debug(logger, "vulcand/oxy/fallback/redirect: completed ServeHttp on request")
 	}
 
 	location := f.u.String()
@@ -100,6 +100,6 @@ func (f *RedirectFallback) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(http.StatusFound)
 	_, err := w.Write([]byte(http.StatusText(http.StatusFound)))
 	if err != nil {
-		f.log.Errorf("vulcand/oxy/fallback/redirect: failed to write response, err: %v", err)
+		logging.Error(utils.RequestLogger(f.log, req), "vulcand/oxy/fallback/redirect: failed to write response", logging.Err(err))
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/vulcand/oxy/logging"
 	"github.com/vulcand/predicate"
 )
 
@@ -49,7 +50,7 @@ func latencyAtQuantile(quantile float64) toInt {
 	return func(c *CircuitBreaker) int {
 		h, err := c.metrics.LatencyHistogram()
 		if err != nil {
-			c.log.Errorf("Failed to get latency histogram, for %v error: %v", c, err)
+			logging.Error(c.log, "Failed to get latency histogram", logging.String("circuit_breaker", c.String()), logging.Err(err))
 			return 0
 		}
 		return int(h.LatencyAtQuantile(quantile) / time.Millisecond)
@@ -5,7 +5,7 @@ import (
 	"time"
 
 	"github.com/mailgun/timetools"
-	log "github.com/sirupsen/logrus"
+	"github.com/vulcand/oxy/logging"
 )
 
 // ratioController allows passing portions traffic back to the endpoints,
@@ -20,10 +20,10 @@ type ratioController struct {
 	allowed  int
 	denied   int
 
-	log *log.Logger
+	log logging.Logger
 }
 
-func newRatioController(tm timetools.TimeProvider, rampUp time.Duration, log *log.Logger) *ratioController {
+func newRatioController(tm timetools.TimeProvider, rampUp time.Duration, log logging.Logger) *ratioController {
 	return &ratioController{
 		duration: rampUp,
 		tm:       tm,
@@ -38,17 +38,23 @@ func (r *ratioController) String() string {
 }
 
 func (r *ratioController) allowRequest() bool {
-	r.log.Debugf("%v", r)
+	if r.log.Enabled(logging.DebugLevel) {
+		logging.Debug(r.log, "ratio controller", logging.String("ratio_controller", r.String()))
+	}
 	t := r.targetRatio()
 	// This condition answers the question - would we satisfy the target ratio if we allow this request?
 	e := r.computeRatio(r.allowed+1, r.denied)
 	if e < t {
 		r.allowed++
-		r.log.Debugf("%v allowed", r)
+		if r.log.Enabled(logging.DebugLevel) {
+			logging.Debug(r.log, "request allowed", logging.String("ratio_controller", r.String()))
+		}
 		return true
 	}
 	r.denied++
-	r.log.Debugf("%v denied", r)
+	if r.log.Enabled(logging.DebugLevel) {
+		logging.Debug(r.log, "request denied", logging.String("ratio_controller", r.String()))
+	}
 	return false
 }
 
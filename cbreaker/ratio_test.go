@@ -5,15 +5,15 @@ import (
 	"testing"
 	"time"
 
-	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/vulcand/oxy/logging"
 	"github.com/vulcand/oxy/testutils"
 )
 
 func TestRampUp(t *testing.T) {
 	clock := testutils.GetClock()
 	duration := 10 * time.Second
-	rc := newRatioController(clock, duration, log.StandardLogger())
+	rc := newRatioController(clock, duration, logging.Default())
 
 	allowed, denied := 0, 0
 	for i := 0; i < int(duration/time.Millisecond); i++ {
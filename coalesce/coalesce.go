@@ -0,0 +1,453 @@
+/*
+Package coalesce provides http.Handler middleware collapsing concurrent identical requests into a single call
+to the next handler, the buffered response is then written to every waiting client.
+
+It prevents a stampede on the backend when many clients request the same resource at once,
+e.g. right after a cached response expired.
+Only GET and HEAD requests without a body are coalesced, the other requests are passed through.
+The requests carrying credentials (Authorization or Cookie headers) are not coalesced either, unless these headers
+are part of the key (see KeyHeaders and Key), and the Set-Cookie header of a shared response is only sent to the
+client whose request reached the next handler.
+
+Examples of a coalescing middleware:
+
+	// coalesces the requests on the method and URL
+	coalesce.New(handler)
+
+	// also keys the requests on the Accept-Encoding header, waits up to 5s for the first request
+	// and does not share responses larger than 10MB
+	coalesce.New(handler,
+		coalesce.KeyHeaders("Accept-Encoding"),
+		coalesce.Timeout(5 * time.Second),
+		coalesce.MaxBodyBytes(10 * 1024 * 1024))
+*/
+package coalesce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vulcand/oxy/buffer"
+	"github.com/vulcand/oxy/logging"
+	"github.com/vulcand/oxy/utils"
+)
+
+// errNotShared is set on a call whose response can't be shared with the waiters
+var errNotShared = errors.New("response not shared")
+
+// Option is a functional option setter for Coalescer
+type Option func(*Coalescer) error
+
+// KeyHeaders adds the values of the headers to the default key, the requests differing on these headers are not coalesced.
+// Adding Authorization or Cookie allows the requests with the same credentials to be coalesced.
+func KeyHeaders(headers ...string) Option {
+	return func(c *Coalescer) error {
+		for _, h := range headers {
+			c.keyHeaders = append(c.keyHeaders, http.CanonicalHeaderKey(h))
+		}
+		return nil
+	}
+}
+
+// Key sets the function computing the key of a request, the requests having the same key are coalesced.
+// It defaults to the method, host, request URI and the headers set with KeyHeaders.
+// The requests carrying credentials are coalesced with a custom key, it must tell the users apart.
+func Key(fn func(*http.Request) string) Option {
+	return func(c *Coalescer) error {
+		c.key = fn
+		c.customKey = true
+		return nil
+	}
+}
+
+// MaxWaiters sets the maximum number of requests waiting for the same response, the extra requests are rejected.
+// It defaults to 0, no limit.
+func MaxWaiters(n int) Option {
+	return func(c *Coalescer) error {
+		if n < 0 {
+			return fmt.Errorf("max waiters should be >= 0 got %d", n)
+		}
+		c.maxWaiters = n
+		return nil
+	}
+}
+
+// Timeout sets how long a request waits for the response of the identical in-flight request before being rejected.
+// It defaults to 0, no timeout.
+func Timeout(d time.Duration) Option {
+	return func(c *Coalescer) error {
+		if d < 0 {
+			return fmt.Errorf("timeout should be >= 0 got %v", d)
+		}
+		c.timeout = d
+		return nil
+	}
+}
+
+// MemBodyBytes sets the maximum size of a response body buffered in memory, the excess is buffered in a temporary file.
+// It defaults to buffer.DefaultMemBodyBytes.
+func MemBodyBytes(m int64) Option {
+	return func(c *Coalescer) error {
+		if m < 0 {
+			return fmt.Errorf("mem bytes should be >= 0 got %d", m)
+		}
+		c.memBodyBytes = m
+		return nil
+	}
+}
+
+// MaxBodyBytes sets the maximum size of a shared response body, when a response is larger it is streamed
+// to the first client and the other coalesced requests are sent to the next handler on their own. It defaults to buffer.DefaultMaxBodyBytes, no limit.
+func MaxBodyBytes(m int64) Option {
+	return func(c *Coalescer) error {
+		c.maxBodyBytes = m
+		return nil
+	}
+}
+
+// ErrorHandler sets the error handler, it defaults to CoalesceErrHandler
+func ErrorHandler(h utils.ErrorHandler) Option {
+	return func(c *Coalescer) error {
+		c.errHandler = h
+		return nil
+	}
+}
+
+// Logger defines the logger the coalescer will use.
+//
+// It defaults to logging.Default().
+func Logger(l logging.Logger) Option {
+	return func(c *Coalescer) error {
+		c.log = l
+		return nil
+	}
+}
+
+// Coalescer collapses concurrent identical requests into a single call to the next handler
+type Coalescer struct {
+	next       http.Handler
+	key        func(*http.Request) string
+	customKey  bool
+	keyHeaders []string
+	maxWaiters int
+	timeout    time.Duration
+
+	memBodyBytes int64
+	maxBodyBytes int64
+
+	mu    sync.Mutex
+	calls map[string]*call
+
+	errHandler utils.ErrorHandler
+	log        logging.Logger
+}
+
+// call is an in-flight request shared by waiters
+type call struct {
+	done    chan struct{}
+	waiters int
+	// refs counts the requests holding the response, it is released by the last one
+	refs int32
+
+	resp *buffer.Response
+	err  error
+}
+
+func (c *call) release() {
+	if atomic.AddInt32(&c.refs, -1) == 0 && c.resp != nil {
+		c.resp.Close()
+	}
+}
+
+// New creates a new coalescing middleware
+func New(next http.Handler, opts ...Option) (*Coalescer, error) {
+	c := &Coalescer{
+		next:         next,
+		memBodyBytes: buffer.DefaultMemBodyBytes,
+		maxBodyBytes: buffer.DefaultMaxBodyBytes,
+		calls:        make(map[string]*call),
+		log:          logging.Default(),
+	}
+	for _, o := range opts {
+		if err := o(c); err != nil {
+			return nil, err
+		}
+	}
+	if c.key == nil {
+		c.key = c.defaultKey
+	}
+	if c.errHandler == nil {
+		c.errHandler = &CoalesceErrHandler{log: c.log}
+	}
+	return c, nil
+}
+
+// Wrap sets the next handler to be called by coalescing handler.
+func (c *Coalescer) Wrap(next http.Handler) error {
+	c.next = next
+	return nil
+}
+
+func (c *Coalescer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if c.log.Enabled(logging.DebugLevel) {
+		logger := utils.RequestLogger(c.log, req).With(utils.RequestField(req))
+		logging.Debug(logger, "vulcand/oxy/coalesce: begin ServeHttp on request")
+		defer logging.Debug(logger, "vulcand/oxy/coalesce: completed ServeHttp on request")
+	}
+
+	if !c.coalescable(req) {
+		c.next.ServeHTTP(w, req)
+		return
+	}
+
+	key := c.key(req)
+
+	c.mu.Lock()
+	if cl, ok := c.calls[key]; ok {
+		if c.maxWaiters > 0 && cl.waiters >= c.maxWaiters {
+			c.mu.Unlock()
+			c.errHandler.ServeHTTP(w, req, &MaxWaitersError{max: c.maxWaiters})
+			return
+		}
+		cl.waiters++
+		atomic.AddInt32(&cl.refs, 1)
+		c.mu.Unlock()
+		c.wait(w, req, cl)
+		return
+	}
+	cl := &call{done: make(chan struct{}), refs: 1}
+	c.calls[key] = cl
+	c.mu.Unlock()
+
+	c.lead(w, req, key, cl)
+}
+
+// lead sends the request to the next handler and shares the response with the waiters
+func (c *Coalescer) lead(w http.ResponseWriter, req *http.Request, key string, cl *call) {
+	resp, streamed, err := c.capture(w, req, key, cl)
+	defer cl.release()
+
+	if streamed {
+		// the response was streamed to the client of the leader, the waiters are sent on their own
+		if c.log.Enabled(logging.DebugLevel) {
+			logging.Debug(utils.RequestLogger(c.log, req), "vulcand/oxy/coalesce: response is too large to be shared", logging.String("key", key))
+		}
+		return
+	}
+	if err != nil {
+		logging.Error(utils.RequestLogger(c.log, req), "vulcand/oxy/coalesce: failed to capture response", logging.String("key", key), logging.Err(err))
+		c.errHandler.ServeHTTP(w, req, err)
+		return
+	}
+	if err := resp.WriteTo(w); err != nil {
+		if c.log.Enabled(logging.DebugLevel) {
+			logging.Debug(utils.RequestLogger(c.log, req), "vulcand/oxy/coalesce: failed to write response", logging.Err(err))
+		}
+	}
+}
+
+// capture buffers the response of the next handler and releases the waiters,
+// a response too large to be shared is streamed to w
+func (c *Coalescer) capture(w http.ResponseWriter, req *http.Request, key string, cl *call) (*buffer.Response, bool, error) {
+	// the waiters must be released even if the next handler panics
+	cl.err = errNotShared
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			c.mu.Lock()
+			delete(c.calls, key)
+			c.mu.Unlock()
+			close(cl.done)
+		})
+	}
+	defer release()
+
+	// the shared call must not be canceled when the first client goes away
+	outReq := req.WithContext(detachedContext{parent: req.Context()})
+	// the waiters don't wait for a streamed response to complete
+	sw := &streamWriter{ResponseWriter: w, start: release}
+	resp, streamed, err := buffer.CaptureOrStream(c.next, sw, outReq, c.memBodyBytes, c.maxBodyBytes)
+	if streamed || err != nil {
+		return nil, streamed, err
+	}
+	// the cookies set for the leader must not leak to the other clients
+	shared := *resp
+	shared.Header = resp.Header.Clone()
+	shared.Header.Del("Set-Cookie")
+	cl.resp, cl.err = &shared, nil
+	return resp, false, nil
+}
+
+// wait waits for the in-flight call and writes its response
+func (c *Coalescer) wait(w http.ResponseWriter, req *http.Request, cl *call) {
+	var timeout <-chan time.Time
+	if c.timeout > 0 {
+		timer := time.NewTimer(c.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-cl.done:
+	case <-timeout:
+		c.leave(cl)
+		c.errHandler.ServeHTTP(w, req, &WaitTimeoutError{timeout: c.timeout})
+		return
+	case <-req.Context().Done():
+		c.leave(cl)
+		c.errHandler.ServeHTTP(w, req, req.Context().Err())
+		return
+	}
+
+	defer cl.release()
+	if cl.err != nil {
+		c.next.ServeHTTP(w, req)
+		return
+	}
+	if err := cl.resp.WriteTo(w); err != nil {
+		if c.log.Enabled(logging.DebugLevel) {
+			logging.Debug(utils.RequestLogger(c.log, req), "vulcand/oxy/coalesce: failed to write response", logging.Err(err))
+		}
+	}
+}
+
+// leave removes a waiter giving up on the call, it no longer counts against MaxWaiters
+func (c *Coalescer) leave(cl *call) {
+	c.mu.Lock()
+	cl.waiters--
+	c.mu.Unlock()
+	cl.release()
+}
+
+func (c *Coalescer) defaultKey(req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(req.Method)
+	b.WriteString(" ")
+	b.WriteString(req.Host)
+	b.WriteString(req.URL.RequestURI())
+	for _, h := range c.keyHeaders {
+		b.WriteString("\x00")
+		b.WriteString(h)
+		b.WriteString(":")
+		b.WriteString(strings.Join(req.Header[h], ","))
+	}
+	return b.String()
+}
+
+// credentialHeaders are the request headers personalizing the response
+var credentialHeaders = []string{"Authorization", "Cookie"}
+
+func (c *Coalescer) coalescable(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if req.ContentLength > 0 || len(req.TransferEncoding) != 0 {
+		return false
+	}
+	if c.customKey {
+		return true
+	}
+	for _, h := range credentialHeaders {
+		if _, ok := req.Header[h]; ok && !c.isKeyHeader(h) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Coalescer) isKeyHeader(h string) bool {
+	for _, k := range c.keyHeaders {
+		if k == h {
+			return true
+		}
+	}
+	return false
+}
+
+// streamWriter calls start before the header of a streamed response is sent
+type streamWriter struct {
+	http.ResponseWriter
+	start func()
+}
+
+func (s *streamWriter) WriteHeader(code int) {
+	s.start()
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Flush flushes the streamed response
+func (s *streamWriter) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// detachedContext keeps the values of its parent but is never canceled
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+func (d detachedContext) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
+
+// MaxWaitersError is returned when too many requests wait for the same response
+type MaxWaitersError struct {
+	max int
+}
+
+func (m *MaxWaitersError) Error() string {
+	return fmt.Sprintf("max waiters reached: %d", m.max)
+}
+
+// ErrorKind classifies the error as too many waiters, served as 503
+func (m *MaxWaitersError) ErrorKind() utils.ErrorKind {
+	return utils.ErrorKindTooManyWaiters
+}
+
+// WaitTimeoutError is returned when the in-flight request did not complete in time
+type WaitTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *WaitTimeoutError) Error() string {
+	return fmt.Sprintf("timeout waiting for in-flight request after %v", e.timeout)
+}
+
+// ErrorKind classifies the error as a timeout
+func (e *WaitTimeoutError) ErrorKind() utils.ErrorKind {
+	return utils.ErrorKindTimeout
+}
+
+// CoalesceErrHandler coalescing error handler
+type CoalesceErrHandler struct {
+	log logging.Logger
+}
+
+func (e *CoalesceErrHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, err error) {
+	switch err.(type) {
+	case *MaxWaitersError, *WaitTimeoutError:
+	default:
+		utils.DefaultHandler.ServeHTTP(w, req, err)
+		return
+	}
+
+	// the status code follows the classification so that the traces and the metrics agree with the response
+	ue := utils.ClassifyError(err)
+	statusCode := ue.StatusCode()
+	utils.RecordError(req, ue)
+	if e.log.Enabled(logging.DebugLevel) {
+		logging.Debug(utils.RequestLogger(e.log, req), "vulcand/oxy/coalesce: error served", logging.Int("code", statusCode), logging.Err(err))
+	}
+	w.WriteHeader(statusCode)
+	_, _ = w.Write([]byte(http.StatusText(statusCode)))
+}
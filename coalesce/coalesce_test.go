@@ -0,0 +1,343 @@
+package coalesce
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/utils"
+)
+
+// blockingHandler counts the calls and blocks them until release is closed
+type blockingHandler struct {
+	calls   int32
+	release chan struct{}
+	body    string
+}
+
+func newBlockingHandler(body string) *blockingHandler {
+	return &blockingHandler{release: make(chan struct{}), body: body}
+}
+
+func (h *blockingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt32(&h.calls, 1)
+	<-h.release
+	w.Header().Set("X-Test", "value")
+	_, _ = w.Write([]byte(h.body))
+}
+
+func waitForWaiters(t *testing.T, c *Coalescer, waiters int) {
+	t.Helper()
+
+	assert.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		for _, cl := range c.calls {
+			if cl.waiters == waiters {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, time.Millisecond)
+}
+
+func serveConcurrently(c http.Handler, reqs ...*http.Request) ([]*httptest.ResponseRecorder, *sync.WaitGroup) {
+	recorders := make([]*httptest.ResponseRecorder, len(reqs))
+	wg := &sync.WaitGroup{}
+	for i, req := range reqs {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(rw *httptest.ResponseRecorder, req *http.Request) {
+			defer wg.Done()
+			c.ServeHTTP(rw, req)
+		}(recorders[i], req)
+	}
+	return recorders, wg
+}
+
+func TestCoalesce(t *testing.T) {
+	h := newBlockingHandler("hello")
+	c, err := New(h)
+	require.NoError(t, err)
+
+	var reqs []*http.Request
+	for i := 0; i < 10; i++ {
+		reqs = append(reqs, httptest.NewRequest(http.MethodGet, "http://localhost/path?a=b", nil))
+	}
+	recorders, wg := serveConcurrently(c, reqs...)
+
+	waitForWaiters(t, c, 9)
+	close(h.release)
+	wg.Wait()
+
+	assert.EqualValues(t, 1, atomic.LoadInt32(&h.calls))
+	for _, rw := range recorders {
+		assert.Equal(t, http.StatusOK, rw.Code)
+		assert.Equal(t, "value", rw.Header().Get("X-Test"))
+		assert.Equal(t, "hello", rw.Body.String())
+	}
+	assert.Empty(t, c.calls)
+}
+
+func TestNotCoalesced(t *testing.T) {
+	testCases := []struct {
+		desc string
+		opts []Option
+		reqs func() []*http.Request
+	}{
+		{
+			desc: "post",
+			reqs: func() []*http.Request {
+				return []*http.Request{
+					httptest.NewRequest(http.MethodPost, "http://localhost/", strings.NewReader("a")),
+					httptest.NewRequest(http.MethodPost, "http://localhost/", strings.NewReader("a")),
+				}
+			},
+		},
+		{
+			desc: "different URLs",
+			reqs: func() []*http.Request {
+				return []*http.Request{
+					httptest.NewRequest(http.MethodGet, "http://localhost/a", nil),
+					httptest.NewRequest(http.MethodGet, "http://localhost/b", nil),
+				}
+			},
+		},
+		{
+			desc: "different key headers",
+			opts: []Option{KeyHeaders("accept-encoding")},
+			reqs: func() []*http.Request {
+				gzip := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+				gzip.Header.Set("Accept-Encoding", "gzip")
+				return []*http.Request{gzip, httptest.NewRequest(http.MethodGet, "http://localhost/", nil)}
+			},
+		},
+		{
+			desc: "authorization",
+			reqs: func() []*http.Request {
+				var reqs []*http.Request
+				for _, user := range []string{"a", "b"} {
+					req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+					req.SetBasicAuth(user, "secret")
+					reqs = append(reqs, req)
+				}
+				return reqs
+			},
+		},
+		{
+			desc: "cookie",
+			reqs: func() []*http.Request {
+				var reqs []*http.Request
+				for _, session := range []string{"a", "b"} {
+					req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+					req.Header.Set("Cookie", "session="+session)
+					reqs = append(reqs, req)
+				}
+				return reqs
+			},
+		},
+		{
+			desc: "different cookie key headers",
+			opts: []Option{KeyHeaders("Cookie")},
+			reqs: func() []*http.Request {
+				var reqs []*http.Request
+				for _, session := range []string{"a", "b"} {
+					req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+					req.Header.Set("Cookie", "session="+session)
+					reqs = append(reqs, req)
+				}
+				return reqs
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			h := newBlockingHandler("hello")
+			c, err := New(h, test.opts...)
+			require.NoError(t, err)
+
+			recorders, wg := serveConcurrently(c, test.reqs()...)
+
+			assert.Eventually(t, func() bool {
+				return atomic.LoadInt32(&h.calls) == int32(len(recorders))
+			}, 5*time.Second, time.Millisecond)
+			close(h.release)
+			wg.Wait()
+
+			for _, rw := range recorders {
+				assert.Equal(t, "hello", rw.Body.String())
+			}
+		})
+	}
+}
+
+func TestSharedCookies(t *testing.T) {
+	h := newBlockingHandler("hello")
+	c, err := New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Set-Cookie", "session=renewed")
+		h.ServeHTTP(w, req)
+	}), KeyHeaders("Cookie"))
+	require.NoError(t, err)
+
+	var reqs []*http.Request
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+		req.Header.Set("Cookie", "session=a")
+		reqs = append(reqs, req)
+	}
+	recorders, wg := serveConcurrently(c, reqs...)
+
+	waitForWaiters(t, c, 2)
+	close(h.release)
+	wg.Wait()
+
+	assert.EqualValues(t, 1, atomic.LoadInt32(&h.calls))
+	cookies := 0
+	for _, rw := range recorders {
+		assert.Equal(t, "hello", rw.Body.String())
+		assert.Equal(t, "value", rw.Header().Get("X-Test"))
+		if rw.Header().Get("Set-Cookie") != "" {
+			cookies++
+		}
+	}
+	// only the leader gets the cookie
+	assert.Equal(t, 1, cookies)
+}
+
+func TestMaxWaiters(t *testing.T) {
+	h := newBlockingHandler("hello")
+	c, err := New(h, MaxWaiters(1))
+	require.NoError(t, err)
+
+	recorders, wg := serveConcurrently(c,
+		httptest.NewRequest(http.MethodGet, "http://localhost/", nil),
+		httptest.NewRequest(http.MethodGet, "http://localhost/", nil))
+	waitForWaiters(t, c, 1)
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rw.Code)
+
+	close(h.release)
+	wg.Wait()
+
+	for _, rw := range recorders {
+		assert.Equal(t, "hello", rw.Body.String())
+	}
+	assert.EqualValues(t, 1, atomic.LoadInt32(&h.calls))
+}
+
+func TestTimeout(t *testing.T) {
+	h := newBlockingHandler("hello")
+	c, err := New(h, Timeout(10*time.Millisecond))
+	require.NoError(t, err)
+
+	recorders, wg := serveConcurrently(c, httptest.NewRequest(http.MethodGet, "http://localhost/", nil))
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&h.calls) == 1
+	}, 5*time.Second, time.Millisecond)
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, rw.Code)
+
+	close(h.release)
+	wg.Wait()
+
+	assert.Equal(t, "hello", recorders[0].Body.String())
+}
+
+func TestMaxWaitersTimeout(t *testing.T) {
+	h := newBlockingHandler("hello")
+	c, err := New(h, MaxWaiters(1), Timeout(10*time.Millisecond))
+	require.NoError(t, err)
+
+	recorders, wg := serveConcurrently(c, httptest.NewRequest(http.MethodGet, "http://localhost/", nil))
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&h.calls) == 1
+	}, 5*time.Second, time.Millisecond)
+
+	// the waiters giving up do not count against the maximum
+	for i := 0; i < 3; i++ {
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/", nil))
+		assert.Equal(t, http.StatusGatewayTimeout, rw.Code)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/", nil).WithContext(ctx))
+	waitForWaiters(t, c, 0)
+
+	close(h.release)
+	wg.Wait()
+
+	assert.Equal(t, "hello", recorders[0].Body.String())
+}
+
+func TestMaxWaitersErrorKind(t *testing.T) {
+	ue := utils.ClassifyError(&MaxWaitersError{max: 1})
+	assert.Equal(t, utils.ErrorKindTooManyWaiters, ue.Kind)
+	assert.Equal(t, http.StatusServiceUnavailable, ue.StatusCode())
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	content := strings.Repeat("hello", 100)
+	h := newBlockingHandler(content)
+	c, err := New(h, MemBodyBytes(10), MaxBodyBytes(100))
+	require.NoError(t, err)
+
+	recorders, wg := serveConcurrently(c,
+		httptest.NewRequest(http.MethodGet, "http://localhost/", nil),
+		httptest.NewRequest(http.MethodGet, "http://localhost/", nil),
+		httptest.NewRequest(http.MethodGet, "http://localhost/", nil))
+
+	waitForWaiters(t, c, 2)
+	close(h.release)
+	wg.Wait()
+
+	// the response is too large to be shared, it is streamed to the leader and the waiters are sent on their own
+	assert.EqualValues(t, 3, atomic.LoadInt32(&h.calls))
+	for _, rw := range recorders {
+		assert.Equal(t, http.StatusOK, rw.Code)
+		assert.Equal(t, content, rw.Body.String())
+	}
+}
+
+func TestLeaderCanceled(t *testing.T) {
+	var canceled int32
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+		if req.Context().Err() != nil {
+			atomic.StoreInt32(&canceled, 1)
+		}
+		_, _ = w.Write([]byte("hello"))
+	})
+	c, err := New(handler)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := httptest.NewRequest(http.MethodGet, "http://localhost/", nil).WithContext(ctx)
+	recorders, wg := serveConcurrently(c, leader, httptest.NewRequest(http.MethodGet, "http://localhost/", nil))
+
+	waitForWaiters(t, c, 1)
+	cancel()
+	close(release)
+	wg.Wait()
+
+	assert.EqualValues(t, 0, atomic.LoadInt32(&canceled))
+	assert.Equal(t, "hello", recorders[1].Body.String())
+}
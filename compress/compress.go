@@ -0,0 +1,245 @@
+/*
+Package compress provides http.Handler middleware compressing the responses of the next handler
+with the encoding negotiated with the Accept-Encoding header of the request: brotli, zstd or gzip.
+
+The responses are not compressed when they are:
+
+	smaller than the minimum size,
+	of a content type not listed in the allowed types,
+	already encoded,
+	partial (Range requests, 206 responses),
+	streamed, that is the next handler flushes before writing the body or the content type is text/event-stream,
+	marked with Cache-Control: no-transform.
+
+The compressed responses have their Content-Length removed, their ETag suffixed with the encoding
+and Accept-Encoding added to their Vary header.
+
+Examples of a compressing middleware:
+
+	// compresses the responses larger than 1KB with the default content types
+	compress.New(handler)
+
+	// only uses gzip, for responses larger than 4KB
+	compress.New(handler, compress.Encodings(compress.Gzip), compress.MinSize(4096))
+*/
+package compress
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/vulcand/oxy/logging"
+	"github.com/vulcand/oxy/utils"
+)
+
+const (
+	// DefaultMinSize is the minimum size of a compressed response body
+	DefaultMinSize = 1024
+)
+
+// DefaultContentTypes lists the content types compressed by default, a type ending with "/" matches all its subtypes
+var DefaultContentTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/ld+json",
+	"application/manifest+json",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// Option is a functional option setter for Compressor
+type Option func(*Compressor) error
+
+// Encodings sets the encodings the compressor uses, in order of preference.
+// It defaults to DefaultEncodings.
+func Encodings(names ...string) Option {
+	return func(c *Compressor) error {
+		for _, name := range names {
+			if _, ok := encoderPools[name]; !ok {
+				return fmt.Errorf("unsupported encoding %q", name)
+			}
+		}
+		c.encodings = names
+		return nil
+	}
+}
+
+// MinSize sets the minimum size of a compressed response body, the responses with an unknown size are buffered
+// up to this size before deciding. It defaults to DefaultMinSize.
+func MinSize(n int) Option {
+	return func(c *Compressor) error {
+		if n < 0 {
+			return fmt.Errorf("min size should be >= 0 got %d", n)
+		}
+		c.minSize = n
+		return nil
+	}
+}
+
+// ContentTypes sets the content types to compress, a type ending with "/" matches all its subtypes.
+// It defaults to DefaultContentTypes.
+func ContentTypes(types ...string) Option {
+	return func(c *Compressor) error {
+		c.contentTypes = types
+		return nil
+	}
+}
+
+// Logger defines the logger the compressor will use.
+//
+// It defaults to logging.Default().
+func Logger(l logging.Logger) Option {
+	return func(c *Compressor) error {
+		c.log = l
+		return nil
+	}
+}
+
+// Compressor is a middleware compressing the responses of the next handler
+type Compressor struct {
+	next         http.Handler
+	encodings    []string
+	minSize      int
+	contentTypes []string
+
+	log logging.Logger
+}
+
+// New creates a new compressing middleware
+func New(next http.Handler, opts ...Option) (*Compressor, error) {
+	c := &Compressor{
+		next:         next,
+		encodings:    DefaultEncodings,
+		minSize:      DefaultMinSize,
+		contentTypes: DefaultContentTypes,
+
+		log: logging.Default(),
+	}
+	for _, o := range opts {
+		if err := o(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Wrap sets the next handler to be called by compressing handler.
+func (c *Compressor) Wrap(next http.Handler) error {
+	c.next = next
+	return nil
+}
+
+func (c *Compressor) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if c.log.Enabled(logging.DebugLevel) {
+		logger := utils.RequestLogger(c.log, req).With(utils.RequestField(req))
+		logging.Debug(logger, "vulcand/oxy/compress: begin ServeHttp on request")
+		defer logging.Debug(logger, "vulcand/oxy/compress: completed ServeHttp on request")
+	}
+
+	encoding := negotiate(req.Header, c.encodings)
+	if req.Header.Get("Range") != "" || req.Method == http.MethodHead {
+		encoding = ""
+	}
+
+	outReq := req
+	var etagSuffix string
+	if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		// the next handler only knows the ETags of the identity representations
+		if stripped, suffix := stripETagSuffixes(ifNoneMatch); suffix != "" {
+			outReq = req.Clone(req.Context())
+			outReq.Header.Set("If-None-Match", stripped)
+			etagSuffix = suffix
+		}
+	}
+
+	cw := &responseWriter{
+		rw:         w,
+		c:          c,
+		encoding:   encoding,
+		etagSuffix: etagSuffix,
+	}
+	defer cw.close()
+
+	c.next.ServeHTTP(cw, outReq)
+}
+
+// compressible tells if the response can be compressed regardless of its size and of the request
+func (c *Compressor) compressible(code int, h http.Header) bool {
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified ||
+		code == http.StatusPartialContent {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	if strings.Contains(strings.ToLower(h.Get("Cache-Control")), "no-transform") {
+		return false
+	}
+	contentType := mediaType(h.Get("Content-Type"))
+	if contentType == "" || contentType == "text/event-stream" {
+		return false
+	}
+	for _, t := range c.contentTypes {
+		if (strings.HasSuffix(t, "/") && strings.HasPrefix(contentType, t)) || contentType == t {
+			return true
+		}
+	}
+	return false
+}
+
+func mediaType(contentType string) string {
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// addVary adds Accept-Encoding to the Vary header if missing
+func addVary(h http.Header) {
+	for _, value := range h["Vary"] {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, "Accept-Encoding") {
+				return
+			}
+		}
+	}
+	h.Add("Vary", "Accept-Encoding")
+}
+
+// etagWithSuffix returns the ETag of the encoded representation
+func etagWithSuffix(etag, suffix string) string {
+	if !strings.HasSuffix(etag, `"`) || len(etag) < 2 {
+		return etag
+	}
+	return etag[:len(etag)-1] + suffix + `"`
+}
+
+// stripETagSuffixes removes the encoding suffixes from the ETags of an If-None-Match header,
+// it returns the suffix found if any
+func stripETagSuffixes(value string) (string, string) {
+	var found string
+	etags := strings.Split(value, ",")
+	for i, etag := range etags {
+		etag = strings.TrimSpace(etag)
+		for name := range encoderPools {
+			suffix := "-" + name
+			if strings.HasSuffix(etag, suffix+`"`) {
+				etag = etag[:len(etag)-len(suffix)-1] + `"`
+				found = suffix
+				break
+			}
+		}
+		etags[i] = etag
+	}
+	if found == "" {
+		return value, ""
+	}
+	return strings.Join(etags, ", "), found
+}
@@ -0,0 +1,249 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/testutils"
+)
+
+var content = strings.Repeat("hello, compressed world! ", 100)
+
+func decode(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+
+	var r io.Reader
+	switch encoding {
+	case Gzip:
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		r = gr
+	case Brotli:
+		r = brotli.NewReader(bytes.NewReader(body))
+	case Zstd:
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		defer zr.Close()
+		r = zr
+	default:
+		return string(body)
+	}
+	decoded, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	return string(decoded)
+}
+
+func newTestServer(t *testing.T, handler http.HandlerFunc, opts ...Option) *httptest.Server {
+	t.Helper()
+
+	c, err := New(handler, opts...)
+	require.NoError(t, err)
+	return httptest.NewServer(c)
+}
+
+func TestCompress(t *testing.T) {
+	for _, encoding := range DefaultEncodings {
+		encoding := encoding
+		t.Run(encoding, func(t *testing.T) {
+			srv := newTestServer(t, func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				w.Header().Set("ETag", `"v1"`)
+				w.Header().Set("Accept-Ranges", "bytes")
+				_, _ = w.Write([]byte(content))
+			})
+			defer srv.Close()
+
+			re, body, err := testutils.Get(srv.URL, testutils.Header("Accept-Encoding", encoding))
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, re.StatusCode)
+			assert.Equal(t, encoding, re.Header.Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", re.Header.Get("Vary"))
+			assert.Equal(t, `"v1-`+encoding+`"`, re.Header.Get("ETag"))
+			assert.Empty(t, re.Header.Get("Accept-Ranges"))
+			assert.True(t, len(body) < len(content))
+			assert.Equal(t, content, decode(t, encoding, body))
+		})
+	}
+}
+
+func TestCompressContentLength(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		_, _ = w.Write([]byte(content))
+	})
+	defer srv.Close()
+
+	re, body, err := testutils.Get(srv.URL, testutils.Header("Accept-Encoding", "gzip"))
+	require.NoError(t, err)
+	assert.Equal(t, Gzip, re.Header.Get("Content-Encoding"))
+	assert.NotEqual(t, strconv.Itoa(len(content)), re.Header.Get("Content-Length"))
+	assert.Equal(t, content, decode(t, Gzip, body))
+}
+
+func TestNotCompressed(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		handler http.HandlerFunc
+		request []testutils.ReqOption
+		vary    string
+	}{
+		{
+			desc: "no accept encoding",
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				_, _ = w.Write([]byte(content))
+			},
+			vary: "Accept-Encoding",
+		},
+		{
+			desc: "too small",
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				_, _ = w.Write([]byte(content[:100]))
+			},
+			request: []testutils.ReqOption{testutils.Header("Accept-Encoding", "gzip")},
+			vary:    "Accept-Encoding",
+		},
+		{
+			desc: "content type not allowed",
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				_, _ = w.Write([]byte(content))
+			},
+			request: []testutils.ReqOption{testutils.Header("Accept-Encoding", "gzip")},
+		},
+		{
+			desc: "already encoded",
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Content-Encoding", "identity")
+				_, _ = w.Write([]byte(content))
+			},
+			request: []testutils.ReqOption{testutils.Header("Accept-Encoding", "gzip")},
+		},
+		{
+			desc: "range request",
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				_, _ = w.Write([]byte(content))
+			},
+			request: []testutils.ReqOption{testutils.Header("Accept-Encoding", "gzip"), testutils.Header("Range", "bytes=0-10")},
+			vary:    "Accept-Encoding",
+		},
+		{
+			desc: "partial content",
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Content-Range", "bytes 0-2499/5000")
+				w.WriteHeader(http.StatusPartialContent)
+				_, _ = w.Write([]byte(content))
+			},
+			request: []testutils.ReqOption{testutils.Header("Accept-Encoding", "gzip")},
+		},
+		{
+			desc: "no-transform",
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Cache-Control", "public, no-transform")
+				_, _ = w.Write([]byte(content))
+			},
+			request: []testutils.ReqOption{testutils.Header("Accept-Encoding", "gzip")},
+		},
+		{
+			desc: "event stream",
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				_, _ = w.Write([]byte(content))
+			},
+			request: []testutils.ReqOption{testutils.Header("Accept-Encoding", "gzip")},
+		},
+		{
+			desc: "flushed before the body",
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.(http.Flusher).Flush()
+				_, _ = w.Write([]byte(content))
+			},
+			request: []testutils.ReqOption{testutils.Header("Accept-Encoding", "gzip")},
+			vary:    "Accept-Encoding",
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			srv := newTestServer(t, test.handler)
+			defer srv.Close()
+
+			re, body, err := testutils.Get(srv.URL, test.request...)
+			require.NoError(t, err)
+			assert.NotEqual(t, Gzip, re.Header.Get("Content-Encoding"))
+			assert.Equal(t, test.vary, re.Header.Get("Vary"))
+			assert.Equal(t, content[:len(body)], string(body))
+		})
+	}
+}
+
+func TestCompressFlush(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(content))
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte(content))
+	})
+	defer srv.Close()
+
+	re, body, err := testutils.Get(srv.URL, testutils.Header("Accept-Encoding", "gzip"))
+	require.NoError(t, err)
+	assert.Equal(t, Gzip, re.Header.Get("Content-Encoding"))
+	assert.Equal(t, content+content, decode(t, Gzip, body))
+}
+
+func TestCompressNotModified(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(content))
+	})
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL,
+		testutils.Header("Accept-Encoding", "gzip"),
+		testutils.Header("If-None-Match", `"v1-gzip"`))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotModified, re.StatusCode)
+	assert.Equal(t, `"v1-gzip"`, re.Header.Get("ETag"))
+}
+
+func TestCompressSniffContentType(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte("<html><body>" + content + "</body></html>"))
+	})
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL, testutils.Header("Accept-Encoding", "gzip"))
+	require.NoError(t, err)
+	assert.Equal(t, Gzip, re.Header.Get("Content-Encoding"))
+	assert.Equal(t, "text/html; charset=utf-8", re.Header.Get("Content-Type"))
+}
+
+func TestInvalidEncoding(t *testing.T) {
+	_, err := New(http.NotFoundHandler(), Encodings("deflate"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,136 @@
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Names of the supported encodings
+const (
+	Gzip   = "gzip"
+	Brotli = "br"
+	Zstd   = "zstd"
+)
+
+// DefaultEncodings lists the encodings used by default, in order of preference
+var DefaultEncodings = []string{Brotli, Zstd, Gzip}
+
+// encoder is a compressing writer, Flush writes the pending data to the underlying writer
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// the encoders are expensive to create, they are reused across responses
+var encoderPools = map[string]*sync.Pool{
+	Gzip: {New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(ioutil.Discard, gzip.DefaultCompression)
+		return w
+	}},
+	Brotli: {New: func() interface{} {
+		return brotli.NewWriterLevel(ioutil.Discard, brotli.DefaultCompression)
+	}},
+	Zstd: {New: func() interface{} {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+		return w
+	}},
+}
+
+func getEncoder(name string, w io.Writer) encoder {
+	e := encoderPools[name].Get().(encoder)
+	e.Reset(w)
+	return e
+}
+
+func putEncoder(name string, e encoder) {
+	e.Reset(ioutil.Discard)
+	encoderPools[name].Put(e)
+}
+
+// acceptedEncoding is a coding listed in Accept-Encoding with its weight
+type acceptedEncoding struct {
+	name string
+	q    float64
+}
+
+// parseAcceptEncoding parses the Accept-Encoding header, see RFC 9110 #12.5.3
+func parseAcceptEncoding(h http.Header) []acceptedEncoding {
+	var accepted []acceptedEncoding
+	for _, value := range h["Accept-Encoding"] {
+		for _, part := range strings.Split(value, ",") {
+			params := strings.Split(part, ";")
+			name := strings.ToLower(strings.TrimSpace(params[0]))
+			if name == "" {
+				continue
+			}
+			q := 1.0
+			for _, param := range params[1:] {
+				param = strings.TrimSpace(param)
+				if !strings.HasPrefix(strings.ToLower(param), "q=") {
+					continue
+				}
+				v, err := strconv.ParseFloat(strings.TrimSpace(param[2:]), 64)
+				if err != nil || v < 0 || v > 1 {
+					v = 0
+				}
+				q = v
+			}
+			accepted = append(accepted, acceptedEncoding{name: name, q: q})
+		}
+	}
+	return accepted
+}
+
+// negotiate returns the encoding to use among the supported ones in order of preference,
+// an empty string means the response is not encoded
+func negotiate(h http.Header, supported []string) string {
+	accepted := parseAcceptEncoding(h)
+	if len(accepted) == 0 {
+		return ""
+	}
+
+	weights := make(map[string]float64, len(accepted))
+	wildcard, hasWildcard := 0.0, false
+	for _, a := range accepted {
+		if a.name == "*" {
+			wildcard, hasWildcard = a.q, true
+			continue
+		}
+		weights[a.name] = a.q
+	}
+
+	candidates := make([]acceptedEncoding, 0, len(supported))
+	for _, name := range supported {
+		q, ok := weights[name]
+		if !ok {
+			if !hasWildcard {
+				continue
+			}
+			q = wildcard
+		}
+		if q > 0 {
+			candidates = append(candidates, acceptedEncoding{name: name, q: q})
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	// the stable sort keeps the server preference among the same weights
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	if identity, ok := weights["identity"]; ok && identity > candidates[0].q {
+		return ""
+	}
+	return candidates[0].name
+}
@@ -0,0 +1,100 @@
+package compress
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiate(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		acceptEncoding string
+		supported      []string
+		expected       string
+	}{
+		{
+			desc:     "no header",
+			expected: "",
+		},
+		{
+			desc:           "server preference",
+			acceptEncoding: "gzip, deflate, br, zstd",
+			expected:       Brotli,
+		},
+		{
+			desc:           "client weights",
+			acceptEncoding: "br;q=0.5, gzip;q=0.8, zstd;q=0.1",
+			expected:       Gzip,
+		},
+		{
+			desc:           "unsupported",
+			acceptEncoding: "deflate, compress",
+			expected:       "",
+		},
+		{
+			desc:           "restricted encodings",
+			acceptEncoding: "br, gzip",
+			supported:      []string{Gzip},
+			expected:       Gzip,
+		},
+		{
+			desc:           "excluded",
+			acceptEncoding: "br;q=0, gzip",
+			expected:       Gzip,
+		},
+		{
+			desc:           "wildcard",
+			acceptEncoding: "*",
+			expected:       Brotli,
+		},
+		{
+			desc:           "wildcard with exclusion",
+			acceptEncoding: "br;q=0, zstd;q=0, *;q=0.5",
+			expected:       Gzip,
+		},
+		{
+			desc:           "identity preferred",
+			acceptEncoding: "identity, gzip;q=0.5",
+			expected:       "",
+		},
+		{
+			desc:           "invalid weight",
+			acceptEncoding: "br;q=abc, gzip",
+			expected:       Gzip,
+		},
+		{
+			desc:           "case insensitive",
+			acceptEncoding: "GZIP; Q=0.5",
+			expected:       Gzip,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			supported := test.supported
+			if supported == nil {
+				supported = DefaultEncodings
+			}
+			h := http.Header{}
+			if test.acceptEncoding != "" {
+				h.Set("Accept-Encoding", test.acceptEncoding)
+			}
+			assert.Equal(t, test.expected, negotiate(h, supported))
+		})
+	}
+}
+
+func TestStripETagSuffixes(t *testing.T) {
+	stripped, suffix := stripETagSuffixes(`"abc-gzip", W/"def-gzip"`)
+	assert.Equal(t, `"abc", W/"def"`, stripped)
+	assert.Equal(t, "-gzip", suffix)
+
+	stripped, suffix = stripETagSuffixes(`"abc"`)
+	assert.Equal(t, `"abc"`, stripped)
+	assert.Empty(t, suffix)
+}
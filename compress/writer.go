@@ -0,0 +1,180 @@
+package compress
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/vulcand/oxy/logging"
+)
+
+// responseWriter compresses the response once it knows it is large enough and not streamed
+type responseWriter struct {
+	rw         http.ResponseWriter
+	c          *Compressor
+	encoding   string
+	etagSuffix string
+
+	code        int
+	wroteHeader bool
+	// decided is set once the header is written to rw, the body is buffered until then
+	decided  bool
+	buf      []byte
+	enc      encoder
+	hijacked bool
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.rw.Header()
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.code = code
+
+	h := w.rw.Header()
+	if code == http.StatusNotModified && w.etagSuffix != "" {
+		// the client validated the encoded representation
+		if etag := h.Get("ETag"); etag != "" {
+			h.Set("ETag", etagWithSuffix(etag, w.etagSuffix))
+		}
+	}
+	if !w.c.compressible(code, h) {
+		w.decide(false)
+		return
+	}
+	addVary(h)
+	if w.encoding == "" {
+		w.decide(false)
+		return
+	}
+	if length, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64); err == nil {
+		w.decide(length >= int64(w.c.minSize))
+	}
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		if _, ok := w.rw.Header()["Content-Type"]; !ok && len(p) > 0 {
+			w.rw.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.decided {
+		if w.enc != nil {
+			return w.enc.Write(p)
+		}
+		return w.rw.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.c.minSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends the buffered data to the client, a response flushed before being large enough to be compressed
+// is considered streamed and not compressed
+func (w *responseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		if err := w.decide(false); err != nil {
+			return
+		}
+	}
+	if w.enc != nil {
+		if err := w.enc.Flush(); err != nil {
+			return
+		}
+	}
+	if f, ok := w.rw.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// CloseNotify returns a channel that receives at most a single value (true)
+// when the client connection has gone away.
+func (w *responseWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.rw.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	logging.Debug(w.c.log, "vulcand/oxy/compress: upstream ResponseWriter does not implement http.CloseNotifier, returning dummy channel",
+		logging.Any("type", reflect.TypeOf(w.rw)))
+	return make(<-chan bool)
+}
+
+// Hijack lets the caller take over the connection.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hi, ok := w.rw.(http.Hijacker); ok {
+		conn, rw, err := hi.Hijack()
+		if err == nil {
+			w.hijacked = true
+		}
+		return conn, rw, err
+	}
+	logging.Debug(w.c.log, "vulcand/oxy/compress: upstream ResponseWriter does not implement http.Hijacker",
+		logging.Any("type", reflect.TypeOf(w.rw)))
+	return nil, nil, fmt.Errorf("the response writer wrapped in this compressor does not implement http.Hijacker. Its type is: %v", reflect.TypeOf(w.rw))
+}
+
+// decide writes the header, compressed or not, followed by the buffered body
+func (w *responseWriter) decide(compress bool) error {
+	w.decided = true
+
+	if compress {
+		h := w.rw.Header()
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		if etag := h.Get("ETag"); etag != "" {
+			h.Set("ETag", etagWithSuffix(etag, "-"+w.encoding))
+		}
+		w.enc = getEncoder(w.encoding, w.rw)
+	}
+	w.rw.WriteHeader(w.code)
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+	buf := w.buf
+	w.buf = nil
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(buf)
+	} else {
+		_, err = w.rw.Write(buf)
+	}
+	return err
+}
+
+// close writes the rest of the response
+func (w *responseWriter) close() {
+	if w.hijacked || !w.wroteHeader {
+		return
+	}
+	if !w.decided {
+		// too small to be compressed
+		if err := w.decide(false); err != nil {
+			logging.Debug(w.c.log, "vulcand/oxy/compress: failed to write response", logging.Err(err))
+		}
+		return
+	}
+	if w.enc != nil {
+		if err := w.enc.Close(); err != nil {
+			logging.Debug(w.c.log, "vulcand/oxy/compress: failed to write response", logging.Err(err))
+		}
+		putEncoder(w.encoding, w.enc)
+		w.enc = nil
+	}
+}
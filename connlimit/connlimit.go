@@ -6,7 +6,8 @@ import (
 	"net/http"
 	"sync"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/vulcand/oxy/logging"
+	"github.com/vulcand/oxy/metrics"
 	"github.com/vulcand/oxy/utils"
 )
 
@@ -21,7 +22,8 @@ type ConnLimiter struct {
 	next             http.Handler
 
 	errHandler utils.ErrorHandler
-	log        *log.Logger
+	observer   metrics.Observer
+	log        logging.Logger
 }
 
 // New creates a new ConnLimiter
@@ -35,7 +37,7 @@ func New(next http.Handler, extract utils.SourceExtractor, maxConnections int64,
 		maxConnections: maxConnections,
 		connections:    make(map[string]int64),
 		next:           next,
-		log:            log.StandardLogger(),
+		log:            logging.Default(),
 	}
 
 	for _, o := range options {
@@ -53,8 +55,8 @@ func New(next http.Handler, extract utils.SourceExtractor, maxConnections int64,
 
 // Logger defines the logger the connection limiter will use.
 //
-// It defaults to logrus.StandardLogger(), the global logger used by logrus.
-func Logger(l *log.Logger) ConnLimitOption {
+// It defaults to logging.Default().
+func Logger(l logging.Logger) ConnLimitOption {
 	return func(cl *ConnLimiter) error {
 		cl.log = l
 		return nil
@@ -69,18 +71,24 @@ func (cl *ConnLimiter) Wrap(h http.Handler) {
 func (cl *ConnLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	token, amount, err := cl.extract.Extract(r)
 	if err != nil {
-		cl.log.Errorf("failed to extract source of the connection: %v", err)
+		logging.Error(utils.RequestLogger(cl.log, r), "failed to extract source of the connection", logging.Err(err))
 		cl.errHandler.ServeHTTP(w, r, err)
 		return
 	}
 	if err := cl.acquire(token, amount); err != nil {
-		cl.log.Debugf("limiting request source %s: %v", token, err)
+		if cl.log.Enabled(logging.DebugLevel) {
+			logging.Debug(utils.RequestLogger(cl.log, r), "limiting request source", logging.String("source", token), logging.Err(err))
+		}
 		cl.errHandler.ServeHTTP(w, r, err)
 		return
 	}
 
 	defer cl.release(token, amount)
 
+	m := metrics.Resolve(r.Context(), cl.observer)
+	m.AddConnections(amount)
+	defer m.AddConnections(-amount)
+
 	cl.next.ServeHTTP(w, r)
 }
 
@@ -111,6 +119,30 @@ func (cl *ConnLimiter) release(token string, amount int64) {
 	}
 }
 
+// Status is the state of the connection limiter at a point in time
+type Status struct {
+	MaxConnections   int64
+	TotalConnections int64
+	// Connections is the number of connections per source
+	Connections map[string]int64
+}
+
+// Status returns the current connections of the limiter
+func (cl *ConnLimiter) Status() Status {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	connections := make(map[string]int64, len(cl.connections))
+	for token, n := range cl.connections {
+		connections[token] = n
+	}
+	return Status{
+		MaxConnections:   cl.maxConnections,
+		TotalConnections: cl.totalConnections,
+		Connections:      connections,
+	}
+}
+
 // MaxConnError maximum connections reached error
 type MaxConnError struct {
 	max int64
@@ -120,19 +152,25 @@ func (m *MaxConnError) Error() string {
 	return fmt.Sprintf("max connections reached: %d", m.max)
 }
 
+// ErrorKind classifies the error as rate limited
+func (m *MaxConnError) ErrorKind() utils.ErrorKind {
+	return utils.ErrorKindRateLimited
+}
+
 // ConnErrHandler connection limiter error handler
 type ConnErrHandler struct {
-	log *log.Logger
+	log logging.Logger
 }
 
 func (e *ConnErrHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, err error) {
-	if e.log.Level >= log.DebugLevel {
-		logEntry := e.log.WithField("Request", utils.DumpHttpRequest(req))
-		logEntry.Debug("vulcand/oxy/connlimit: begin ServeHttp on request")
-		defer logEntry.Debug("vulcand/oxy/connlimit: completed ServeHttp on request")
+	if e.log.Enabled(logging.DebugLevel) {
+		logger := utils.RequestLogger(e.log, req).With(utils.RequestField(req))
+		logging.Debug(logger, "vulcand/oxy/connlimit: begin ServeHttp on request")
+		defer logging.Debug(logger, "vulcand/oxy/connlimit: completed ServeHttp on request")
 	}
 
 	if _, ok := err.(*MaxConnError); ok {
+		utils.RecordError(req, utils.ClassifyError(err))
 		w.WriteHeader(429)
 		w.Write([]byte(err.Error()))
 		return
@@ -150,3 +188,12 @@ func ErrorHandler(h utils.ErrorHandler) ConnLimitOption {
 		return nil
 	}
 }
+
+// Metrics sets the observer of the accepted connections,
+// it defaults to the observer of the metrics middleware handling the request.
+func Metrics(o metrics.Observer) ConnLimitOption {
+	return func(cl *ConnLimiter) error {
+		cl.observer = o
+		return nil
+	}
+}
@@ -4,10 +4,16 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/metrics"
+	"github.com/vulcand/oxy/metrics/inmemmetrics"
+	"github.com/vulcand/oxy/metrics/prommetrics"
 	"github.com/vulcand/oxy/testutils"
 	"github.com/vulcand/oxy/utils"
 )
@@ -60,6 +66,75 @@ func TestHitLimitAndRelease(t *testing.T) {
 	assert.Equal(t, http.StatusOK, re.StatusCode)
 }
 
+func TestMetrics(t *testing.T) {
+	proceed := make(chan bool)
+	wait := make(chan bool)
+	finish := make(chan bool)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Wait") != "" {
+			proceed <- true
+			<-wait
+		}
+		w.Write([]byte("hello"))
+	})
+
+	cl, err := New(handler, headerLimit, 2)
+	require.NoError(t, err)
+
+	registry := prometheus.NewRegistry()
+	m, err := prommetrics.NewMetrics(registry)
+	require.NoError(t, err)
+	mw, err := metrics.New(cl, m)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(mw)
+	defer srv.Close()
+
+	go func() {
+		re, _, errGet := testutils.Get(srv.URL, testutils.Header("Limit", "a"), testutils.Header("Wait", "yes"))
+		require.NoError(t, errGet)
+		assert.Equal(t, http.StatusOK, re.StatusCode)
+		finish <- true
+	}()
+
+	<-proceed
+
+	gauge := `
+		# HELP oxy_connlimit_connections Number of connections currently accepted by the connection limiters.
+		# TYPE oxy_connlimit_connections gauge
+		oxy_connlimit_connections %d
+	`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(fmt.Sprintf(gauge, 1)), "oxy_connlimit_connections"))
+
+	close(wait)
+	<-finish
+
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(fmt.Sprintf(gauge, 0)), "oxy_connlimit_connections"))
+}
+
+func TestMetricsOption(t *testing.T) {
+	m, err := inmemmetrics.NewMemMetrics()
+	require.NoError(t, err)
+
+	var observed int64
+	cl, err := New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		observed = m.Snapshot().Connections
+		w.Write([]byte("hello"))
+	}), headerLimit, 1, Metrics(m))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(cl)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL, testutils.Header("Limit", "a"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+
+	assert.Equal(t, int64(1), observed)
+	assert.Equal(t, int64(0), m.Snapshot().Connections)
+}
+
 // We've hit the limit and were able to proceed once the request has completed
 func TestCustomHandlers(t *testing.T) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
@@ -109,3 +184,25 @@ func faultyExtractor(_ *http.Request) (string, int64, error) {
 
 var headerLimit = utils.ExtractorFunc(headerLimiter)
 var faultyExtract = utils.ExtractorFunc(faultyExtractor)
+
+func TestStatus(t *testing.T) {
+	var status Status
+	var cl *ConnLimiter
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		status = cl.Status()
+		w.Write([]byte("hello"))
+	})
+
+	cl, err := New(handler, headerLimit, 3)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(cl)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL, testutils.Header("Limit", "a"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+
+	assert.Equal(t, Status{MaxConnections: 3, TotalConnections: 1, Connections: map[string]int64{"a": 1}}, status)
+	assert.Equal(t, Status{MaxConnections: 3, Connections: map[string]int64{}}, cl.Status())
+}
@@ -20,24 +20,15 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
-	log "github.com/sirupsen/logrus"
+	"github.com/vulcand/oxy/logging"
+	"github.com/vulcand/oxy/metrics"
+	"github.com/vulcand/oxy/tracing"
 	"github.com/vulcand/oxy/utils"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/semconv/v1.17.0/httpconv"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// OxyLogger interface of the internal
-type OxyLogger interface {
-	log.FieldLogger
-	GetLevel() log.Level
-}
-
-type internalLogger struct {
-	*log.Logger
-}
-
-func (i *internalLogger) GetLevel() log.Level {
-	return i.Level
-}
-
 // ReqRewriter can alter request headers and body
 type ReqRewriter interface {
 	Rewrite(r *http.Request)
@@ -78,6 +69,16 @@ func WebsocketTLSClientConfig(tcc *tls.Config) optSetter {
 	}
 }
 
+// TLSConfigProvider sets the provider of the per backend TLS configurations, used by the websocket dialer
+// and by the HTTP round tripper. The default round tripper becomes a TransportManager using the provider,
+// a round tripper set with the RoundTripper option must be a TransportManager using the same provider.
+func TLSConfigProvider(p *TLSProvider) optSetter {
+	return func(f *Forwarder) error {
+		f.httpForwarder.tlsProvider = p
+		return nil
+	}
+}
+
 // ErrorHandler is a functional argument that sets error handler of the server
 func ErrorHandler(h utils.ErrorHandler) optSetter {
 	return func(f *Forwarder) error {
@@ -94,6 +95,15 @@ func BufferPool(pool httputil.BufferPool) optSetter {
 	}
 }
 
+// Metrics sets the observer of the upstream requests and the websocket connections,
+// it defaults to the observer of the metrics middleware handling the request.
+func Metrics(o metrics.Observer) optSetter {
+	return func(f *Forwarder) error {
+		f.observer = o
+		return nil
+	}
+}
+
 // Stream specifies if HTTP responses should be streamed.
 func Stream(stream bool) optSetter {
 	return func(f *Forwarder) error {
@@ -104,20 +114,11 @@ func Stream(stream bool) optSetter {
 
 // Logger defines the logger the forwarder will use.
 //
-// It defaults to logrus.StandardLogger(), the global logger used by logrus.
-func Logger(l log.FieldLogger) optSetter {
+// It defaults to logging.Default().
+func Logger(l logging.Logger) optSetter {
 	return func(f *Forwarder) error {
-		if logger, ok := l.(OxyLogger); ok {
-			f.log = logger
-			return nil
-		}
-
-		if logger, ok := l.(*log.Logger); ok {
-			f.log = &internalLogger{Logger: logger}
-			return nil
-		}
-
-		return errors.New("the type of the logger must be OxyLogger or logrus.Logger")
+		f.log = l
+		return nil
 	}
 }
 
@@ -194,10 +195,16 @@ type httpForwarder struct {
 	passHost       bool
 	flushInterval  time.Duration
 	modifyResponse func(*http.Response) error
+	pathRewriter   pathRewriter
+	timeouts       Timeouts
 
 	tlsClientConfig *tls.Config
+	tlsProvider     *TLSProvider
+
+	log      logging.Logger
+	observer metrics.Observer
 
-	log OxyLogger
+	websockets websocketSessions
 
 	bufferPool                    httputil.BufferPool
 	websocketConnectionClosedHook func(req *http.Request, conn net.Conn)
@@ -217,7 +224,7 @@ type UrlForwardingStateListener func(*url.URL, int)
 // New creates an instance of Forwarder based on the provided list of configuration options
 func New(setters ...optSetter) (*Forwarder, error) {
 	f := &Forwarder{
-		httpForwarder:  &httpForwarder{log: &internalLogger{Logger: log.StandardLogger()}},
+		httpForwarder:  &httpForwarder{log: logging.Default()},
 		handlerContext: &handlerContext{},
 	}
 	for _, s := range setters {
@@ -240,6 +247,23 @@ func New(setters ...optSetter) (*Forwarder, error) {
 		f.httpForwarder.rewriter = &HeaderRewriter{TrustForwardHeader: true, Hostname: h}
 	}
 
+	if f.tlsProvider != nil {
+		switch rt := f.httpForwarder.roundTripper.(type) {
+		case nil:
+			tm, err := NewTransportManager(TransportTLSProvider(f.tlsProvider))
+			if err != nil {
+				return nil, err
+			}
+			f.httpForwarder.roundTripper = tm
+		case *TransportManager:
+			if rt.tlsProvider != f.tlsProvider {
+				return nil, errors.New("the TransportManager must use the TLS provider of the forwarder")
+			}
+		default:
+			return nil, errors.New("the round tripper must be a TransportManager to use a TLS provider")
+		}
+	}
+
 	if f.httpForwarder.roundTripper == nil {
 		f.httpForwarder.roundTripper = http.DefaultTransport
 	}
@@ -249,11 +273,19 @@ func New(setters ...optSetter) (*Forwarder, error) {
 	}
 
 	if f.tlsClientConfig == nil {
-		if ht, ok := f.httpForwarder.roundTripper.(*http.Transport); ok {
-			f.tlsClientConfig = ht.TLSClientConfig
+		switch rt := f.httpForwarder.roundTripper.(type) {
+		case *http.Transport:
+			f.tlsClientConfig = rt.TLSClientConfig
+		case *TransportManager:
+			f.tlsClientConfig = rt.tlsClientConfig
 		}
 	}
 
+	f.httpForwarder.roundTripper = &timeoutRoundTripper{
+		RoundTripper: f.httpForwarder.roundTripper,
+		timeouts:     f.httpForwarder.timeouts,
+	}
+
 	f.httpForwarder.roundTripper = ErrorHandlingRoundTripper{
 		RoundTripper: f.httpForwarder.roundTripper,
 		errorHandler: f.errHandler,
@@ -267,10 +299,10 @@ func New(setters ...optSetter) (*Forwarder, error) {
 // ServeHTTP decides which forwarder to use based on the specified
 // request and delegates to the proper implementation
 func (f *Forwarder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	if f.log.GetLevel() >= log.DebugLevel {
-		logEntry := f.log.WithField("Request", utils.DumpHttpRequest(req))
-		logEntry.Debug("vulcand/oxy/forward: begin ServeHttp on request")
-		defer logEntry.Debug("vulcand/oxy/forward: completed ServeHttp on request")
+	if f.log.Enabled(logging.DebugLevel) {
+		logger := f.requestLogger(req).With(utils.RequestField(req))
+		logging.Debug(logger, "vulcand/oxy/forward: begin ServeHttp on request")
+		defer logging.Debug(logger, "vulcand/oxy/forward: completed ServeHttp on request")
 	}
 
 	if f.stateListener != nil {
@@ -294,7 +326,7 @@ func (f *httpForwarder) getUrlFromRequest(req *http.Request) *url.URL {
 		if err == nil {
 			u = parsedURL
 		} else {
-			f.log.Warnf("vulcand/oxy/forward: error when parsing RequestURI: %s", err)
+			logging.Warn(f.requestLogger(req), "vulcand/oxy/forward: error when parsing RequestURI", logging.Err(err))
 		}
 	}
 	return u
@@ -321,6 +353,10 @@ func (f *httpForwarder) modifyRequest(outReq *http.Request, target *url.URL) {
 		f.rewriter.Rewrite(outReq)
 	}
 
+	f.rewritePath(outReq)
+	setRequestID(outReq)
+	tracing.Inject(outReq.Context(), outReq.Header)
+
 	// Do not pass client Host header unless optsetter PassHostHeader is set.
 	if !f.passHost {
 		outReq.Host = target.Host
@@ -329,37 +365,53 @@ func (f *httpForwarder) modifyRequest(outReq *http.Request, target *url.URL) {
 
 // serveHTTP forwards websocket traffic
 func (f *httpForwarder) serveWebSocket(w http.ResponseWriter, req *http.Request, ctx *handlerContext) {
-	if f.log.GetLevel() >= log.DebugLevel {
-		logEntry := f.log.WithField("Request", utils.DumpHttpRequest(req))
-		logEntry.Debug("vulcand/oxy/forward/websocket: begin ServeHttp on request")
-		defer logEntry.Debug("vulcand/oxy/forward/websocket: completed ServeHttp on request")
+	if f.log.Enabled(logging.DebugLevel) {
+		logger := f.requestLogger(req).With(utils.RequestField(req))
+		logging.Debug(logger, "vulcand/oxy/forward/websocket: begin ServeHttp on request")
+		defer logging.Debug(logger, "vulcand/oxy/forward/websocket: completed ServeHttp on request")
 	}
 
-	outReq := f.copyWebSocketRequest(req)
+	spanCtx, span := tracing.StartSpan(req.Context(), "websocket", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	outReq := f.copyWebSocketRequest(req.WithContext(spanCtx))
+	span.SetAttributes(httpconv.ClientRequest(outReq)...)
 
-	dialer := websocket.DefaultDialer
+	// copy the default dialer to not share the TLS configuration and the timeouts between backends
+	dialer := *websocket.DefaultDialer
+	f.timeouts.forRequest(outReq).applyToDialer(&dialer, outReq.URL.Scheme == "wss")
 
-	if outReq.URL.Scheme == "wss" && f.tlsClientConfig != nil {
-		dialer.TLSClientConfig = f.tlsClientConfig.Clone()
-		// WebSocket is only in http/1.1
-		dialer.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	if outReq.URL.Scheme == "wss" {
+		tlsClientConfig := f.tlsClientConfig
+		if f.tlsProvider != nil {
+			if c := f.tlsProvider.ClientConfig(outReq.URL.Host); c != nil {
+				tlsClientConfig = c
+			}
+		}
+		if tlsClientConfig != nil {
+			dialer.TLSClientConfig = tlsClientConfig.Clone()
+			// WebSocket is only in http/1.1
+			dialer.TLSClientConfig.NextProtos = []string{"http/1.1"}
+		}
 	}
 	targetConn, resp, err := dialer.DialContext(outReq.Context(), outReq.URL.String(), outReq.Header)
 	if err != nil {
+		tracing.SetError(span, err)
 		if resp == nil {
 			ctx.errHandler.ServeHTTP(w, req, err)
 		} else {
-			f.log.Errorf("vulcand/oxy/forward/websocket: Error dialing %q: %v with resp: %d %s", outReq.Host, err, resp.StatusCode, resp.Status)
+			logging.Error(f.requestLogger(req), "vulcand/oxy/forward/websocket: Error dialing",
+				logging.String("host", outReq.Host), logging.Err(err), logging.Int("code", resp.StatusCode), logging.String("status", resp.Status))
 			hijacker, ok := w.(http.Hijacker)
 			if !ok {
-				f.log.Errorf("vulcand/oxy/forward/websocket: %s can not be hijack", reflect.TypeOf(w))
+				logging.Error(f.requestLogger(req), "vulcand/oxy/forward/websocket: ResponseWriter can not be hijacked", logging.Any("type", reflect.TypeOf(w)))
 				ctx.errHandler.ServeHTTP(w, req, err)
 				return
 			}
 
 			conn, _, errHijack := hijacker.Hijack()
 			if errHijack != nil {
-				f.log.Errorf("vulcand/oxy/forward/websocket: Failed to hijack responseWriter")
+				logging.Error(f.requestLogger(req), "vulcand/oxy/forward/websocket: Failed to hijack responseWriter", logging.Err(errHijack))
 				ctx.errHandler.ServeHTTP(w, req, errHijack)
 				return
 			}
@@ -372,7 +424,7 @@ func (f *httpForwarder) serveWebSocket(w http.ResponseWriter, req *http.Request,
 
 			errWrite := resp.Write(conn)
 			if errWrite != nil {
-				f.log.Errorf("vulcand/oxy/forward/websocket: Failed to forward response")
+				logging.Error(f.requestLogger(req), "vulcand/oxy/forward/websocket: Failed to forward response", logging.Err(errWrite))
 				ctx.errHandler.ServeHTTP(w, req, errWrite)
 				return
 			}
@@ -380,6 +432,8 @@ func (f *httpForwarder) serveWebSocket(w http.ResponseWriter, req *http.Request,
 		return
 	}
 
+	span.SetAttributes(semconv.HTTPStatusCode(resp.StatusCode))
+
 	// Only the targetConn choose to CheckOrigin or not
 	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool {
 		return true
@@ -390,9 +444,15 @@ func (f *httpForwarder) serveWebSocket(w http.ResponseWriter, req *http.Request,
 
 	underlyingConn, err := upgrader.Upgrade(w, req, resp.Header)
 	if err != nil {
-		f.log.Errorf("vulcand/oxy/forward/websocket: Error while upgrading connection : %v", err)
+		logging.Error(f.requestLogger(req), "vulcand/oxy/forward/websocket: Error while upgrading connection", logging.Err(err))
+		tracing.SetError(span, err)
 		return
 	}
+	m := metrics.Resolve(req.Context(), f.observer)
+	m.WebsocketOpened()
+	defer m.WebsocketClosed()
+	session := f.websockets.add(req, outReq)
+	defer f.websockets.remove(session)
 	defer func() {
 		underlyingConn.Close()
 		targetConn.Close()
@@ -462,13 +522,14 @@ func (f *httpForwarder) serveWebSocket(w http.ResponseWriter, req *http.Request,
 	var message string
 	select {
 	case err = <-errClient:
-		message = "vulcand/oxy/forward/websocket: Error when copying from backend to client: %v"
+		message = "vulcand/oxy/forward/websocket: Error when copying from backend to client"
 	case err = <-errBackend:
-		message = "vulcand/oxy/forward/websocket: Error when copying from client to backend: %v"
+		message = "vulcand/oxy/forward/websocket: Error when copying from client to backend"
 
 	}
 	if e, ok := err.(*websocket.CloseError); !ok || e.Code == websocket.CloseAbnormalClosure {
-		f.log.Errorf(message, err)
+		logging.Error(f.requestLogger(req), message, logging.Err(err))
+		tracing.SetError(span, err)
 	}
 }
 
@@ -509,25 +570,33 @@ func (f *httpForwarder) copyWebSocketRequest(req *http.Request) (outReq *http.Re
 	if f.rewriter != nil {
 		f.rewriter.Rewrite(outReq)
 	}
+
+	f.rewritePath(outReq)
+	setRequestID(outReq)
+	tracing.Inject(outReq.Context(), outReq.Header)
 	return outReq
 }
 
 // serveHTTP forwards HTTP traffic using the configured transport
 func (f *httpForwarder) serveHTTP(w http.ResponseWriter, inReq *http.Request, ctx *handlerContext) {
-	if f.log.GetLevel() >= log.DebugLevel {
-		logEntry := f.log.WithField("Request", utils.DumpHttpRequest(inReq))
-		logEntry.Debug("vulcand/oxy/forward/http: begin ServeHttp on request")
-		defer logEntry.Debug("vulcand/oxy/forward/http: completed ServeHttp on request")
+	if f.log.Enabled(logging.DebugLevel) {
+		logger := f.requestLogger(inReq).With(utils.RequestField(inReq))
+		logging.Debug(logger, "vulcand/oxy/forward/http: begin ServeHttp on request")
+		defer logging.Debug(logger, "vulcand/oxy/forward/http: completed ServeHttp on request")
 	}
 
 	start := time.Now().UTC()
 
+	spanCtx, span := tracing.StartSpan(inReq.Context(), "upstream", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
 	outReq := new(http.Request)
-	*outReq = *inReq // includes shallow copies of maps, but we handle this in Director
+	*outReq = *inReq.WithContext(spanCtx) // includes shallow copies of maps, but we handle this in Director
 
 	revproxy := httputil.ReverseProxy{
 		Director: func(req *http.Request) {
 			f.modifyRequest(req, inReq.URL)
+			span.SetAttributes(httpconv.ClientRequest(req)...)
 		},
 		Transport:      f.roundTripper,
 		FlushInterval:  f.flushInterval,
@@ -535,24 +604,21 @@ func (f *httpForwarder) serveHTTP(w http.ResponseWriter, inReq *http.Request, ct
 		BufferPool:     f.bufferPool,
 	}
 
-	if f.log.GetLevel() >= log.DebugLevel {
+	m := metrics.Resolve(inReq.Context(), f.observer)
+	if f.log.Enabled(logging.DebugLevel) || span.IsRecording() || m != metrics.Nop {
 		pw := utils.NewProxyWriter(w)
 		revproxy.ServeHTTP(pw, outReq)
 
-		if inReq.TLS != nil {
-			f.log.Debugf("vulcand/oxy/forward/http: Round trip: %v, code: %v, Length: %v, duration: %v tls:version: %x, tls:resume:%t, tls:csuite:%x, tls:server:%v",
-				inReq.URL, pw.StatusCode(), pw.GetLength(), time.Now().UTC().Sub(start),
-				inReq.TLS.Version,
-				inReq.TLS.DidResume,
-				inReq.TLS.CipherSuite,
-				inReq.TLS.ServerName)
-		} else {
-			f.log.Debugf("vulcand/oxy/forward/http: Round trip: %v, code: %v, Length: %v, duration: %v",
-				inReq.URL, pw.StatusCode(), pw.GetLength(), time.Now().UTC().Sub(start))
+		span.SetAttributes(semconv.HTTPStatusCode(pw.StatusCode()))
+		span.SetStatus(httpconv.ClientStatus(pw.StatusCode()))
+		m.ObserveUpstream(backend(inReq.URL), inReq.Method, pw.StatusCode(), time.Now().UTC().Sub(start))
+		if f.log.Enabled(logging.DebugLevel) {
+			f.logRoundTrip(inReq, pw, start)
 		}
 	} else {
 		revproxy.ServeHTTP(w, outReq)
 	}
+	utils.RecordRoundTrip(inReq, backend(inReq.URL), time.Now().UTC().Sub(start))
 
 	for key := range w.Header() {
 		if strings.HasPrefix(key, http.TrailerPrefix) {
@@ -565,6 +631,47 @@ func (f *httpForwarder) serveHTTP(w http.ResponseWriter, inReq *http.Request, ct
 
 }
 
+// logRoundTrip logs the status, length and duration of the round trip to the upstream
+func (f *httpForwarder) logRoundTrip(inReq *http.Request, pw *utils.ProxyWriter, start time.Time) {
+	fields := []logging.Field{
+		logging.Any("url", inReq.URL),
+		logging.Int("code", pw.StatusCode()),
+		logging.Int64("length", pw.GetLength()),
+		logging.Duration("duration", time.Now().UTC().Sub(start)),
+	}
+	if inReq.TLS != nil {
+		fields = append(fields,
+			logging.String("tls_version", fmt.Sprintf("%x", inReq.TLS.Version)),
+			logging.Bool("tls_resume", inReq.TLS.DidResume),
+			logging.String("tls_cipher_suite", fmt.Sprintf("%x", inReq.TLS.CipherSuite)),
+			logging.String("tls_server", inReq.TLS.ServerName))
+	}
+	logging.Debug(f.requestLogger(inReq), "vulcand/oxy/forward/http: Round trip", fields...)
+}
+
+// backend returns the scheme and host of the URL of the backend
+func backend(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+// setRequestID propagates the ID of the request from its context in the header carrying it,
+// unless the request already has this header
+func setRequestID(outReq *http.Request) {
+	id := utils.RequestIDFromContext(outReq.Context())
+	if id == "" {
+		return
+	}
+	header := utils.RequestIDHeaderFromContext(outReq.Context())
+	if outReq.Header.Get(header) == "" {
+		outReq.Header.Set(header, id)
+	}
+}
+
+// requestLogger returns the logger with the ID of the request, if any, and the backend the request is forwarded to
+func (f *httpForwarder) requestLogger(req *http.Request) logging.Logger {
+	return utils.RequestLogger(f.log, req).With(logging.String("backend", backend(req.URL)))
+}
+
 // IsWebsocketRequest determines if the specified HTTP request is a
 // websocket handshake request
 func IsWebsocketRequest(req *http.Request) bool {
@@ -12,8 +12,19 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/logging/zaplog"
+	"github.com/vulcand/oxy/metrics"
+	"github.com/vulcand/oxy/metrics/inmemmetrics"
 	"github.com/vulcand/oxy/testutils"
+	"github.com/vulcand/oxy/tracing"
 	"github.com/vulcand/oxy/utils"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 // Makes sure hop-by-hop headers are removed
@@ -247,7 +258,8 @@ func TestCustomLogger(t *testing.T) {
 	})
 	defer srv.Close()
 
-	f, err := New()
+	core, logs := observer.New(zapcore.DebugLevel)
+	f, err := New(Logger(zaplog.New(zap.New(core))))
 	require.NoError(t, err)
 
 	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
@@ -256,9 +268,178 @@ func TestCustomLogger(t *testing.T) {
 	})
 	defer proxy.Close()
 
+	re, _, err := testutils.Get(proxy.URL, testutils.Header(utils.RequestIDHeader, "abc"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+
+	entries := logs.FilterMessage("vulcand/oxy/forward/http: Round trip").All()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "abc", fields[utils.RequestIDField])
+	assert.Equal(t, srv.URL, fields["backend"])
+	assert.Equal(t, int64(http.StatusOK), fields["code"])
+	assert.NotEmpty(t, logs.FilterMessage("vulcand/oxy/forward: begin ServeHttp on request").All())
+}
+
+func TestForwardRequestID(t *testing.T) {
+	var outID string
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		outID = req.Header.Get(utils.RequestIDHeader)
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	f, err := New()
+	require.NoError(t, err)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req.WithContext(utils.ContextWithRequestID(req.Context(), "from-context")))
+	})
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "from-context", outID)
+
+	// the header of the request is kept
+	re, _, err = testutils.Get(proxy.URL, testutils.Header(utils.RequestIDHeader, "from-header"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "from-header", outID)
+}
+
+func TestForwardRequestIDCustomHeader(t *testing.T) {
+	var outHeaders http.Header
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		outHeaders = req.Header
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	f, err := New()
+	require.NoError(t, err)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req.WithContext(utils.ContextWithRequestIDHeader(req.Context(), "X-Correlation-Id", "from-context")))
+	})
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "from-context", outHeaders.Get("X-Correlation-Id"))
+	assert.Empty(t, outHeaders.Get(utils.RequestIDHeader))
+}
+
+func TestForwardTracing(t *testing.T) {
+	var outHeaders http.Header
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		outHeaders = req.Header
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer srv.Close()
+
+	f, err := New()
+	require.NoError(t, err)
+
+	exporter := tracetest.NewInMemoryExporter()
+	tr, err := tracing.New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	}), tracing.TracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(tr)
+	defer proxy.Close()
+
 	re, _, err := testutils.Get(proxy.URL)
 	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, re.StatusCode)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	upstream, server := spans[0], spans[1]
+	assert.Equal(t, "upstream", upstream.Name)
+	assert.Equal(t, trace.SpanKindClient, upstream.SpanKind)
+	assert.Equal(t, server.SpanContext.SpanID(), upstream.Parent.SpanID())
+	assert.Equal(t, codes.Error, upstream.Status.Code)
+
+	traceID, spanID := upstream.SpanContext.TraceID().String(), upstream.SpanContext.SpanID().String()
+	assert.Equal(t, "00-"+traceID+"-"+spanID+"-01", outHeaders.Get("Traceparent"))
+	assert.Equal(t, traceID, outHeaders.Get("X-B3-Traceid"))
+	assert.Equal(t, spanID, outHeaders.Get("X-B3-Spanid"))
+}
+
+func TestForwardTracingClientB3(t *testing.T) {
+	var outHeaders http.Header
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		outHeaders = req.Header
+	})
+	defer srv.Close()
+
+	f, err := New()
+	require.NoError(t, err)
+
+	exporter := tracetest.NewInMemoryExporter()
+	tr, err := tracing.New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	}), tracing.TracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(tr)
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL, testutils.Header("B3", "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1"))
+	require.NoError(t, err)
 	assert.Equal(t, http.StatusOK, re.StatusCode)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	upstream := spans[0]
+	assert.Equal(t, "80f198ee56343ba864fe8b2a57d3eff7", upstream.SpanContext.TraceID().String())
+
+	// the upstream parents onto the upstream span, not the span of the client
+	assert.Empty(t, outHeaders.Get("B3"))
+	assert.Equal(t, upstream.SpanContext.TraceID().String(), outHeaders.Get("X-B3-Traceid"))
+	assert.Equal(t, upstream.SpanContext.SpanID().String(), outHeaders.Get("X-B3-Spanid"))
+}
+
+func TestForwardMetrics(t *testing.T) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer srv.Close()
+
+	fromContext, err := inmemmetrics.NewMemMetrics()
+	require.NoError(t, err)
+	fromOption, err := inmemmetrics.NewMemMetrics()
+	require.NoError(t, err)
+
+	f, err := New(Metrics(fromOption))
+	require.NoError(t, err)
+
+	// the observer of the option takes precedence over the one of the metrics middleware
+	mw, err := metrics.New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	}), fromContext)
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(mw)
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, re.StatusCode)
+
+	upstreams := fromOption.Snapshot().Upstreams
+	require.Contains(t, upstreams, srv.URL)
+	assert.Equal(t, map[int]int64{http.StatusServiceUnavailable: 1}, upstreams[srv.URL].StatusCodesCounts())
+	assert.Empty(t, fromContext.Snapshot().Upstreams)
 }
 
 func TestRouteForwarding(t *testing.T) {
@@ -4,17 +4,26 @@ import (
 	"bufio"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 
 	gorillawebsocket "github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/metrics"
+	"github.com/vulcand/oxy/metrics/prommetrics"
 	"github.com/vulcand/oxy/testutils"
+	"github.com/vulcand/oxy/tracing"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"golang.org/x/net/websocket"
 )
 
@@ -206,6 +215,59 @@ func TestWebSocketEcho(t *testing.T) {
 	conn.Close()
 }
 
+func TestWebSocketTracing(t *testing.T) {
+	f, err := New()
+	require.NoError(t, err)
+
+	var traceparent string
+	mux := http.NewServeMux()
+	mux.Handle("/ws", websocket.Handler(func(conn *websocket.Conn) {
+		traceparent = conn.Request().Header.Get("Traceparent")
+		msg := make([]byte, 4)
+		conn.Read(msg)
+		conn.Write(msg)
+		conn.Close()
+	}))
+
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		mux.ServeHTTP(w, req)
+	})
+	defer srv.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tr, err := tracing.New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	}), tracing.TracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(tr)
+	defer proxy.Close()
+
+	webSocketURL := "ws://" + proxy.Listener.Addr().String() + "/ws"
+	headers := http.Header{}
+	headers.Add("Origin", webSocketURL)
+
+	conn, resp, err := gorillawebsocket.DefaultDialer.Dial(webSocketURL, headers)
+	require.NoError(t, err, "Error during Dial with response: %+v", resp)
+
+	conn.WriteMessage(gorillawebsocket.TextMessage, []byte("ping"))
+	_, msg, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(msg))
+	conn.Close()
+
+	// the spans end with the session
+	assert.Eventually(t, func() bool { return len(exporter.GetSpans()) == 2 }, time.Second, 10*time.Millisecond)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	session, server := spans[0], spans[1]
+	assert.Equal(t, "websocket", session.Name)
+	assert.Equal(t, server.SpanContext.SpanID(), session.Parent.SpanID())
+	assert.Contains(t, traceparent, session.SpanContext.SpanID().String())
+}
+
 func TestWebSocketPassHost(t *testing.T) {
 	testCases := []struct {
 		desc     string
@@ -798,3 +860,108 @@ func (w *websocketRequest) open() (*websocket.Conn, net.Conn, error) {
 	}
 	return conn, client, err
 }
+
+func TestWebSocketMetrics(t *testing.T) {
+	f, err := New()
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.Handle("/ws", websocket.Handler(func(conn *websocket.Conn) {
+		// echoes until the client closes the connection
+		io.Copy(conn, conn)
+	}))
+
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		mux.ServeHTTP(w, req)
+	})
+	defer srv.Close()
+
+	registry := prometheus.NewRegistry()
+	m, err := prommetrics.NewMetrics(registry)
+	require.NoError(t, err)
+	mw, err := metrics.New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	}), m)
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(mw)
+	defer proxy.Close()
+
+	webSocketURL := "ws://" + proxy.Listener.Addr().String() + "/ws"
+	headers := http.Header{}
+	headers.Add("Origin", webSocketURL)
+
+	conn, resp, err := gorillawebsocket.DefaultDialer.Dial(webSocketURL, headers)
+	require.NoError(t, err, "Error during Dial with response: %+v", resp)
+
+	conn.WriteMessage(gorillawebsocket.TextMessage, []byte("ping"))
+	_, msg, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(msg))
+
+	expected := `
+		# HELP oxy_websocket_connections Number of open websocket connections.
+		# TYPE oxy_websocket_connections gauge
+		oxy_websocket_connections %d
+		# HELP oxy_websocket_connections_total Number of websocket connections opened.
+		# TYPE oxy_websocket_connections_total counter
+		oxy_websocket_connections_total 1
+	`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(fmt.Sprintf(expected, 1)),
+		"oxy_websocket_connections", "oxy_websocket_connections_total"))
+
+	conn.Close()
+
+	// the gauge is decreased when the session ends
+	assert.Eventually(t, func() bool {
+		return testutil.GatherAndCompare(registry, strings.NewReader(fmt.Sprintf(expected, 0)),
+			"oxy_websocket_connections", "oxy_websocket_connections_total") == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestWebSocketSessions(t *testing.T) {
+	f, err := New()
+	require.NoError(t, err)
+
+	upgrader := gorillawebsocket.Upgrader{}
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(w, req, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		for {
+			mt, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(mt, msg)
+		}
+	})
+	defer srv.Close()
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	assert.Empty(t, f.WebsocketSessions())
+
+	conn, resp, err := gorillawebsocket.DefaultDialer.Dial("ws://"+proxy.Listener.Addr().String()+"/ws", nil)
+	require.NoError(t, err, "Error during Dial with response: %+v", resp)
+
+	require.NoError(t, conn.WriteMessage(gorillawebsocket.TextMessage, []byte("OK")))
+	_, msg, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "OK", string(msg))
+
+	sessions := f.WebsocketSessions()
+	require.Len(t, sessions, 1)
+	assert.Equal(t, srv.URL, sessions[0].Backend)
+	assert.Equal(t, "/ws", sessions[0].Path)
+	assert.Equal(t, conn.LocalAddr().String(), sessions[0].RemoteAddr)
+	assert.False(t, sessions[0].Start.IsZero())
+
+	conn.Close()
+	assert.Eventually(t, func() bool { return len(f.WebsocketSessions()) == 0 }, time.Second, 10*time.Millisecond)
+}
@@ -7,6 +7,7 @@ const (
 	XForwardedHost         = "X-Forwarded-Host"
 	XForwardedPort         = "X-Forwarded-Port"
 	XForwardedServer       = "X-Forwarded-Server"
+	XForwardedPrefix       = "X-Forwarded-Prefix"
 	XRealIp                = "X-Real-Ip"
 	Connection             = "Connection"
 	KeepAlive              = "Keep-Alive"
@@ -62,5 +63,6 @@ var XHeaders = []string{
 	XForwardedHost,
 	XForwardedPort,
 	XForwardedServer,
+	XForwardedPrefix,
 	XRealIp,
 }
@@ -0,0 +1,134 @@
+package forward
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/vulcand/oxy/logging"
+)
+
+// StripPrefix removes the first matching prefix from the request path before forwarding.
+// The stripped prefix is sent to the backend in the X-Forwarded-Prefix header.
+func StripPrefix(prefixes ...string) optSetter {
+	return func(f *Forwarder) error {
+		for _, p := range prefixes {
+			if p == "" || p == "/" {
+				return fmt.Errorf("invalid prefix to strip: %q", p)
+			}
+			f.httpForwarder.pathRewriter.stripPrefixes = append(f.httpForwarder.pathRewriter.stripPrefixes, strings.TrimSuffix(p, "/"))
+		}
+		return nil
+	}
+}
+
+// AddPrefix prepends a prefix to the request path before forwarding
+func AddPrefix(prefix string) optSetter {
+	return func(f *Forwarder) error {
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("prefix should start with '/', got %q", prefix)
+		}
+		f.httpForwarder.pathRewriter.addPrefix = strings.TrimSuffix(prefix, "/")
+		return nil
+	}
+}
+
+// ReplacePathRegex rewrites the request path matching the regular expression with the replacement,
+// the replacement may reference capturing groups, e.g. "/users/$1"
+func ReplacePathRegex(regex, replacement string) optSetter {
+	return func(f *Forwarder) error {
+		re, err := regexp.Compile(regex)
+		if err != nil {
+			return err
+		}
+		f.httpForwarder.pathRewriter.regex = re
+		f.httpForwarder.pathRewriter.replacement = replacement
+		return nil
+	}
+}
+
+// pathRewriter rewrites the request path sent to the backend. It works on the escaped
+// form of the path so an encoded path (e.g. containing %2F) keeps its encoding.
+// Rewrites are applied in the following order: strip prefix, regex replace and add prefix.
+type pathRewriter struct {
+	stripPrefixes []string
+	addPrefix     string
+	regex         *regexp.Regexp
+	replacement   string
+}
+
+func (p *pathRewriter) enabled() bool {
+	return len(p.stripPrefixes) != 0 || p.addPrefix != "" || p.regex != nil
+}
+
+// rewrite rewrites the path of the URL in place and returns the prefix that was stripped
+func (p *pathRewriter) rewrite(u *url.URL) (string, error) {
+	escaped := u.EscapedPath()
+
+	var stripped string
+	for _, prefix := range p.stripPrefixes {
+		escapedPrefix := (&url.URL{Path: prefix}).EscapedPath()
+		if escaped == escapedPrefix || strings.HasPrefix(escaped, escapedPrefix+"/") {
+			stripped = prefix
+			escaped = ensureLeadingSlash(strings.TrimPrefix(escaped, escapedPrefix))
+			break
+		}
+	}
+
+	if p.regex != nil {
+		escaped = p.regex.ReplaceAllString(escaped, p.replacement)
+	}
+
+	if p.addPrefix != "" {
+		escaped = (&url.URL{Path: p.addPrefix}).EscapedPath() + ensureLeadingSlash(escaped)
+	}
+
+	return stripped, setEscapedPath(u, escaped)
+}
+
+// setEscapedPath sets the escaped path of the URL, RawPath is only kept when
+// the path has an encoding that differs from the default one
+func setEscapedPath(u *url.URL, escaped string) error {
+	path, err := url.PathUnescape(escaped)
+	if err != nil {
+		return err
+	}
+	u.Path = path
+	u.RawPath = ""
+	if u.EscapedPath() != escaped {
+		u.RawPath = escaped
+	}
+	return nil
+}
+
+func ensureLeadingSlash(p string) string {
+	if strings.HasPrefix(p, "/") {
+		return p
+	}
+	return "/" + p
+}
+
+// rewritePath applies the configured path rewrites to the outgoing request and
+// sets the X-Forwarded-Prefix header when a prefix was stripped
+func (f *httpForwarder) rewritePath(outReq *http.Request) {
+	if !f.pathRewriter.enabled() {
+		return
+	}
+	stripped, err := f.pathRewriter.rewrite(outReq.URL)
+	if err != nil {
+		logging.Warn(f.requestLogger(outReq), "vulcand/oxy/forward: error when rewriting path",
+			logging.String("path", outReq.URL.Path), logging.Err(err))
+		return
+	}
+	if stripped == "" {
+		return
+	}
+	// X-Forwarded-Prefix is only present here if the header rewriter trusts it,
+	// in that case the prefix stripped by a previous proxy comes first.
+	if prior := outReq.Header.Get(XForwardedPrefix); prior != "" {
+		stripped = strings.TrimSuffix(prior, "/") + stripped
+	}
+	outReq.Header.Set(XForwardedPrefix, stripped)
+}
@@ -0,0 +1,200 @@
+package forward
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/testutils"
+)
+
+func TestPathRewriter(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		rewriter         pathRewriter
+		path             string
+		rawPath          string
+		expectedPath     string
+		expectedRawPath  string
+		expectedStripped string
+	}{
+		{
+			desc:             "strip prefix",
+			rewriter:         pathRewriter{stripPrefixes: []string{"/api/v1"}},
+			path:             "/api/v1/users",
+			expectedPath:     "/users",
+			expectedStripped: "/api/v1",
+		},
+		{
+			desc:             "strip whole path",
+			rewriter:         pathRewriter{stripPrefixes: []string{"/api"}},
+			path:             "/api",
+			expectedPath:     "/",
+			expectedStripped: "/api",
+		},
+		{
+			desc:         "strip prefix not on segment boundary",
+			rewriter:     pathRewriter{stripPrefixes: []string{"/api"}},
+			path:         "/apiv2/users",
+			expectedPath: "/apiv2/users",
+		},
+		{
+			desc:             "strip first matching prefix",
+			rewriter:         pathRewriter{stripPrefixes: []string{"/web", "/api"}},
+			path:             "/api/users",
+			expectedPath:     "/users",
+			expectedStripped: "/api",
+		},
+		{
+			desc:             "strip prefix keeps encoding",
+			rewriter:         pathRewriter{stripPrefixes: []string{"/api"}},
+			path:             "/api/files/a/b",
+			rawPath:          "/api/files/a%2Fb",
+			expectedPath:     "/files/a/b",
+			expectedRawPath:  "/files/a%2Fb",
+			expectedStripped: "/api",
+		},
+		{
+			desc:         "add prefix",
+			rewriter:     pathRewriter{addPrefix: "/backend"},
+			path:         "/users",
+			expectedPath: "/backend/users",
+		},
+		{
+			desc:            "add prefix keeps encoding",
+			rewriter:        pathRewriter{addPrefix: "/backend"},
+			path:            "/a/b",
+			rawPath:         "/a%2Fb",
+			expectedPath:    "/backend/a/b",
+			expectedRawPath: "/backend/a%2Fb",
+		},
+		{
+			desc:         "regex replace",
+			rewriter:     pathRewriter{regex: regexp.MustCompile(`^/users/(\d+)$`), replacement: "/accounts/$1/profile"},
+			path:         "/users/42",
+			expectedPath: "/accounts/42/profile",
+		},
+		{
+			desc: "strip then add",
+			rewriter: pathRewriter{
+				stripPrefixes: []string{"/public"},
+				addPrefix:     "/internal",
+			},
+			path:             "/public/status",
+			expectedPath:     "/internal/status",
+			expectedStripped: "/public",
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			u := &url.URL{Path: test.path, RawPath: test.rawPath}
+			stripped, err := test.rewriter.rewrite(u)
+			require.NoError(t, err)
+
+			assert.Equal(t, test.expectedPath, u.Path)
+			assert.Equal(t, test.expectedRawPath, u.RawPath)
+			assert.Equal(t, test.expectedStripped, stripped)
+		})
+	}
+}
+
+func TestStripPrefix(t *testing.T) {
+	var outURI, outPrefix string
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		outURI = req.RequestURI
+		outPrefix = req.Header.Get(XForwardedPrefix)
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	f, err := New(StripPrefix("/api/v1/"))
+	require.NoError(t, err)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL + "/api/v1/files/a%2Fb?q=1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "/files/a%2Fb?q=1", outURI)
+	assert.Equal(t, "/api/v1", outPrefix)
+
+	re, _, err = testutils.Get(proxy.URL+"/api/v1/users", testutils.Header(XForwardedPrefix, "/edge"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "/users", outURI)
+	assert.Equal(t, "/edge/api/v1", outPrefix)
+
+	re, _, err = testutils.Get(proxy.URL + "/other")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "/other", outURI)
+	assert.Equal(t, "", outPrefix)
+}
+
+func TestUntrustedForwardedPrefix(t *testing.T) {
+	var outPrefix string
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		outPrefix = req.Header.Get(XForwardedPrefix)
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	f, err := New(Rewriter(&HeaderRewriter{TrustForwardHeader: false}), StripPrefix("/api"))
+	require.NoError(t, err)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL+"/api/users", testutils.Header(XForwardedPrefix, "/evil"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "/api", outPrefix)
+}
+
+func TestAddPrefixAndRegex(t *testing.T) {
+	var outURI string
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		outURI = req.RequestURI
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	f, err := New(ReplacePathRegex(`^/u/(.*)`, "/users/$1"), AddPrefix("/v2"))
+	require.NoError(t, err)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL + "/u/john")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "/v2/users/john", outURI)
+}
+
+func TestBadPathOptions(t *testing.T) {
+	_, err := New(StripPrefix("/"))
+	assert.Error(t, err)
+
+	_, err = New(AddPrefix("api"))
+	assert.Error(t, err)
+
+	_, err = New(ReplacePathRegex("(", ""))
+	assert.Error(t, err)
+}
@@ -0,0 +1,176 @@
+package forward
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Response headers rewritten by ResponseRewriter
+const (
+	Location        = "Location"
+	ContentLocation = "Content-Location"
+	Refresh         = "Refresh"
+	SetCookie       = "Set-Cookie"
+)
+
+// ResponseRewriter rewrites the backend host and path found in response headers back to the
+// public host and path, similar to nginx proxy_redirect and proxy_cookie_domain/proxy_cookie_path.
+//
+// It rewrites the Location, Content-Location and Refresh headers pointing at the backend, and the
+// Domain and Path attributes of Set-Cookie headers. The public host and scheme are taken from the
+// X-Forwarded-Host and X-Forwarded-Proto headers of the outgoing request and the public path prefix
+// from X-Forwarded-Prefix, see StripPrefix.
+//
+// Use it with the ResponseModifier option:
+//
+//	rw := &forward.ResponseRewriter{}
+//	fwd, _ := forward.New(forward.StripPrefix("/api"), forward.ResponseModifier(rw.Rewrite))
+type ResponseRewriter struct {
+	// Host overrides the public host, e.g. "example.com:8443"
+	Host string
+	// CookieDomains maps cookie domains set by the backend to public domains.
+	// Cookies set for the backend host are always rewritten to the public host.
+	CookieDomains map[string]string
+}
+
+// Rewrite rewrites the response headers, it has the signature expected by ResponseModifier
+func (rw *ResponseRewriter) Rewrite(resp *http.Response) error {
+	if resp.Request == nil || resp.Request.URL == nil {
+		return nil
+	}
+	p := rw.newPublic(resp.Request)
+
+	for _, h := range []string{Location, ContentLocation} {
+		if v := resp.Header.Get(h); v != "" {
+			resp.Header.Set(h, p.rewriteURL(v))
+		}
+	}
+
+	if v := resp.Header.Get(Refresh); v != "" {
+		resp.Header.Set(Refresh, p.rewriteRefresh(v))
+	}
+
+	if cookies := resp.Header[SetCookie]; len(cookies) != 0 {
+		for i, c := range cookies {
+			cookies[i] = p.rewriteCookie(c, rw.CookieDomains)
+		}
+	}
+	return nil
+}
+
+// public holds the public and backend locations of the proxied request
+type public struct {
+	scheme      string
+	host        string
+	prefix      string
+	backendHost string
+}
+
+func (rw *ResponseRewriter) newPublic(outReq *http.Request) *public {
+	p := &public{
+		scheme:      "http",
+		host:        rw.Host,
+		prefix:      strings.TrimSuffix(outReq.Header.Get(XForwardedPrefix), "/"),
+		backendHost: outReq.URL.Host,
+	}
+	if p.host == "" {
+		p.host = outReq.Header.Get(XForwardedHost)
+	}
+	if p.host == "" {
+		p.host = outReq.Host
+	}
+	switch outReq.Header.Get(XForwardedProto) {
+	case "https", "wss":
+		p.scheme = "https"
+	}
+	return p
+}
+
+// rewriteURL rewrites absolute URLs pointing at the backend and relative URLs
+func (p *public) rewriteURL(v string) string {
+	u, err := url.Parse(v)
+	if err != nil {
+		return v
+	}
+	if u.Host != "" {
+		if !strings.EqualFold(u.Host, p.backendHost) {
+			return v
+		}
+		u.Scheme = p.scheme
+		u.Host = p.host
+	}
+	if p.prefix != "" && strings.HasPrefix(u.Path, "/") && !hasPathPrefix(u.Path, p.prefix) {
+		if err := setEscapedPath(u, (&url.URL{Path: p.prefix}).EscapedPath()+u.EscapedPath()); err != nil {
+			return v
+		}
+	}
+	return u.String()
+}
+
+// rewriteRefresh rewrites the URL in a Refresh header, e.g. "5; url=http://backend/next"
+func (p *public) rewriteRefresh(v string) string {
+	parts := strings.SplitN(v, ";", 2)
+	if len(parts) != 2 {
+		return v
+	}
+	target := strings.TrimSpace(parts[1])
+	if len(target) < 4 || !strings.EqualFold(target[:4], "url=") {
+		return v
+	}
+	return parts[0] + "; " + target[:4] + p.rewriteURL(target[4:])
+}
+
+// rewriteCookie rewrites the Domain and Path attributes of a Set-Cookie header value
+// leaving the rest of the cookie untouched
+func (p *public) rewriteCookie(v string, domains map[string]string) string {
+	attrs := strings.Split(v, ";")
+	// the first element is the cookie name and value
+	for i := 1; i < len(attrs); i++ {
+		attr := strings.TrimSpace(attrs[i])
+		kv := strings.SplitN(attr, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch strings.ToLower(kv[0]) {
+		case "domain":
+			attrs[i] = " " + kv[0] + "=" + p.rewriteDomain(kv[1], domains)
+		case "path":
+			if p.prefix != "" && strings.HasPrefix(kv[1], "/") && !hasPathPrefix(kv[1], p.prefix) {
+				path := p.prefix
+				if kv[1] != "/" {
+					path += kv[1]
+				}
+				attrs[i] = " " + kv[0] + "=" + path
+			}
+		}
+	}
+	return strings.Join(attrs, ";")
+}
+
+func (p *public) rewriteDomain(domain string, domains map[string]string) string {
+	d := strings.ToLower(strings.TrimPrefix(domain, "."))
+	for from, to := range domains {
+		if strings.ToLower(strings.TrimPrefix(from, ".")) == d {
+			return to
+		}
+	}
+	if d == strings.ToLower(hostOnly(p.backendHost)) {
+		return hostOnly(p.host)
+	}
+	return domain
+}
+
+// hasPathPrefix tells if the path already starts with the prefix, e.g. when the
+// backend builds its links using the X-Forwarded-Prefix header
+func hasPathPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+func hostOnly(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
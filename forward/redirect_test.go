@@ -0,0 +1,151 @@
+package forward
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/testutils"
+)
+
+func TestResponseRewriterHeaders(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		prefix   string
+		header   string
+		value    string
+		expected string
+	}{
+		{
+			desc:     "absolute location to backend",
+			header:   Location,
+			value:    "http://10.0.0.1:8080/login?next=%2F",
+			expected: "https://example.com/login?next=%2F",
+		},
+		{
+			desc:     "absolute location to another host",
+			header:   Location,
+			value:    "http://auth.example.com/login",
+			expected: "http://auth.example.com/login",
+		},
+		{
+			desc:     "absolute location with prefix",
+			prefix:   "/api",
+			header:   Location,
+			value:    "http://10.0.0.1:8080/users/1",
+			expected: "https://example.com/api/users/1",
+		},
+		{
+			desc:     "relative location with prefix",
+			prefix:   "/api",
+			header:   Location,
+			value:    "/users/1",
+			expected: "/api/users/1",
+		},
+		{
+			desc:     "location already prefixed",
+			prefix:   "/api",
+			header:   Location,
+			value:    "/api/users/1",
+			expected: "/api/users/1",
+		},
+		{
+			desc:     "content location",
+			header:   ContentLocation,
+			value:    "http://10.0.0.1:8080/doc",
+			expected: "https://example.com/doc",
+		},
+		{
+			desc:     "refresh",
+			prefix:   "/app",
+			header:   Refresh,
+			value:    "5; url=http://10.0.0.1:8080/next",
+			expected: "5; url=https://example.com/app/next",
+		},
+		{
+			desc:     "refresh without url",
+			header:   Refresh,
+			value:    "5",
+			expected: "5",
+		},
+		{
+			desc:     "cookie domain",
+			header:   SetCookie,
+			value:    "sid=1; Domain=10.0.0.1; Path=/; HttpOnly",
+			expected: "sid=1; Domain=example.com; Path=/; HttpOnly",
+		},
+		{
+			desc:     "cookie mapped domain",
+			header:   SetCookie,
+			value:    "sid=1; domain=.internal.local; Secure",
+			expected: "sid=1; domain=example.org; Secure",
+		},
+		{
+			desc:     "cookie path with prefix",
+			prefix:   "/api/v1",
+			header:   SetCookie,
+			value:    "sid=1; Path=/; Max-Age=60",
+			expected: "sid=1; Path=/api/v1; Max-Age=60",
+		},
+		{
+			desc:     "cookie sub path with prefix",
+			prefix:   "/api/v1",
+			header:   SetCookie,
+			value:    "sid=1; Path=/admin",
+			expected: "sid=1; Path=/api/v1/admin",
+		},
+	}
+
+	rw := &ResponseRewriter{CookieDomains: map[string]string{"internal.local": "example.org"}}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			outReq, err := http.NewRequest(http.MethodGet, "http://10.0.0.1:8080/", nil)
+			require.NoError(t, err)
+			outReq.Header.Set(XForwardedHost, "example.com")
+			outReq.Header.Set(XForwardedProto, "https")
+			if test.prefix != "" {
+				outReq.Header.Set(XForwardedPrefix, test.prefix)
+			}
+
+			resp := &http.Response{Header: make(http.Header), Request: outReq}
+			resp.Header.Set(test.header, test.value)
+
+			require.NoError(t, rw.Rewrite(resp))
+			assert.Equal(t, test.expected, resp.Header.Get(test.header))
+		})
+	}
+}
+
+func TestResponseRewriterForwarder(t *testing.T) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "1", Path: "/", Domain: "127.0.0.1"})
+		http.Redirect(w, req, "http://"+req.Host+"/login", http.StatusFound)
+	})
+	defer srv.Close()
+
+	rw := &ResponseRewriter{}
+	f, err := New(StripPrefix("/app"), ResponseModifier(rw.Rewrite))
+	require.NoError(t, err)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	req, err := http.NewRequest(http.MethodGet, proxy.URL+"/app/home", nil)
+	require.NoError(t, err)
+	req.Host = "example.com"
+
+	re, err := http.DefaultTransport.RoundTrip(req)
+	require.NoError(t, err)
+	defer re.Body.Close()
+	assert.Equal(t, http.StatusFound, re.StatusCode)
+	assert.Equal(t, "http://example.com/app/login", re.Header.Get(Location))
+	assert.Equal(t, "sid=1; Path=/app; Domain=example.com", re.Header.Get(SetCookie))
+}
@@ -0,0 +1,68 @@
+package forward
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vulcand/oxy/utils"
+)
+
+// WebsocketSession is a websocket connection in flight through the forwarder
+type WebsocketSession struct {
+	Backend string
+	// Path is the path sent to the backend
+	Path       string
+	RemoteAddr string
+	RequestID  string
+	Start      time.Time
+}
+
+// websocketSessions keeps track of the websocket connections in flight
+type websocketSessions struct {
+	mu       sync.Mutex
+	sessions map[*WebsocketSession]struct{}
+}
+
+// add registers the session of the request, outReq is the request sent to the backend
+func (s *websocketSessions) add(req, outReq *http.Request) *WebsocketSession {
+	ws := &WebsocketSession{
+		Backend:    backend(req.URL),
+		Path:       outReq.URL.Path,
+		RemoteAddr: req.RemoteAddr,
+		RequestID:  utils.RequestID(req),
+		Start:      time.Now().UTC(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions == nil {
+		s.sessions = make(map[*WebsocketSession]struct{})
+	}
+	s.sessions[ws] = struct{}{}
+	return ws
+}
+
+func (s *websocketSessions) remove(ws *WebsocketSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, ws)
+}
+
+func (s *websocketSessions) list() []WebsocketSession {
+	s.mu.Lock()
+	out := make([]WebsocketSession, 0, len(s.sessions))
+	for ws := range s.sessions {
+		out = append(out, *ws)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out
+}
+
+// WebsocketSessions returns the websocket connections in flight through the forwarder, oldest first
+func (f *Forwarder) WebsocketSessions() []WebsocketSession {
+	return f.websockets.list()
+}
@@ -0,0 +1,341 @@
+package forward
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vulcand/oxy/utils"
+)
+
+// Timeouts defines the timeouts applied by the forwarder to HTTP requests, a zero value means no timeout.
+type Timeouts struct {
+	// Dial limits the time spent getting a connection to the backend, including DNS resolution
+	Dial time.Duration
+	// TLSHandshake limits the time spent in the TLS handshake with the backend
+	TLSHandshake time.Duration
+	// ResponseHeader limits the time between writing the request and receiving the first byte of the response
+	ResponseHeader time.Duration
+	// Idle limits the time between two reads of the response body
+	Idle time.Duration
+	// Total limits the duration of the whole request, including the streaming of the response body
+	Total time.Duration
+}
+
+func (t Timeouts) enabled() bool {
+	return t.Dial > 0 || t.TLSHandshake > 0 || t.ResponseHeader > 0 || t.Idle > 0 || t.Total > 0
+}
+
+// merge overrides the timeouts with the non-zero values of o, a negative value disables the timeout.
+func (t Timeouts) merge(o Timeouts) Timeouts {
+	override := func(d *time.Duration, v time.Duration) {
+		if v != 0 {
+			*d = v
+		}
+	}
+	override(&t.Dial, o.Dial)
+	override(&t.TLSHandshake, o.TLSHandshake)
+	override(&t.ResponseHeader, o.ResponseHeader)
+	override(&t.Idle, o.Idle)
+	override(&t.Total, o.Total)
+	return t
+}
+
+// DialTimeout limits the time spent getting a connection to the backend
+func DialTimeout(d time.Duration) optSetter {
+	return func(f *Forwarder) error {
+		f.httpForwarder.timeouts.Dial = d
+		return nil
+	}
+}
+
+// TLSHandshakeTimeout limits the time spent in the TLS handshake with the backend
+func TLSHandshakeTimeout(d time.Duration) optSetter {
+	return func(f *Forwarder) error {
+		f.httpForwarder.timeouts.TLSHandshake = d
+		return nil
+	}
+}
+
+// ResponseHeaderTimeout limits the time to wait for the first byte of the backend response
+// once the request has been written
+func ResponseHeaderTimeout(d time.Duration) optSetter {
+	return func(f *Forwarder) error {
+		f.httpForwarder.timeouts.ResponseHeader = d
+		return nil
+	}
+}
+
+// IdleTimeout limits the time between two reads of the backend response body
+func IdleTimeout(d time.Duration) optSetter {
+	return func(f *Forwarder) error {
+		f.httpForwarder.timeouts.Idle = d
+		return nil
+	}
+}
+
+// RequestTimeout limits the total duration of a request, including the streaming of the response body
+func RequestTimeout(d time.Duration) optSetter {
+	return func(f *Forwarder) error {
+		f.httpForwarder.timeouts.Total = d
+		return nil
+	}
+}
+
+type timeoutsKey struct{}
+
+// WithTimeouts returns a copy of the context overriding the forwarder timeouts for a single request.
+// Only the non-zero values are overridden, a negative value disables the timeout.
+func WithTimeouts(ctx context.Context, t Timeouts) context.Context {
+	return context.WithValue(ctx, timeoutsKey{}, t)
+}
+
+// forRequest returns the timeouts overridden by the ones of the request context, if any
+func (t Timeouts) forRequest(req *http.Request) Timeouts {
+	if o, ok := req.Context().Value(timeoutsKey{}).(Timeouts); ok {
+		return t.merge(o)
+	}
+	return t
+}
+
+// applyToDialer bounds the dial of the websocket dialer with the Dial timeout, and its whole handshake,
+// i.e. the dial, the TLS handshake and the upgrade response, with the sum of the timeouts of these steps.
+// The handshake keeps the dialer default when one of its steps has no timeout.
+func (t Timeouts) applyToDialer(d *websocket.Dialer, secure bool) {
+	if t.Dial > 0 {
+		dial := t.Dial
+		d.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialCtx, cancel := context.WithTimeout(ctx, dial)
+			defer cancel()
+			conn, err := (&net.Dialer{}).DialContext(dialCtx, network, addr)
+			if err != nil && ctx.Err() == nil && errors.Is(dialCtx.Err(), context.DeadlineExceeded) {
+				return nil, &TimeoutError{Kind: utils.ErrorKindDialTimeout, Duration: dial}
+			}
+			return conn, err
+		}
+	}
+
+	steps := []time.Duration{t.Dial, t.ResponseHeader}
+	if secure {
+		steps = append(steps, t.TLSHandshake)
+	}
+	var handshake time.Duration
+	for _, s := range steps {
+		if s <= 0 {
+			return
+		}
+		handshake += s
+	}
+	d.HandshakeTimeout = handshake
+}
+
+// TimeoutError is returned to the ErrorHandler when one of the forwarder timeouts expires.
+// It implements net.Error and utils.ClassifiedError.
+type TimeoutError struct {
+	Kind     utils.ErrorKind
+	Duration time.Duration
+}
+
+// Timeout errors, they can be matched with errors.Is
+var (
+	ErrDialTimeout           = &TimeoutError{Kind: utils.ErrorKindDialTimeout}
+	ErrTLSHandshakeTimeout   = &TimeoutError{Kind: utils.ErrorKindTLSHandshakeTimeout}
+	ErrResponseHeaderTimeout = &TimeoutError{Kind: utils.ErrorKindResponseHeaderTimeout}
+	ErrIdleTimeout           = &TimeoutError{Kind: utils.ErrorKindIdleTimeout}
+	ErrRequestTimeout        = &TimeoutError{Kind: utils.ErrorKindRequestTimeout}
+)
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("vulcand/oxy/forward: %s after %v", e.Kind, e.Duration)
+}
+
+// Is reports whether target is a TimeoutError of the same kind
+func (e *TimeoutError) Is(target error) bool {
+	t, ok := target.(*TimeoutError)
+	return ok && t.Kind == e.Kind
+}
+
+// ErrorKind returns the classification of the error
+func (e *TimeoutError) ErrorKind() utils.ErrorKind {
+	return e.Kind
+}
+
+// Timeout is part of net.Error
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// Temporary is part of net.Error
+func (e *TimeoutError) Temporary() bool {
+	return true
+}
+
+// timeoutRoundTripper enforces the forwarder timeouts around the configured round tripper.
+type timeoutRoundTripper struct {
+	http.RoundTripper
+	timeouts Timeouts
+}
+
+func (rt *timeoutRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	timeouts := rt.timeouts.forRequest(req)
+	if !timeouts.enabled() {
+		return rt.RoundTripper.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	d := &deadlines{timeouts: timeouts, cancel: cancel}
+	d.start(utils.ErrorKindRequestTimeout, timeouts.Total)
+
+	ctx = httptrace.WithClientTrace(ctx, d.clientTrace())
+	resp, err := rt.RoundTripper.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		if errTimeout := d.finish(); errTimeout != nil {
+			return nil, errTimeout
+		}
+		return nil, err
+	}
+
+	d.stopAll(utils.ErrorKindDialTimeout, utils.ErrorKindTLSHandshakeTimeout, utils.ErrorKindResponseHeaderTimeout)
+	resp.Body = &timeoutBody{ReadCloser: resp.Body, deadlines: d, req: req}
+	d.start(utils.ErrorKindIdleTimeout, timeouts.Idle)
+	return resp, nil
+}
+
+// deadlines tracks the timers of a single request, the first expired timer cancels the request.
+type deadlines struct {
+	timeouts Timeouts
+	cancel   context.CancelFunc
+
+	mu     sync.Mutex
+	timers map[utils.ErrorKind]*time.Timer
+	err    *TimeoutError
+	done   bool
+}
+
+func (d *deadlines) start(kind utils.ErrorKind, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.done {
+		return
+	}
+	if d.timers == nil {
+		d.timers = make(map[utils.ErrorKind]*time.Timer)
+	}
+	if t, ok := d.timers[kind]; ok {
+		t.Reset(timeout)
+		return
+	}
+	d.timers[kind] = time.AfterFunc(timeout, func() {
+		d.expire(&TimeoutError{Kind: kind, Duration: timeout})
+	})
+}
+
+func (d *deadlines) stopAll(kinds ...utils.ErrorKind) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, kind := range kinds {
+		if t, ok := d.timers[kind]; ok {
+			t.Stop()
+			delete(d.timers, kind)
+		}
+	}
+}
+
+func (d *deadlines) expire(err *TimeoutError) {
+	d.mu.Lock()
+	if d.done || d.err != nil {
+		d.mu.Unlock()
+		return
+	}
+	d.err = err
+	d.mu.Unlock()
+	d.cancel()
+}
+
+// finish stops all the timers, releases the request context and returns the expired timeout if any.
+func (d *deadlines) finish() error {
+	d.mu.Lock()
+	d.done = true
+	for _, t := range d.timers {
+		t.Stop()
+	}
+	err := d.err
+	d.mu.Unlock()
+	d.cancel()
+	if err == nil {
+		return nil
+	}
+	return err
+}
+
+// expired returns the expired timeout if any.
+func (d *deadlines) expired() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err == nil {
+		return nil
+	}
+	return d.err
+}
+
+func (d *deadlines) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(string) {
+			d.start(utils.ErrorKindDialTimeout, d.timeouts.Dial)
+		},
+		TLSHandshakeStart: func() {
+			d.stopAll(utils.ErrorKindDialTimeout)
+			d.start(utils.ErrorKindTLSHandshakeTimeout, d.timeouts.TLSHandshake)
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			d.stopAll(utils.ErrorKindTLSHandshakeTimeout)
+		},
+		GotConn: func(httptrace.GotConnInfo) {
+			d.stopAll(utils.ErrorKindDialTimeout, utils.ErrorKindTLSHandshakeTimeout)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			d.start(utils.ErrorKindResponseHeaderTimeout, d.timeouts.ResponseHeader)
+		},
+		GotFirstResponseByte: func() {
+			d.stopAll(utils.ErrorKindResponseHeaderTimeout)
+		},
+	}
+}
+
+// timeoutBody resets the idle timer on every read and releases the request once closed.
+type timeoutBody struct {
+	io.ReadCloser
+	deadlines *deadlines
+	req       *http.Request
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		if errTimeout := b.deadlines.expired(); errTimeout != nil {
+			// the response has already been sent, let the wrapping middlewares know why it was cut short
+			utils.RecordError(b.req, utils.ClassifyError(errTimeout))
+			return n, errTimeout
+		}
+		return n, err
+	}
+	b.deadlines.start(utils.ErrorKindIdleTimeout, b.deadlines.timeouts.Idle)
+	return n, err
+}
+
+func (b *timeoutBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.deadlines.finish()
+	return err
+}
@@ -0,0 +1,301 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gorillawebsocket "github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/testutils"
+	"github.com/vulcand/oxy/utils"
+)
+
+// proxyWithRecord forwards the requests to target and reports the classified error of each request.
+func proxyWithRecord(t *testing.T, f *Forwarder, target string, errs chan<- *utils.UpstreamError) *httptest.Server {
+	t.Helper()
+
+	return testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		ctx, record := utils.ContextWithErrorRecord(req.Context())
+		req = req.WithContext(ctx)
+		req.URL = testutils.ParseURI(target)
+		// the reverse proxy aborts the handler when the body copy fails
+		defer func() { errs <- record.Get() }()
+		f.ServeHTTP(w, req)
+	})
+}
+
+func TestTimeouts(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		option       optSetter
+		handler      http.HandlerFunc
+		expectedCode int
+		expectedKind utils.ErrorKind
+	}{
+		{
+			desc:   "response header",
+			option: ResponseHeaderTimeout(20 * time.Millisecond),
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				time.Sleep(200 * time.Millisecond)
+			},
+			expectedCode: http.StatusGatewayTimeout,
+			expectedKind: utils.ErrorKindResponseHeaderTimeout,
+		},
+		{
+			desc:   "total before headers",
+			option: RequestTimeout(20 * time.Millisecond),
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				time.Sleep(200 * time.Millisecond)
+			},
+			expectedCode: http.StatusGatewayTimeout,
+			expectedKind: utils.ErrorKindRequestTimeout,
+		},
+		{
+			desc:   "idle body",
+			option: IdleTimeout(20 * time.Millisecond),
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				w.Write([]byte("hello"))
+				w.(http.Flusher).Flush()
+				time.Sleep(200 * time.Millisecond)
+				w.Write([]byte("world"))
+			},
+			expectedCode: http.StatusOK,
+			expectedKind: utils.ErrorKindIdleTimeout,
+		},
+		{
+			desc:   "total while streaming",
+			option: RequestTimeout(100 * time.Millisecond),
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				for i := 0; i < 10; i++ {
+					w.Write([]byte("hello"))
+					w.(http.Flusher).Flush()
+					time.Sleep(20 * time.Millisecond)
+				}
+			},
+			expectedCode: http.StatusOK,
+			expectedKind: utils.ErrorKindRequestTimeout,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			srv := testutils.NewHandler(test.handler)
+			defer srv.Close()
+
+			f, err := New(test.option, Stream(true))
+			require.NoError(t, err)
+
+			errs := make(chan *utils.UpstreamError, 1)
+			proxy := proxyWithRecord(t, f, srv.URL, errs)
+			defer proxy.Close()
+
+			re, _, _ := testutils.Get(proxy.URL)
+			require.NotNil(t, re)
+			assert.Equal(t, test.expectedCode, re.StatusCode)
+
+			ue := <-errs
+			require.NotNil(t, ue)
+			assert.Equal(t, test.expectedKind, ue.Kind)
+		})
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	f, err := New(
+		DialTimeout(20*time.Millisecond),
+		RoundTripper(&http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		}),
+	)
+	require.NoError(t, err)
+
+	errs := make(chan *utils.UpstreamError, 1)
+	proxy := proxyWithRecord(t, f, "http://backend.invalid", errs)
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusGatewayTimeout, re.StatusCode)
+
+	ue := <-errs
+	require.NotNil(t, ue)
+	assert.Equal(t, utils.ErrorKindDialTimeout, ue.Kind)
+	assert.True(t, errors.Is(ue, ErrDialTimeout))
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	// accepts the connections but never answers the handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, errAccept := ln.Accept()
+			if errAccept != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	f, err := New(TLSHandshakeTimeout(20*time.Millisecond), RoundTripper(&http.Transport{}))
+	require.NoError(t, err)
+
+	errs := make(chan *utils.UpstreamError, 1)
+	proxy := proxyWithRecord(t, f, "https://"+ln.Addr().String(), errs)
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusGatewayTimeout, re.StatusCode)
+
+	ue := <-errs
+	require.NotNil(t, ue)
+	assert.Equal(t, utils.ErrorKindTLSHandshakeTimeout, ue.Kind)
+}
+
+func TestTimeoutsOverride(t *testing.T) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	f, err := New(ResponseHeaderTimeout(10 * time.Millisecond))
+	require.NoError(t, err)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		if req.Header.Get("X-Slow") != "" {
+			req = req.WithContext(WithTimeouts(req.Context(), Timeouts{ResponseHeader: -1}))
+		}
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusGatewayTimeout, re.StatusCode)
+
+	re, body, err := testutils.Get(proxy.URL, testutils.Header("X-Slow", "true"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestTimeoutsNotExpired(t *testing.T) {
+	srv := testutils.NewResponder("hello")
+	defer srv.Close()
+
+	f, err := New(
+		DialTimeout(time.Second),
+		TLSHandshakeTimeout(time.Second),
+		ResponseHeaderTimeout(time.Second),
+		IdleTimeout(time.Second),
+		RequestTimeout(time.Second),
+	)
+	require.NoError(t, err)
+
+	errs := make(chan *utils.UpstreamError, 1)
+	proxy := proxyWithRecord(t, f, srv.URL, errs)
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "hello", string(body))
+	assert.Nil(t, <-errs)
+}
+
+func TestWebSocketHandshakeTimeout(t *testing.T) {
+	// accepts the connections but never answers the upgrade
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, errAccept := ln.Accept()
+			if errAccept != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	f, err := New(DialTimeout(20*time.Millisecond), ResponseHeaderTimeout(20*time.Millisecond))
+	require.NoError(t, err)
+
+	errs := make(chan *utils.UpstreamError, 1)
+	proxy := proxyWithRecord(t, f, "http://"+ln.Addr().String(), errs)
+	defer proxy.Close()
+
+	start := time.Now()
+	_, resp, err := gorillawebsocket.DefaultDialer.Dial("ws://"+proxy.Listener.Addr().String()+"/ws", nil)
+	require.Error(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	ue := <-errs
+	require.NotNil(t, ue)
+	assert.Equal(t, utils.ErrorKindTimeout, ue.Kind)
+}
+
+func TestApplyToDialer(t *testing.T) {
+	testCases := []struct {
+		desc              string
+		timeouts          Timeouts
+		secure            bool
+		expectedHandshake time.Duration
+		expectedDial      bool
+	}{
+		{
+			desc:              "no timeouts",
+			expectedHandshake: gorillawebsocket.DefaultDialer.HandshakeTimeout,
+		},
+		{
+			desc:              "ws",
+			timeouts:          Timeouts{Dial: time.Second, TLSHandshake: 2 * time.Second, ResponseHeader: 3 * time.Second},
+			expectedHandshake: 4 * time.Second,
+			expectedDial:      true,
+		},
+		{
+			desc:              "wss",
+			timeouts:          Timeouts{Dial: time.Second, TLSHandshake: 2 * time.Second, ResponseHeader: 3 * time.Second},
+			secure:            true,
+			expectedHandshake: 6 * time.Second,
+			expectedDial:      true,
+		},
+		{
+			desc:              "response header disabled",
+			timeouts:          Timeouts{Dial: time.Second, ResponseHeader: -1},
+			expectedHandshake: gorillawebsocket.DefaultDialer.HandshakeTimeout,
+			expectedDial:      true,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			dialer := *gorillawebsocket.DefaultDialer
+			test.timeouts.applyToDialer(&dialer, test.secure)
+
+			assert.Equal(t, test.expectedHandshake, dialer.HandshakeTimeout)
+			assert.Equal(t, test.expectedDial, dialer.NetDialContext != nil)
+		})
+	}
+}
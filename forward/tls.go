@@ -0,0 +1,357 @@
+package forward
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vulcand/oxy/logging"
+)
+
+// BackendTLS configures the TLS connections to a backend.
+// The certificate, key and CA files are reloaded when they change on disk.
+type BackendTLS struct {
+	// CertFile and KeyFile are the PEM encoded client certificate and key presented to the backend
+	CertFile string
+	KeyFile  string
+	// CAFile is the PEM encoded bundle of CAs used to verify the backend certificate,
+	// the system roots are used if empty
+	CAFile string
+	// ServerName overrides the server name sent with SNI and used to verify the backend certificate
+	ServerName string
+	// MinVersion is the minimum TLS version, e.g. tls.VersionTLS12
+	MinVersion uint16
+	// PinnedSPKI is a list of base64 encoded SHA-256 hashes of certificate public keys (SubjectPublicKeyInfo),
+	// if set one of the certificates presented by the backend must match one of the hashes
+	PinnedSPKI []string
+	// InsecureSkipVerify disables the verification of the backend certificate chain, the pins are still checked
+	InsecureSkipVerify bool
+}
+
+// TLSProviderOption is a functional option setter for TLSProvider
+type TLSProviderOption func(*TLSProvider) error
+
+// DefaultBackendTLS sets the TLS configuration of the backends without a specific configuration
+func DefaultBackendTLS(c BackendTLS) TLSProviderOption {
+	return func(p *TLSProvider) error {
+		b, err := newBackendTLS(c)
+		if err != nil {
+			return err
+		}
+		p.defaultBackend = b
+		return nil
+	}
+}
+
+// HostBackendTLS sets the TLS configuration of a backend, host is the host:port of the backend URL
+func HostBackendTLS(host string, c BackendTLS) TLSProviderOption {
+	return func(p *TLSProvider) error {
+		b, err := newBackendTLS(c)
+		if err != nil {
+			return fmt.Errorf("backend %s: %v", host, err)
+		}
+		p.backends[host] = b
+		return nil
+	}
+}
+
+// TLSReloadInterval sets the interval between two checks of the certificate files, 0 disables the reload.
+// It defaults to 10 seconds.
+func TLSReloadInterval(d time.Duration) TLSProviderOption {
+	return func(p *TLSProvider) error {
+		if d < 0 {
+			return errors.New("reload interval should be >= 0")
+		}
+		p.reloadInterval = d
+		return nil
+	}
+}
+
+// TLSProviderLogger defines the logger the TLS provider will use.
+//
+// It defaults to logging.Default().
+func TLSProviderLogger(l logging.Logger) TLSProviderOption {
+	return func(p *TLSProvider) error {
+		p.log = l
+		return nil
+	}
+}
+
+// TLSProvider provides the TLS configuration of each backend.
+// The configurations it returns pick up the rotated certificates and CA bundles without being rebuilt,
+// so the transports and their pooled connections don't need to be recreated.
+type TLSProvider struct {
+	defaultBackend *backendTLS
+	backends       map[string]*backendTLS
+	reloadInterval time.Duration
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	log logging.Logger
+}
+
+const defaultTLSReloadInterval = 10 * time.Second
+
+// NewTLSProvider creates a new TLSProvider, the certificate files are loaded immediately
+// and an error is returned if one of them is invalid.
+func NewTLSProvider(opts ...TLSProviderOption) (*TLSProvider, error) {
+	p := &TLSProvider{
+		backends:       make(map[string]*backendTLS),
+		reloadInterval: defaultTLSReloadInterval,
+		stop:           make(chan struct{}),
+
+		log: logging.Default(),
+	}
+	for _, o := range opts {
+		if err := o(p); err != nil {
+			return nil, err
+		}
+	}
+	if p.reloadInterval > 0 {
+		go p.watch()
+	}
+	return p, nil
+}
+
+// ClientConfig returns the TLS configuration of a backend, host is the host:port of the backend URL.
+// It returns nil if there is neither a configuration for this backend nor a default one.
+func (p *TLSProvider) ClientConfig(host string) *tls.Config {
+	b, ok := p.backends[host]
+	if !ok {
+		b = p.defaultBackend
+	}
+	if b == nil {
+		return nil
+	}
+	return b.clientConfig(host)
+}
+
+// Reload reloads the certificate files that changed on disk. On error the previous certificates are kept.
+func (p *TLSProvider) Reload() error {
+	var errs []string
+	reload := func(name string, b *backendTLS) {
+		if err := b.reload(false); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if p.defaultBackend != nil {
+		reload("default", p.defaultBackend)
+	}
+	for host, b := range p.backends {
+		reload(host, b)
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// Close stops watching the certificate files
+func (p *TLSProvider) Close() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+}
+
+func (p *TLSProvider) watch() {
+	ticker := time.NewTicker(p.reloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if err := p.Reload(); err != nil {
+				logging.Error(p.log, "vulcand/oxy/forward/tls: failed to reload certificates", logging.Err(err))
+			}
+		}
+	}
+}
+
+// backendTLS holds the current certificates of a backend
+type backendTLS struct {
+	config BackendTLS
+	pins   [][]byte
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	rootCAs  *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+func newBackendTLS(c BackendTLS) (*backendTLS, error) {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, errors.New("both the certificate and the key files must be set")
+	}
+	b := &backendTLS{config: c, modTimes: make(map[string]time.Time)}
+	for _, pin := range c.PinnedSPKI {
+		hash, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid SPKI pin %q, expected a base64 encoded SHA-256 hash", pin)
+		}
+		b.pins = append(b.pins, hash)
+	}
+	if err := b.reload(true); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// changed reports whether the files were modified since the last load
+func (b *backendTLS) changed(files ...string) (bool, error) {
+	changed := false
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return false, err
+		}
+		b.mu.RLock()
+		modTime := b.modTimes[file]
+		b.mu.RUnlock()
+		if !fi.ModTime().Equal(modTime) {
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+func (b *backendTLS) setModTimes(files ...string) {
+	for _, file := range files {
+		if fi, err := os.Stat(file); err == nil {
+			b.modTimes[file] = fi.ModTime()
+		}
+	}
+}
+
+func (b *backendTLS) reload(force bool) error {
+	if b.config.CertFile != "" {
+		changed, err := b.changed(b.config.CertFile, b.config.KeyFile)
+		if err != nil {
+			return err
+		}
+		if changed || force {
+			cert, err := tls.LoadX509KeyPair(b.config.CertFile, b.config.KeyFile)
+			if err != nil {
+				return err
+			}
+			b.mu.Lock()
+			b.cert = &cert
+			b.setModTimes(b.config.CertFile, b.config.KeyFile)
+			b.mu.Unlock()
+		}
+	}
+
+	if b.config.CAFile != "" {
+		changed, err := b.changed(b.config.CAFile)
+		if err != nil {
+			return err
+		}
+		if changed || force {
+			data, err := ioutil.ReadFile(b.config.CAFile)
+			if err != nil {
+				return err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(data) {
+				return fmt.Errorf("no certificate found in %s", b.config.CAFile)
+			}
+			b.mu.Lock()
+			b.rootCAs = pool
+			b.setModTimes(b.config.CAFile)
+			b.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+func (b *backendTLS) clientConfig(host string) *tls.Config {
+	serverName := b.config.ServerName
+	if serverName == "" {
+		serverName = hostOnly(host)
+	}
+
+	c := &tls.Config{
+		ServerName:         serverName,
+		MinVersion:         b.config.MinVersion,
+		InsecureSkipVerify: b.config.InsecureSkipVerify,
+	}
+	if b.config.CertFile != "" {
+		c.GetClientCertificate = b.getClientCertificate
+	}
+	// The CA bundle may be rotated, so the chain is verified against the current bundle
+	// instead of a RootCAs pool frozen in the configuration.
+	if b.config.CAFile != "" {
+		c.InsecureSkipVerify = true
+		c.VerifyPeerCertificate = b.verifier(serverName, !b.config.InsecureSkipVerify)
+	} else if len(b.pins) > 0 {
+		c.VerifyPeerCertificate = b.verifier(serverName, false)
+	}
+	return c
+}
+
+func (b *backendTLS) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.cert, nil
+}
+
+func (b *backendTLS) verifier(serverName string, verifyChain bool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs[i] = cert
+		}
+		if len(certs) == 0 {
+			return errors.New("no certificate presented by the backend")
+		}
+
+		if verifyChain {
+			b.mu.RLock()
+			roots := b.rootCAs
+			b.mu.RUnlock()
+
+			intermediates := x509.NewCertPool()
+			for _, cert := range certs[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := certs[0].Verify(x509.VerifyOptions{
+				Roots:         roots,
+				Intermediates: intermediates,
+				DNSName:       serverName,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return b.checkPins(certs)
+	}
+}
+
+func (b *backendTLS) checkPins(certs []*x509.Certificate) error {
+	if len(b.pins) == 0 {
+		return nil
+	}
+	for _, cert := range certs {
+		hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range b.pins {
+			if string(pin) == string(hash[:]) {
+				return nil
+			}
+		}
+	}
+	return errors.New("tls: no certificate presented by the backend matches the pinned public keys")
+}
@@ -0,0 +1,306 @@
+package forward
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/testutils"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func (c *testCert) spki() string {
+	hash := sha256.Sum256(c.cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func (c *testCert) writeFiles(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+
+	certFile := filepath.Join(dir, name+".crt")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600))
+
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+var serialNumber int64
+
+// newTestCert creates a certificate signed by parent, or a self-signed CA if parent is nil
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serialNumber++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serialNumber),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"backend.local"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// newMTLSServer starts a TLS server requiring a client certificate signed by ca
+func newMTLSServer(t *testing.T, ca, serverCert *testCert) *httptest.Server {
+	t.Helper()
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert.tlsCertificate()},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	srv.StartTLS()
+	return srv
+}
+
+func TestTLSProviderMTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, "ca", nil)
+	srv := newMTLSServer(t, ca, newTestCert(t, "server", ca))
+	defer srv.Close()
+
+	caFile, _ := ca.writeFiles(t, dir, "ca")
+	certFile, keyFile := newTestCert(t, "client", ca).writeFiles(t, dir, "client")
+
+	host := testutils.ParseURI(srv.URL).Host
+	p, err := NewTLSProvider(HostBackendTLS(host, BackendTLS{
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		CAFile:     caFile,
+		MinVersion: tls.VersionTLS12,
+	}))
+	require.NoError(t, err)
+	defer p.Close()
+
+	f, err := New(TLSConfigProvider(p))
+	require.NoError(t, err)
+
+	proxy := createProxyWithForwarder(f, srv.URL)
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "client", string(body))
+
+	// without a client certificate
+	f, err = New(RoundTripper(&http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}))
+	require.NoError(t, err)
+
+	proxy = createProxyWithForwarder(f, srv.URL)
+	defer proxy.Close()
+
+	re, _, err = testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, re.StatusCode)
+}
+
+func TestTLSProviderReload(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, "ca", nil)
+	otherCA := newTestCert(t, "other ca", nil)
+	srv := newMTLSServer(t, ca, newTestCert(t, "server", ca))
+	defer srv.Close()
+
+	// the backend is not trusted and the client certificate is not accepted
+	caFile, _ := otherCA.writeFiles(t, dir, "ca")
+	certFile, keyFile := newTestCert(t, "old client", otherCA).writeFiles(t, dir, "client")
+
+	p, err := NewTLSProvider(
+		DefaultBackendTLS(BackendTLS{CertFile: certFile, KeyFile: keyFile, CAFile: caFile}),
+		TLSReloadInterval(0),
+	)
+	require.NoError(t, err)
+
+	tm, err := NewTransportManager(TransportTLSProvider(p))
+	require.NoError(t, err)
+	defer tm.Close()
+
+	f, err := New(TLSConfigProvider(p), RoundTripper(tm))
+	require.NoError(t, err)
+
+	proxy := createProxyWithForwarder(f, srv.URL)
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.NotEqual(t, http.StatusOK, re.StatusCode)
+
+	// rotate the files
+	ca.writeFiles(t, dir, "ca")
+	newTestCert(t, "new client", ca).writeFiles(t, dir, "client")
+	future := time.Now().Add(time.Minute)
+	for _, file := range []string{caFile, certFile, keyFile} {
+		require.NoError(t, os.Chtimes(file, future, future))
+	}
+	require.NoError(t, p.Reload())
+
+	re, body, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "new client", string(body))
+
+	// an invalid file keeps the previous certificates
+	require.NoError(t, ioutil.WriteFile(caFile, []byte("invalid"), 0600))
+	require.NoError(t, os.Chtimes(caFile, future.Add(time.Minute), future.Add(time.Minute)))
+	assert.Error(t, p.Reload())
+
+	re, _, err = testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+}
+
+func TestTLSProviderPinning(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	serverCert := newTestCert(t, "server", ca)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert.tlsCertificate()}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	testCases := []struct {
+		desc  string
+		pins  []string
+		valid bool
+	}{
+		{
+			desc:  "leaf pin",
+			pins:  []string{serverCert.spki()},
+			valid: true,
+		},
+		{
+			desc:  "CA pin",
+			pins:  []string{ca.spki()},
+			valid: false,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			p, err := NewTLSProvider(DefaultBackendTLS(BackendTLS{
+				ServerName:         "backend.local",
+				PinnedSPKI:         test.pins,
+				InsecureSkipVerify: true,
+			}))
+			require.NoError(t, err)
+			defer p.Close()
+
+			f, err := New(TLSConfigProvider(p))
+			require.NoError(t, err)
+
+			proxy := createProxyWithForwarder(f, srv.URL)
+			defer proxy.Close()
+
+			re, _, err := testutils.Get(proxy.URL)
+			require.NoError(t, err)
+			if test.valid {
+				assert.Equal(t, http.StatusOK, re.StatusCode)
+			} else {
+				assert.NotEqual(t, http.StatusOK, re.StatusCode)
+			}
+		})
+	}
+}
+
+func TestTLSProviderWebsocket(t *testing.T) {
+	srv := createTLSWebsocketServer()
+	defer srv.Close()
+
+	// the test server certificate is only valid for example.com
+	p, err := NewTLSProvider(DefaultBackendTLS(BackendTLS{ServerName: "example.com", InsecureSkipVerify: true}))
+	require.NoError(t, err)
+	defer p.Close()
+
+	f, err := New(PassHostHeader(true), TLSConfigProvider(p))
+	require.NoError(t, err)
+
+	proxy := createProxyWithForwarder(f, srv.URL)
+	defer proxy.Close()
+
+	resp, err := newWebsocketRequest(
+		withServer(proxy.Listener.Addr().String()),
+		withPath("/ws"),
+		withData("ok"),
+	).send()
+	require.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+}
+
+func TestTLSProviderInvalidConfig(t *testing.T) {
+	_, err := NewTLSProvider(DefaultBackendTLS(BackendTLS{CertFile: "client.crt"}))
+	assert.Error(t, err)
+
+	_, err = NewTLSProvider(DefaultBackendTLS(BackendTLS{PinnedSPKI: []string{"not a pin"}}))
+	assert.Error(t, err)
+
+	_, err = NewTLSProvider(DefaultBackendTLS(BackendTLS{CAFile: "missing.crt"}))
+	assert.Error(t, err)
+
+	p, err := NewTLSProvider()
+	require.NoError(t, err)
+	defer p.Close()
+
+	_, err = New(TLSConfigProvider(p), RoundTripper(http.DefaultTransport))
+	assert.Error(t, err)
+}
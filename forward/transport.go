@@ -0,0 +1,366 @@
+package forward
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vulcand/oxy/logging"
+)
+
+// PoolConfig configures the connection pool of a backend
+type PoolConfig struct {
+	// MaxConns limits the number of connections to the backend, including the ones in use, 0 means no limit
+	MaxConns int
+	// MaxIdleConns limits the number of idle connections kept to the backend
+	MaxIdleConns int
+	// IdleConnTimeout is the time an idle connection is kept before being closed
+	IdleConnTimeout time.Duration
+	// KeepAlive is the interval between TCP keep-alive probes, a negative value disables them
+	KeepAlive time.Duration
+	// DialTimeout limits the time spent establishing the TCP connection
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout limits the time spent in the TLS handshake
+	TLSHandshakeTimeout time.Duration
+}
+
+// DefaultPoolConfig is the pool configuration used for the backends without a specific configuration,
+// the values match http.DefaultTransport except for the number of idle connections
+var DefaultPoolConfig = PoolConfig{
+	MaxIdleConns:        100,
+	IdleConnTimeout:     90 * time.Second,
+	KeepAlive:           30 * time.Second,
+	DialTimeout:         30 * time.Second,
+	TLSHandshakeTimeout: 10 * time.Second,
+}
+
+// PoolStats are the statistics of a backend connection pool
+type PoolStats struct {
+	// Active is the number of connections serving a request
+	Active int64
+	// Idle is the number of open connections not serving a request
+	Idle int64
+	// Dials is the number of connections established
+	Dials int64
+	// DialErrors is the number of failed dials
+	DialErrors int64
+	// Requests is the number of requests that got a connection
+	Requests int64
+	// Reused is the number of requests served by a previously used connection
+	Reused int64
+}
+
+// ReuseRatio is the ratio of requests served by a previously used connection
+func (s PoolStats) ReuseRatio() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Reused) / float64(s.Requests)
+}
+
+// TransportManagerOption is a functional option setter for TransportManager
+type TransportManagerOption func(*TransportManager) error
+
+// PoolDefaults sets the pool configuration of the backends without a specific configuration
+func PoolDefaults(c PoolConfig) TransportManagerOption {
+	return func(m *TransportManager) error {
+		m.defaultConfig = c
+		return nil
+	}
+}
+
+// HostPool sets the pool configuration of a backend, host is the host:port of the backend URL
+func HostPool(host string, c PoolConfig) TransportManagerOption {
+	return func(m *TransportManager) error {
+		m.hostConfigs[host] = c
+		return nil
+	}
+}
+
+// TransportTLSClientConfig sets the TLS configuration used to connect to the backends
+func TransportTLSClientConfig(c *tls.Config) TransportManagerOption {
+	return func(m *TransportManager) error {
+		m.tlsClientConfig = c
+		return nil
+	}
+}
+
+// TransportTLSProvider sets the provider of the per backend TLS configurations,
+// it takes precedence over TransportTLSClientConfig
+func TransportTLSProvider(p *TLSProvider) TransportManagerOption {
+	return func(m *TransportManager) error {
+		m.tlsProvider = p
+		return nil
+	}
+}
+
+// TransportLogger defines the logger the transport manager will use.
+//
+// It defaults to logging.Default().
+func TransportLogger(l logging.Logger) TransportManagerOption {
+	return func(m *TransportManager) error {
+		m.log = l
+		return nil
+	}
+}
+
+// TransportManager is a http.RoundTripper isolating the connections of each backend in its own pool,
+// so that a slow backend can't exhaust the connections of the other ones.
+// Use it with the RoundTripper option of the forwarder and release the pools of the removed servers
+// with ClosePool, e.g. using roundrobin.RoundRobinServerRemovedListener.
+type TransportManager struct {
+	defaultConfig   PoolConfig
+	hostConfigs     map[string]PoolConfig
+	tlsClientConfig *tls.Config
+	tlsProvider     *TLSProvider
+
+	mu    sync.RWMutex
+	pools map[string]*pool
+
+	log logging.Logger
+}
+
+// NewTransportManager creates a new TransportManager
+func NewTransportManager(opts ...TransportManagerOption) (*TransportManager, error) {
+	m := &TransportManager{
+		defaultConfig: DefaultPoolConfig,
+		hostConfigs:   make(map[string]PoolConfig),
+		pools:         make(map[string]*pool),
+
+		log: logging.Default(),
+	}
+	for _, o := range opts {
+		if err := o(m); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// RoundTrip sends the request using the pool of the backend
+func (m *TransportManager) RoundTrip(req *http.Request) (*http.Response, error) {
+	return m.getPool(req.URL).roundTrip(req)
+}
+
+// Stats returns the statistics of the pools indexed by backend, scheme://host:port
+func (m *TransportManager) Stats() map[string]PoolStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stats := make(map[string]PoolStats, len(m.pools))
+	for key, p := range m.pools {
+		stats[key] = p.stats()
+	}
+	return stats
+}
+
+// PoolStats returns the statistics of the pool of a backend
+func (m *TransportManager) PoolStats(u *url.URL) (PoolStats, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p, ok := m.pools[poolKey(u)]
+	if !ok {
+		return PoolStats{}, false
+	}
+	return p.stats(), true
+}
+
+// ClosePool closes the pool of a backend, the requests in flight complete but their connections are not reused
+func (m *TransportManager) ClosePool(u *url.URL) {
+	key := poolKey(u)
+
+	m.mu.Lock()
+	p, ok := m.pools[key]
+	delete(m.pools, key)
+	m.mu.Unlock()
+
+	if ok {
+		logging.Debug(m.log, "vulcand/oxy/forward/transport: closing pool", logging.String("pool", key))
+		p.close()
+	}
+}
+
+// Close closes all the pools
+func (m *TransportManager) Close() {
+	m.mu.Lock()
+	pools := m.pools
+	m.pools = make(map[string]*pool)
+	m.mu.Unlock()
+
+	for _, p := range pools {
+		p.close()
+	}
+}
+
+func (m *TransportManager) getPool(u *url.URL) *pool {
+	key := poolKey(u)
+
+	m.mu.RLock()
+	p, ok := m.pools[key]
+	m.mu.RUnlock()
+	if ok {
+		return p
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p, ok := m.pools[key]; ok {
+		return p
+	}
+	config, ok := m.hostConfigs[u.Host]
+	if !ok {
+		config = m.defaultConfig
+	}
+	tlsClientConfig := m.tlsClientConfig
+	if m.tlsProvider != nil {
+		if c := m.tlsProvider.ClientConfig(u.Host); c != nil {
+			tlsClientConfig = c
+		}
+	}
+	p = newPool(config, tlsClientConfig)
+	m.pools[key] = p
+	logging.Debug(m.log, "vulcand/oxy/forward/transport: created pool", logging.String("pool", key))
+	return p
+}
+
+func poolKey(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+// pool is the connection pool of a backend
+type pool struct {
+	transport *http.Transport
+
+	open       int64
+	active     int64
+	dials      int64
+	dialErrors int64
+	requests   int64
+	reused     int64
+	closed     int32
+}
+
+func newPool(c PoolConfig, tlsClientConfig *tls.Config) *pool {
+	p := &pool{}
+	dialer := &net.Dialer{Timeout: c.DialTimeout, KeepAlive: c.KeepAlive}
+	p.transport = &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				atomic.AddInt64(&p.dialErrors, 1)
+				return nil, err
+			}
+			atomic.AddInt64(&p.dials, 1)
+			atomic.AddInt64(&p.open, 1)
+			return &poolConn{Conn: conn, pool: p}, nil
+		},
+		TLSClientConfig:     tlsClientConfig.Clone(),
+		TLSHandshakeTimeout: c.TLSHandshakeTimeout,
+		// HTTP/2 is otherwise disabled by the custom dialer and TLS configuration
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          c.MaxIdleConns,
+		MaxIdleConnsPerHost:   c.MaxIdleConns,
+		MaxConnsPerHost:       c.MaxConns,
+		IdleConnTimeout:       c.IdleConnTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	return p
+}
+
+func (p *pool) roundTrip(req *http.Request) (*http.Response, error) {
+	var gotConn int32
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if !atomic.CompareAndSwapInt32(&gotConn, 0, 1) {
+				return
+			}
+			atomic.AddInt64(&p.requests, 1)
+			atomic.AddInt64(&p.active, 1)
+			if info.Reused {
+				atomic.AddInt64(&p.reused, 1)
+			}
+		},
+	}
+	release := func() {
+		if atomic.CompareAndSwapInt32(&gotConn, 1, 2) {
+			atomic.AddInt64(&p.active, -1)
+		}
+		if atomic.LoadInt32(&p.closed) == 1 {
+			p.transport.CloseIdleConnections()
+		}
+	}
+
+	resp, err := p.transport.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &poolBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+func (p *pool) close() {
+	atomic.StoreInt32(&p.closed, 1)
+	p.transport.CloseIdleConnections()
+}
+
+func (p *pool) stats() PoolStats {
+	open := atomic.LoadInt64(&p.open)
+	active := atomic.LoadInt64(&p.active)
+	idle := open - active
+	if idle < 0 {
+		// HTTP/2 connections serve several requests at once
+		idle = 0
+	}
+	return PoolStats{
+		Active:     active,
+		Idle:       idle,
+		Dials:      atomic.LoadInt64(&p.dials),
+		DialErrors: atomic.LoadInt64(&p.dialErrors),
+		Requests:   atomic.LoadInt64(&p.requests),
+		Reused:     atomic.LoadInt64(&p.reused),
+	}
+}
+
+// poolConn tracks the number of open connections of a pool
+type poolConn struct {
+	net.Conn
+	pool *pool
+	once sync.Once
+}
+
+func (c *poolConn) Close() error {
+	c.once.Do(func() {
+		atomic.AddInt64(&c.pool.open, -1)
+	})
+	return c.Conn.Close()
+}
+
+// poolBody releases the connection once the response body is consumed or closed
+type poolBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *poolBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.release()
+	}
+	return n, err
+}
+
+func (b *poolBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,168 @@
+package forward
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/testutils"
+)
+
+func TestTransportManagerIsolatesBackends(t *testing.T) {
+	a := testutils.NewResponder("a")
+	defer a.Close()
+	b := testutils.NewResponder("b")
+	defer b.Close()
+
+	tm, err := NewTransportManager()
+	require.NoError(t, err)
+	defer tm.Close()
+
+	f, err := New(RoundTripper(tm))
+	require.NoError(t, err)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(req.Header.Get("X-Backend"))
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	for i := 0; i < 3; i++ {
+		_, body, err := testutils.Get(proxy.URL, testutils.Header("X-Backend", a.URL))
+		require.NoError(t, err)
+		assert.Equal(t, "a", string(body))
+	}
+	_, body, err := testutils.Get(proxy.URL, testutils.Header("X-Backend", b.URL))
+	require.NoError(t, err)
+	assert.Equal(t, "b", string(body))
+
+	stats := tm.Stats()
+	require.Len(t, stats, 2)
+
+	statsA, ok := tm.PoolStats(testutils.ParseURI(a.URL))
+	require.True(t, ok)
+	assert.Equal(t, int64(1), statsA.Dials)
+	assert.Equal(t, int64(3), statsA.Requests)
+	assert.Equal(t, int64(2), statsA.Reused)
+	assert.InDelta(t, 2.0/3.0, statsA.ReuseRatio(), 0.001)
+	assert.Equal(t, int64(0), statsA.Active)
+	assert.Equal(t, int64(1), statsA.Idle)
+
+	statsB := stats[testutils.ParseURI(b.URL).Scheme+"://"+testutils.ParseURI(b.URL).Host]
+	assert.Equal(t, int64(1), statsB.Dials)
+	assert.Equal(t, int64(1), statsB.Requests)
+}
+
+func TestTransportManagerHostPool(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var concurrent, maxConcurrent int
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		concurrent++
+		if concurrent > maxConcurrent {
+			maxConcurrent = concurrent
+		}
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		concurrent--
+		mu.Unlock()
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	tm, err := NewTransportManager(HostPool(testutils.ParseURI(srv.URL).Host, PoolConfig{MaxConns: 1, MaxIdleConns: 1}))
+	require.NoError(t, err)
+	defer tm.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+			require.NoError(t, err)
+			resp, err := tm.RoundTrip(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	stats, ok := tm.PoolStats(testutils.ParseURI(srv.URL))
+	require.True(t, ok)
+	assert.Equal(t, int64(1), stats.Active)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, 1, maxConcurrent)
+	stats, _ = tm.PoolStats(testutils.ParseURI(srv.URL))
+	assert.Equal(t, int64(1), stats.Dials)
+	assert.Equal(t, int64(0), stats.Active)
+}
+
+func TestTransportManagerClosePool(t *testing.T) {
+	srv := testutils.NewResponder("hello")
+	defer srv.Close()
+
+	tm, err := NewTransportManager()
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	resp, err := tm.RoundTrip(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	u := testutils.ParseURI(srv.URL)
+	_, ok := tm.PoolStats(u)
+	assert.True(t, ok)
+
+	tm.ClosePool(u)
+	_, ok = tm.PoolStats(u)
+	assert.False(t, ok)
+
+	// a new pool is created on demand
+	resp, err = tm.RoundTrip(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	stats, ok := tm.PoolStats(u)
+	require.True(t, ok)
+	assert.Equal(t, int64(1), stats.Dials)
+	assert.Equal(t, int64(0), stats.Reused)
+}
+
+func TestTransportManagerHTTP2(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.Proto))
+	}))
+	backend.EnableHTTP2 = true
+	backend.StartTLS()
+	defer backend.Close()
+
+	tm, err := NewTransportManager(TransportTLSClientConfig(&tls.Config{InsecureSkipVerify: true}))
+	require.NoError(t, err)
+	defer tm.Close()
+
+	f, err := New(RoundTripper(tm))
+	require.NoError(t, err)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(backend.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	_, body, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/2.0", string(body))
+}
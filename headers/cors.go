@@ -0,0 +1,166 @@
+package headers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS headers
+const (
+	Origin                        = "Origin"
+	AccessControlRequestMethod    = "Access-Control-Request-Method"
+	AccessControlRequestHeaders   = "Access-Control-Request-Headers"
+	AccessControlAllowOrigin      = "Access-Control-Allow-Origin"
+	AccessControlAllowMethods     = "Access-Control-Allow-Methods"
+	AccessControlAllowHeaders     = "Access-Control-Allow-Headers"
+	AccessControlAllowCredentials = "Access-Control-Allow-Credentials"
+	AccessControlExposeHeaders    = "Access-Control-Expose-Headers"
+	AccessControlMaxAge           = "Access-Control-Max-Age"
+	Vary                          = "Vary"
+)
+
+// CORSOptions configures the handling of cross-origin requests
+type CORSOptions struct {
+	// AllowOrigins lists the origins allowed to make cross-origin requests, "*" allows any origin
+	AllowOrigins []string
+	// AllowMethods lists the methods allowed in cross-origin requests, defaults to GET, HEAD and POST
+	AllowMethods []string
+	// AllowHeaders lists the request headers allowed in cross-origin requests, "*" allows any header
+	AllowHeaders []string
+	// ExposeHeaders lists the response headers the browser exposes to the client
+	ExposeHeaders []string
+	// AllowCredentials allows requests with cookies or HTTP authentication
+	AllowCredentials bool
+	// MaxAge tells how long the browser may cache the result of the preflight request
+	MaxAge time.Duration
+}
+
+type cors struct {
+	o             CORSOptions
+	anyOrigin     bool
+	anyHeader     bool
+	origins       map[string]bool
+	methods       map[string]bool
+	headers       map[string]bool
+	allowMethods  string
+	exposeHeaders string
+}
+
+var defaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+
+func newCORS(o CORSOptions) (*cors, error) {
+	if len(o.AllowOrigins) == 0 {
+		return nil, fmt.Errorf("provide at least one allowed origin")
+	}
+	if o.MaxAge < 0 {
+		return nil, fmt.Errorf("max age should be >= 0 got %v", o.MaxAge)
+	}
+	if len(o.AllowMethods) == 0 {
+		o.AllowMethods = defaultCORSMethods
+	}
+	c := &cors{
+		o:       o,
+		origins: make(map[string]bool, len(o.AllowOrigins)),
+		methods: make(map[string]bool, len(o.AllowMethods)),
+		headers: make(map[string]bool, len(o.AllowHeaders)),
+	}
+	for _, origin := range o.AllowOrigins {
+		if origin == "*" {
+			c.anyOrigin = true
+			continue
+		}
+		c.origins[strings.ToLower(origin)] = true
+	}
+	for _, m := range o.AllowMethods {
+		c.methods[strings.ToUpper(m)] = true
+	}
+	for _, h := range o.AllowHeaders {
+		if h == "*" {
+			c.anyHeader = true
+			continue
+		}
+		c.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	c.allowMethods = strings.Join(o.AllowMethods, ", ")
+	c.exposeHeaders = strings.Join(o.ExposeHeaders, ", ")
+	return c, nil
+}
+
+func (c *cors) isPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions &&
+		req.Header.Get(Origin) != "" &&
+		req.Header.Get(AccessControlRequestMethod) != ""
+}
+
+func (c *cors) allowOrigin(origin string) bool {
+	return c.anyOrigin || c.origins[strings.ToLower(origin)]
+}
+
+func (c *cors) allowHeaders(requested string) bool {
+	if c.anyHeader || requested == "" {
+		return true
+	}
+	for _, h := range strings.Split(requested, ",") {
+		h = strings.TrimSpace(h)
+		if h != "" && !c.headers[http.CanonicalHeaderKey(h)] {
+			return false
+		}
+	}
+	return true
+}
+
+// servePreflight answers the preflight request without passing it to the next handler
+func (c *cors) servePreflight(w http.ResponseWriter, req *http.Request) {
+	h := w.Header()
+	h.Add(Vary, Origin)
+	h.Add(Vary, AccessControlRequestMethod)
+	h.Add(Vary, AccessControlRequestHeaders)
+
+	origin := req.Header.Get(Origin)
+	method := strings.ToUpper(req.Header.Get(AccessControlRequestMethod))
+	requested := req.Header.Get(AccessControlRequestHeaders)
+	if !c.allowOrigin(origin) || !c.methods[method] || !c.allowHeaders(requested) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	c.setAllowOrigin(h, origin)
+	h.Set(AccessControlAllowMethods, c.allowMethods)
+	if requested != "" {
+		h.Set(AccessControlAllowHeaders, requested)
+	}
+	if c.o.MaxAge > 0 {
+		h.Set(AccessControlMaxAge, strconv.Itoa(int(c.o.MaxAge/time.Second)))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decorate adds CORS headers to the response of an actual cross-origin request
+func (c *cors) decorate(h http.Header, req *http.Request) {
+	if !c.anyOrigin || c.o.AllowCredentials {
+		h.Add(Vary, Origin)
+	}
+	origin := req.Header.Get(Origin)
+	if origin == "" || !c.allowOrigin(origin) {
+		return
+	}
+	c.setAllowOrigin(h, origin)
+	if c.exposeHeaders != "" {
+		h.Set(AccessControlExposeHeaders, c.exposeHeaders)
+	}
+}
+
+func (c *cors) setAllowOrigin(h http.Header, origin string) {
+	// the wildcard is not allowed for requests with credentials
+	if c.anyOrigin && !c.o.AllowCredentials {
+		h.Set(AccessControlAllowOrigin, "*")
+	} else {
+		h.Set(AccessControlAllowOrigin, origin)
+	}
+	if c.o.AllowCredentials {
+		h.Set(AccessControlAllowCredentials, "true")
+	}
+}
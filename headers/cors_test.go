@@ -0,0 +1,134 @@
+package headers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/testutils"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	called := false
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		called = true
+	})
+
+	rw, err := New(handler, CORS(CORSOptions{
+		AllowOrigins: []string{"https://example.com"},
+		AllowMethods: []string{http.MethodGet, http.MethodPut},
+		AllowHeaders: []string{"X-Token"},
+		MaxAge:       time.Hour,
+	}))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(rw)
+	defer srv.Close()
+
+	re, _, err := testutils.MakeRequest(srv.URL, testutils.Method(http.MethodOptions),
+		testutils.Header(Origin, "https://example.com"),
+		testutils.Header(AccessControlRequestMethod, http.MethodPut),
+		testutils.Header(AccessControlRequestHeaders, "x-token"),
+	)
+	require.NoError(t, err)
+	assert.False(t, called)
+	assert.Equal(t, http.StatusNoContent, re.StatusCode)
+	assert.Equal(t, "https://example.com", re.Header.Get(AccessControlAllowOrigin))
+	assert.Equal(t, "GET, PUT", re.Header.Get(AccessControlAllowMethods))
+	assert.Equal(t, "x-token", re.Header.Get(AccessControlAllowHeaders))
+	assert.Equal(t, "3600", re.Header.Get(AccessControlMaxAge))
+}
+
+func TestCORSPreflightRejected(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+
+	rw, err := New(handler, CORS(CORSOptions{
+		AllowOrigins: []string{"https://example.com"},
+		AllowHeaders: []string{"X-Token"},
+	}))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(rw)
+	defer srv.Close()
+
+	testCases := []struct {
+		desc    string
+		origin  string
+		method  string
+		headers string
+	}{
+		{desc: "origin", origin: "https://evil.com", method: http.MethodGet},
+		{desc: "method", origin: "https://example.com", method: http.MethodDelete},
+		{desc: "headers", origin: "https://example.com", method: http.MethodGet, headers: "X-Token, X-Other"},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			re, _, err := testutils.MakeRequest(srv.URL, testutils.Method(http.MethodOptions),
+				testutils.Header(Origin, test.origin),
+				testutils.Header(AccessControlRequestMethod, test.method),
+				testutils.Header(AccessControlRequestHeaders, test.headers),
+			)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusNoContent, re.StatusCode)
+			assert.Equal(t, "", re.Header.Get(AccessControlAllowOrigin))
+		})
+	}
+}
+
+func TestCORSActualRequest(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	rw, err := New(handler, CORS(CORSOptions{
+		AllowOrigins:     []string{"*"},
+		AllowCredentials: true,
+		ExposeHeaders:    []string{"X-Total", "X-Page"},
+	}))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(rw)
+	defer srv.Close()
+
+	re, body, err := testutils.Get(srv.URL, testutils.Header(Origin, "https://any.com"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, "https://any.com", re.Header.Get(AccessControlAllowOrigin))
+	assert.Equal(t, "true", re.Header.Get(AccessControlAllowCredentials))
+	assert.Equal(t, "X-Total, X-Page", re.Header.Get(AccessControlExposeHeaders))
+	assert.Equal(t, Origin, re.Header.Get(Vary))
+
+	re, _, err = testutils.Get(srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "", re.Header.Get(AccessControlAllowOrigin))
+}
+
+func TestCORSWildcard(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	rw, err := New(handler, CORS(CORSOptions{AllowOrigins: []string{"*"}}))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(rw)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL, testutils.Header(Origin, "https://any.com"))
+	require.NoError(t, err)
+	assert.Equal(t, "*", re.Header.Get(AccessControlAllowOrigin))
+	assert.Equal(t, "", re.Header.Get(Vary))
+}
+
+func TestCORSBadOptions(t *testing.T) {
+	_, err := New(nil, CORS(CORSOptions{}))
+	assert.Error(t, err)
+
+	_, err = New(nil, CORS(CORSOptions{AllowOrigins: []string{"*"}, MaxAge: -time.Second}))
+	assert.Error(t, err)
+}
@@ -0,0 +1,349 @@
+/*
+Package headers provides http.Handler middleware that adds, sets, removes or renames
+request and response headers according to a list of declarative rules.
+
+Rule values may reference request variables enclosed in curly braces, the variables
+are resolved for every request:
+
+	{client.ip}              - client IP address
+	{request.host}           - request host
+	{request.method}         - request method, e.g. GET
+	{request.path}           - request path
+	{request.scheme}         - http or https
+	{request.id}             - request ID set by the requestid middleware, or else the X-Request-Id header
+	{request.header.<Name>}  - value of the request header <Name>
+	{backend.url}            - backend URL chosen by the load balancer, e.g. RoundRobin
+	{tls.version}            - TLS version of the client connection
+	{tls.cipher}             - TLS cipher suite of the client connection
+	{tls.server_name}        - server name sent by the client in SNI
+
+Examples of a headers middleware:
+
+	// sample HTTP handler
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	  w.Write([]byte("hello"))
+	})
+
+	// Passes the client IP to the backend and hides the Server header from the client
+	headers.New(handler,
+	  headers.RequestRules(headers.Rule{Action: headers.Set, Name: "X-Client-Ip", Value: "{client.ip}"}),
+	  headers.ResponseRules(headers.Rule{Action: headers.Remove, Name: "Server"}))
+
+	// Answers CORS preflight requests and decorates the responses for the allowed origins
+	headers.New(handler, headers.CORS(headers.CORSOptions{
+	  AllowOrigins: []string{"https://example.com"},
+	  AllowMethods: []string{http.MethodGet, http.MethodPost},
+	}))
+
+Put the middleware between the load balancer and the forwarder to have access to the
+backend URL via {backend.url}.
+*/
+package headers
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/vulcand/oxy/logging"
+	"github.com/vulcand/oxy/utils"
+)
+
+// Action is a header manipulation performed by a rule
+type Action int
+
+const (
+	// Add appends a value to the header
+	Add Action = iota
+	// Set replaces all the values of the header
+	Set
+	// Remove deletes the header
+	Remove
+	// Rename moves all the values of the header to a header with a new name
+	Rename
+)
+
+// String returns log-friendly representation of the action
+func (a Action) String() string {
+	switch a {
+	case Add:
+		return "add"
+	case Set:
+		return "set"
+	case Remove:
+		return "remove"
+	case Rename:
+		return "rename"
+	}
+	return "undefined"
+}
+
+// Rule describes a single header manipulation
+type Rule struct {
+	// Action is the manipulation to perform
+	Action Action
+	// Name is the header the rule applies to
+	Name string
+	// Value is the header value used by Add and Set, it may contain variables e.g. {client.ip}
+	Value string
+	// NewName is the new header name used by Rename
+	NewName string
+	// PathPrefix limits the rule to requests with a path starting with the prefix
+	PathPrefix string
+	// Host limits the rule to requests sent to the host
+	Host string
+}
+
+// Option is a functional option setter for Rewriter
+type Option func(*Rewriter) error
+
+// RequestRules adds rules applied to the request headers before the request is passed to the next handler
+func RequestRules(rules ...Rule) Option {
+	return func(r *Rewriter) error {
+		for _, rule := range rules {
+			c, err := compileRule(rule)
+			if err != nil {
+				return err
+			}
+			r.requestRules = append(r.requestRules, c)
+		}
+		return nil
+	}
+}
+
+// ResponseRules adds rules applied to the response headers before they are written to the client
+func ResponseRules(rules ...Rule) Option {
+	return func(r *Rewriter) error {
+		for _, rule := range rules {
+			c, err := compileRule(rule)
+			if err != nil {
+				return err
+			}
+			r.responseRules = append(r.responseRules, c)
+		}
+		return nil
+	}
+}
+
+// CORS enables handling of CORS preflight requests and adds CORS headers to the responses
+func CORS(o CORSOptions) Option {
+	return func(r *Rewriter) error {
+		c, err := newCORS(o)
+		if err != nil {
+			return err
+		}
+		r.cors = c
+		return nil
+	}
+}
+
+// Logger defines the logger the rewriter will use.
+//
+// It defaults to logging.Default().
+func Logger(l logging.Logger) Option {
+	return func(r *Rewriter) error {
+		r.log = l
+		return nil
+	}
+}
+
+// Rewriter is http.Handler that manipulates request and response headers
+type Rewriter struct {
+	requestRules  []*rule
+	responseRules []*rule
+	cors          *cors
+
+	next http.Handler
+
+	log logging.Logger
+}
+
+// New creates a new Rewriter middleware
+func New(next http.Handler, opts ...Option) (*Rewriter, error) {
+	r := &Rewriter{
+		next: next,
+		log:  logging.Default(),
+	}
+	for _, o := range opts {
+		if err := o(r); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Wrap sets the next handler to be called by headers handler.
+func (r *Rewriter) Wrap(next http.Handler) {
+	r.next = next
+}
+
+func (r *Rewriter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if r.log.Enabled(logging.DebugLevel) {
+		logger := utils.RequestLogger(r.log, req).With(utils.RequestField(req))
+		logging.Debug(logger, "vulcand/oxy/headers: begin ServeHttp on request")
+		defer logging.Debug(logger, "vulcand/oxy/headers: completed ServeHttp on request")
+	}
+
+	if r.cors != nil && r.cors.isPreflight(req) {
+		r.cors.servePreflight(w, req)
+		return
+	}
+
+	// snapshot the request so the response rules are resolved against the request URL
+	// and headers as seen by this middleware, before the request rules and the next handlers change them
+	orig := *req
+	orig.Header = req.Header.Clone()
+	for _, rl := range r.requestRules {
+		rl.apply(req.Header, &orig)
+	}
+
+	if r.cors == nil && len(r.responseRules) == 0 {
+		r.next.ServeHTTP(w, req)
+		return
+	}
+
+	rw := &responseWriter{
+		ResponseWriter: w,
+		before: func(h http.Header) {
+			if r.cors != nil {
+				r.cors.decorate(h, &orig)
+			}
+			for _, rl := range r.responseRules {
+				rl.apply(h, &orig)
+			}
+		},
+		log: r.log,
+	}
+	r.next.ServeHTTP(rw, req)
+	// the handler returned without writing anything, the implicit 200 still gets the response headers
+	if !rw.wroteHeader && !rw.hijacked {
+		rw.wroteHeader = true
+		rw.before(rw.ResponseWriter.Header())
+	}
+}
+
+type rule struct {
+	Rule
+	value *template
+}
+
+func compileRule(r Rule) (*rule, error) {
+	if r.Name == "" {
+		return nil, fmt.Errorf("header name can not be empty")
+	}
+	c := &rule{Rule: r}
+	switch r.Action {
+	case Add, Set:
+		t, err := parseTemplate(r.Value)
+		if err != nil {
+			return nil, err
+		}
+		c.value = t
+	case Remove:
+	case Rename:
+		if r.NewName == "" {
+			return nil, fmt.Errorf("new header name can not be empty when renaming %q", r.Name)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported action: %v", r.Action)
+	}
+	return c, nil
+}
+
+func (r *rule) matches(req *http.Request) bool {
+	if r.Host != "" && !strings.EqualFold(hostname(req.Host), r.Host) {
+		return false
+	}
+	if r.PathPrefix != "" && !strings.HasPrefix(req.URL.Path, r.PathPrefix) {
+		return false
+	}
+	return true
+}
+
+func (r *rule) apply(h http.Header, req *http.Request) {
+	if !r.matches(req) {
+		return
+	}
+	switch r.Action {
+	case Add:
+		h.Add(r.Name, r.value.execute(req))
+	case Set:
+		h.Set(r.Name, r.value.execute(req))
+	case Remove:
+		h.Del(r.Name)
+	case Rename:
+		values := h[http.CanonicalHeaderKey(r.Name)]
+		if len(values) == 0 {
+			return
+		}
+		h.Del(r.Name)
+		for _, v := range values {
+			h.Add(r.NewName, v)
+		}
+	}
+}
+
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// responseWriter calls before with the response headers right before they are sent to the client
+type responseWriter struct {
+	http.ResponseWriter
+	before      func(http.Header)
+	wroteHeader bool
+	hijacked    bool
+
+	log logging.Logger
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	if !rw.wroteHeader {
+		rw.wroteHeader = true
+		rw.before(rw.ResponseWriter.Header())
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(buf []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	return rw.ResponseWriter.Write(buf)
+}
+
+// Flush flush the writer
+func (rw *responseWriter) Flush() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// CloseNotify returns a channel that receives at most a single value (true)
+// when the client connection has gone away.
+func (rw *responseWriter) CloseNotify() <-chan bool {
+	if cn, ok := rw.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	logging.Debug(rw.log, "vulcand/oxy/headers: upstream ResponseWriter does not implement http.CloseNotifier, returning dummy channel",
+		logging.Any("type", reflect.TypeOf(rw.ResponseWriter)))
+	return make(<-chan bool)
+}
+
+// Hijack lets the caller take over the connection.
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hi, ok := rw.ResponseWriter.(http.Hijacker); ok {
+		rw.hijacked = true
+		return hi.Hijack()
+	}
+	return nil, nil, fmt.Errorf("the response writer wrapped in this proxy does not implement http.Hijacker. Its type is: %v", reflect.TypeOf(rw.ResponseWriter))
+}
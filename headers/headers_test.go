@@ -0,0 +1,266 @@
+package headers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/forward"
+	"github.com/vulcand/oxy/roundrobin"
+	"github.com/vulcand/oxy/testutils"
+	"github.com/vulcand/oxy/utils"
+)
+
+func TestRequestRules(t *testing.T) {
+	var outHeaders http.Header
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		outHeaders = req.Header
+		w.Write([]byte("hello"))
+	})
+
+	rw, err := New(handler, RequestRules(
+		Rule{Action: Set, Name: "X-Client", Value: "{client.ip}"},
+		Rule{Action: Add, Name: "X-Trace", Value: "{request.method} {request.path}"},
+		Rule{Action: Remove, Name: "X-Secret"},
+		Rule{Action: Rename, Name: "X-Old", NewName: "X-New"},
+	))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(rw)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL+"/hello",
+		testutils.Header("X-Secret", "s3cr3t"),
+		testutils.Header("X-Old", "a"),
+		testutils.Header("X-Old", "b"),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+
+	assert.Equal(t, "127.0.0.1", outHeaders.Get("X-Client"))
+	assert.Equal(t, "GET /hello", outHeaders.Get("X-Trace"))
+	assert.Equal(t, "", outHeaders.Get("X-Secret"))
+	assert.Empty(t, outHeaders["X-Old"])
+	assert.Equal(t, []string{"a", "b"}, outHeaders["X-New"])
+}
+
+func TestResponseRules(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Server", "backend/1.0")
+		w.Write([]byte("hello"))
+	})
+
+	rw, err := New(handler, ResponseRules(
+		Rule{Action: Remove, Name: "Server"},
+		Rule{Action: Set, Name: "X-Request-Id", Value: "{request.id}"},
+		Rule{Action: Set, Name: "X-Host", Value: "host={request.header.X-Forwarded-Host}"},
+	))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(rw)
+	defer srv.Close()
+
+	re, body, err := testutils.Get(srv.URL,
+		testutils.Header("X-Request-Id", "abc"),
+		testutils.Header("X-Forwarded-Host", "example.com"),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, "", re.Header.Get("Server"))
+	assert.Equal(t, "abc", re.Header.Get("X-Request-Id"))
+	assert.Equal(t, "host=example.com", re.Header.Get("X-Host"))
+}
+
+func TestResponseRulesSeeIncomingHeaders(t *testing.T) {
+	var outHeader string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		outHeader = req.Header.Get("X-Tenant")
+		req.Header.Set("X-Tenant", "changed-by-next")
+		w.Write([]byte("hello"))
+	})
+
+	rw, err := New(handler,
+		RequestRules(Rule{Action: Set, Name: "X-Tenant", Value: "rewritten"}),
+		ResponseRules(Rule{Action: Set, Name: "X-Incoming-Tenant", Value: "{request.header.X-Tenant}"}),
+	)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(rw)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL, testutils.Header("X-Tenant", "acme"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "rewritten", outHeader)
+	assert.Equal(t, "acme", re.Header.Get("X-Incoming-Tenant"))
+}
+
+func TestRequestIDVariable(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	rw, err := New(handler, ResponseRules(Rule{Action: Set, Name: "X-Trace-Id", Value: "{request.id}"}))
+	require.NoError(t, err)
+
+	// the ID set by the requestid middleware in the context, whatever its header
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(utils.ContextWithRequestIDHeader(req.Context(), "X-Correlation-Id", "abc"))
+	recorder := httptest.NewRecorder()
+	rw.ServeHTTP(recorder, req)
+	assert.Equal(t, "abc", recorder.Header().Get("X-Trace-Id"))
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	recorder = httptest.NewRecorder()
+	rw.ServeHTTP(recorder, req)
+	assert.Empty(t, recorder.Header().Get("X-Trace-Id"))
+}
+
+func TestResponseRulesWithoutBody(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	rw, err := New(handler, ResponseRules(Rule{Action: Set, Name: "X-Proxy", Value: "oxy"}))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(rw)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, re.StatusCode)
+	assert.Equal(t, "oxy", re.Header.Get("X-Proxy"))
+}
+
+func TestResponseRulesWithoutWrite(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Server", "backend/1.0")
+	})
+
+	rw, err := New(handler,
+		ResponseRules(Rule{Action: Set, Name: "X-Proxy", Value: "oxy"}, Rule{Action: Remove, Name: "Server"}),
+		CORS(CORSOptions{AllowOrigins: []string{"http://example.com"}}))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(rw)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL, testutils.Header("Origin", "http://example.com"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "oxy", re.Header.Get("X-Proxy"))
+	assert.Equal(t, "", re.Header.Get("Server"))
+	assert.Equal(t, "http://example.com", re.Header.Get("Access-Control-Allow-Origin"))
+}
+
+func TestConditionalRules(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	rw, err := New(handler, ResponseRules(
+		Rule{Action: Set, Name: "X-Api", Value: "true", PathPrefix: "/api"},
+		Rule{Action: Set, Name: "X-Host", Value: "true", Host: "example.com"},
+	))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(rw)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL + "/api/users")
+	require.NoError(t, err)
+	assert.Equal(t, "true", re.Header.Get("X-Api"))
+	assert.Equal(t, "", re.Header.Get("X-Host"))
+
+	re, _, err = testutils.Get(srv.URL+"/web", testutils.Host("example.com"))
+	require.NoError(t, err)
+	assert.Equal(t, "", re.Header.Get("X-Api"))
+	assert.Equal(t, "true", re.Header.Get("X-Host"))
+}
+
+func TestBackendURL(t *testing.T) {
+	var backend string
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		backend = req.Header.Get("X-Backend")
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	fwd, err := forward.New()
+	require.NoError(t, err)
+
+	rw, err := New(fwd,
+		RequestRules(Rule{Action: Set, Name: "X-Backend", Value: "{backend.url}"}),
+		ResponseRules(Rule{Action: Set, Name: "X-Backend", Value: "{backend.url}"}),
+	)
+	require.NoError(t, err)
+
+	lb, err := roundrobin.New(rw)
+	require.NoError(t, err)
+	require.NoError(t, lb.UpsertServer(testutils.ParseURI(srv.URL)))
+
+	proxy := httptest.NewServer(lb)
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL + "/path")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, srv.URL, backend)
+	assert.Equal(t, srv.URL, re.Header.Get("X-Backend"))
+}
+
+func TestTemplate(t *testing.T) {
+	req := &http.Request{
+		Method:     http.MethodPost,
+		Host:       "example.com",
+		RemoteAddr: "10.0.0.1:4567",
+		URL:        &url.URL{Path: "/a/b"},
+		Header:     http.Header{"X-Foo": []string{"bar"}},
+	}
+
+	testCases := []struct {
+		desc     string
+		template string
+		expected string
+	}{
+		{desc: "literal", template: "hello", expected: "hello"},
+		{desc: "empty", template: "", expected: ""},
+		{desc: "client ip", template: "{client.ip}", expected: "10.0.0.1"},
+		{desc: "mixed", template: "{request.method} {request.host}{request.path}", expected: "POST example.com/a/b"},
+		{desc: "header", template: "foo={request.header.X-Foo};", expected: "foo=bar;"},
+		{desc: "scheme", template: "{request.scheme}", expected: "http"},
+		{desc: "no backend", template: "{backend.url}", expected: ""},
+		{desc: "no tls", template: "{tls.version}", expected: ""},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			tpl, err := parseTemplate(test.template)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, tpl.execute(req))
+		})
+	}
+}
+
+func TestBadRules(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+
+	rules := []Rule{
+		{Action: Set, Value: "x"},
+		{Action: Set, Name: "X-A", Value: "{unknown}"},
+		{Action: Set, Name: "X-A", Value: "{client.ip"},
+		{Action: Set, Name: "X-A", Value: "{request.header.}"},
+		{Action: Rename, Name: "X-A"},
+		{Action: Action(42), Name: "X-A"},
+	}
+	for _, r := range rules {
+		_, err := New(handler, RequestRules(r))
+		assert.Error(t, err, "rule %+v", r)
+	}
+}
@@ -0,0 +1,147 @@
+package headers
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/vulcand/oxy/utils"
+)
+
+// template is a header value with variables resolved for every request
+type template struct {
+	parts []part
+}
+
+// part returns either a literal or a resolved variable
+type part func(req *http.Request) string
+
+func parseTemplate(in string) (*template, error) {
+	t := &template{}
+	for len(in) > 0 {
+		start := strings.IndexByte(in, '{')
+		if start == -1 {
+			t.parts = append(t.parts, literal(in))
+			break
+		}
+		end := strings.IndexByte(in[start:], '}')
+		if end == -1 {
+			return nil, fmt.Errorf("unterminated variable in %q", in)
+		}
+		if start > 0 {
+			t.parts = append(t.parts, literal(in[:start]))
+		}
+		v, err := newVariable(in[start+1 : start+end])
+		if err != nil {
+			return nil, err
+		}
+		t.parts = append(t.parts, v)
+		in = in[start+end+1:]
+	}
+	return t, nil
+}
+
+func (t *template) execute(req *http.Request) string {
+	if len(t.parts) == 1 {
+		return t.parts[0](req)
+	}
+	var b strings.Builder
+	for _, p := range t.parts {
+		b.WriteString(p(req))
+	}
+	return b.String()
+}
+
+func literal(s string) part {
+	return func(*http.Request) string {
+		return s
+	}
+}
+
+func newVariable(name string) (part, error) {
+	switch name {
+	case "client.ip":
+		return clientIP, nil
+	case "request.host":
+		return func(req *http.Request) string { return req.Host }, nil
+	case "request.method":
+		return func(req *http.Request) string { return req.Method }, nil
+	case "request.path":
+		return func(req *http.Request) string { return req.URL.Path }, nil
+	case "request.scheme":
+		return scheme, nil
+	case "request.id":
+		return utils.RequestID, nil
+	case "backend.url":
+		return backendURL, nil
+	case "tls.version":
+		return tlsVersion, nil
+	case "tls.cipher":
+		return tlsCipher, nil
+	case "tls.server_name":
+		return tlsServerName, nil
+	}
+	if strings.HasPrefix(name, "request.header.") {
+		header := strings.TrimPrefix(name, "request.header.")
+		if len(header) == 0 {
+			return nil, fmt.Errorf("wrong header: %s", header)
+		}
+		return func(req *http.Request) string { return req.Header.Get(header) }, nil
+	}
+	return nil, fmt.Errorf("unsupported variable: '%s'", name)
+}
+
+func clientIP(req *http.Request) string {
+	if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return ip
+	}
+	return req.RemoteAddr
+}
+
+func scheme(req *http.Request) string {
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// backendURL returns the request URL once a load balancer has replaced it with a backend URL
+func backendURL(req *http.Request) string {
+	if req.URL == nil || req.URL.Host == "" {
+		return ""
+	}
+	return req.URL.String()
+}
+
+func tlsVersion(req *http.Request) string {
+	if req.TLS == nil {
+		return ""
+	}
+	switch req.TLS.Version {
+	case tls.VersionTLS10:
+		return "TLS10"
+	case tls.VersionTLS11:
+		return "TLS11"
+	case tls.VersionTLS12:
+		return "TLS12"
+	case tls.VersionTLS13:
+		return "TLS13"
+	}
+	return fmt.Sprintf("unknown: %x", req.TLS.Version)
+}
+
+func tlsCipher(req *http.Request) string {
+	if req.TLS == nil {
+		return ""
+	}
+	return fmt.Sprintf("%#04x", req.TLS.CipherSuite)
+}
+
+func tlsServerName(req *http.Request) string {
+	if req.TLS == nil {
+		return ""
+	}
+	return req.TLS.ServerName
+}
@@ -0,0 +1,219 @@
+/*
+Package logging defines the structured logger used by the oxy middlewares.
+
+Every middleware accepts a Logger through its Logger option. Std writes to the standard library logger
+and Slog (Go 1.21+) to log/slog, the adapters of the other logging libraries live in subpackages so
+that the middlewares do not link them: zaplog for zap and logruslog for logrus.
+
+	fwd, err := forward.New(forward.Logger(zaplog.New(zapLogger)))
+
+	// the logrus loggers given to the middlewares before
+	fwd, err := forward.New(forward.Logger(logruslog.FromLogrus(logrusLogger)))
+
+A log line is made of a constant message and of typed fields:
+
+	logging.Warn(l, "vulcand/oxy/roundrobin/rr: failed to parse cookie", logging.Err(err))
+
+Building the fields of a line costs allocations, the debug lines of the middlewares are therefore guarded
+by Enabled, so that nothing is allocated when debug logging is disabled:
+
+	if l.Enabled(logging.DebugLevel) {
+		logging.Debug(l, "vulcand/oxy/forward: begin ServeHttp on request", utils.RequestField(req))
+	}
+
+Fields stored in the context of a request with ContextWithFields are added to the lines logged
+by the middlewares handling that request, see FromContext.
+*/
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Level is the severity of a log line
+type Level int8
+
+const (
+	// DebugLevel is the level of the detailed lines useful when troubleshooting
+	DebugLevel Level = iota
+	// InfoLevel is the level of the general operational lines
+	InfoLevel
+	// WarnLevel is the level of the lines about unexpected but handled situations
+	WarnLevel
+	// ErrorLevel is the level of the lines about failures
+	ErrorLevel
+)
+
+// String returns the lower case name of the level
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int8(l))
+}
+
+// Logger is the minimal structured logger accepted by the oxy middlewares
+type Logger interface {
+	// Enabled tells if the lines of the level are logged
+	Enabled(level Level) bool
+	// Log logs the message with the fields at the level
+	Log(level Level, msg string, fields ...Field)
+	// With returns a logger adding the fields to all its lines
+	With(fields ...Field) Logger
+}
+
+// Field is a key/value pair attached to a log line
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// String returns a field holding a string
+func String(key, value string) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Int returns a field holding an int
+func Int(key string, value int) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Int64 returns a field holding an int64
+func Int64(key string, value int64) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Bool returns a field holding a bool
+func Bool(key string, value bool) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Duration returns a field holding a duration
+func Duration(key string, value time.Duration) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Err returns the field "error" holding the error
+func Err(err error) Field {
+	return Field{Key: "error", Value: err}
+}
+
+// Any returns a field holding any value
+func Any(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Lazy returns a field whose value is computed by fn only when the line is written
+func Lazy(key string, fn func() interface{}) Field {
+	return Field{Key: key, Value: lazy(fn)}
+}
+
+type lazy func() interface{}
+
+// Resolve returns the value of the field, computing it if lazy
+func (f Field) Resolve() interface{} {
+	if fn, ok := f.Value.(lazy); ok {
+		return fn()
+	}
+	return f.Value
+}
+
+// Debug logs the message with the fields at DebugLevel
+func Debug(l Logger, msg string, fields ...Field) {
+	if l.Enabled(DebugLevel) {
+		l.Log(DebugLevel, msg, fields...)
+	}
+}
+
+// Info logs the message with the fields at InfoLevel
+func Info(l Logger, msg string, fields ...Field) {
+	if l.Enabled(InfoLevel) {
+		l.Log(InfoLevel, msg, fields...)
+	}
+}
+
+// Warn logs the message with the fields at WarnLevel
+func Warn(l Logger, msg string, fields ...Field) {
+	if l.Enabled(WarnLevel) {
+		l.Log(WarnLevel, msg, fields...)
+	}
+}
+
+// Error logs the message with the fields at ErrorLevel
+func Error(l Logger, msg string, fields ...Field) {
+	if l.Enabled(ErrorLevel) {
+		l.Log(ErrorLevel, msg, fields...)
+	}
+}
+
+// Nop is the logger discarding all the lines
+var Nop Logger = nop{}
+
+type nop struct{}
+
+func (nop) Enabled(Level) bool          { return false }
+func (nop) Log(Level, string, ...Field) {}
+func (n nop) With(...Field) Logger      { return n }
+
+var defaultLogger atomic.Value
+
+func init() {
+	defaultLogger.Store(holder{Std(log.New(os.Stderr, "", log.LstdFlags), InfoLevel)})
+}
+
+// holder keeps the type stored in the atomic value constant
+type holder struct {
+	Logger
+}
+
+// Default returns the logger used by the middlewares created without the Logger option,
+// it writes the info lines and above to the standard error, unless changed with SetDefault.
+func Default() Logger {
+	return defaultLogger.Load().(holder).Logger
+}
+
+// SetDefault replaces the default logger, the middlewares created before keep the previous one.
+// A nil logger discards all the lines.
+func SetDefault(l Logger) {
+	if l == nil {
+		l = Nop
+	}
+	defaultLogger.Store(holder{l})
+}
+
+type fieldsKey struct{}
+
+// ContextWithFields returns a copy of the context holding the fields along with the ones already in it
+func ContextWithFields(ctx context.Context, fields ...Field) context.Context {
+	existing := FieldsFromContext(ctx)
+	all := make([]Field, 0, len(existing)+len(fields))
+	all = append(all, existing...)
+	all = append(all, fields...)
+	return context.WithValue(ctx, fieldsKey{}, all)
+}
+
+// FieldsFromContext returns the fields stored in the context
+func FieldsFromContext(ctx context.Context) []Field {
+	fields, _ := ctx.Value(fieldsKey{}).([]Field)
+	return fields
+}
+
+// FromContext returns the logger adding the fields stored in the context to its lines
+func FromContext(ctx context.Context, l Logger) Logger {
+	if fields := FieldsFromContext(ctx); len(fields) > 0 {
+		return l.With(fields...)
+	}
+	return l
+}
@@ -0,0 +1,93 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLevel(t *testing.T) {
+	assert.Equal(t, "debug", DebugLevel.String())
+	assert.Equal(t, "info", InfoLevel.String())
+	assert.Equal(t, "warn", WarnLevel.String())
+	assert.Equal(t, "error", ErrorLevel.String())
+	assert.Equal(t, "level(12)", Level(12).String())
+}
+
+func TestLazy(t *testing.T) {
+	calls := 0
+	field := Lazy("lazy", func() interface{} {
+		calls++
+		return "value"
+	})
+
+	out := &bytes.Buffer{}
+	l := newStd(out, InfoLevel)
+
+	Debug(l, "disabled", field)
+	assert.Equal(t, 0, calls)
+	assert.Empty(t, out.String())
+
+	Info(l, "enabled", field)
+	assert.Equal(t, 1, calls)
+	assert.Contains(t, out.String(), `lazy=value`)
+}
+
+func TestNop(t *testing.T) {
+	assert.False(t, Nop.Enabled(ErrorLevel))
+	assert.Equal(t, Nop, Nop.With(String("key", "value")))
+	Error(Nop, "discarded")
+}
+
+func TestDefault(t *testing.T) {
+	initial := Default()
+	defer SetDefault(initial)
+
+	out := &bytes.Buffer{}
+	l := newStd(out, DebugLevel)
+	SetDefault(l)
+	assert.Equal(t, l, Default())
+
+	SetDefault(nil)
+	assert.Equal(t, Nop, Default())
+}
+
+func TestContextFields(t *testing.T) {
+	ctx := context.Background()
+	assert.Empty(t, FieldsFromContext(ctx))
+
+	out := &bytes.Buffer{}
+	l := newStd(out, DebugLevel)
+	assert.Equal(t, l, FromContext(ctx, l))
+
+	ctx = ContextWithFields(ctx, String("tenant", "acme"))
+	child := ContextWithFields(ctx, Int("attempt", 2))
+	assert.Equal(t, []Field{String("tenant", "acme")}, FieldsFromContext(ctx))
+	assert.Equal(t, []Field{String("tenant", "acme"), Int("attempt", 2)}, FieldsFromContext(child))
+
+	Warn(FromContext(child, l), "retrying", Err(errors.New("boom")))
+	assert.Contains(t, out.String(), `tenant=acme`)
+	assert.Contains(t, out.String(), `attempt=2`)
+	assert.Contains(t, out.String(), `error=boom`)
+}
+
+func TestDisabledAllocations(t *testing.T) {
+	l := newStd(&bytes.Buffer{}, InfoLevel)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if l.Enabled(DebugLevel) {
+			Debug(l, "disabled", String("key", "value"), Int("count", 42))
+		}
+		Debug(l, "disabled")
+	})
+	require.Equal(t, float64(0), allocs)
+}
+
+func newStd(out *bytes.Buffer, level Level) Logger {
+	return Std(log.New(out, "", 0), level)
+}
@@ -0,0 +1,72 @@
+/*
+Package logruslog provides the logging.Logger writing to a logrus logger.
+
+The middlewares accepted a logrus logger before logging.Logger, FromLogrus bridges the loggers
+given to their Logger options until then:
+
+	fwd, err := forward.New(forward.Logger(logruslog.FromLogrus(logrus.StandardLogger())))
+
+	// the logger of the middlewares created without the Logger option, the default before logging.Logger
+	logging.SetDefault(logruslog.FromLogrus(logrus.StandardLogger()))
+*/
+package logruslog
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/vulcand/oxy/logging"
+)
+
+// FromLogrus returns the logger writing to the logrus logger, e.g. a *logrus.Logger or a *logrus.Entry
+func FromLogrus(l logrus.FieldLogger) logging.Logger {
+	if l == nil {
+		return logging.Nop
+	}
+	entry := l.WithFields(logrus.Fields{})
+	if entry == nil || entry.Logger == nil {
+		return logging.Nop
+	}
+	return &logrusLogger{entry: entry}
+}
+
+type logrusLogger struct {
+	entry *logrus.Entry
+}
+
+func (l *logrusLogger) Enabled(level logging.Level) bool {
+	return l.entry.Logger.IsLevelEnabled(logrusLevel(level))
+}
+
+func (l *logrusLogger) Log(level logging.Level, msg string, fields ...logging.Field) {
+	entry := l.entry
+	if len(fields) > 0 {
+		entry = entry.WithFields(logrusFields(fields))
+	}
+	entry.Log(logrusLevel(level), msg)
+}
+
+func (l *logrusLogger) With(fields ...logging.Field) logging.Logger {
+	if len(fields) == 0 {
+		return l
+	}
+	return &logrusLogger{entry: l.entry.WithFields(logrusFields(fields))}
+}
+
+func logrusFields(fields []logging.Field) logrus.Fields {
+	f := make(logrus.Fields, len(fields))
+	for _, field := range fields {
+		f[field.Key] = field.Resolve()
+	}
+	return f
+}
+
+func logrusLevel(level logging.Level) logrus.Level {
+	switch level {
+	case logging.DebugLevel:
+		return logrus.DebugLevel
+	case logging.InfoLevel:
+		return logrus.InfoLevel
+	case logging.WarnLevel:
+		return logrus.WarnLevel
+	}
+	return logrus.ErrorLevel
+}
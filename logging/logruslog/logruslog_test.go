@@ -0,0 +1,54 @@
+package logruslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/logging"
+)
+
+func TestFromLogrus(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := logrus.New()
+	l.Out = out
+	l.Level = logrus.InfoLevel
+	l.Formatter = &logrus.JSONFormatter{}
+
+	testCases := []struct {
+		desc   string
+		logger logrus.FieldLogger
+	}{
+		{desc: "logger", logger: l},
+		{desc: "entry", logger: l.WithField("component", "proxy")},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			out.Reset()
+			lg := FromLogrus(test.logger)
+
+			assert.False(t, lg.Enabled(logging.DebugLevel))
+			assert.True(t, lg.Enabled(logging.InfoLevel))
+			assert.True(t, lg.Enabled(logging.ErrorLevel))
+
+			lg.With(logging.String("request_id", "abc")).Log(logging.WarnLevel, "hello",
+				logging.Duration("duration", time.Second), logging.Bool("ok", true))
+
+			var line map[string]interface{}
+			require.NoError(t, json.Unmarshal(out.Bytes(), &line))
+			assert.Equal(t, "warning", line["level"])
+			assert.Equal(t, "hello", line["msg"])
+			assert.Equal(t, "abc", line["request_id"])
+			assert.Equal(t, float64(time.Second), line["duration"])
+			assert.Equal(t, true, line["ok"])
+		})
+	}
+
+	assert.Equal(t, logging.Nop, FromLogrus(nil))
+}
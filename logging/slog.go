@@ -0,0 +1,57 @@
+//go:build go1.21
+// +build go1.21
+
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Slog returns the logger writing to the slog logger
+func Slog(l *slog.Logger) Logger {
+	return &slogLogger{l: l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (l *slogLogger) Enabled(level Level) bool {
+	return l.l.Enabled(context.Background(), slogLevel(level))
+}
+
+func (l *slogLogger) Log(level Level, msg string, fields ...Field) {
+	l.l.LogAttrs(context.Background(), slogLevel(level), msg, slogAttrs(fields)...)
+}
+
+func (l *slogLogger) With(fields ...Field) Logger {
+	if len(fields) == 0 {
+		return l
+	}
+	args := make([]interface{}, len(fields))
+	for i, attr := range slogAttrs(fields) {
+		args[i] = attr
+	}
+	return &slogLogger{l: l.l.With(args...)}
+}
+
+func slogAttrs(fields []Field) []slog.Attr {
+	attrs := make([]slog.Attr, len(fields))
+	for i, field := range fields {
+		attrs[i] = slog.Any(field.Key, field.Resolve())
+	}
+	return attrs
+}
+
+func slogLevel(level Level) slog.Level {
+	switch level {
+	case DebugLevel:
+		return slog.LevelDebug
+	case InfoLevel:
+		return slog.LevelInfo
+	case WarnLevel:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
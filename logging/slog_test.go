@@ -0,0 +1,36 @@
+//go:build go1.21
+// +build go1.21
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlog(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := Slog(slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	assert.False(t, l.Enabled(DebugLevel))
+	assert.True(t, l.Enabled(InfoLevel))
+
+	Debug(l, "disabled")
+	assert.Empty(t, out.String())
+
+	l.With(String("request_id", "abc")).Log(WarnLevel, "hello", Int("code", 502), Err(errors.New("boom")))
+
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &line))
+	assert.Equal(t, "WARN", line["level"])
+	assert.Equal(t, "hello", line["msg"])
+	assert.Equal(t, "abc", line["request_id"])
+	assert.Equal(t, float64(502), line["code"])
+	assert.Equal(t, "boom", line["error"])
+}
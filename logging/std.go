@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Std returns the logger writing the lines of the level and above to the standard library logger,
+// in the logfmt format, e.g. level=warn msg="vulcand/oxy/forward: failed" error="connection refused"
+func Std(l *log.Logger, level Level) Logger {
+	return &stdLogger{l: l, level: level}
+}
+
+type stdLogger struct {
+	l      *log.Logger
+	level  Level
+	fields []Field
+}
+
+func (l *stdLogger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+func (l *stdLogger) Log(level Level, msg string, fields ...Field) {
+	if !l.Enabled(level) {
+		return
+	}
+	b := &strings.Builder{}
+	b.WriteString("level=")
+	b.WriteString(level.String())
+	b.WriteString(" msg=")
+	b.WriteString(logfmtValue(msg))
+	for _, f := range l.fields {
+		writeLogfmtField(b, f)
+	}
+	for _, f := range fields {
+		writeLogfmtField(b, f)
+	}
+	l.l.Print(b.String())
+}
+
+func (l *stdLogger) With(fields ...Field) Logger {
+	if len(fields) == 0 {
+		return l
+	}
+	all := make([]Field, 0, len(l.fields)+len(fields))
+	all = append(all, l.fields...)
+	all = append(all, fields...)
+	return &stdLogger{l: l.l, level: l.level, fields: all}
+}
+
+func writeLogfmtField(b *strings.Builder, f Field) {
+	b.WriteByte(' ')
+	b.WriteString(f.Key)
+	b.WriteByte('=')
+	b.WriteString(logfmtValue(fmt.Sprint(f.Resolve())))
+}
+
+// logfmtValue quotes the value if it is empty or contains spaces, quotes or equal signs
+func logfmtValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+		return strconv.Quote(v)
+	}
+	return v
+}
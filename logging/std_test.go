@@ -0,0 +1,27 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStd(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := Std(log.New(out, "", 0), InfoLevel)
+
+	assert.False(t, l.Enabled(DebugLevel))
+	assert.True(t, l.Enabled(InfoLevel))
+	assert.True(t, l.Enabled(ErrorLevel))
+
+	l.Log(DebugLevel, "disabled")
+	assert.Empty(t, out.String())
+
+	l.With(String("request_id", "abc")).Log(WarnLevel, "vulcand/oxy/forward: round trip",
+		Int("code", 502), Duration("duration", time.Second), Err(errors.New("connection refused")), String("empty", ""))
+	assert.Equal(t, `level=warn msg="vulcand/oxy/forward: round trip" request_id=abc code=502 duration=1s error="connection refused" empty=""`+"\n", out.String())
+}
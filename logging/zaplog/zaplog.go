@@ -0,0 +1,80 @@
+/*
+Package zaplog provides the logging.Logger writing to a zap logger.
+
+Examples of a zap logger:
+
+	fwd, err := forward.New(forward.Logger(zaplog.New(zapLogger)))
+
+	// the logger of the middlewares created without the Logger option
+	logging.SetDefault(zaplog.New(zapLogger))
+*/
+package zaplog
+
+import (
+	"time"
+
+	"github.com/vulcand/oxy/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// New returns the logger writing to the zap logger
+func New(l *zap.Logger) logging.Logger {
+	return &zapLogger{l: l}
+}
+
+type zapLogger struct {
+	l *zap.Logger
+}
+
+func (l *zapLogger) Enabled(level logging.Level) bool {
+	return l.l.Core().Enabled(zapLevel(level))
+}
+
+func (l *zapLogger) Log(level logging.Level, msg string, fields ...logging.Field) {
+	if ce := l.l.Check(zapLevel(level), msg); ce != nil {
+		ce.Write(zapFields(fields)...)
+	}
+}
+
+func (l *zapLogger) With(fields ...logging.Field) logging.Logger {
+	if len(fields) == 0 {
+		return l
+	}
+	return &zapLogger{l: l.l.With(zapFields(fields)...)}
+}
+
+func zapFields(fields []logging.Field) []zap.Field {
+	f := make([]zap.Field, len(fields))
+	for i, field := range fields {
+		switch v := field.Resolve().(type) {
+		case string:
+			f[i] = zap.String(field.Key, v)
+		case int:
+			f[i] = zap.Int(field.Key, v)
+		case int64:
+			f[i] = zap.Int64(field.Key, v)
+		case bool:
+			f[i] = zap.Bool(field.Key, v)
+		case time.Duration:
+			f[i] = zap.Duration(field.Key, v)
+		case error:
+			f[i] = zap.NamedError(field.Key, v)
+		default:
+			f[i] = zap.Any(field.Key, v)
+		}
+	}
+	return f
+}
+
+func zapLevel(level logging.Level) zapcore.Level {
+	switch level {
+	case logging.DebugLevel:
+		return zapcore.DebugLevel
+	case logging.InfoLevel:
+		return zapcore.InfoLevel
+	case logging.WarnLevel:
+		return zapcore.WarnLevel
+	}
+	return zapcore.ErrorLevel
+}
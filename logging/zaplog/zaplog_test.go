@@ -0,0 +1,39 @@
+package zaplog
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNew(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	l := New(zap.New(core))
+
+	assert.False(t, l.Enabled(logging.DebugLevel))
+	assert.True(t, l.Enabled(logging.InfoLevel))
+
+	logging.Debug(l, "disabled")
+	l.With(logging.String("request_id", "abc")).Log(logging.ErrorLevel, "hello",
+		logging.Int("code", 502), logging.Int64("length", 42), logging.Duration("duration", time.Second), logging.Err(errors.New("boom")), logging.Any("url", "http://localhost"))
+
+	entries := logs.AllUntimed()
+	require.Len(t, entries, 1)
+	assert.Equal(t, zapcore.ErrorLevel, entries[0].Level)
+	assert.Equal(t, "hello", entries[0].Message)
+	assert.Equal(t, map[string]interface{}{
+		"request_id": "abc",
+		"code":       int64(502),
+		"length":     int64(42),
+		"duration":   time.Second,
+		"error":      "boom",
+		"url":        "http://localhost",
+	}, entries[0].ContextMap())
+}
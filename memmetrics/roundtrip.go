@@ -18,6 +18,7 @@ type RTMetrics struct {
 	total           *RollingCounter
 	netErrors       *RollingCounter
 	statusCodes     map[int]*RollingCounter
+	errorKinds      map[string]*RollingCounter
 	statusCodesLock sync.RWMutex
 	histogram       *RollingHDRHistogram
 	histogramLock   sync.RWMutex
@@ -66,6 +67,7 @@ func RTClock(clock timetools.TimeProvider) rrOptSetter {
 func NewRTMetrics(settings ...rrOptSetter) (*RTMetrics, error) {
 	m := &RTMetrics{
 		statusCodes:     make(map[int]*RollingCounter),
+		errorKinds:      make(map[string]*RollingCounter),
 		statusCodesLock: sync.RWMutex{},
 	}
 	for _, s := range settings {
@@ -128,6 +130,11 @@ func (m *RTMetrics) Export() *RTMetrics {
 		exportStatusCodes[code] = rollingCounter.Clone()
 	}
 	export.statusCodes = exportStatusCodes
+	exportErrorKinds := map[string]*RollingCounter{}
+	for kind, rollingCounter := range m.errorKinds {
+		exportErrorKinds[kind] = rollingCounter.Clone()
+	}
+	export.errorKinds = exportErrorKinds
 	if m.histogram != nil {
 		export.histogram = m.histogram.Export()
 	}
@@ -202,6 +209,16 @@ func (m *RTMetrics) Append(other *RTMetrics) error {
 			m.statusCodes[code] = c.Clone()
 		}
 	}
+	for kind, c := range copied.errorKinds {
+		o, ok := m.errorKinds[kind]
+		if ok {
+			if err := o.Append(c); err != nil {
+				return err
+			}
+		} else {
+			m.errorKinds[kind] = c.Clone()
+		}
+	}
 
 	return m.histogram.Append(copied.histogram)
 }
@@ -216,6 +233,36 @@ func (m *RTMetrics) Record(code int, duration time.Duration) {
 	m.recordLatency(duration)
 }
 
+// RecordErrorKind records the classification of a failed request, e.g. "connection_refused"
+func (m *RTMetrics) RecordErrorKind(kind string) {
+	m.statusCodesLock.Lock()
+	defer m.statusCodesLock.Unlock()
+
+	if c, ok := m.errorKinds[kind]; ok {
+		c.Inc(1)
+		return
+	}
+	c, err := m.newCounter()
+	if err != nil {
+		return
+	}
+	c.Inc(1)
+	m.errorKinds[kind] = c
+}
+
+// ErrorKindCounts returns map with counts of the failed requests by classification
+func (m *RTMetrics) ErrorKindCounts() map[string]int64 {
+	ek := make(map[string]int64)
+	m.statusCodesLock.RLock()
+	defer m.statusCodesLock.RUnlock()
+	for k, v := range m.errorKinds {
+		if v.Count() != 0 {
+			ek[k] = v.Count()
+		}
+	}
+	return ek
+}
+
 // TotalCount returns total count of processed requests collected.
 func (m *RTMetrics) TotalCount() int64 {
 	return m.total.Count()
@@ -256,6 +303,7 @@ func (m *RTMetrics) Reset() {
 	m.total.Reset()
 	m.netErrors.Reset()
 	m.statusCodes = make(map[int]*RollingCounter)
+	m.errorKinds = make(map[string]*RollingCounter)
 }
 
 func (m *RTMetrics) recordLatency(d time.Duration) error {
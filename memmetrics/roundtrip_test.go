@@ -149,3 +149,27 @@ func TestRTMetricExportReturnsNewCopy(t *testing.T) {
 		}
 	}
 }
+
+func TestErrorKinds(t *testing.T) {
+	clock := testutils.GetClock()
+
+	rr, err := NewRTMetrics(RTClock(clock))
+	require.NoError(t, err)
+
+	rr.RecordErrorKind("connection_refused")
+	rr.RecordErrorKind("connection_refused")
+	rr.RecordErrorKind("timeout")
+	assert.Equal(t, map[string]int64{"connection_refused": 2, "timeout": 1}, rr.ErrorKindCounts())
+
+	rr2, err := NewRTMetrics(RTClock(clock))
+	require.NoError(t, err)
+	rr2.RecordErrorKind("timeout")
+	rr2.RecordErrorKind("dns_failure")
+
+	require.NoError(t, rr.Append(rr2))
+	assert.Equal(t, map[string]int64{"connection_refused": 2, "timeout": 2, "dns_failure": 1}, rr.ErrorKindCounts())
+	assert.Equal(t, rr.ErrorKindCounts(), rr.Export().ErrorKindCounts())
+
+	rr.Reset()
+	assert.Equal(t, map[string]int64{}, rr.ErrorKindCounts())
+}
@@ -0,0 +1,211 @@
+/*
+Package inmemmetrics provides the metrics.Observer keeping the metrics of the oxy middlewares in memory,
+e.g. to expose them on an admin endpoint or to assert them in tests.
+
+Examples of an in-memory observer:
+
+	m, _ := inmemmetrics.NewMemMetrics()
+	metrics.New(handler, m)
+
+	// the round trip metrics of a backend
+	m.Snapshot().Upstreams["http://10.0.0.1:8080"].NetworkErrorRatio()
+*/
+package inmemmetrics
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/vulcand/oxy/memmetrics"
+)
+
+// MemMetricsOption is a functional option setter for MemMetrics
+type MemMetricsOption func(*MemMetrics) error
+
+// MemRTMetrics sets the builder of the round trip metrics of the backends, it defaults to memmetrics.NewRTMetrics.
+func MemRTMetrics(fn memmetrics.NewRTMetricsFn) MemMetricsOption {
+	return func(m *MemMetrics) error {
+		if fn == nil {
+			return fmt.Errorf("round trip metrics builder should not be nil")
+		}
+		m.newRTMetrics = fn
+		return nil
+	}
+}
+
+// MemSnapshot is a copy of the metrics held by MemMetrics
+type MemSnapshot struct {
+	// Upstreams are the round trip metrics of the upstream requests by backend
+	Upstreams map[string]*memmetrics.RTMetrics
+	// Retries are the retries by middleware
+	Retries map[string]int64
+	// CircuitBreakers are the current states by circuit breaker instance
+	CircuitBreakers map[string]string
+	// Rejections are the requests rejected by the rate limiter
+	Rejections int64
+	// Connections are the connections currently accepted by the connection limiters
+	Connections int64
+	// SpooledBytes are the bytes spooled to disk by body, request or response
+	SpooledBytes map[string]int64
+	// Websockets are the open websocket connections
+	Websockets int64
+	// WebsocketsTotal are the websocket connections opened
+	WebsocketsTotal int64
+}
+
+// MemMetrics is the Observer keeping the metrics in memory,
+// the upstream requests are recorded in the rolling windows of a memmetrics.RTMetrics by backend.
+type MemMetrics struct {
+	newRTMetrics memmetrics.NewRTMetricsFn
+
+	mu              sync.Mutex
+	upstreams       map[string]*upstreamMetrics
+	retries         map[string]int64
+	circuitBreakers map[string]string
+	rejections      int64
+	connections     int64
+	spooledBytes    map[string]int64
+	websockets      int64
+	websocketsTotal int64
+}
+
+// NewMemMetrics returns a new in-memory observer
+func NewMemMetrics(opts ...MemMetricsOption) (*MemMetrics, error) {
+	m := &MemMetrics{
+		newRTMetrics: func() (*memmetrics.RTMetrics, error) {
+			return memmetrics.NewRTMetrics()
+		},
+		upstreams:       make(map[string]*upstreamMetrics),
+		retries:         make(map[string]int64),
+		circuitBreakers: make(map[string]string),
+		spooledBytes:    make(map[string]int64),
+	}
+	for _, o := range opts {
+		if err := o(m); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// ObserveUpstream records the code and the latency in the round trip metrics of the backend
+func (m *MemMetrics) ObserveUpstream(backend, _ string, code int, latency time.Duration) {
+	u := m.upstream(backend)
+	if u == nil {
+		return
+	}
+	// only the requests to the same backend are serialized
+	u.mu.Lock()
+	u.rt.Record(code, latency)
+	u.mu.Unlock()
+}
+
+// upstreamMetrics guards the round trip metrics of a backend, RTMetrics.Record is not safe for concurrent use
+type upstreamMetrics struct {
+	mu sync.Mutex
+	rt *memmetrics.RTMetrics
+}
+
+// upstream returns the metrics of the backend, creating them on first use
+func (m *MemMetrics) upstream(backend string) *upstreamMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.upstreams[backend]
+	if !ok {
+		rt, err := m.newRTMetrics()
+		if err != nil {
+			return nil
+		}
+		u = &upstreamMetrics{rt: rt}
+		m.upstreams[backend] = u
+	}
+	return u
+}
+
+// Retry counts a retry of the middleware
+func (m *MemMetrics) Retry(middleware string) {
+	m.mu.Lock()
+	m.retries[middleware]++
+	m.mu.Unlock()
+}
+
+// SetCircuitBreakerState sets the current state of the circuit breaker instance
+func (m *MemMetrics) SetCircuitBreakerState(instance, state string) {
+	m.mu.Lock()
+	m.circuitBreakers[instance] = state
+	m.mu.Unlock()
+}
+
+// RateLimited counts a request rejected by the rate limiter, the sources are not kept
+func (m *MemMetrics) RateLimited(*http.Request, string) {
+	m.mu.Lock()
+	m.rejections++
+	m.mu.Unlock()
+}
+
+// AddConnections adds the delta to the connections accepted by the connection limiters
+func (m *MemMetrics) AddConnections(delta int64) {
+	m.mu.Lock()
+	m.connections += delta
+	m.mu.Unlock()
+}
+
+// SpooledToDisk counts the bytes of a body spooled to disk
+func (m *MemMetrics) SpooledToDisk(body string, n int64) {
+	if n <= 0 {
+		return
+	}
+	m.mu.Lock()
+	m.spooledBytes[body] += n
+	m.mu.Unlock()
+}
+
+// WebsocketOpened counts an open websocket connection
+func (m *MemMetrics) WebsocketOpened() {
+	m.mu.Lock()
+	m.websockets++
+	m.websocketsTotal++
+	m.mu.Unlock()
+}
+
+// WebsocketClosed counts a closed websocket connection
+func (m *MemMetrics) WebsocketClosed() {
+	m.mu.Lock()
+	m.websockets--
+	m.mu.Unlock()
+}
+
+// Snapshot returns a copy of the metrics
+func (m *MemMetrics) Snapshot() MemSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := MemSnapshot{
+		Upstreams:       make(map[string]*memmetrics.RTMetrics, len(m.upstreams)),
+		Retries:         make(map[string]int64, len(m.retries)),
+		CircuitBreakers: make(map[string]string, len(m.circuitBreakers)),
+		Rejections:      m.rejections,
+		Connections:     m.connections,
+		SpooledBytes:    make(map[string]int64, len(m.spooledBytes)),
+		Websockets:      m.websockets,
+		WebsocketsTotal: m.websocketsTotal,
+	}
+	for k, v := range m.upstreams {
+		v.mu.Lock()
+		s.Upstreams[k] = v.rt.Export()
+		v.mu.Unlock()
+	}
+	for k, v := range m.retries {
+		s.Retries[k] = v
+	}
+	for k, v := range m.circuitBreakers {
+		s.CircuitBreakers[k] = v
+	}
+	for k, v := range m.spooledBytes {
+		s.SpooledBytes[k] = v
+	}
+	return s
+}
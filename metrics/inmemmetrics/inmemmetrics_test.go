@@ -0,0 +1,78 @@
+package inmemmetrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/memmetrics"
+	"github.com/vulcand/oxy/testutils"
+)
+
+func TestMemMetrics(t *testing.T) {
+	clock := testutils.GetClock()
+	m, err := NewMemMetrics(MemRTMetrics(func() (*memmetrics.RTMetrics, error) {
+		return memmetrics.NewRTMetrics(memmetrics.RTClock(clock))
+	}))
+	require.NoError(t, err)
+
+	m.ObserveUpstream("http://a", http.MethodGet, http.StatusOK, 10*time.Millisecond)
+	m.ObserveUpstream("http://a", http.MethodGet, http.StatusBadGateway, 20*time.Millisecond)
+	m.ObserveUpstream("http://b", http.MethodPost, http.StatusOK, time.Second)
+	m.Retry("buffer")
+	m.Retry("buffer")
+	m.SetCircuitBreakerState("cb", "tripped")
+	m.RateLimited(httptest.NewRequest(http.MethodGet, "/", nil), "10.0.0.1")
+	m.AddConnections(3)
+	m.AddConnections(-1)
+	m.SpooledToDisk("request", 100)
+	m.SpooledToDisk("request", 0)
+	m.WebsocketOpened()
+	m.WebsocketOpened()
+	m.WebsocketClosed()
+
+	s := m.Snapshot()
+	require.Len(t, s.Upstreams, 2)
+	assert.Equal(t, int64(2), s.Upstreams["http://a"].TotalCount())
+	assert.Equal(t, 0.5, s.Upstreams["http://a"].NetworkErrorRatio())
+	assert.Equal(t, map[int]int64{http.StatusOK: 1}, s.Upstreams["http://b"].StatusCodesCounts())
+	assert.Equal(t, map[string]int64{"buffer": 2}, s.Retries)
+	assert.Equal(t, map[string]string{"cb": "tripped"}, s.CircuitBreakers)
+	assert.Equal(t, int64(1), s.Rejections)
+	assert.Equal(t, int64(2), s.Connections)
+	assert.Equal(t, map[string]int64{"request": 100}, s.SpooledBytes)
+	assert.Equal(t, int64(1), s.Websockets)
+	assert.Equal(t, int64(2), s.WebsocketsTotal)
+
+	// the snapshot is a copy
+	m.Retry("buffer")
+	m.ObserveUpstream("http://b", http.MethodPost, http.StatusOK, time.Second)
+	assert.Equal(t, map[string]int64{"buffer": 2}, s.Retries)
+	assert.Equal(t, int64(1), s.Upstreams["http://b"].TotalCount())
+
+	_, err = NewMemMetrics(MemRTMetrics(nil))
+	assert.Error(t, err)
+}
+
+func TestMemMetricsConcurrentUpstreams(t *testing.T) {
+	m, err := NewMemMetrics()
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.ObserveUpstream("http://a", http.MethodGet, http.StatusOK, time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(1000), m.Snapshot().Upstreams["http://a"].TotalCount())
+}
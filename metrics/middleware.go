@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/vulcand/oxy/logging"
+	"github.com/vulcand/oxy/utils"
+)
+
+// Option is a functional option setter for Middleware
+type Option func(*Middleware) error
+
+// Logger defines the logger the metrics middleware will use.
+//
+// It defaults to logging.Default().
+func Logger(l logging.Logger) Option {
+	return func(mw *Middleware) error {
+		mw.log = l
+		return nil
+	}
+}
+
+// Middleware makes the observer available to the oxy middlewares handling the requests
+type Middleware struct {
+	next     http.Handler
+	observer Observer
+
+	log logging.Logger
+}
+
+// New returns a new metrics middleware
+func New(next http.Handler, o Observer, opts ...Option) (*Middleware, error) {
+	if o == nil {
+		return nil, fmt.Errorf("observer should not be nil")
+	}
+	mw := &Middleware{
+		next:     next,
+		observer: o,
+		log:      logging.Default(),
+	}
+	for _, o := range opts {
+		if err := o(mw); err != nil {
+			return nil, err
+		}
+	}
+	return mw, nil
+}
+
+// Wrap sets the next handler to be called by metrics handler.
+func (mw *Middleware) Wrap(next http.Handler) {
+	mw.next = next
+}
+
+func (mw *Middleware) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if mw.log.Enabled(logging.DebugLevel) {
+		logger := utils.RequestLogger(mw.log, req).With(utils.RequestField(req))
+		logging.Debug(logger, "vulcand/oxy/metrics: begin ServeHttp on request")
+		defer logging.Debug(logger, "vulcand/oxy/metrics: completed ServeHttp on request")
+	}
+
+	mw.next.ServeHTTP(w, req.WithContext(ContextWithMetrics(req.Context(), mw.observer)))
+}
@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	m := &testObserver{}
+
+	var fromContext Observer
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fromContext = FromContext(req.Context())
+		w.Write([]byte("hello"))
+	})
+
+	mw, err := New(handler, m)
+	require.NoError(t, err)
+
+	rw := httptest.NewRecorder()
+	mw.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Same(t, m, fromContext)
+
+	_, err = New(handler, nil)
+	assert.Error(t, err)
+}
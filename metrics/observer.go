@@ -0,0 +1,94 @@
+/*
+Package metrics records the metrics of the oxy middlewares with a pluggable Observer.
+
+The forwarder counts the upstream requests by backend, code and method and observes their latency, the buffer
+and stream count their retries, the circuit breaker reports its state, the rate limiter counts its rejections,
+the connection limiter reports the current connections and the buffer counts the bytes spooled to disk.
+The middlewares use the observer set with their Metrics option, or else the observer of the metrics
+middleware handling the request.
+
+The package only defines the Observer and the middleware, it does not depend on any metrics client. The observers
+exporting the metrics live in subpackages so the middlewares do not link their clients: prommetrics for Prometheus,
+inmemmetrics for in-memory rolling windows, statsdmetrics for StatsD and DogStatsD, and otelmetrics for OpenTelemetry.
+
+Examples of a metrics middleware:
+
+	registry := prometheus.NewRegistry()
+	m, _ := prommetrics.NewMetrics(registry)
+
+	// the metrics of the middlewares wrapped by the metrics middleware are recorded
+	metrics.New(handler, m)
+
+	// sends the metrics of a single middleware to a DogStatsD agent
+	s, _ := statsdmetrics.NewStatsD("127.0.0.1:8125", statsdmetrics.DogStatsD(true))
+	forward.New(forward.Metrics(s))
+*/
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Observer receives the metrics of the oxy middlewares, the implementations export them to a backend,
+// see the subpackages. The methods are called concurrently on the hot path and must not block.
+type Observer interface {
+	// ObserveUpstream records a request forwarded upstream and its latency
+	ObserveUpstream(backend, method string, code int, latency time.Duration)
+	// Retry records a retry of the middleware
+	Retry(middleware string)
+	// SetCircuitBreakerState records the current state of the circuit breaker instance
+	SetCircuitBreakerState(instance, state string)
+	// RateLimited records a request of the source rejected by the rate limiter
+	RateLimited(req *http.Request, source string)
+	// AddConnections adds the delta to the connections accepted by the connection limiters
+	AddConnections(delta int64)
+	// SpooledToDisk records the bytes of a body spooled to disk, body is request or response
+	SpooledToDisk(body string, n int64)
+	// WebsocketOpened records an open websocket connection
+	WebsocketOpened()
+	// WebsocketClosed records a closed websocket connection
+	WebsocketClosed()
+}
+
+// CircuitBreakerStates are the states reported by SetCircuitBreakerState
+var CircuitBreakerStates = []string{"standby", "tripped", "recovering"}
+
+// Nop is the Observer recording nothing, used when no observer is set
+var Nop Observer = nop{}
+
+type nop struct{}
+
+func (nop) ObserveUpstream(string, string, int, time.Duration) {}
+func (nop) Retry(string)                                       {}
+func (nop) SetCircuitBreakerState(string, string)              {}
+func (nop) RateLimited(*http.Request, string)                  {}
+func (nop) AddConnections(int64)                               {}
+func (nop) SpooledToDisk(string, int64)                        {}
+func (nop) WebsocketOpened()                                   {}
+func (nop) WebsocketClosed()                                   {}
+
+type observerKey struct{}
+
+// ContextWithMetrics returns a copy of the context holding the observer
+func ContextWithMetrics(ctx context.Context, o Observer) context.Context {
+	return context.WithValue(ctx, observerKey{}, o)
+}
+
+// FromContext returns the observer of the context, or Nop if the request is not handled by a metrics middleware
+func FromContext(ctx context.Context) Observer {
+	if o, ok := ctx.Value(observerKey{}).(Observer); ok && o != nil {
+		return o
+	}
+	return Nop
+}
+
+// Resolve returns the observer set on a middleware with its Metrics option,
+// or else the observer of the context, see FromContext.
+func Resolve(ctx context.Context, o Observer) Observer {
+	if o != nil {
+		return o
+	}
+	return FromContext(ctx)
+}
@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testObserver is an Observer distinct from Nop, compared by identity
+type testObserver struct {
+	nop
+	name string
+}
+
+func TestResolve(t *testing.T) {
+	fromContext, fromOption := &testObserver{name: "context"}, &testObserver{name: "option"}
+
+	ctx := context.Background()
+	assert.Equal(t, Nop, FromContext(ctx))
+	assert.Equal(t, Nop, Resolve(ctx, nil))
+	assert.Same(t, fromOption, Resolve(ctx, fromOption))
+
+	ctx = ContextWithMetrics(ctx, fromContext)
+	assert.Same(t, fromContext, FromContext(ctx))
+	assert.Same(t, fromContext, Resolve(ctx, nil))
+	assert.Same(t, fromOption, Resolve(ctx, fromOption))
+}
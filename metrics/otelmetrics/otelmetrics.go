@@ -0,0 +1,156 @@
+/*
+Package otelmetrics provides the metrics.Observer recording the metrics of the oxy middlewares
+with the OpenTelemetry metrics API.
+
+Examples of an OpenTelemetry observer:
+
+	// records the metrics with the global meter provider
+	m, _ := otelmetrics.NewOTelMetrics(nil)
+	metrics.New(handler, m)
+*/
+package otelmetrics
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/vulcand/oxy/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// instrumentationName is the name of the meter of the oxy middlewares
+const instrumentationName = "github.com/vulcand/oxy"
+
+// OTelMetrics is the Observer recording the metrics with the OpenTelemetry metrics API.
+// The states of the circuit breakers are reported by an asynchronous gauge.
+type OTelMetrics struct {
+	requests        metric.Int64Counter
+	latency         metric.Float64Histogram
+	retries         metric.Int64Counter
+	rejections      metric.Int64Counter
+	connections     metric.Int64UpDownCounter
+	spooledBytes    metric.Int64Counter
+	websockets      metric.Int64UpDownCounter
+	websocketsTotal metric.Int64Counter
+
+	mu              sync.Mutex
+	circuitBreakers map[string]string
+}
+
+// NewOTelMetrics creates the instruments with the meter provider,
+// it uses the global meter provider if mp is nil, see otel.GetMeterProvider.
+func NewOTelMetrics(mp metric.MeterProvider) (*OTelMetrics, error) {
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+	meter := mp.Meter(instrumentationName)
+	m := &OTelMetrics{circuitBreakers: make(map[string]string)}
+
+	var err error
+	if m.requests, err = meter.Int64Counter("oxy.upstream.requests",
+		metric.WithDescription("Number of requests forwarded upstream by backend, code and method.")); err != nil {
+		return nil, err
+	}
+	if m.latency, err = meter.Float64Histogram("oxy.upstream.request.duration", metric.WithUnit("s"),
+		metric.WithDescription("Latency of the requests forwarded upstream by backend.")); err != nil {
+		return nil, err
+	}
+	if m.retries, err = meter.Int64Counter("oxy.retries",
+		metric.WithDescription("Number of retried requests by middleware.")); err != nil {
+		return nil, err
+	}
+	if m.rejections, err = meter.Int64Counter("oxy.ratelimit.rejections",
+		metric.WithDescription("Number of requests rejected by the rate limiter.")); err != nil {
+		return nil, err
+	}
+	if m.connections, err = meter.Int64UpDownCounter("oxy.connlimit.connections",
+		metric.WithDescription("Number of connections currently accepted by the connection limiters.")); err != nil {
+		return nil, err
+	}
+	if m.spooledBytes, err = meter.Int64Counter("oxy.buffer.spooled_bytes", metric.WithUnit("By"),
+		metric.WithDescription("Number of bytes of the buffered bodies spooled to disk by body, request or response.")); err != nil {
+		return nil, err
+	}
+	if m.websockets, err = meter.Int64UpDownCounter("oxy.websocket.connections",
+		metric.WithDescription("Number of open websocket connections.")); err != nil {
+		return nil, err
+	}
+	if m.websocketsTotal, err = meter.Int64Counter("oxy.websocket.connections.opened",
+		metric.WithDescription("Number of websocket connections opened.")); err != nil {
+		return nil, err
+	}
+	if _, err = meter.Int64ObservableGauge("oxy.circuit_breaker.state",
+		metric.WithDescription("State of the circuit breakers, 1 for the current state of the instance and 0 for the others."),
+		metric.WithInt64Callback(m.observeCircuitBreakers)); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ObserveUpstream counts a request forwarded upstream and records its latency
+func (m *OTelMetrics) ObserveUpstream(backend, method string, code int, latency time.Duration) {
+	ctx := context.Background()
+	m.requests.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("backend", backend), attribute.Int("code", code), attribute.String("method", method)))
+	m.latency.Record(ctx, latency.Seconds(), metric.WithAttributes(attribute.String("backend", backend)))
+}
+
+// Retry counts a retry of the middleware
+func (m *OTelMetrics) Retry(middleware string) {
+	m.retries.Add(context.Background(), 1, metric.WithAttributes(attribute.String("middleware", middleware)))
+}
+
+// SetCircuitBreakerState sets the current state of the circuit breaker instance
+func (m *OTelMetrics) SetCircuitBreakerState(instance, state string) {
+	m.mu.Lock()
+	m.circuitBreakers[instance] = state
+	m.mu.Unlock()
+}
+
+// RateLimited counts a request rejected by the rate limiter, the sources are not recorded
+func (m *OTelMetrics) RateLimited(req *http.Request, _ string) {
+	m.rejections.Add(req.Context(), 1)
+}
+
+// AddConnections adds the delta to the connections accepted by the connection limiters
+func (m *OTelMetrics) AddConnections(delta int64) {
+	m.connections.Add(context.Background(), delta)
+}
+
+// SpooledToDisk counts the bytes of a body spooled to disk
+func (m *OTelMetrics) SpooledToDisk(body string, n int64) {
+	if n <= 0 {
+		return
+	}
+	m.spooledBytes.Add(context.Background(), n, metric.WithAttributes(attribute.String("body", body)))
+}
+
+// WebsocketOpened counts an open websocket connection
+func (m *OTelMetrics) WebsocketOpened() {
+	m.websockets.Add(context.Background(), 1)
+	m.websocketsTotal.Add(context.Background(), 1)
+}
+
+// WebsocketClosed counts a closed websocket connection
+func (m *OTelMetrics) WebsocketClosed() {
+	m.websockets.Add(context.Background(), -1)
+}
+
+func (m *OTelMetrics) observeCircuitBreakers(_ context.Context, o metric.Int64Observer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for instance, state := range m.circuitBreakers {
+		for _, s := range metrics.CircuitBreakerStates {
+			var value int64
+			if s == state {
+				value = 1
+			}
+			o.Observe(value, metric.WithAttributes(attribute.String("instance", instance), attribute.String("state", s)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package otelmetrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestOTelMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	m, err := NewOTelMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	require.NoError(t, err)
+
+	m.ObserveUpstream("http://a", http.MethodGet, http.StatusOK, 10*time.Millisecond)
+	m.ObserveUpstream("http://a", http.MethodGet, http.StatusOK, 20*time.Millisecond)
+	m.Retry("buffer")
+	m.SetCircuitBreakerState("cb", "tripped")
+	m.RateLimited(httptest.NewRequest(http.MethodGet, "/", nil), "10.0.0.1")
+	m.AddConnections(3)
+	m.AddConnections(-1)
+	m.SpooledToDisk("request", 100)
+	m.WebsocketOpened()
+	m.WebsocketOpened()
+	m.WebsocketClosed()
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+	assert.Equal(t, instrumentationName, rm.ScopeMetrics[0].Scope.Name)
+
+	collected := make(map[string]points)
+	for _, metric := range rm.ScopeMetrics[0].Metrics {
+		collected[metric.Name] = dataPoints(t, metric.Data)
+	}
+
+	upstream := map[string]interface{}{"backend": "http://a", "code": float64(http.StatusOK), "method": http.MethodGet}
+	assertValue(t, collected["oxy.upstream.requests"], 2, upstream)
+	assertValue(t, collected["oxy.retries"], 1, map[string]interface{}{"middleware": "buffer"})
+	assertValue(t, collected["oxy.ratelimit.rejections"], 1, map[string]interface{}{})
+	assertValue(t, collected["oxy.connlimit.connections"], 2, map[string]interface{}{})
+	assertValue(t, collected["oxy.buffer.spooled_bytes"], 100, map[string]interface{}{"body": "request"})
+	assertValue(t, collected["oxy.websocket.connections"], 1, map[string]interface{}{})
+	assertValue(t, collected["oxy.websocket.connections.opened"], 2, map[string]interface{}{})
+
+	latency := collected["oxy.upstream.request.duration"]
+	require.Len(t, latency, 1)
+	assert.Equal(t, uint64(2), latency[0].Count)
+	assert.InDelta(t, 0.03, latency[0].Sum, 1e-9)
+
+	values := make(map[interface{}]float64)
+	for _, dp := range collected["oxy.circuit_breaker.state"] {
+		assert.Equal(t, "cb", dp.Attributes["instance"])
+		values[dp.Attributes["state"]] = dp.Value
+	}
+	assert.Equal(t, map[interface{}]float64{"standby": 0, "tripped": 1, "recovering": 0}, values)
+}
+
+type point struct {
+	Attributes map[string]interface{}
+	Value      float64
+	Count      uint64
+	Sum        float64
+}
+
+type points []point
+
+// dataPoints decodes the data points of the aggregation, whatever the type of its values
+func dataPoints(t *testing.T, data metricdata.Aggregation) points {
+	t.Helper()
+
+	raw, err := json.Marshal(data)
+	require.NoError(t, err)
+
+	var decoded struct {
+		DataPoints []struct {
+			Attributes []struct {
+				Key   string
+				Value struct{ Value interface{} }
+			}
+			Value float64
+			Count uint64
+			Sum   float64
+		}
+	}
+	require.NoError(t, json.Unmarshal(raw, &decoded))
+
+	var result points
+	for _, dp := range decoded.DataPoints {
+		p := point{Attributes: make(map[string]interface{}), Value: dp.Value, Count: dp.Count, Sum: dp.Sum}
+		for _, attr := range dp.Attributes {
+			p.Attributes[attr.Key] = attr.Value.Value
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+func assertValue(t *testing.T, data points, expected float64, attrs map[string]interface{}) {
+	t.Helper()
+
+	require.Len(t, data, 1)
+	assert.Equal(t, expected, data[0].Value)
+	assert.Equal(t, attrs, data[0].Attributes)
+}
@@ -0,0 +1,230 @@
+/*
+Package prommetrics provides the metrics.Observer exporting the metrics of the oxy middlewares
+in the Prometheus format. The collectors are registered on the given registerer, there is no global state.
+
+Examples of a Prometheus observer:
+
+	registry := prometheus.NewRegistry()
+	m, _ := prommetrics.NewMetrics(registry)
+
+	// the metrics of the middlewares wrapped by the metrics middleware are recorded
+	metrics.New(handler, m)
+
+	// exposes the metrics
+	http.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+*/
+package prommetrics
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vulcand/oxy/metrics"
+)
+
+// DefaultNamespace is the default namespace of the metrics
+const DefaultNamespace = "oxy"
+
+// MetricsOption is a functional option setter for Metrics
+type MetricsOption func(*Metrics) error
+
+// Namespace sets the namespace of the metrics, it defaults to oxy.
+func Namespace(ns string) MetricsOption {
+	return func(m *Metrics) error {
+		m.namespace = ns
+		return nil
+	}
+}
+
+// Buckets sets the buckets in seconds of the upstream latency histogram, it defaults to prometheus.DefBuckets.
+func Buckets(buckets ...float64) MetricsOption {
+	return func(m *Metrics) error {
+		if len(buckets) == 0 {
+			return fmt.Errorf("buckets should not be empty")
+		}
+		m.buckets = buckets
+		return nil
+	}
+}
+
+// SourceClass sets the function classifying the sources rejected by the rate limiter,
+// the class is a label of the rejection counter and must have a low cardinality.
+// It defaults to "default" for all the sources.
+func SourceClass(fn func(req *http.Request, source string) string) MetricsOption {
+	return func(m *Metrics) error {
+		if fn == nil {
+			return fmt.Errorf("source class function should not be nil")
+		}
+		m.sourceClass = fn
+		return nil
+	}
+}
+
+// Metrics is the Observer exporting the metrics of the oxy middlewares in the Prometheus format.
+// The methods can be called on a nil Metrics, they do nothing.
+type Metrics struct {
+	namespace   string
+	buckets     []float64
+	sourceClass func(req *http.Request, source string) string
+
+	requests        *prometheus.CounterVec
+	latency         *prometheus.HistogramVec
+	retries         *prometheus.CounterVec
+	circuitBreakers *prometheus.GaugeVec
+	rateLimited     *prometheus.CounterVec
+	connections     prometheus.Gauge
+	spooledBytes    *prometheus.CounterVec
+	websockets      prometheus.Gauge
+	websocketsTotal prometheus.Counter
+}
+
+// NewMetrics creates the collectors and registers them on the registerer
+func NewMetrics(reg prometheus.Registerer, opts ...MetricsOption) (*Metrics, error) {
+	if reg == nil {
+		return nil, fmt.Errorf("registerer should not be nil")
+	}
+	m := &Metrics{
+		namespace: DefaultNamespace,
+		buckets:   prometheus.DefBuckets,
+		sourceClass: func(*http.Request, string) string {
+			return "default"
+		},
+	}
+	for _, o := range opts {
+		if err := o(m); err != nil {
+			return nil, err
+		}
+	}
+
+	m.requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.namespace,
+		Name:      "upstream_requests_total",
+		Help:      "Number of requests forwarded upstream by backend, code and method.",
+	}, []string{"backend", "code", "method"})
+	m.latency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: m.namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Latency of the requests forwarded upstream by backend.",
+		Buckets:   m.buckets,
+	}, []string{"backend"})
+	m.retries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.namespace,
+		Name:      "retries_total",
+		Help:      "Number of retried requests by middleware.",
+	}, []string{"middleware"})
+	m.circuitBreakers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: m.namespace,
+		Name:      "circuit_breaker_state",
+		Help:      "State of the circuit breakers, 1 for the current state of the instance and 0 for the others.",
+	}, []string{"instance", "state"})
+	m.rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.namespace,
+		Name:      "ratelimit_rejections_total",
+		Help:      "Number of requests rejected by the rate limiter by source class.",
+	}, []string{"source_class"})
+	m.connections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: m.namespace,
+		Name:      "connlimit_connections",
+		Help:      "Number of connections currently accepted by the connection limiters.",
+	})
+	m.spooledBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.namespace,
+		Name:      "buffer_spooled_bytes_total",
+		Help:      "Number of bytes of the buffered bodies spooled to disk by body, request or response.",
+	}, []string{"body"})
+	m.websockets = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: m.namespace,
+		Name:      "websocket_connections",
+		Help:      "Number of open websocket connections.",
+	})
+	m.websocketsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: m.namespace,
+		Name:      "websocket_connections_total",
+		Help:      "Number of websocket connections opened.",
+	})
+
+	for _, c := range []prometheus.Collector{
+		m.requests, m.latency, m.retries, m.circuitBreakers, m.rateLimited,
+		m.connections, m.spooledBytes, m.websockets, m.websocketsTotal,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// ObserveUpstream counts a request forwarded upstream and observes its latency
+func (m *Metrics) ObserveUpstream(backend, method string, code int, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	m.requests.WithLabelValues(backend, strconv.Itoa(code), method).Inc()
+	m.latency.WithLabelValues(backend).Observe(latency.Seconds())
+}
+
+// Retry counts a retry of the middleware
+func (m *Metrics) Retry(middleware string) {
+	if m == nil {
+		return
+	}
+	m.retries.WithLabelValues(middleware).Inc()
+}
+
+// SetCircuitBreakerState sets the current state of the circuit breaker instance
+func (m *Metrics) SetCircuitBreakerState(instance, state string) {
+	if m == nil {
+		return
+	}
+	for _, s := range metrics.CircuitBreakerStates {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		m.circuitBreakers.WithLabelValues(instance, s).Set(value)
+	}
+}
+
+// RateLimited counts a request of the source rejected by the rate limiter
+func (m *Metrics) RateLimited(req *http.Request, source string) {
+	if m == nil {
+		return
+	}
+	m.rateLimited.WithLabelValues(m.sourceClass(req, source)).Inc()
+}
+
+// AddConnections adds the delta to the connections accepted by the connection limiters
+func (m *Metrics) AddConnections(delta int64) {
+	if m == nil {
+		return
+	}
+	m.connections.Add(float64(delta))
+}
+
+// SpooledToDisk counts the bytes of a body spooled to disk, body is request or response
+func (m *Metrics) SpooledToDisk(body string, n int64) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.spooledBytes.WithLabelValues(body).Add(float64(n))
+}
+
+// WebsocketOpened counts an open websocket connection
+func (m *Metrics) WebsocketOpened() {
+	if m == nil {
+		return
+	}
+	m.websockets.Inc()
+	m.websocketsTotal.Inc()
+}
+
+// WebsocketClosed counts a closed websocket connection
+func (m *Metrics) WebsocketClosed() {
+	if m == nil {
+		return
+	}
+	m.websockets.Dec()
+}
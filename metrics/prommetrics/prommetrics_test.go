@@ -0,0 +1,120 @@
+package prommetrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := NewMetrics(registry, SourceClass(func(req *http.Request, source string) string {
+		if strings.HasPrefix(source, "10.") {
+			return "internal"
+		}
+		return "external"
+	}))
+	require.NoError(t, err)
+
+	m.ObserveUpstream("http://a", http.MethodGet, http.StatusOK, 10*time.Millisecond)
+	m.ObserveUpstream("http://a", http.MethodGet, http.StatusOK, 20*time.Millisecond)
+	m.ObserveUpstream("http://b", http.MethodPost, http.StatusBadGateway, time.Second)
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.requests.WithLabelValues("http://a", "200", "GET")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues("http://b", "502", "POST")))
+
+	m.Retry("buffer")
+	m.Retry("buffer")
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.retries.WithLabelValues("buffer")))
+
+	m.SetCircuitBreakerState("cb", "tripped")
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.circuitBreakers.WithLabelValues("cb", "tripped")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.circuitBreakers.WithLabelValues("cb", "standby")))
+	m.SetCircuitBreakerState("cb", "standby")
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.circuitBreakers.WithLabelValues("cb", "tripped")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.circuitBreakers.WithLabelValues("cb", "standby")))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	m.RateLimited(req, "10.0.0.1")
+	m.RateLimited(req, "192.168.0.1")
+	m.RateLimited(req, "10.0.0.2")
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.rateLimited.WithLabelValues("internal")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.rateLimited.WithLabelValues("external")))
+
+	m.AddConnections(3)
+	m.AddConnections(-1)
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.connections))
+
+	m.SpooledToDisk("request", 100)
+	m.SpooledToDisk("request", 0)
+	assert.Equal(t, 100.0, testutil.ToFloat64(m.spooledBytes.WithLabelValues("request")))
+
+	m.WebsocketOpened()
+	m.WebsocketOpened()
+	m.WebsocketClosed()
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.websockets))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.websocketsTotal))
+
+	expected := `
+		# HELP oxy_upstream_request_duration_seconds Latency of the requests forwarded upstream by backend.
+		# TYPE oxy_upstream_request_duration_seconds histogram
+		oxy_upstream_request_duration_seconds_bucket{backend="http://b",le="0.005"} 0
+		oxy_upstream_request_duration_seconds_bucket{backend="http://b",le="0.01"} 0
+		oxy_upstream_request_duration_seconds_bucket{backend="http://b",le="0.025"} 0
+		oxy_upstream_request_duration_seconds_bucket{backend="http://b",le="0.05"} 0
+		oxy_upstream_request_duration_seconds_bucket{backend="http://b",le="0.1"} 0
+		oxy_upstream_request_duration_seconds_bucket{backend="http://b",le="0.25"} 0
+		oxy_upstream_request_duration_seconds_bucket{backend="http://b",le="0.5"} 0
+		oxy_upstream_request_duration_seconds_bucket{backend="http://b",le="1"} 1
+		oxy_upstream_request_duration_seconds_bucket{backend="http://b",le="2.5"} 1
+		oxy_upstream_request_duration_seconds_bucket{backend="http://b",le="5"} 1
+		oxy_upstream_request_duration_seconds_bucket{backend="http://b",le="10"} 1
+		oxy_upstream_request_duration_seconds_bucket{backend="http://b",le="+Inf"} 1
+		oxy_upstream_request_duration_seconds_sum{backend="http://b"} 1
+		oxy_upstream_request_duration_seconds_count{backend="http://b"} 1
+	`
+	m.latency.DeleteLabelValues("http://a")
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "oxy_upstream_request_duration_seconds"))
+}
+
+func TestMetricsOptions(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := NewMetrics(registry, Namespace("proxy"), Buckets(0.1, 1))
+	require.NoError(t, err)
+
+	m.ObserveUpstream("http://a", http.MethodGet, http.StatusOK, 10*time.Millisecond)
+	count, err := testutil.GatherAndCount(registry, "proxy_upstream_requests_total", "proxy_upstream_request_duration_seconds")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// the collectors are already registered
+	_, err = NewMetrics(registry, Namespace("proxy"))
+	assert.Error(t, err)
+
+	_, err = NewMetrics(nil)
+	assert.Error(t, err)
+	_, err = NewMetrics(registry, Buckets())
+	assert.Error(t, err)
+	_, err = NewMetrics(registry, SourceClass(nil))
+	assert.Error(t, err)
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	assert.NotPanics(t, func() {
+		m.ObserveUpstream("http://a", http.MethodGet, http.StatusOK, time.Second)
+		m.Retry("buffer")
+		m.SetCircuitBreakerState("cb", "tripped")
+		m.RateLimited(httptest.NewRequest(http.MethodGet, "/", nil), "a")
+		m.AddConnections(1)
+		m.SpooledToDisk("request", 1)
+		m.WebsocketOpened()
+		m.WebsocketClosed()
+	})
+}
@@ -0,0 +1,185 @@
+/*
+Package statsdmetrics provides the metrics.Observer sending the metrics of the oxy middlewares
+to a StatsD or DogStatsD agent.
+
+Examples of a StatsD observer:
+
+	// sends the metrics of a single middleware to a DogStatsD agent
+	s, _ := statsdmetrics.NewStatsD("127.0.0.1:8125", statsdmetrics.DogStatsD(true))
+	forward.New(forward.Metrics(s))
+*/
+package statsdmetrics
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vulcand/oxy/metrics"
+)
+
+// DefaultStatsDPrefix is the default prefix of the StatsD metrics
+const DefaultStatsDPrefix = "oxy."
+
+// StatsDOption is a functional option setter for StatsD
+type StatsDOption func(*StatsD) error
+
+// StatsDPrefix sets the prefix of the metric names, it defaults to "oxy.".
+func StatsDPrefix(prefix string) StatsDOption {
+	return func(s *StatsD) error {
+		s.prefix = prefix
+		return nil
+	}
+}
+
+// DogStatsD sends the labels as DogStatsD tags, e.g. oxy.retries:1|c|#middleware:buffer.
+// By default the labels are appended to the metric names, e.g. oxy.retries.buffer:1|c.
+func DogStatsD(enabled bool) StatsDOption {
+	return func(s *StatsD) error {
+		s.tags = enabled
+		return nil
+	}
+}
+
+// StatsD is the Observer sending the metrics to a StatsD or DogStatsD agent over UDP.
+// A metric is sent per datagram and the write errors are ignored, the agent being best effort.
+// The sources rejected by the rate limiter are not sent to keep a low cardinality.
+type StatsD struct {
+	conn   net.Conn
+	prefix string
+	tags   bool
+}
+
+// NewStatsD returns a new observer sending the metrics to the agent listening on the UDP address
+func NewStatsD(addr string, opts ...StatsDOption) (*StatsD, error) {
+	s := &StatsD{prefix: DefaultStatsDPrefix}
+	for _, o := range opts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial statsd agent %v: %v", addr, err)
+	}
+	s.conn = conn
+	return s, nil
+}
+
+// Close closes the connection to the agent
+func (s *StatsD) Close() error {
+	return s.conn.Close()
+}
+
+// ObserveUpstream counts a request forwarded upstream and times its latency
+func (s *StatsD) ObserveUpstream(backend, method string, code int, latency time.Duration) {
+	s.send("upstream.requests", "1", "c", "backend", backend, "code", strconv.Itoa(code), "method", method)
+	ms := strconv.FormatFloat(float64(latency)/float64(time.Millisecond), 'f', -1, 64)
+	s.send("upstream.request_duration", ms, "ms", "backend", backend)
+}
+
+// Retry counts a retry of the middleware
+func (s *StatsD) Retry(middleware string) {
+	s.send("retries", "1", "c", "middleware", middleware)
+}
+
+// SetCircuitBreakerState sets a gauge per state of the circuit breaker instance, 1 for the current state and 0 for the others
+func (s *StatsD) SetCircuitBreakerState(instance, state string) {
+	for _, st := range metrics.CircuitBreakerStates {
+		value := "0"
+		if st == state {
+			value = "1"
+		}
+		s.send("circuit_breaker.state", value, "g", "instance", instance, "state", st)
+	}
+}
+
+// RateLimited counts a request rejected by the rate limiter
+func (s *StatsD) RateLimited(*http.Request, string) {
+	s.send("ratelimit.rejections", "1", "c")
+}
+
+// AddConnections adds the delta to the gauge of the connections accepted by the connection limiters
+func (s *StatsD) AddConnections(delta int64) {
+	s.send("connlimit.connections", signed(delta), "g")
+}
+
+// SpooledToDisk counts the bytes of a body spooled to disk
+func (s *StatsD) SpooledToDisk(body string, n int64) {
+	if n <= 0 {
+		return
+	}
+	s.send("buffer.spooled_bytes", strconv.FormatInt(n, 10), "c", "body", body)
+}
+
+// WebsocketOpened counts an open websocket connection
+func (s *StatsD) WebsocketOpened() {
+	s.send("websocket.connections", "+1", "g")
+	s.send("websocket.connections_total", "1", "c")
+}
+
+// WebsocketClosed counts a closed websocket connection
+func (s *StatsD) WebsocketClosed() {
+	s.send("websocket.connections", "-1", "g")
+}
+
+// send writes the metric with its labels given as key value pairs
+func (s *StatsD) send(name, value, kind string, labels ...string) {
+	var b strings.Builder
+	b.WriteString(s.prefix)
+	b.WriteString(name)
+	if !s.tags {
+		for i := 1; i < len(labels); i += 2 {
+			b.WriteByte('.')
+			b.WriteString(sanitizeName(labels[i]))
+		}
+	}
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteByte('|')
+	b.WriteString(kind)
+	if s.tags && len(labels) > 0 {
+		b.WriteString("|#")
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i])
+			b.WriteByte(':')
+			b.WriteString(sanitizeTag(labels[i+1]))
+		}
+	}
+	s.conn.Write([]byte(b.String()))
+}
+
+// signed formats a gauge delta, a value without sign would set the gauge
+func signed(n int64) string {
+	if n < 0 {
+		return strconv.FormatInt(n, 10)
+	}
+	return "+" + strconv.FormatInt(n, 10)
+}
+
+// sanitizeName replaces the characters of a label which are not allowed in a metric name
+func sanitizeName(v string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, v)
+}
+
+// sanitizeTag replaces the separators of the DogStatsD format in a tag value
+func sanitizeTag(v string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ',', '|', '#', '\n':
+			return '_'
+		}
+		return r
+	}, v)
+}
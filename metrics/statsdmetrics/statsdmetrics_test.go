@@ -0,0 +1,97 @@
+package statsdmetrics
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsD(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		opts     []StatsDOption
+		expected []string
+	}{
+		{
+			desc: "labels in names",
+			expected: []string{
+				"oxy.upstream.requests.http___a_80.502.GET:1|c",
+				"oxy.upstream.request_duration.http___a_80:12.5|ms",
+				"oxy.retries.buffer:1|c",
+				"oxy.circuit_breaker.state.cb.standby:0|g",
+				"oxy.circuit_breaker.state.cb.tripped:1|g",
+				"oxy.circuit_breaker.state.cb.recovering:0|g",
+				"oxy.ratelimit.rejections:1|c",
+				"oxy.connlimit.connections:+2|g",
+				"oxy.connlimit.connections:-1|g",
+				"oxy.buffer.spooled_bytes.response:100|c",
+				"oxy.websocket.connections:+1|g",
+				"oxy.websocket.connections_total:1|c",
+				"oxy.websocket.connections:-1|g",
+			},
+		},
+		{
+			desc: "dogstatsd tags",
+			opts: []StatsDOption{DogStatsD(true), StatsDPrefix("proxy.")},
+			expected: []string{
+				"proxy.upstream.requests:1|c|#backend:http://a:80,code:502,method:GET",
+				"proxy.upstream.request_duration:12.5|ms|#backend:http://a:80",
+				"proxy.retries:1|c|#middleware:buffer",
+				"proxy.circuit_breaker.state:0|g|#instance:cb,state:standby",
+				"proxy.circuit_breaker.state:1|g|#instance:cb,state:tripped",
+				"proxy.circuit_breaker.state:0|g|#instance:cb,state:recovering",
+				"proxy.ratelimit.rejections:1|c",
+				"proxy.connlimit.connections:+2|g",
+				"proxy.connlimit.connections:-1|g",
+				"proxy.buffer.spooled_bytes:100|c|#body:response",
+				"proxy.websocket.connections:+1|g",
+				"proxy.websocket.connections_total:1|c",
+				"proxy.websocket.connections:-1|g",
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer agent.Close()
+
+			s, err := NewStatsD(agent.LocalAddr().String(), test.opts...)
+			require.NoError(t, err)
+			defer s.Close()
+
+			s.ObserveUpstream("http://a:80", http.MethodGet, http.StatusBadGateway, 12500*time.Microsecond)
+			s.Retry("buffer")
+			s.SetCircuitBreakerState("cb", "tripped")
+			s.RateLimited(httptest.NewRequest(http.MethodGet, "/", nil), "10.0.0.1")
+			s.AddConnections(2)
+			s.AddConnections(-1)
+			s.SpooledToDisk("response", 100)
+			s.SpooledToDisk("response", 0)
+			s.WebsocketOpened()
+			s.WebsocketClosed()
+
+			var received []string
+			buf := make([]byte, 1024)
+			for range test.expected {
+				require.NoError(t, agent.SetReadDeadline(time.Now().Add(time.Second)))
+				n, _, err := agent.ReadFrom(buf)
+				require.NoError(t, err)
+				received = append(received, string(buf[:n]))
+			}
+			assert.Equal(t, test.expected, received)
+		})
+	}
+}
+
+func TestStatsDInvalidAddress(t *testing.T) {
+	_, err := NewStatsD("localhost:not-a-port")
+	assert.Error(t, err)
+}
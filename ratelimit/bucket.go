@@ -105,6 +105,29 @@ func (tb *tokenBucket) timeTillAvailable(tokens int64) time.Duration {
 	return time.Duration(missingTokens) * tb.timePerToken
 }
 
+// BucketStatus is the occupancy of a token bucket
+type BucketStatus struct {
+	Period  time.Duration
+	Average int64
+	Burst   int64
+	// Available is the number of tokens available for consumption
+	Available int64
+}
+
+// status returns the occupancy of the bucket, refilled up to now without modifying the bucket
+func (tb *tokenBucket) status() BucketStatus {
+	available := tb.availableTokens + int64(tb.clock.UtcNow().Sub(tb.lastRefresh)/tb.timePerToken)
+	if available > tb.burst {
+		available = tb.burst
+	}
+	return BucketStatus{
+		Period:    tb.period,
+		Average:   int64(tb.period / tb.timePerToken),
+		Burst:     tb.burst,
+		Available: available,
+	}
+}
+
 // updateAvailableTokens updates the number of tokens available for consumption.
 // It is calculated based on the refill rate, the time passed since last refresh,
 // and is limited by the bucket capacity.
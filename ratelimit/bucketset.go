@@ -86,6 +86,16 @@ func (tbs *TokenBucketSet) GetMaxPeriod() time.Duration {
 	return tbs.maxPeriod
 }
 
+// Status returns the occupancy of the buckets of the set, sorted by period
+func (tbs *TokenBucketSet) Status() []BucketStatus {
+	out := make([]BucketStatus, 0, len(tbs.buckets))
+	for _, bucket := range tbs.buckets {
+		out = append(out, bucket.status())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Period < out[j].Period })
+	return out
+}
+
 // debugState returns string that reflects the current state of all buckets in
 // this set. It is intended to be used for debugging and testing only.
 func (tbs *TokenBucketSet) debugState() string {
@@ -4,13 +4,17 @@ package ratelimit
 import (
 	"fmt"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/mailgun/timetools"
 	"github.com/mailgun/ttlmap"
-	log "github.com/sirupsen/logrus"
+	"github.com/vulcand/oxy/logging"
+	"github.com/vulcand/oxy/metrics"
+	"github.com/vulcand/oxy/tracing"
 	"github.com/vulcand/oxy/utils"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // DefaultCapacity default capacity
@@ -69,11 +73,13 @@ type TokenLimiter struct {
 	clock        timetools.TimeProvider
 	mutex        sync.Mutex
 	bucketSets   *ttlmap.TtlMap
+	sources      map[string]struct{}
 	errHandler   utils.ErrorHandler
 	capacity     int
+	observer     metrics.Observer
 	next         http.Handler
 
-	log *log.Logger
+	log logging.Logger
 }
 
 // New constructs a `TokenLimiter` middleware instance.
@@ -89,7 +95,7 @@ func New(next http.Handler, extract utils.SourceExtractor, defaultRates *RateSet
 		defaultRates: defaultRates,
 		extract:      extract,
 
-		log: log.StandardLogger(),
+		log: logging.Default(),
 	}
 
 	for _, o := range opts {
@@ -103,13 +109,14 @@ func New(next http.Handler, extract utils.SourceExtractor, defaultRates *RateSet
 		return nil, err
 	}
 	tl.bucketSets = bucketSets
+	tl.sources = make(map[string]struct{})
 	return tl, nil
 }
 
 // Logger defines the logger the token limiter will use.
 //
-// It defaults to logrus.StandardLogger(), the global logger used by logrus.
-func Logger(l *log.Logger) TokenLimiterOption {
+// It defaults to logging.Default().
+func Logger(l logging.Logger) TokenLimiterOption {
 	return func(tl *TokenLimiter) error {
 		tl.log = l
 		return nil
@@ -128,8 +135,15 @@ func (tl *TokenLimiter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if err := tl.consumeRates(req, source, amount); err != nil {
-		tl.log.Warnf("limiting request %v %v, limit: %v", req.Method, req.URL, err)
+	_, span := tracing.StartSpan(req.Context(), "ratelimit", trace.WithAttributes(tracing.SourceKey.String(source)))
+	err = tl.consumeRates(req, source, amount)
+	span.SetAttributes(tracing.AllowedKey.Bool(err == nil))
+	span.End()
+
+	if err != nil {
+		metrics.Resolve(req.Context(), tl.observer).RateLimited(req, source)
+		logging.Warn(utils.RequestLogger(tl.log, req), "limiting request",
+			logging.String("method", req.Method), logging.Any("url", req.URL), logging.Err(err))
 		tl.errHandler.ServeHTTP(w, req, err)
 		return
 	}
@@ -153,6 +167,11 @@ func (tl *TokenLimiter) consumeRates(req *http.Request, source string, amount in
 		// We set ttl as 10 times rate period. E.g. if rate is 100 requests/second per client ip
 		// the counters for this ip will expire after 10 seconds of inactivity
 		tl.bucketSets.Set(source, bucketSet, int(bucketSet.maxPeriod/time.Second)*10+1)
+		tl.sources[source] = struct{}{}
+		// the bucket sets expire or are evicted silently, forget their sources once in a while
+		if len(tl.sources) > 2*tl.capacity {
+			tl.pruneSources()
+		}
 	}
 	delay, err := bucketSet.Consume(amount)
 	if err != nil {
@@ -164,6 +183,37 @@ func (tl *TokenLimiter) consumeRates(req *http.Request, source string, amount in
 	return nil
 }
 
+// SourceStatus is the occupancy of the token buckets of a source
+type SourceStatus struct {
+	Source  string
+	Buckets []BucketStatus
+}
+
+// Sources returns the occupancy of the token buckets of the sources seen recently, sorted by source
+func (tl *TokenLimiter) Sources() []SourceStatus {
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+
+	tl.pruneSources()
+	out := make([]SourceStatus, 0, len(tl.sources))
+	for source := range tl.sources {
+		if bucketSetI, ok := tl.bucketSets.Get(source); ok {
+			out = append(out, SourceStatus{Source: source, Buckets: bucketSetI.(*TokenBucketSet).Status()})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Source < out[j].Source })
+	return out
+}
+
+// pruneSources forgets the sources whose bucket sets expired or were evicted
+func (tl *TokenLimiter) pruneSources() {
+	for source := range tl.sources {
+		if _, ok := tl.bucketSets.Get(source); !ok {
+			delete(tl.sources, source)
+		}
+	}
+}
+
 // effectiveRates retrieves rates to be applied to the request.
 func (tl *TokenLimiter) resolveRates(req *http.Request) *RateSet {
 	// If configuration mapper is not specified for this instance, then return
@@ -174,7 +224,7 @@ func (tl *TokenLimiter) resolveRates(req *http.Request) *RateSet {
 
 	rates, err := tl.extractRates.Extract(req)
 	if err != nil {
-		tl.log.Errorf("Failed to retrieve rates: %v", err)
+		logging.Error(utils.RequestLogger(tl.log, req), "Failed to retrieve rates", logging.Err(err))
 		return tl.defaultRates
 	}
 
@@ -195,11 +245,17 @@ func (m *MaxRateError) Error() string {
 	return fmt.Sprintf("max rate reached: retry-in %v", m.delay)
 }
 
+// ErrorKind classifies the error as rate limited
+func (m *MaxRateError) ErrorKind() utils.ErrorKind {
+	return utils.ErrorKindRateLimited
+}
+
 // RateErrHandler error handler
 type RateErrHandler struct{}
 
 func (e *RateErrHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, err error) {
 	if rerr, ok := err.(*MaxRateError); ok {
+		utils.RecordError(req, utils.ClassifyError(err))
 		w.Header().Set("Retry-After", fmt.Sprintf("%.0f", rerr.delay.Seconds()))
 		w.Header().Set("X-Retry-In", rerr.delay.String())
 		w.WriteHeader(http.StatusTooManyRequests)
@@ -220,6 +276,15 @@ func ErrorHandler(h utils.ErrorHandler) TokenLimiterOption {
 	}
 }
 
+// Metrics sets the observer of the rejected requests,
+// it defaults to the observer of the metrics middleware handling the request.
+func Metrics(o metrics.Observer) TokenLimiterOption {
+	return func(tl *TokenLimiter) error {
+		tl.observer = o
+		return nil
+	}
+}
+
 // ExtractRates sets the rate extractor
 func ExtractRates(e RateExtractor) TokenLimiterOption {
 	return func(cl *TokenLimiter) error {
@@ -4,13 +4,21 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/metrics"
+	"github.com/vulcand/oxy/metrics/prommetrics"
 	"github.com/vulcand/oxy/testutils"
+	"github.com/vulcand/oxy/tracing"
 	"github.com/vulcand/oxy/utils"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestRateSetAdd(t *testing.T) {
@@ -67,6 +75,79 @@ func TestHitLimit(t *testing.T) {
 	assert.Equal(t, http.StatusOK, re.StatusCode)
 }
 
+func TestTracing(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	rates := NewRateSet()
+	err := rates.Add(time.Second, 1, 1)
+	require.NoError(t, err)
+
+	l, err := New(handler, headerLimit, rates, Clock(testutils.GetClock()))
+	require.NoError(t, err)
+
+	exporter := tracetest.NewInMemoryExporter()
+	tr, err := tracing.New(l, tracing.TracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(tr)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL, testutils.Header("Source", "a"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+
+	re, _, err = testutils.Get(srv.URL, testutils.Header("Source", "a"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, re.StatusCode)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 4)
+	for i, allowed := range []bool{true, false} {
+		span := spans[2*i]
+		assert.Equal(t, "ratelimit", span.Name)
+		assert.Equal(t, spans[2*i+1].SpanContext.SpanID(), span.Parent.SpanID())
+		assert.Contains(t, span.Attributes, tracing.SourceKey.String("a"))
+		assert.Contains(t, span.Attributes, tracing.AllowedKey.Bool(allowed))
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	rates := NewRateSet()
+	err := rates.Add(time.Second, 1, 1)
+	require.NoError(t, err)
+
+	l, err := New(handler, headerLimit, rates, Clock(testutils.GetClock()))
+	require.NoError(t, err)
+
+	registry := prometheus.NewRegistry()
+	m, err := prommetrics.NewMetrics(registry)
+	require.NoError(t, err)
+	mw, err := metrics.New(l, m)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(mw)
+	defer srv.Close()
+
+	for _, code := range []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests} {
+		re, _, err := testutils.Get(srv.URL, testutils.Header("Source", "a"))
+		require.NoError(t, err)
+		assert.Equal(t, code, re.StatusCode)
+	}
+
+	expected := `
+		# HELP oxy_ratelimit_rejections_total Number of requests rejected by the rate limiter by source class.
+		# TYPE oxy_ratelimit_rejections_total counter
+		oxy_ratelimit_rejections_total{source_class="default"} 2
+	`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "oxy_ratelimit_rejections_total"))
+}
+
 // We've failed to extract client ip
 func TestFailure(t *testing.T) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
@@ -347,3 +428,48 @@ func faultyExtractor(_ *http.Request) (string, int64, error) {
 
 var headerLimit = utils.ExtractorFunc(headerLimiter)
 var faultyExtract = utils.ExtractorFunc(faultyExtractor)
+
+func TestSources(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	rates := NewRateSet()
+	require.NoError(t, rates.Add(time.Second, 2, 3))
+	require.NoError(t, rates.Add(time.Minute, 10, 20))
+
+	clock := testutils.GetClock()
+
+	l, err := New(handler, headerLimit, rates, Clock(clock))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(l)
+	defer srv.Close()
+
+	assert.Empty(t, l.Sources())
+
+	for _, source := range []string{"b", "a", "a"} {
+		re, _, err := testutils.Get(srv.URL, testutils.Header("Source", source))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, re.StatusCode)
+	}
+
+	assert.Equal(t, []SourceStatus{
+		{Source: "a", Buckets: []BucketStatus{
+			{Period: time.Second, Average: 2, Burst: 3, Available: 1},
+			{Period: time.Minute, Average: 10, Burst: 20, Available: 18},
+		}},
+		{Source: "b", Buckets: []BucketStatus{
+			{Period: time.Second, Average: 2, Burst: 3, Available: 2},
+			{Period: time.Minute, Average: 10, Burst: 20, Available: 19},
+		}},
+	}, l.Sources())
+
+	// the buckets are refilled without being consumed
+	clock.Sleep(time.Second)
+	assert.Equal(t, int64(3), l.Sources()[0].Buckets[0].Available)
+
+	// the expired sources are forgotten
+	clock.Sleep(24 * time.Hour)
+	assert.Empty(t, l.Sources())
+}
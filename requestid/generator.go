@@ -0,0 +1,116 @@
+package requestid
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Format is the format of the generated request IDs
+type Format string
+
+// Formats of the generated request IDs
+const (
+	// UUIDv4 is a random UUID, e.g. 0f8fad5b-d9cb-469f-a165-70867728950e
+	UUIDv4 Format = "uuidv4"
+	// UUIDv7 is a UUID starting with the time in milliseconds, the IDs sort by creation time
+	UUIDv7 Format = "uuidv7"
+	// ULID is a 26 characters lexicographically sortable ID, e.g. 01ARZ3NDEKTSV4RRFFQ69G5FAV
+	ULID Format = "ulid"
+)
+
+// crockford is the base32 alphabet of the ULIDs
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// generator returns the function generating the IDs of the format
+func generator(f Format) (func() string, error) {
+	switch f {
+	case UUIDv4:
+		return newUUIDv4, nil
+	case UUIDv7:
+		return func() string { return newUUIDv7(time.Now()) }, nil
+	case ULID:
+		return func() string { return newULID(time.Now()) }, nil
+	}
+	return nil, fmt.Errorf("unsupported request ID format %q", f)
+}
+
+func newUUIDv4() string {
+	var u [16]byte
+	randomBytes(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return formatUUID(u)
+}
+
+func newUUIDv7(now time.Time) string {
+	var u [16]byte
+	putMillis(u[:], now)
+	randomBytes(u[6:])
+	u[6] = u[6]&0x0f | 0x70
+	u[8] = u[8]&0x3f | 0x80
+	return formatUUID(u)
+}
+
+func formatUUID(u [16]byte) string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
+
+// newULID encodes the 48 bits of time in milliseconds followed by 80 random bits in base32
+func newULID(now time.Time) string {
+	var u [16]byte
+	putMillis(u[:], now)
+	randomBytes(u[6:])
+
+	// 128 bits are 26 characters of 5 bits, the first character holds the 3 high bits
+	var buf [26]byte
+	n := new128(u)
+	for i := len(buf) - 1; i >= 0; i-- {
+		buf[i] = crockford[n.lo&0x1f]
+		n = n.shiftRight5()
+	}
+	return string(buf[:])
+}
+
+// uint128 is the 128 bits of a ULID
+type uint128 struct {
+	hi, lo uint64
+}
+
+func new128(u [16]byte) uint128 {
+	return uint128{hi: binary.BigEndian.Uint64(u[0:8]), lo: binary.BigEndian.Uint64(u[8:16])}
+}
+
+func (n uint128) shiftRight5() uint128 {
+	return uint128{hi: n.hi >> 5, lo: n.lo>>5 | n.hi<<59}
+}
+
+// putMillis writes the unix time in milliseconds on the first 48 bits of b
+func putMillis(b []byte, now time.Time) {
+	ms := uint64(now.UnixNano() / int64(time.Millisecond))
+	b[0] = byte(ms >> 40)
+	b[1] = byte(ms >> 32)
+	b[2] = byte(ms >> 24)
+	b[3] = byte(ms >> 16)
+	b[4] = byte(ms >> 8)
+	b[5] = byte(ms)
+}
+
+func randomBytes(b []byte) {
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(fmt.Sprintf("vulcand/oxy/requestid: failed to read random bytes: %v", err))
+	}
+}
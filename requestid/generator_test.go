@@ -0,0 +1,67 @@
+package requestid
+
+import (
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerator(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		format   Format
+		expected *regexp.Regexp
+	}{
+		{
+			desc:     "UUIDv4",
+			format:   UUIDv4,
+			expected: regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+		},
+		{
+			desc:     "UUIDv7",
+			format:   UUIDv7,
+			expected: regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+		},
+		{
+			desc:     "ULID",
+			format:   ULID,
+			expected: regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`),
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			generate, err := generator(test.format)
+			require.NoError(t, err)
+
+			first, second := generate(), generate()
+			assert.Regexp(t, test.expected, first)
+			assert.Regexp(t, test.expected, second)
+			assert.NotEqual(t, first, second)
+		})
+	}
+
+	_, err := generator("snowflake")
+	assert.Error(t, err)
+}
+
+func TestTimeOrderedIDs(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	ids := []string{newULID(now.Add(2 * time.Millisecond)), newULID(now), newULID(now.Add(time.Millisecond))}
+	sort.Strings(ids)
+	assert.Equal(t, "01HF7YAT00", ids[0][:10])
+	assert.True(t, ids[0] < ids[1] && ids[1] < ids[2])
+
+	uuids := []string{newUUIDv7(now.Add(time.Millisecond)), newUUIDv7(now)}
+	assert.True(t, uuids[1] < uuids[0])
+	// 1700000000000 ms
+	assert.Equal(t, "018bcfe5-6800", uuids[1][:13])
+}
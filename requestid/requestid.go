@@ -0,0 +1,154 @@
+/*
+Package requestid provides http.Handler middleware generating or accepting the ID of the requests.
+
+The ID is set on the request header so that the forwarder propagates it upstream, echoed in the response
+header and stored in the request context: the log lines of the oxy middlewares and the trace records
+of the request include it, see utils.RequestIDFromContext.
+
+Examples of a request ID middleware:
+
+	// accepts the X-Request-Id header of the client or generates a random UUID
+	requestid.New(handler)
+
+	// always generates a ULID in the X-Correlation-Id header
+	requestid.New(handler,
+		requestid.Header("X-Correlation-Id"),
+		requestid.IDFormat(requestid.ULID),
+		requestid.AcceptIncoming(false))
+*/
+package requestid
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/vulcand/oxy/logging"
+	"github.com/vulcand/oxy/utils"
+)
+
+// maxIDLength is the maximum length of an incoming ID, the longer IDs are replaced
+const maxIDLength = 128
+
+// Option is a functional option setter for RequestID
+type Option func(*RequestID) error
+
+// Header sets the header holding the request ID, it defaults to X-Request-Id.
+func Header(name string) Option {
+	return func(r *RequestID) error {
+		if name == "" {
+			return fmt.Errorf("header name should not be empty")
+		}
+		r.header = http.CanonicalHeaderKey(name)
+		return nil
+	}
+}
+
+// IDFormat sets the format of the generated IDs, it defaults to UUIDv4.
+func IDFormat(f Format) Option {
+	return func(r *RequestID) error {
+		g, err := generator(f)
+		if err != nil {
+			return err
+		}
+		r.generate = g
+		return nil
+	}
+}
+
+// Generator sets the function generating the IDs, it overrides IDFormat.
+func Generator(fn func() string) Option {
+	return func(r *RequestID) error {
+		if fn == nil {
+			return fmt.Errorf("generator should not be nil")
+		}
+		r.generate = fn
+		return nil
+	}
+}
+
+// AcceptIncoming tells if the ID sent by the client is kept, it defaults to true.
+// An incoming ID longer than 128 characters or with characters other than printable ASCII is replaced.
+func AcceptIncoming(accept bool) Option {
+	return func(r *RequestID) error {
+		r.acceptIncoming = accept
+		return nil
+	}
+}
+
+// Logger defines the logger the request ID middleware will use.
+//
+// It defaults to logging.Default().
+func Logger(l logging.Logger) Option {
+	return func(r *RequestID) error {
+		r.log = l
+		return nil
+	}
+}
+
+// RequestID is a middleware setting the ID of the requests
+type RequestID struct {
+	next           http.Handler
+	header         string
+	generate       func() string
+	acceptIncoming bool
+
+	log logging.Logger
+}
+
+// New returns a new RequestID middleware
+func New(next http.Handler, opts ...Option) (*RequestID, error) {
+	r := &RequestID{
+		next:           next,
+		header:         utils.RequestIDHeader,
+		generate:       newUUIDv4,
+		acceptIncoming: true,
+		log:            logging.Default(),
+	}
+	for _, o := range opts {
+		if err := o(r); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Wrap sets the next handler to be called by request ID handler.
+func (r *RequestID) Wrap(next http.Handler) {
+	r.next = next
+}
+
+func (r *RequestID) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	id := req.Header.Get(r.header)
+	if !r.acceptIncoming || !validID(id) {
+		if id != "" && r.acceptIncoming {
+			logging.Debug(r.log, "vulcand/oxy/requestid: replacing invalid request ID", logging.String("invalid_id", id))
+		}
+		id = r.generate()
+	}
+
+	outReq := req.WithContext(utils.ContextWithRequestIDHeader(req.Context(), r.header, id))
+	outReq.Header = req.Header.Clone()
+	outReq.Header.Set(r.header, id)
+	w.Header().Set(r.header, id)
+
+	if r.log.Enabled(logging.DebugLevel) {
+		logger := utils.RequestLogger(r.log, outReq).With(utils.RequestField(outReq))
+		logging.Debug(logger, "vulcand/oxy/requestid: begin ServeHttp on request")
+		defer logging.Debug(logger, "vulcand/oxy/requestid: completed ServeHttp on request")
+	}
+
+	r.next.ServeHTTP(w, outReq)
+}
+
+// validID tells if the incoming ID is safe to log and to propagate
+func validID(id string) bool {
+	if id == "" || len(id) > maxIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
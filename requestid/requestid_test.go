@@ -0,0 +1,127 @@
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/forward"
+	"github.com/vulcand/oxy/testutils"
+	"github.com/vulcand/oxy/utils"
+)
+
+func TestRequestID(t *testing.T) {
+	var ctxID, headerID string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctxID = utils.RequestIDFromContext(req.Context())
+		headerID = req.Header.Get(utils.RequestIDHeader)
+		w.Write([]byte("hello"))
+	})
+
+	testCases := []struct {
+		desc       string
+		options    []Option
+		incoming   string
+		expectedID string
+	}{
+		{
+			desc:       "generated",
+			expectedID: "generated",
+		},
+		{
+			desc:       "accepted",
+			incoming:   "incoming-id",
+			expectedID: "incoming-id",
+		},
+		{
+			desc:       "not accepted",
+			options:    []Option{AcceptIncoming(false)},
+			incoming:   "incoming-id",
+			expectedID: "generated",
+		},
+		{
+			desc:       "invalid",
+			incoming:   "incoming id",
+			expectedID: "generated",
+		},
+		{
+			desc:       "too long",
+			incoming:   strings.Repeat("a", 129),
+			expectedID: "generated",
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			opts := append([]Option{Generator(func() string { return "generated" })}, test.options...)
+			rid, err := New(handler, opts...)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.incoming != "" {
+				req.Header.Set(utils.RequestIDHeader, test.incoming)
+			}
+			rw := httptest.NewRecorder()
+			rid.ServeHTTP(rw, req)
+
+			assert.Equal(t, test.expectedID, ctxID)
+			assert.Equal(t, test.expectedID, headerID)
+			assert.Equal(t, test.expectedID, rw.Header().Get(utils.RequestIDHeader))
+			// the request of the caller is not modified
+			assert.Equal(t, test.incoming, req.Header.Get(utils.RequestIDHeader))
+		})
+	}
+}
+
+func TestRequestIDCustomHeader(t *testing.T) {
+	var headerID string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		headerID = req.Header.Get("X-Correlation-Id")
+	})
+
+	rid, err := New(handler, Header("x-correlation-id"), IDFormat(ULID))
+	require.NoError(t, err)
+
+	rw := httptest.NewRecorder()
+	rid.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Len(t, headerID, 26)
+	assert.Equal(t, headerID, rw.Header().Get("X-Correlation-Id"))
+
+	_, err = New(handler, Header(""))
+	assert.Error(t, err)
+	_, err = New(handler, IDFormat("snowflake"))
+	assert.Error(t, err)
+}
+
+func TestRequestIDPropagatedUpstream(t *testing.T) {
+	var upstreamID string
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		upstreamID = req.Header.Get(utils.RequestIDHeader)
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	fwd, err := forward.New()
+	require.NoError(t, err)
+
+	rid, err := New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		fwd.ServeHTTP(w, req)
+	}))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(rid)
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "hello", string(body))
+	assert.NotEmpty(t, upstreamID)
+	assert.Equal(t, upstreamID, re.Header.Get(utils.RequestIDHeader))
+}
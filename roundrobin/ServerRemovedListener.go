@@ -0,0 +1,6 @@
+package roundrobin
+
+import "net/url"
+
+// ServerRemovedListener function called when a server is removed from the load balancer
+type ServerRemovedListener func(u *url.URL)
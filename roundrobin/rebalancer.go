@@ -8,8 +8,10 @@ import (
 	"time"
 
 	"github.com/mailgun/timetools"
-	log "github.com/sirupsen/logrus"
+	"github.com/vulcand/oxy/logging"
 	"github.com/vulcand/oxy/memmetrics"
+	"github.com/vulcand/oxy/metrics"
+	"github.com/vulcand/oxy/tracing"
 	"github.com/vulcand/oxy/utils"
 )
 
@@ -54,7 +56,9 @@ type Rebalancer struct {
 
 	requestRewriteListener RequestRewriteListener
 
-	log *log.Logger
+	observer metrics.Observer
+
+	log logging.Logger
 }
 
 // RebalancerClock sets a clock
@@ -105,6 +109,19 @@ func RebalancerRequestRewriteListener(rrl RequestRewriteListener) RebalancerOpti
 	}
 }
 
+// RebalancerMetrics sets the observer of the code and the latency of the requests sent to the servers.
+//
+// Unlike the Metrics option of the other middlewares it has no default: the forwarder next in chain
+// already reports the upstream requests to the observer of the metrics middleware,
+// set it when the next handler does not, e.g. it is not a forward.Forwarder.
+// The meters keep rating the servers on their own, see RebalancerMeter.
+func RebalancerMetrics(o metrics.Observer) RebalancerOption {
+	return func(r *Rebalancer) error {
+		r.observer = o
+		return nil
+	}
+}
+
 // NewRebalancer creates a new Rebalancer
 func NewRebalancer(handler balancerHandler, opts ...RebalancerOption) (*Rebalancer, error) {
 	rb := &Rebalancer{
@@ -112,7 +129,7 @@ func NewRebalancer(handler balancerHandler, opts ...RebalancerOption) (*Rebalanc
 		next:          handler,
 		stickySession: nil,
 
-		log: log.StandardLogger(),
+		log: logging.Default(),
 	}
 	for _, o := range opts {
 		if err := o(rb); err != nil {
@@ -146,8 +163,8 @@ func NewRebalancer(handler balancerHandler, opts ...RebalancerOption) (*Rebalanc
 
 // RebalancerLogger defines the logger the rebalancer will use.
 //
-// It defaults to logrus.StandardLogger(), the global logger used by logrus.
-func RebalancerLogger(l *log.Logger) RebalancerOption {
+// It defaults to logging.Default().
+func RebalancerLogger(l logging.Logger) RebalancerOption {
 	return func(rb *Rebalancer) error {
 		rb.log = l
 		return nil
@@ -162,16 +179,91 @@ func (rb *Rebalancer) Servers() []*url.URL {
 	return rb.next.Servers()
 }
 
+// RebalancerServerStatus is the state of a server of the rebalancer
+type RebalancerServerStatus struct {
+	URL *url.URL
+	// OriginalWeight is the weight supplied by the user
+	OriginalWeight int
+	// CurrentWeight is the weight set by the rebalancer
+	CurrentWeight int
+	// Rating is the failure rate measured by the meter of the server, meaningful once Ready
+	Rating float64
+	Ready  bool
+	// Good tells if the server was rated better than the others by the last rebalancing
+	Good    bool
+	Drained bool
+}
+
+// ServerStatuses returns the state of the servers in the rebalancer
+func (rb *Rebalancer) ServerStatuses() []RebalancerServerStatus {
+	rb.mtx.Lock()
+	defer rb.mtx.Unlock()
+
+	drained := map[string]bool{}
+	if s, ok := rb.next.(interface{ ServerStatuses() []ServerStatus }); ok {
+		for _, st := range s.ServerStatuses() {
+			drained[st.URL.String()] = st.Drained
+		}
+	}
+
+	out := make([]RebalancerServerStatus, len(rb.servers))
+	for i, srv := range rb.servers {
+		out[i] = RebalancerServerStatus{
+			URL:            utils.CopyURL(srv.url),
+			OriginalWeight: srv.origWeight,
+			CurrentWeight:  srv.curWeight,
+			Rating:         srv.meter.Rating(),
+			Ready:          srv.meter.IsReady(),
+			Good:           srv.good,
+			Drained:        drained[srv.url.String()],
+		}
+	}
+	return out
+}
+
+// DrainServer excludes the server from the rotation of the next load balancer without removing it,
+// see RoundRobin.DrainServer
+func (rb *Rebalancer) DrainServer(u *url.URL) error {
+	rb.mtx.Lock()
+	defer rb.mtx.Unlock()
+
+	d, ok := rb.next.(drainer)
+	if !ok {
+		return fmt.Errorf("%T does not support draining", rb.next)
+	}
+	return d.DrainServer(u)
+}
+
+// UndrainServer puts a drained server back in the rotation of the next load balancer
+func (rb *Rebalancer) UndrainServer(u *url.URL) error {
+	rb.mtx.Lock()
+	defer rb.mtx.Unlock()
+
+	d, ok := rb.next.(drainer)
+	if !ok {
+		return fmt.Errorf("%T does not support draining", rb.next)
+	}
+	return d.UndrainServer(u)
+}
+
+// drainer is implemented by the load balancers able to exclude servers from their rotation
+type drainer interface {
+	DrainServer(u *url.URL) error
+	UndrainServer(u *url.URL) error
+}
+
 func (rb *Rebalancer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	if rb.log.Level >= log.DebugLevel {
-		logEntry := rb.log.WithField("Request", utils.DumpHttpRequest(req))
-		logEntry.Debug("vulcand/oxy/roundrobin/rebalancer: begin ServeHttp on request")
-		defer logEntry.Debug("vulcand/oxy/roundrobin/rebalancer: completed ServeHttp on request")
+	if rb.log.Enabled(logging.DebugLevel) {
+		logger := utils.RequestLogger(rb.log, req).With(utils.RequestField(req))
+		logging.Debug(logger, "vulcand/oxy/roundrobin/rebalancer: begin ServeHttp on request")
+		defer logging.Debug(logger, "vulcand/oxy/roundrobin/rebalancer: completed ServeHttp on request")
 	}
 
 	pw := utils.NewProxyWriter(w)
 	start := rb.clock.UtcNow()
 
+	_, span := tracing.StartSpan(req.Context(), "balancer")
+
 	// make shallow copy of request before changing anything to avoid side effects
 	newReq := *req
 	stuck := false
@@ -180,7 +272,7 @@ func (rb *Rebalancer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		cookieUrl, present, err := rb.stickySession.GetBackend(&newReq, rb.Servers())
 
 		if err != nil {
-			log.Warnf("vulcand/oxy/roundrobin/rebalancer: error using server from cookie: %v", err)
+			logging.Warn(utils.RequestLogger(rb.log, req), "vulcand/oxy/roundrobin/rebalancer: error using server from cookie", logging.Err(err))
 		}
 
 		if present {
@@ -192,13 +284,16 @@ func (rb *Rebalancer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	if !stuck {
 		fwdURL, err := rb.next.NextServer()
 		if err != nil {
+			tracing.SetError(span, err)
+			span.End()
 			rb.errHandler.ServeHTTP(w, req, err)
 			return
 		}
 
-		if log.GetLevel() >= log.DebugLevel {
+		if rb.log.Enabled(logging.DebugLevel) {
 			// log which backend URL we're sending this request to
-			log.WithFields(log.Fields{"Request": utils.DumpHttpRequest(req), "ForwardURL": fwdURL}).Debugf("vulcand/oxy/roundrobin/rebalancer: Forwarding this request to URL")
+			logging.Debug(utils.RequestLogger(rb.log, req), "vulcand/oxy/roundrobin/rebalancer: Forwarding this request to URL",
+				utils.RequestField(req), logging.Any("forward_url", fwdURL))
 		}
 
 		if rb.stickySession != nil {
@@ -207,6 +302,8 @@ func (rb *Rebalancer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 		newReq.URL = fwdURL
 	}
+	span.SetAttributes(tracing.BackendKey.String(newReq.URL.String()), tracing.StickyKey.Bool(stuck))
+	span.End()
 
 	// Emit event to a listener if one exists
 	if rb.requestRewriteListener != nil {
@@ -215,7 +312,11 @@ func (rb *Rebalancer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	rb.next.Next().ServeHTTP(pw, &newReq)
 
-	rb.recordMetrics(newReq.URL, pw.StatusCode(), rb.clock.UtcNow().Sub(start))
+	latency := rb.clock.UtcNow().Sub(start)
+	rb.recordMetrics(newReq.URL, pw.StatusCode(), latency)
+	if rb.observer != nil {
+		rb.observer.ObserveUpstream(newReq.URL.Scheme+"://"+newReq.URL.Host, req.Method, pw.StatusCode(), latency)
+	}
 	rb.adjustWeights()
 }
 
@@ -343,7 +444,9 @@ func (rb *Rebalancer) adjustWeights() {
 
 func (rb *Rebalancer) applyWeights() {
 	for _, srv := range rb.servers {
-		rb.log.Debugf("upsert server %v, weight %v", srv.url, srv.curWeight)
+		if rb.log.Enabled(logging.DebugLevel) {
+			logging.Debug(rb.log, "upsert server", logging.Any("url", srv.url), logging.Int("weight", srv.curWeight))
+		}
 		rb.next.UpsertServer(srv.url, Weight(srv.curWeight))
 	}
 }
@@ -355,7 +458,10 @@ func (rb *Rebalancer) setMarkedWeights() bool {
 		if srv.good {
 			weight := increase(srv.curWeight)
 			if weight <= FSMMaxWeight {
-				rb.log.Debugf("increasing weight of %v from %v to %v", srv.url, srv.curWeight, weight)
+				if rb.log.Enabled(logging.DebugLevel) {
+					logging.Debug(rb.log, "increasing weight", logging.Any("url", srv.url),
+						logging.Int("from", srv.curWeight), logging.Int("to", weight))
+				}
 				srv.curWeight = weight
 				changed = true
 			}
@@ -402,7 +508,9 @@ func (rb *Rebalancer) markServers() bool {
 		}
 	}
 	if len(g) != 0 && len(b) != 0 {
-		rb.log.Debugf("bad: %v good: %v, ratings: %v", b, g, rb.ratings)
+		if rb.log.Enabled(logging.DebugLevel) {
+			logging.Debug(rb.log, "rated servers", logging.Any("bad", b), logging.Any("good", g), logging.Any("ratings", rb.ratings))
+		}
 	}
 	return len(g) != 0 && len(b) != 0
 }
@@ -416,7 +524,10 @@ func (rb *Rebalancer) convergeWeights() bool {
 		}
 		changed = true
 		newWeight := decrease(s.origWeight, s.curWeight)
-		log.Debugf("decreasing weight of %v from %v to %v", s.url, s.curWeight, newWeight)
+		if rb.log.Enabled(logging.DebugLevel) {
+			logging.Debug(rb.log, "decreasing weight", logging.Any("url", s.url),
+				logging.Int("from", s.curWeight), logging.Int("to", newWeight))
+		}
 		s.curWeight = newWeight
 	}
 	if !changed {
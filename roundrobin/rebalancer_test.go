@@ -10,6 +10,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/vulcand/oxy/forward"
+	"github.com/vulcand/oxy/metrics/inmemmetrics"
 	"github.com/vulcand/oxy/testutils"
 )
 
@@ -444,3 +445,67 @@ func (tm *testMeter) Record(int, time.Duration) {
 func (tm *testMeter) IsReady() bool {
 	return !tm.notReady
 }
+
+func TestRebalancerDrainServer(t *testing.T) {
+	a, b := testutils.NewResponder("a"), testutils.NewResponder("b")
+	defer a.Close()
+	defer b.Close()
+
+	fwd, err := forward.New()
+	require.NoError(t, err)
+
+	lb, err := New(fwd)
+	require.NoError(t, err)
+
+	rb, err := NewRebalancer(lb)
+	require.NoError(t, err)
+
+	require.NoError(t, rb.UpsertServer(testutils.ParseURI(a.URL)))
+	require.NoError(t, rb.UpsertServer(testutils.ParseURI(b.URL), Weight(3)))
+
+	proxy := httptest.NewServer(rb)
+	defer proxy.Close()
+
+	require.NoError(t, rb.DrainServer(testutils.ParseURI(a.URL)))
+	assert.Equal(t, []string{"b", "b", "b"}, seq(t, proxy.URL, 3))
+
+	statuses := rb.ServerStatuses()
+	require.Len(t, statuses, 2)
+	assert.Equal(t, a.URL, statuses[0].URL.String())
+	assert.True(t, statuses[0].Drained)
+	assert.Equal(t, 3, statuses[1].OriginalWeight)
+	assert.Equal(t, 3, statuses[1].CurrentWeight)
+	assert.False(t, statuses[1].Drained)
+
+	require.NoError(t, rb.UndrainServer(testutils.ParseURI(a.URL)))
+	assert.False(t, rb.ServerStatuses()[0].Drained)
+}
+
+func TestRebalancerMetrics(t *testing.T) {
+	a := testutils.NewResponder("a")
+	defer a.Close()
+
+	fwd, err := forward.New()
+	require.NoError(t, err)
+
+	lb, err := New(fwd)
+	require.NoError(t, err)
+
+	m, err := inmemmetrics.NewMemMetrics()
+	require.NoError(t, err)
+
+	rb, err := NewRebalancer(lb, RebalancerMetrics(m))
+	require.NoError(t, err)
+
+	require.NoError(t, rb.UpsertServer(testutils.ParseURI(a.URL)))
+
+	proxy := httptest.NewServer(rb)
+	defer proxy.Close()
+
+	assert.Equal(t, []string{"a", "a", "a"}, seq(t, proxy.URL, 3))
+
+	s := m.Snapshot()
+	require.Contains(t, s.Upstreams, a.URL)
+	assert.Equal(t, int64(3), s.Upstreams[a.URL].TotalCount())
+	assert.Equal(t, map[int]int64{http.StatusOK: 3}, s.Upstreams[a.URL].StatusCodesCounts())
+}
@@ -7,7 +7,8 @@ import (
 	"net/url"
 	"sync"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/vulcand/oxy/logging"
+	"github.com/vulcand/oxy/tracing"
 	"github.com/vulcand/oxy/utils"
 )
 
@@ -46,6 +47,15 @@ func RoundRobinRequestRewriteListener(rrl RequestRewriteListener) LBOption {
 	}
 }
 
+// RoundRobinServerRemovedListener is a functional argument that sets a listener called when a server is removed,
+// e.g. to release the connections of the server with forward.TransportManager.ClosePool
+func RoundRobinServerRemovedListener(srl ServerRemovedListener) LBOption {
+	return func(s *RoundRobin) error {
+		s.serverRemovedListener = srl
+		return nil
+	}
+}
+
 // RoundRobin implements dynamic weighted round robin load balancer http handler
 type RoundRobin struct {
 	mutex      *sync.Mutex
@@ -57,8 +67,9 @@ type RoundRobin struct {
 	currentWeight          int
 	stickySession          *StickySession
 	requestRewriteListener RequestRewriteListener
+	serverRemovedListener  ServerRemovedListener
 
-	log *log.Logger
+	log logging.Logger
 }
 
 // New created a new RoundRobin
@@ -70,7 +81,7 @@ func New(next http.Handler, opts ...LBOption) (*RoundRobin, error) {
 		servers:       []*server{},
 		stickySession: nil,
 
-		log: log.StandardLogger(),
+		log: logging.Default(),
 	}
 	for _, o := range opts {
 		if err := o(rr); err != nil {
@@ -85,8 +96,8 @@ func New(next http.Handler, opts ...LBOption) (*RoundRobin, error) {
 
 // RoundRobinLogger defines the logger the round robin load balancer will use.
 //
-// It defaults to logrus.StandardLogger(), the global logger used by logrus.
-func RoundRobinLogger(l *log.Logger) LBOption {
+// It defaults to logging.Default().
+func RoundRobinLogger(l logging.Logger) LBOption {
 	return func(r *RoundRobin) error {
 		r.log = l
 		return nil
@@ -99,12 +110,14 @@ func (r *RoundRobin) Next() http.Handler {
 }
 
 func (r *RoundRobin) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	if r.log.Level >= log.DebugLevel {
-		logEntry := r.log.WithField("Request", utils.DumpHttpRequest(req))
-		logEntry.Debug("vulcand/oxy/roundrobin/rr: begin ServeHttp on request")
-		defer logEntry.Debug("vulcand/oxy/roundrobin/rr: completed ServeHttp on request")
+	if r.log.Enabled(logging.DebugLevel) {
+		logger := utils.RequestLogger(r.log, req).With(utils.RequestField(req))
+		logging.Debug(logger, "vulcand/oxy/roundrobin/rr: begin ServeHttp on request")
+		defer logging.Debug(logger, "vulcand/oxy/roundrobin/rr: completed ServeHttp on request")
 	}
 
+	_, span := tracing.StartSpan(req.Context(), "balancer")
+
 	// make shallow copy of request before chaning anything to avoid side effects
 	newReq := *req
 	stuck := false
@@ -112,7 +125,7 @@ func (r *RoundRobin) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		cookieURL, present, err := r.stickySession.GetBackend(&newReq, r.Servers())
 
 		if err != nil {
-			log.Warnf("vulcand/oxy/roundrobin/rr: error using server from cookie: %v", err)
+			logging.Warn(utils.RequestLogger(r.log, req), "vulcand/oxy/roundrobin/rr: error using server from cookie", logging.Err(err))
 		}
 
 		if present {
@@ -124,6 +137,8 @@ func (r *RoundRobin) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	if !stuck {
 		url, err := r.NextServer()
 		if err != nil {
+			tracing.SetError(span, err)
+			span.End()
 			r.errHandler.ServeHTTP(w, req, err)
 			return
 		}
@@ -133,10 +148,13 @@ func (r *RoundRobin) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		}
 		newReq.URL = url
 	}
+	span.SetAttributes(tracing.BackendKey.String(newReq.URL.String()), tracing.StickyKey.Bool(stuck))
+	span.End()
 
-	if r.log.Level >= log.DebugLevel {
+	if r.log.Enabled(logging.DebugLevel) {
 		// log which backend URL we're sending this request to
-		r.log.WithFields(log.Fields{"Request": utils.DumpHttpRequest(req), "ForwardURL": newReq.URL}).Debugf("vulcand/oxy/roundrobin/rr: Forwarding this request to URL")
+		logging.Debug(utils.RequestLogger(r.log, req), "vulcand/oxy/roundrobin/rr: Forwarding this request to URL",
+			utils.RequestField(req), logging.Any("forward_url", newReq.URL))
 	}
 
 	// Emit event to a listener if one exists
@@ -185,7 +203,7 @@ func (r *RoundRobin) nextServer() (*server, error) {
 			}
 		}
 		srv := r.servers[r.index]
-		if srv.weight >= r.currentWeight {
+		if srv.effectiveWeight() >= r.currentWeight {
 			return srv, nil
 		}
 	}
@@ -193,16 +211,29 @@ func (r *RoundRobin) nextServer() (*server, error) {
 
 // RemoveServer remove a server
 func (r *RoundRobin) RemoveServer(u *url.URL) error {
+	srv, err := r.removeServer(u)
+	if err != nil {
+		return err
+	}
+
+	// Emit event to a listener if one exists, outside of the lock as the listener may be slow
+	if r.serverRemovedListener != nil {
+		r.serverRemovedListener(utils.CopyURL(srv.url))
+	}
+	return nil
+}
+
+func (r *RoundRobin) removeServer(u *url.URL) (*server, error) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
 	e, index := r.findServerByURL(u)
 	if e == nil {
-		return fmt.Errorf("server not found")
+		return nil, fmt.Errorf("server not found")
 	}
 	r.servers = append(r.servers[:index], r.servers[index+1:]...)
 	r.resetState()
-	return nil
+	return e, nil
 }
 
 // Servers gets servers URL
@@ -217,6 +248,50 @@ func (r *RoundRobin) Servers() []*url.URL {
 	return out
 }
 
+// ServerStatus is the state of a server of the load balancer
+type ServerStatus struct {
+	URL    *url.URL
+	Weight int
+	// Drained tells if the server is excluded from the rotation, see DrainServer
+	Drained bool
+}
+
+// ServerStatuses returns the state of the servers in the load balancer
+func (r *RoundRobin) ServerStatuses() []ServerStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	out := make([]ServerStatus, len(r.servers))
+	for i, srv := range r.servers {
+		out[i] = ServerStatus{URL: utils.CopyURL(srv.url), Weight: srv.weight, Drained: srv.drained}
+	}
+	return out
+}
+
+// DrainServer excludes the server from the rotation without removing it, the requests already
+// forwarded to it complete and the clients stuck to it by a sticky session keep reaching it
+func (r *RoundRobin) DrainServer(u *url.URL) error {
+	return r.setDrained(u, true)
+}
+
+// UndrainServer puts a drained server back in the rotation
+func (r *RoundRobin) UndrainServer(u *url.URL) error {
+	return r.setDrained(u, false)
+}
+
+func (r *RoundRobin) setDrained(u *url.URL, drained bool) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s, _ := r.findServerByURL(u)
+	if s == nil {
+		return fmt.Errorf("server not found")
+	}
+	s.drained = drained
+	r.resetState()
+	return nil
+}
+
 // ServerWeight gets the server weight
 func (r *RoundRobin) ServerWeight(u *url.URL) (int, bool) {
 	r.mutex.Lock()
@@ -287,8 +362,8 @@ func (r *RoundRobin) findServerByURL(u *url.URL) (*server, int) {
 func (r *RoundRobin) maxWeight() int {
 	max := -1
 	for _, s := range r.servers {
-		if s.weight > max {
-			max = s.weight
+		if s.effectiveWeight() > max {
+			max = s.effectiveWeight()
 		}
 	}
 	return max
@@ -298,9 +373,9 @@ func (r *RoundRobin) weightGcd() int {
 	divisor := -1
 	for _, s := range r.servers {
 		if divisor == -1 {
-			divisor = s.weight
+			divisor = s.effectiveWeight()
 		} else {
-			divisor = gcd(divisor, s.weight)
+			divisor = gcd(divisor, s.effectiveWeight())
 		}
 	}
 	return divisor
@@ -324,6 +399,16 @@ type server struct {
 	url *url.URL
 	// Relative weight for the enpoint to other enpoints in the load balancer
 	weight int
+	// drained servers are skipped by the rotation
+	drained bool
+}
+
+// effectiveWeight is the weight of the server in the rotation, 0 when drained
+func (s *server) effectiveWeight() int {
+	if s.drained {
+		return 0
+	}
+	return s.weight
 }
 
 var defaultWeight = 1
@@ -3,13 +3,18 @@ package roundrobin
 import (
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/vulcand/oxy/forward"
 	"github.com/vulcand/oxy/testutils"
+	"github.com/vulcand/oxy/tracing"
 	"github.com/vulcand/oxy/utils"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestNoServers(t *testing.T) {
@@ -27,6 +32,55 @@ func TestNoServers(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, re.StatusCode)
 }
 
+func TestTracing(t *testing.T) {
+	a := testutils.NewResponder("a")
+	defer a.Close()
+
+	fwd, err := forward.New()
+	require.NoError(t, err)
+
+	lb, err := New(fwd, EnableStickySession(NewStickySession("test")))
+	require.NoError(t, err)
+
+	exporter := tracetest.NewInMemoryExporter()
+	tr, err := tracing.New(lb, tracing.TracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(tr)
+	defer proxy.Close()
+
+	// no servers
+	re, _, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, re.StatusCode)
+
+	require.NoError(t, lb.UpsertServer(testutils.ParseURI(a.URL)))
+	re, _, err = testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+
+	req, err := http.NewRequest(http.MethodGet, proxy.URL, nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "test", Value: a.URL})
+	re, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	re.Body.Close()
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+
+	var balancers []tracetest.SpanStub
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "balancer" {
+			balancers = append(balancers, span)
+		}
+	}
+	require.Len(t, balancers, 3)
+	assert.Equal(t, codes.Error, balancers[0].Status.Code)
+	assert.Contains(t, balancers[1].Attributes, tracing.BackendKey.String(a.URL))
+	assert.Contains(t, balancers[1].Attributes, tracing.StickyKey.Bool(false))
+	assert.Contains(t, balancers[2].Attributes, tracing.BackendKey.String(a.URL))
+	assert.Contains(t, balancers[2].Attributes, tracing.StickyKey.Bool(true))
+}
+
 func TestRemoveBadServer(t *testing.T) {
 	lb, err := New(nil)
 	require.NoError(t, err)
@@ -228,6 +282,42 @@ func TestRequestRewriteListener(t *testing.T) {
 	assert.NotNil(t, lb.requestRewriteListener)
 }
 
+func TestServerRemovedListener(t *testing.T) {
+	a := testutils.NewResponder("a")
+	defer a.Close()
+
+	tm, err := forward.NewTransportManager()
+	require.NoError(t, err)
+	defer tm.Close()
+
+	fwd, err := forward.New(forward.RoundTripper(tm))
+	require.NoError(t, err)
+
+	var removed []string
+	lb, err := New(fwd, RoundRobinServerRemovedListener(func(u *url.URL) {
+		removed = append(removed, u.String())
+		tm.ClosePool(u)
+	}))
+	require.NoError(t, err)
+
+	require.NoError(t, lb.UpsertServer(testutils.ParseURI(a.URL)))
+
+	proxy := httptest.NewServer(lb)
+	defer proxy.Close()
+
+	assert.Equal(t, []string{"a"}, seq(t, proxy.URL, 1))
+	_, ok := tm.PoolStats(testutils.ParseURI(a.URL))
+	assert.True(t, ok)
+
+	require.NoError(t, lb.RemoveServer(testutils.ParseURI(a.URL)))
+	assert.Equal(t, []string{a.URL}, removed)
+	_, ok = tm.PoolStats(testutils.ParseURI(a.URL))
+	assert.False(t, ok)
+
+	assert.Error(t, lb.RemoveServer(testutils.ParseURI(a.URL)))
+	assert.Len(t, removed, 1)
+}
+
 func seq(t *testing.T, url string, repeat int) []string {
 	var out []string
 	for i := 0; i < repeat; i++ {
@@ -237,3 +327,35 @@ func seq(t *testing.T, url string, repeat int) []string {
 	}
 	return out
 }
+
+func TestDrainServer(t *testing.T) {
+	a := testutils.NewResponder("a")
+	defer a.Close()
+
+	b := testutils.NewResponder("b")
+	defer b.Close()
+
+	fwd, err := forward.New()
+	require.NoError(t, err)
+
+	lb, err := New(fwd)
+	require.NoError(t, err)
+
+	require.NoError(t, lb.UpsertServer(testutils.ParseURI(a.URL)))
+	require.NoError(t, lb.UpsertServer(testutils.ParseURI(b.URL), Weight(2)))
+
+	proxy := httptest.NewServer(lb)
+	defer proxy.Close()
+
+	require.NoError(t, lb.DrainServer(testutils.ParseURI(b.URL)))
+	assert.Equal(t, []string{"a", "a", "a"}, seq(t, proxy.URL, 3))
+	assert.Equal(t, []ServerStatus{
+		{URL: testutils.ParseURI(a.URL), Weight: 1},
+		{URL: testutils.ParseURI(b.URL), Weight: 2, Drained: true},
+	}, lb.ServerStatuses())
+
+	require.NoError(t, lb.UndrainServer(testutils.ParseURI(b.URL)))
+	assert.Equal(t, []string{"b", "a", "b"}, seq(t, proxy.URL, 3))
+
+	assert.Error(t, lb.DrainServer(testutils.ParseURI("http://localhost:1")))
+}
@@ -15,7 +15,12 @@ does not play well with buffering.
 Risks:
 1. Connections could survive for very long periods of time.
 
-2. There is no easy way to enforce limits on size/time of a connection.
+2. The limits on the size of the bodies are enforced on the fly: a request is rejected
+with 413 once its body exceeds the limit, a response exceeding the limit is replaced with a 502
+if its header has not been sent yet, the connection is aborted otherwise.
+
+3. The requests are only retried when their body has not been read by the next handler,
+e.g. bodiless requests or requests that failed before any byte was sent.
 
 Examples of a streaming middleware:
 
@@ -28,23 +33,40 @@ Examples of a streaming middleware:
   // or validation of the data.
   stream.New(handler)
 
+  // Stream rejects the requests and the responses larger than 1MB and
+  // retries the requests failing with a network error
+  stream.New(handler,
+    stream.MaxRequestBodyBytes(1<<20),
+    stream.MaxResponseBodyBytes(1<<20),
+    stream.Retry("IsNetworkError() && Attempts() < 3"))
+
 */
 package stream
 
 import (
+	"fmt"
 	"net/http"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/mailgun/multibuf"
+	"github.com/vulcand/oxy/logging"
+	"github.com/vulcand/oxy/metrics"
+	"github.com/vulcand/oxy/tracing"
 	"github.com/vulcand/oxy/utils"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	// DefaultMaxBodyBytes No limit by default
 	DefaultMaxBodyBytes = -1
+
+	// DefaultMaxRetryAttempts Maximum retry attempts
+	DefaultMaxRetryAttempts = 10
 )
 
-// Stream is responsible for buffering requests and responses
-// It buffers large requests and responses to disk,
+var errHandler utils.ErrorHandler = &SizeErrHandler{}
+
+// Stream is responsible for streaming requests and responses
+// It enforces the body limits and retries the requests without buffering them
 type Stream struct {
 	maxRequestBodyBytes int64
 
@@ -54,8 +76,9 @@ type Stream struct {
 
 	next       http.Handler
 	errHandler utils.ErrorHandler
+	observer   metrics.Observer
 
-	log *log.Logger
+	log logging.Logger
 }
 
 // New returns a new streamer middleware. New() function supports optional functional arguments
@@ -67,20 +90,23 @@ func New(next http.Handler, setters ...optSetter) (*Stream, error) {
 
 		maxResponseBodyBytes: DefaultMaxBodyBytes,
 
-		log: log.StandardLogger(),
+		log: logging.Default(),
 	}
 	for _, s := range setters {
 		if err := s(strm); err != nil {
 			return nil, err
 		}
 	}
+	if strm.errHandler == nil {
+		strm.errHandler = errHandler
+	}
 	return strm, nil
 }
 
 // Logger defines the logger the streamer will use.
 //
-// It defaults to logrus.StandardLogger(), the global logger used by logrus.
-func Logger(l *log.Logger) optSetter {
+// It defaults to logging.Default().
+func Logger(l logging.Logger) optSetter {
 	return func(s *Stream) error {
 		s.log = l
 		return nil
@@ -89,6 +115,80 @@ func Logger(l *log.Logger) optSetter {
 
 type optSetter func(s *Stream) error
 
+// CondSetter Conditional setter.
+// ex: Cond(a > 4, MaxRequestBodyBytes(a))
+func CondSetter(condition bool, setter optSetter) optSetter {
+	if !condition {
+		// NoOp setter
+		return func(*Stream) error {
+			return nil
+		}
+	}
+	return setter
+}
+
+// Retry provides a predicate that allows stream middleware to replay the request
+// if it matches certain condition, e.g. returns special error code. Available functions are:
+//
+// Attempts() - limits the amount of retry attempts
+// ResponseCode() - returns http response code
+// IsNetworkError() - tests if response code is related to networking error
+//
+// Example of the predicate:
+//
+// `Attempts() <= 2 && ResponseCode() == 502`
+//
+// The requests whose body has been read, even partially, are never retried.
+func Retry(predicate string) optSetter {
+	return func(s *Stream) error {
+		p, err := parseExpression(predicate)
+		if err != nil {
+			return err
+		}
+		s.retryPredicate = p
+		return nil
+	}
+}
+
+// ErrorHandler sets error handler of the server
+func ErrorHandler(h utils.ErrorHandler) optSetter {
+	return func(s *Stream) error {
+		s.errHandler = h
+		return nil
+	}
+}
+
+// Metrics sets the observer of the retries,
+// it defaults to the observer of the metrics middleware handling the request.
+func Metrics(o metrics.Observer) optSetter {
+	return func(s *Stream) error {
+		s.observer = o
+		return nil
+	}
+}
+
+// MaxRequestBodyBytes sets the maximum request body size in bytes
+func MaxRequestBodyBytes(m int64) optSetter {
+	return func(s *Stream) error {
+		if m < 0 {
+			return fmt.Errorf("max bytes should be >= 0 got %d", m)
+		}
+		s.maxRequestBodyBytes = m
+		return nil
+	}
+}
+
+// MaxResponseBodyBytes sets the maximum response body size in bytes
+func MaxResponseBodyBytes(m int64) optSetter {
+	return func(s *Stream) error {
+		if m < 0 {
+			return fmt.Errorf("max bytes should be >= 0 got %d", m)
+		}
+		s.maxResponseBodyBytes = m
+		return nil
+	}
+}
+
 // Wrap sets the next handler to be called by stream handler.
 func (s *Stream) Wrap(next http.Handler) error {
 	s.next = next
@@ -96,11 +196,102 @@ func (s *Stream) Wrap(next http.Handler) error {
 }
 
 func (s *Stream) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	if s.log.Level >= log.DebugLevel {
-		logEntry := s.log.WithField("Request", utils.DumpHttpRequest(req))
-		logEntry.Debug("vulcand/oxy/stream: begin ServeHttp on request")
-		defer logEntry.Debug("vulcand/oxy/stream: completed ServeHttp on request")
+	if s.log.Enabled(logging.DebugLevel) {
+		logger := utils.RequestLogger(s.log, req).With(utils.RequestField(req))
+		logging.Debug(logger, "vulcand/oxy/stream: begin ServeHttp on request")
+		defer logging.Debug(logger, "vulcand/oxy/stream: completed ServeHttp on request")
+	}
+
+	if s.maxRequestBodyBytes > 0 && req.ContentLength > s.maxRequestBodyBytes {
+		if s.log.Enabled(logging.DebugLevel) {
+			logging.Debug(utils.RequestLogger(s.log, req), "vulcand/oxy/stream: request body over limit", logging.Int64("length", req.ContentLength), logging.Int64("limit", s.maxRequestBodyBytes))
+		}
+		s.errHandler.ServeHTTP(w, req, &multibuf.MaxSizeReachedError{MaxSize: s.maxRequestBodyBytes})
+		return
+	}
+
+	outreq := req
+	var body *requestBody
+	if req.Body != nil && req.Body != http.NoBody {
+		body = &requestBody{rc: req.Body, max: s.maxRequestBodyBytes}
+		outreq = req.WithContext(req.Context())
+		outreq.Body = body
+	}
+
+	attempt := 1
+	for {
+		sw := newResponseWriter(w, s, req, body, attempt)
+		attemptCtx, span := tracing.StartSpan(req.Context(), "attempt", trace.WithAttributes(tracing.AttemptKey.Int(attempt)))
+		s.next.ServeHTTP(sw, outreq.WithContext(attemptCtx))
+		if !sw.wroteHeader && !sw.hijacked {
+			// the handler returned without writing, it is an implicit 200 with the header of the attempt
+			sw.WriteHeader(http.StatusOK)
+		}
+		span.SetAttributes(tracing.RetryKey.Bool(sw.retry))
+		span.End()
+
+		switch {
+		case sw.hijacked:
+			if s.log.Enabled(logging.DebugLevel) {
+				logging.Debug(utils.RequestLogger(s.log, req), "vulcand/oxy/stream: connection was hijacked downstream, not taking any action in stream")
+			}
+			return
+		case sw.retry:
+			metrics.Resolve(req.Context(), s.observer).Retry("stream")
+			utils.RecordRetry(req)
+			attempt++
+			if s.log.Enabled(logging.DebugLevel) {
+				logging.Debug(utils.RequestLogger(s.log, req), "vulcand/oxy/stream: retrying request", logging.String("method", req.Method), logging.Any("url", req.URL), logging.Int("attempt", attempt))
+			}
+			outreq = outreq.WithContext(req.Context())
+			continue
+		case sw.err != nil:
+			if s.log.Enabled(logging.DebugLevel) {
+				logging.Debug(utils.RequestLogger(s.log, req), "vulcand/oxy/stream: failed to stream response", logging.Err(sw.err))
+			}
+			s.errHandler.ServeHTTP(w, req, sw.err)
+		case sw.aborted:
+			// the header is already sent, the client must not mistake the truncated response for a complete one
+			if s.log.Enabled(logging.DebugLevel) {
+				logging.Debug(utils.RequestLogger(s.log, req), "vulcand/oxy/stream: response body over limit, aborting", logging.Int64("limit", s.maxResponseBodyBytes))
+			}
+			panic(http.ErrAbortHandler)
+		default:
+			sw.sendHeader()
+		}
+		return
 	}
+}
 
-	s.next.ServeHTTP(w, req)
+// ResponseSizeError is returned when the response body is larger than the maximum response body size
+type ResponseSizeError struct {
+	MaxSize int64
+}
+
+func (e *ResponseSizeError) Error() string {
+	return fmt.Sprintf("response body exceeds the maximum size of %d bytes", e.MaxSize)
+}
+
+// ErrorKind classifies the error as an upstream response too large, served as 502 like SizeErrHandler does
+func (e *ResponseSizeError) ErrorKind() utils.ErrorKind {
+	return utils.ErrorKindResponseTooLarge
+}
+
+// SizeErrHandler Size error handler, it answers 413 to the requests over limit and 502
+// to the requests whose response is over limit
+type SizeErrHandler struct{}
+
+func (e *SizeErrHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, err error) {
+	switch err.(type) {
+	case *multibuf.MaxSizeReachedError:
+		utils.RecordError(req, utils.ClassifyError(err))
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(http.StatusText(http.StatusRequestEntityTooLarge)))
+	case *ResponseSizeError:
+		utils.RecordError(req, utils.ClassifyError(err))
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(http.StatusText(http.StatusBadGateway)))
+	default:
+		utils.DefaultHandler.ServeHTTP(w, req, err)
+	}
 }
@@ -5,17 +5,24 @@ import (
 	"crypto/tls"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
-	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/vulcand/oxy/forward"
+	"github.com/vulcand/oxy/logging"
 	"github.com/vulcand/oxy/testutils"
+	"github.com/vulcand/oxy/tracing"
+	"github.com/vulcand/oxy/utils"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 type noOpNextHTTPHandler struct{}
@@ -288,6 +295,24 @@ func TestNoBody(t *testing.T) {
 	assert.Equal(t, http.StatusOK, re.StatusCode)
 }
 
+func TestImplicitHeader(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Test", "value")
+	})
+
+	st, err := New(handler, MaxResponseBodyBytes(10))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(st)
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "value", re.Header.Get("X-Test"))
+	assert.Empty(t, body)
+}
+
 // Make sure that stream handler preserves TLS settings
 func TestPreservesTLS(t *testing.T) {
 	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
@@ -323,11 +348,250 @@ func TestPreservesTLS(t *testing.T) {
 	assert.NotNil(t, cs)
 }
 
-func BenchmarkLoggingDebugLevel(b *testing.B) {
-	streamer, _ := New(noOpNextHTTPHandler{})
+func TestRequestLimitEnforced(t *testing.T) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = ioutil.ReadAll(req.Body)
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
 
-	log.SetLevel(log.DebugLevel)
-	log.SetOutput(&noOpIoWriter{}) // Make sure we don't emit a bunch of stuff on screen
+	fwd, err := forward.New(forward.Stream(true))
+	require.NoError(t, err)
+
+	rdr := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		fwd.ServeHTTP(w, req)
+	})
+
+	st, err := New(rdr, MaxRequestBodyBytes(4))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(st)
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL, testutils.Body("this request is too long"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, re.StatusCode)
+
+	// without Content-Length the limit is enforced while the body is read
+	re, err = http.Post(proxy.URL, "text/plain", ioutil.NopCloser(strings.NewReader("this request is too long")))
+	require.NoError(t, err)
+	re.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, re.StatusCode)
+
+	re, body, err := testutils.Get(proxy.URL, testutils.Body("ok"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestResponseLimitEnforced(t *testing.T) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("chunked") != "" {
+			for i := 0; i < 5; i++ {
+				w.Write([]byte("hello"))
+				w.(http.Flusher).Flush()
+				time.Sleep(10 * time.Millisecond)
+			}
+			return
+		}
+		w.Write([]byte("hello, this response is too large"))
+	})
+	defer srv.Close()
+
+	fwd, err := forward.New(forward.Stream(true))
+	require.NoError(t, err)
+
+	rdr := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL + "?" + req.URL.RawQuery)
+		fwd.ServeHTTP(w, req)
+	})
+
+	st, err := New(rdr, MaxResponseBodyBytes(10))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(st)
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, re.StatusCode)
+
+	// the header of a chunked response is already sent when the limit is reached, the connection is aborted
+	re, err = http.Get(proxy.URL + "?chunked=1")
+	require.NoError(t, err)
+	defer re.Body.Close()
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	_, err = ioutil.ReadAll(re.Body)
+	assert.Error(t, err)
+}
+
+func TestRetry(t *testing.T) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		w.Write(append([]byte("hello"), body...))
+	})
+	defer srv.Close()
+
+	down := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {})
+	downURL := down.URL
+	down.Close()
+
+	fwd, err := forward.New(forward.Stream(true))
+	require.NoError(t, err)
+
+	var attempts int32
+	rdr := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			req.URL = testutils.ParseURI(downURL)
+		} else {
+			req.URL = testutils.ParseURI(srv.URL)
+		}
+		fwd.ServeHTTP(w, req)
+	})
+
+	st, err := New(rdr, Retry("IsNetworkError() && Attempts() <= 2"))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(st)
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "hello", string(body))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&attempts))
+
+	// the body was not sent to the backend that refused the connection
+	atomic.StoreInt32(&attempts, 0)
+	re, body, err = testutils.Post(proxy.URL, testutils.Body(" world"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "hello world", string(body))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&attempts))
+}
+
+func TestRetryTracing(t *testing.T) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	down := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {})
+	downURL := down.URL
+	down.Close()
+
+	fwd, err := forward.New(forward.Stream(true))
+	require.NoError(t, err)
+
+	var attempts int32
+	rdr := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			req.URL = testutils.ParseURI(downURL)
+		} else {
+			req.URL = testutils.ParseURI(srv.URL)
+		}
+		fwd.ServeHTTP(w, req)
+	})
+
+	st, err := New(rdr, Retry("IsNetworkError() && Attempts() <= 2"))
+	require.NoError(t, err)
+
+	exporter := tracetest.NewInMemoryExporter()
+	tr, err := tracing.New(st, tracing.TracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(tr)
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+	assert.Equal(t, "hello", string(body))
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 5)
+	for i, span := range []tracetest.SpanStub{spans[1], spans[3]} {
+		assert.Equal(t, "attempt", span.Name)
+		assert.Equal(t, spans[4].SpanContext.SpanID(), span.Parent.SpanID())
+		assert.Contains(t, span.Attributes, tracing.AttemptKey.Int(i+1))
+		assert.Contains(t, span.Attributes, tracing.RetryKey.Bool(i == 0))
+	}
+	assert.Equal(t, spans[1].SpanContext.SpanID(), spans[0].Parent.SpanID())
+	assert.Equal(t, spans[3].SpanContext.SpanID(), spans[2].Parent.SpanID())
+}
+
+func TestNoRetryConsumedBody(t *testing.T) {
+	var attempts int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		_, _ = ioutil.ReadAll(req.Body)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	st, err := New(handler, Retry("IsNetworkError() && Attempts() <= 2"))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(st)
+	defer proxy.Close()
+
+	re, _, err := testutils.Post(proxy.URL, testutils.Body("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, re.StatusCode)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&attempts))
+
+	atomic.StoreInt32(&attempts, 0)
+	re, _, err = testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, re.StatusCode)
+	assert.EqualValues(t, 3, atomic.LoadInt32(&attempts))
+}
+
+func TestErrorPagesLimit(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello, this response is too large"))
+	})
+
+	pages, err := utils.NewErrorPages()
+	require.NoError(t, err)
+
+	st, err := New(handler, MaxResponseBodyBytes(4), ErrorHandler(pages))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(st)
+	defer proxy.Close()
+
+	// the response of the backend is too large, not the request of the client
+	re, body, err := testutils.Get(proxy.URL, testutils.Header("Accept", "application/json"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, re.StatusCode)
+	assert.Contains(t, string(body), `"kind":"response_too_large"`)
+}
+
+func TestCustomErrorHandlerLimit(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello, this response is too large"))
+	})
+
+	errHandler := utils.ErrorHandlerFunc(func(w http.ResponseWriter, req *http.Request, err error) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte(http.StatusText(http.StatusTeapot)))
+	})
+
+	st, err := New(handler, MaxResponseBodyBytes(4), ErrorHandler(errHandler))
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(st)
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTeapot, re.StatusCode)
+}
+
+func BenchmarkLoggingDebugLevel(b *testing.B) {
+	// Make sure we don't emit a bunch of stuff on screen
+	streamer, _ := New(noOpNextHTTPHandler{}, Logger(logging.Std(log.New(&noOpIoWriter{}, "", 0), logging.DebugLevel)))
 
 	for i := 0; i < b.N; i++ {
 		heavyServeHTTPLoad(streamer)
@@ -335,10 +599,8 @@ func BenchmarkLoggingDebugLevel(b *testing.B) {
 }
 
 func BenchmarkLoggingInfoLevel(b *testing.B) {
-	streamer, _ := New(noOpNextHTTPHandler{})
-
-	log.SetLevel(log.InfoLevel)
-	log.SetOutput(&noOpIoWriter{}) // Make sure we don't emit a bunch of stuff on screen
+	// Make sure we don't emit a bunch of stuff on screen
+	streamer, _ := New(noOpNextHTTPHandler{}, Logger(logging.Std(log.New(&noOpIoWriter{}, "", 0), logging.InfoLevel)))
 
 	for i := 0; i < b.N; i++ {
 		heavyServeHTTPLoad(streamer)
@@ -0,0 +1,224 @@
+package stream
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"github.com/mailgun/multibuf"
+	"github.com/vulcand/oxy/logging"
+)
+
+// requestBody counts the bytes read from the request body and fails once the limit is exceeded.
+// The body is read by the transport goroutines, hence the lock.
+type requestBody struct {
+	rc  io.ReadCloser
+	max int64
+
+	mu       sync.Mutex
+	n        int64
+	exceeded bool
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.n += int64(n)
+	if b.max > 0 && b.n > b.max {
+		b.exceeded = true
+		return n, &multibuf.MaxSizeReachedError{MaxSize: b.max}
+	}
+	return n, err
+}
+
+// Close does not close the request body, the transports close it on failure
+// and it has to stay readable for the next attempts. The server closes it.
+func (b *requestBody) Close() error {
+	return nil
+}
+
+func (b *requestBody) state() (consumed bool, exceeded bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.n > 0, b.exceeded
+}
+
+// responseWriter holds the header of the response until it knows the response is not
+// to be retried or replaced with an error, then streams the body while counting it.
+type responseWriter struct {
+	rw      http.ResponseWriter
+	s       *Stream
+	req     *http.Request
+	body    *requestBody
+	attempt int
+	header  http.Header
+
+	code        int
+	wroteHeader bool
+	// committed is set once the response is to be sent to the client, sent once its header is written
+	committed bool
+	sent      bool
+	// discard is set when the response of the attempt is not sent to the client
+	discard  bool
+	retry    bool
+	err      error
+	aborted  bool
+	hijacked bool
+	length   int64
+}
+
+func newResponseWriter(rw http.ResponseWriter, s *Stream, req *http.Request, body *requestBody, attempt int) *responseWriter {
+	return &responseWriter{
+		rw:      rw,
+		s:       s,
+		req:     req,
+		body:    body,
+		attempt: attempt,
+		header:  rw.Header().Clone(),
+	}
+}
+
+func (w *responseWriter) Header() http.Header {
+	if w.committed {
+		return w.rw.Header()
+	}
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		// informational responses are sent as they come
+		w.copyHeader()
+		w.rw.WriteHeader(code)
+		return
+	}
+	w.wroteHeader = true
+
+	var consumed, exceeded bool
+	if w.body != nil {
+		consumed, exceeded = w.body.state()
+	}
+	if exceeded {
+		w.discard = true
+		w.err = &multibuf.MaxSizeReachedError{MaxSize: w.s.maxRequestBodyBytes}
+		return
+	}
+
+	if w.s.retryPredicate != nil && w.attempt <= DefaultMaxRetryAttempts && !consumed &&
+		w.s.retryPredicate(&context{r: w.req, attempt: w.attempt, responseCode: code}) {
+		w.discard = true
+		w.retry = true
+		return
+	}
+
+	if w.s.maxResponseBodyBytes > 0 {
+		length, err := strconv.ParseInt(w.header.Get("Content-Length"), 10, 64)
+		if err == nil && length > w.s.maxResponseBodyBytes {
+			w.discard = true
+			w.err = &ResponseSizeError{MaxSize: w.s.maxResponseBodyBytes}
+			return
+		}
+	}
+
+	w.copyHeader()
+	w.committed = true
+	w.code = code
+}
+
+// sendHeader writes the header of a committed response, it is delayed until the first write
+// so that a response exceeding the limit on its first write can still be replaced with an error
+func (w *responseWriter) sendHeader() {
+	if w.committed && !w.sent {
+		w.sent = true
+		w.rw.WriteHeader(w.code)
+	}
+}
+
+// copyHeader replaces the header of the client response with the header of the attempt
+func (w *responseWriter) copyHeader() {
+	h := w.rw.Header()
+	for k := range h {
+		if _, ok := w.header[k]; !ok {
+			delete(h, k)
+		}
+	}
+	for k, v := range w.header {
+		h[k] = v
+	}
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.discard {
+		return len(p), nil
+	}
+	if w.aborted {
+		return 0, &ResponseSizeError{MaxSize: w.s.maxResponseBodyBytes}
+	}
+	if w.s.maxResponseBodyBytes > 0 && w.length+int64(len(p)) > w.s.maxResponseBodyBytes {
+		err := &ResponseSizeError{MaxSize: w.s.maxResponseBodyBytes}
+		if !w.sent {
+			w.committed = false
+			w.discard = true
+			w.err = err
+			return len(p), nil
+		}
+		w.aborted = true
+		return 0, err
+	}
+	w.sendHeader()
+	n, err := w.rw.Write(p)
+	w.length += int64(n)
+	return n, err
+}
+
+// Flush sends any buffered data to the client.
+func (w *responseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.committed {
+		return
+	}
+	w.sendHeader()
+	if f, ok := w.rw.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// CloseNotify returns a channel that receives at most a single value (true)
+// when the client connection has gone away.
+func (w *responseWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.rw.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	logging.Debug(w.s.log, "vulcand/oxy/stream: upstream ResponseWriter does not implement http.CloseNotifier, returning dummy channel",
+		logging.Any("type", reflect.TypeOf(w.rw)))
+	return make(<-chan bool)
+}
+
+// Hijack lets the caller take over the connection.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hi, ok := w.rw.(http.Hijacker); ok {
+		conn, rw, err := hi.Hijack()
+		if err == nil {
+			w.hijacked = true
+		}
+		return conn, rw, err
+	}
+	logging.Debug(w.s.log, "vulcand/oxy/stream: upstream ResponseWriter does not implement http.Hijacker",
+		logging.Any("type", reflect.TypeOf(w.rw)))
+	return nil, nil, fmt.Errorf("the response writer wrapped in this stream does not implement http.Hijacker. Its type is: %v", reflect.TypeOf(w.rw))
+}
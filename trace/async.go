@@ -0,0 +1,121 @@
+package trace
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/vulcand/oxy/logging"
+)
+
+// DefaultQueueSize is the default number of records queued by an AsyncWriter
+const DefaultQueueSize = 1024
+
+// ErrWriterClosed is returned by the writes to a closed AsyncWriter
+var ErrWriterClosed = errors.New("trace: writer closed")
+
+// AsyncOption is a functional option setter for AsyncWriter
+type AsyncOption func(*AsyncWriter) error
+
+// QueueSize sets the number of writes queued before they are dropped, it defaults to DefaultQueueSize.
+func QueueSize(n int) AsyncOption {
+	return func(a *AsyncWriter) error {
+		if n <= 0 {
+			return fmt.Errorf("queue size should be positive, got %v", n)
+		}
+		a.queueSize = n
+		return nil
+	}
+}
+
+// AsyncLogger defines the logger reporting the errors of the underlying writer.
+//
+// It defaults to logging.Default().
+func AsyncLogger(l logging.Logger) AsyncOption {
+	return func(a *AsyncWriter) error {
+		a.log = l
+		return nil
+	}
+}
+
+// AsyncWriter writes to the underlying writer in the background so that a slow output does not add
+// latency to the requests. The writes are queued in a bounded queue and dropped when it is full,
+// see Dropped. As the formatters write a record with a single call to Write, a record is either
+// written or dropped as a whole.
+type AsyncWriter struct {
+	w         io.Writer
+	queueSize int
+	queue     chan []byte
+	done      chan struct{}
+	dropped   int64
+
+	mu     sync.RWMutex
+	closed bool
+
+	log logging.Logger
+}
+
+// NewAsyncWriter returns a new AsyncWriter writing to w, Close must be called to flush the queued writes
+func NewAsyncWriter(w io.Writer, opts ...AsyncOption) (*AsyncWriter, error) {
+	a := &AsyncWriter{
+		w:         w,
+		queueSize: DefaultQueueSize,
+		done:      make(chan struct{}),
+		log:       logging.Default(),
+	}
+	for _, o := range opts {
+		if err := o(a); err != nil {
+			return nil, err
+		}
+	}
+	a.queue = make(chan []byte, a.queueSize)
+	go a.run()
+	return a, nil
+}
+
+// Write queues a copy of p, it never blocks: p is dropped when the queue is full
+func (a *AsyncWriter) Write(p []byte) (int, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return 0, ErrWriterClosed
+	}
+
+	b := make([]byte, len(p))
+	copy(b, p)
+	select {
+	case a.queue <- b:
+	default:
+		atomic.AddInt64(&a.dropped, 1)
+	}
+	return len(p), nil
+}
+
+// Dropped returns the number of writes dropped because the queue was full
+func (a *AsyncWriter) Dropped() int64 {
+	return atomic.LoadInt64(&a.dropped)
+}
+
+// Close writes the queued writes and stops the writer, the underlying writer is not closed
+func (a *AsyncWriter) Close() error {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+
+	<-a.done
+	return nil
+}
+
+func (a *AsyncWriter) run() {
+	defer close(a.done)
+	for b := range a.queue {
+		if _, err := a.w.Write(b); err != nil {
+			logging.Error(a.log, "vulcand/oxy/trace: failed to write record", logging.Err(err))
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package trace
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingWriter blocks the writes until it is released
+type blockingWriter struct {
+	release chan struct{}
+	mu      sync.Mutex
+	buf     bytes.Buffer
+}
+
+func (b *blockingWriter) Write(p []byte) (int, error) {
+	<-b.release
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func TestAsyncWriter(t *testing.T) {
+	out := &bytes.Buffer{}
+	a, err := NewAsyncWriter(out)
+	require.NoError(t, err)
+
+	for _, line := range []string{"a\n", "b\n", "c\n"} {
+		n, err := a.Write([]byte(line))
+		require.NoError(t, err)
+		assert.Equal(t, len(line), n)
+	}
+	require.NoError(t, a.Close())
+
+	assert.Equal(t, "a\nb\nc\n", out.String())
+	assert.EqualValues(t, 0, a.Dropped())
+
+	_, err = a.Write([]byte("d\n"))
+	assert.Equal(t, ErrWriterClosed, err)
+	assert.NoError(t, a.Close())
+}
+
+func TestAsyncWriterDrops(t *testing.T) {
+	out := &blockingWriter{release: make(chan struct{})}
+	a, err := NewAsyncWriter(out, QueueSize(2))
+	require.NoError(t, err)
+
+	// the first write is taken by the background writer which blocks,
+	// the queue holds the next two and the others are dropped
+	buf := []byte("x\n")
+	for i := 0; i < 10; i++ {
+		_, err := a.Write(buf)
+		require.NoError(t, err)
+	}
+	// the writes are copied
+	buf[0] = 'y'
+
+	dropped := a.Dropped()
+	assert.True(t, dropped >= 7 && dropped <= 8, "dropped %v", dropped)
+
+	close(out.release)
+	require.NoError(t, a.Close())
+	assert.Equal(t, bytes.Repeat([]byte("x\n"), int(10-dropped)), out.buf.Bytes())
+
+	_, err = NewAsyncWriter(out, QueueSize(0))
+	assert.Error(t, err)
+}
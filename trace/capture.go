@@ -0,0 +1,135 @@
+package trace
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DefaultCaptureContentTypes are the content types of the bodies captured when no content type is given,
+// a type ending with a slash matches all its subtypes
+var DefaultCaptureContentTypes = []string{
+	"application/json",
+	"application/x-www-form-urlencoded",
+	"application/xml",
+	"text/",
+}
+
+// bodyCapture keeps the first bytes of a body, the request body may still be read by the
+// transport when the response is recorded so the access is synchronized
+type bodyCapture struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (c *bodyCapture) capture(p []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if remaining := c.limit - c.buf.Len(); len(p) > remaining {
+		p = p[:remaining]
+		c.truncated = true
+	}
+	c.buf.Write(p)
+}
+
+func (c *bodyCapture) body() (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.String(), c.truncated
+}
+
+// captureReader captures the bytes of the request body read by the next handler
+type captureReader struct {
+	io.ReadCloser
+	capture *bodyCapture
+}
+
+func (r *captureReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.capture.capture(p[:n])
+	return n, err
+}
+
+// captureWriter captures the bytes of the response body written by the next handler,
+// the decision to capture is taken on the first write once the headers are known
+type captureWriter struct {
+	http.ResponseWriter
+	tracer  *Tracer
+	capture *bodyCapture
+	decided bool
+	enabled bool
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.decided = true
+		w.enabled = w.tracer.capturable(w.Header())
+	}
+	n, err := w.ResponseWriter.Write(p)
+	if w.enabled {
+		w.capture.capture(p[:n])
+	}
+	return n, err
+}
+
+// Flush flush the writer
+func (w *captureWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// CloseNotify returns a channel that receives at most a single value (true)
+// when the client connection has gone away.
+func (w *captureWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(<-chan bool)
+}
+
+// Hijack lets the caller take over the connection.
+func (w *captureWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hi, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hi.Hijack()
+	}
+	return nil, nil, fmt.Errorf("the response writer %T does not implement http.Hijacker", w.ResponseWriter)
+}
+
+// capturable tells if the body with the headers is captured, the encoded bodies are not captured
+func (t *Tracer) capturable(h http.Header) bool {
+	if enc := h.Get("Content-Encoding"); enc != "" && !strings.EqualFold(enc, "identity") {
+		return false
+	}
+	mediaType := mediaType(h)
+	if mediaType == "" {
+		return false
+	}
+	for _, ct := range t.captureTypes {
+		if strings.HasSuffix(ct, "/") && strings.HasPrefix(mediaType, ct) || mediaType == ct {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaType returns the lowercase media type of the Content-Type header, without the parameters
+func mediaType(h http.Header) string {
+	mt, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mt
+}
@@ -0,0 +1,230 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formatter writes a record to the output, a record is written with a single call to Write
+type Formatter interface {
+	Format(w io.Writer, r *Record) error
+}
+
+// FormatterFunc is an adapter to use a function as a Formatter
+type FormatterFunc func(w io.Writer, r *Record) error
+
+// Format calls f(w, r)
+func (f FormatterFunc) Format(w io.Writer, r *Record) error {
+	return f(w, r)
+}
+
+// clfTimeFormat is the time format of the Common Log Format
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+var (
+	// JSON writes the records as JSON objects, one per line
+	JSON Formatter = FormatterFunc(formatJSON)
+
+	// CommonLog writes the records in the Common Log Format, e.g.
+	// 127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326
+	CommonLog Formatter = FormatterFunc(func(w io.Writer, r *Record) error {
+		return formatCommonLog(w, r, false)
+	})
+
+	// CombinedLog writes the records in the Combined Log Format, the Common Log Format followed by
+	// the Referer and User-Agent headers
+	CombinedLog Formatter = FormatterFunc(func(w io.Writer, r *Record) error {
+		return formatCommonLog(w, r, true)
+	})
+)
+
+// DefaultLogfmtFields are the fields written by Logfmt when no field is given
+var DefaultLogfmtFields = []string{
+	"time", "request_id", "client_ip", "method", "url", "proto", "code", "bytes_written",
+	"duration", "backend", "upstream_duration", "retries", "error_kind",
+}
+
+// fields are the fields of a record available to the Logfmt and JSONTemplate formatters,
+// the durations are in milliseconds
+var fields = map[string]func(r *Record) interface{}{
+	"time":              func(r *Record) interface{} { return r.Time.Format(time.RFC3339Nano) },
+	"request_id":        func(r *Record) interface{} { return r.RequestID },
+	"client_ip":         func(r *Record) interface{} { return r.Request.ClientIP },
+	"user_agent":        func(r *Record) interface{} { return r.Request.UserAgent },
+	"referer":           func(r *Record) interface{} { return r.Request.Referer },
+	"method":            func(r *Record) interface{} { return r.Request.Method },
+	"url":               func(r *Record) interface{} { return r.Request.URL },
+	"proto":             func(r *Record) interface{} { return r.Request.Proto },
+	"request_bytes":     func(r *Record) interface{} { return r.Request.BodyBytes },
+	"code":              func(r *Record) interface{} { return r.Response.Code },
+	"response_bytes":    func(r *Record) interface{} { return r.Response.BodyBytes },
+	"bytes_written":     func(r *Record) interface{} { return r.Response.BytesWritten },
+	"duration":          func(r *Record) interface{} { return r.Response.Roundtrip },
+	"error":             func(r *Record) interface{} { return r.Response.Error },
+	"error_kind":        func(r *Record) interface{} { return r.Response.ErrorKind },
+	"backend":           func(r *Record) interface{} { return r.upstream().Backend },
+	"upstream_duration": func(r *Record) interface{} { return r.upstream().Duration },
+	"retries":           func(r *Record) interface{} { return r.upstream().Retries },
+	"tls_version": func(r *Record) interface{} {
+		if r.Request.TLS == nil {
+			return ""
+		}
+		return r.Request.TLS.Version
+	},
+}
+
+// upstream returns the upstream record, or an empty one if the request was not forwarded
+func (r *Record) upstream() *Upstream {
+	if r.Upstream == nil {
+		return &Upstream{}
+	}
+	return r.Upstream
+}
+
+// Logfmt returns a formatter writing the fields of the records as logfmt key=value pairs, e.g.
+// time=2000-10-10T20:55:36Z method=GET url=/ code=200 duration=1.5
+// The fields are written in the given order, DefaultLogfmtFields are written when no field is given.
+func Logfmt(names ...string) (Formatter, error) {
+	if len(names) == 0 {
+		names = DefaultLogfmtFields
+	}
+	if err := checkFields(names...); err != nil {
+		return nil, err
+	}
+	return FormatterFunc(func(w io.Writer, r *Record) error {
+		var b bytes.Buffer
+		for i, name := range names {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(name)
+			b.WriteByte('=')
+			b.WriteString(logfmtValue(fields[name](r)))
+		}
+		b.WriteByte('\n')
+		_, err := w.Write(b.Bytes())
+		return err
+	}), nil
+}
+
+// JSONTemplate returns a formatter writing the records as JSON objects with a custom set of fields,
+// the keys of the template are the keys of the objects and its values are the names of the fields, e.g.
+//
+//	trace.JSONTemplate(map[string]string{"status": "code", "ip": "client_ip", "took_ms": "duration"})
+//
+// The fields are time, request_id, client_ip, user_agent, referer, method, url, proto, request_bytes, code,
+// response_bytes, bytes_written, duration, error, error_kind, backend, upstream_duration, retries and tls_version.
+func JSONTemplate(template map[string]string) (Formatter, error) {
+	if len(template) == 0 {
+		return nil, fmt.Errorf("template should not be empty")
+	}
+	keys := make(map[string]string, len(template))
+	for key, name := range template {
+		if err := checkFields(name); err != nil {
+			return nil, err
+		}
+		keys[key] = name
+	}
+	return FormatterFunc(func(w io.Writer, r *Record) error {
+		out := make(map[string]interface{}, len(keys))
+		for key, name := range keys {
+			out[key] = fields[name](r)
+		}
+		return json.NewEncoder(w).Encode(out)
+	}), nil
+}
+
+func checkFields(names ...string) error {
+	for _, name := range names {
+		if _, ok := fields[name]; !ok {
+			known := make([]string, 0, len(fields))
+			for f := range fields {
+				known = append(known, f)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("unknown field %q, supported fields are %v", name, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
+
+func formatJSON(w io.Writer, r *Record) error {
+	return json.NewEncoder(w).Encode(r)
+}
+
+func formatCommonLog(w io.Writer, r *Record, combined bool) error {
+	var b bytes.Buffer
+	b.WriteString(orDash(r.Request.ClientIP))
+	b.WriteString(" - - [")
+	b.WriteString(r.Time.Format(clfTimeFormat))
+	b.WriteString(`] "`)
+	b.WriteString(escapeQuoted(r.Request.Method + " " + r.Request.URL + " " + r.Request.Proto))
+	b.WriteString(`" `)
+	b.WriteString(strconv.Itoa(r.Response.Code))
+	b.WriteByte(' ')
+	if r.Response.BytesWritten > 0 {
+		b.WriteString(strconv.FormatInt(r.Response.BytesWritten, 10))
+	} else {
+		b.WriteByte('-')
+	}
+	if combined {
+		b.WriteString(` "`)
+		b.WriteString(escapeQuoted(orDash(r.Request.Referer)))
+		b.WriteString(`" "`)
+		b.WriteString(escapeQuoted(orDash(r.Request.UserAgent)))
+		b.WriteByte('"')
+	}
+	b.WriteByte('\n')
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// escapeQuoted escapes the backslashes, the quotes and the control characters of a quoted field
+func escapeQuoted(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case c < ' ' || c == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func logfmtValue(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		s = fmt.Sprint(v)
+	}
+	if strings.ContainsAny(s, " =\"\\") || strings.IndexFunc(s, func(c rune) bool { return c < ' ' }) >= 0 {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,133 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRecord() *Record {
+	return &Record{
+		RequestID: "id-1",
+		Time:      time.Date(2000, time.October, 10, 20, 55, 36, 0, time.UTC),
+		Request: Request{
+			Method:    "GET",
+			URL:       "/apache_pb.gif?q=a b",
+			Proto:     "HTTP/1.0",
+			ClientIP:  "127.0.0.1",
+			UserAgent: `Mozilla/4.08 "test"`,
+			Referer:   "http://www.example.com/start.html",
+		},
+		Response: Response{
+			Code:         200,
+			Roundtrip:    12.5,
+			BodyBytes:    2326,
+			BytesWritten: 2326,
+		},
+		Upstream: &Upstream{
+			Backend:  "http://10.0.0.1:8080",
+			Duration: 10.25,
+			Retries:  1,
+		},
+	}
+}
+
+func TestFormatters(t *testing.T) {
+	logfmt, err := Logfmt()
+	require.NoError(t, err)
+	logfmtFields, err := Logfmt("code", "user_agent", "error_kind")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		desc      string
+		formatter Formatter
+		record    *Record
+		expected  string
+	}{
+		{
+			desc:      "common log",
+			formatter: CommonLog,
+			record:    newTestRecord(),
+			expected:  `127.0.0.1 - - [10/Oct/2000:20:55:36 +0000] "GET /apache_pb.gif?q=a b HTTP/1.0" 200 2326` + "\n",
+		},
+		{
+			desc:      "common log without body",
+			formatter: CommonLog,
+			record:    &Record{Time: time.Date(2000, time.October, 10, 20, 55, 36, 0, time.UTC), Response: Response{Code: 304}},
+			expected:  `- - - [10/Oct/2000:20:55:36 +0000] "  " 304 -` + "\n",
+		},
+		{
+			desc:      "combined log",
+			formatter: CombinedLog,
+			record:    newTestRecord(),
+			expected: `127.0.0.1 - - [10/Oct/2000:20:55:36 +0000] "GET /apache_pb.gif?q=a b HTTP/1.0" 200 2326 ` +
+				`"http://www.example.com/start.html" "Mozilla/4.08 \"test\""` + "\n",
+		},
+		{
+			desc:      "logfmt",
+			formatter: logfmt,
+			record:    newTestRecord(),
+			expected: `time=2000-10-10T20:55:36Z request_id=id-1 client_ip=127.0.0.1 method=GET url="/apache_pb.gif?q=a b" ` +
+				`proto=HTTP/1.0 code=200 bytes_written=2326 duration=12.5 backend=http://10.0.0.1:8080 ` +
+				`upstream_duration=10.25 retries=1 error_kind=` + "\n",
+		},
+		{
+			desc:      "logfmt fields",
+			formatter: logfmtFields,
+			record:    newTestRecord(),
+			expected:  `code=200 user_agent="Mozilla/4.08 \"test\"" error_kind=` + "\n",
+		},
+		{
+			desc:      "logfmt not forwarded",
+			formatter: logfmtFields,
+			record:    &Record{Response: Response{Code: 429, ErrorKind: "rate_limited"}},
+			expected:  `code=429 user_agent= error_kind=rate_limited` + "\n",
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			out := &bytes.Buffer{}
+			require.NoError(t, test.formatter.Format(out, test.record))
+			assert.Equal(t, test.expected, out.String())
+		})
+	}
+}
+
+func TestJSONTemplate(t *testing.T) {
+	f, err := JSONTemplate(map[string]string{
+		"status":  "code",
+		"ip":      "client_ip",
+		"took_ms": "duration",
+		"backend": "backend",
+		"retries": "retries",
+	})
+	require.NoError(t, err)
+
+	out := &bytes.Buffer{}
+	require.NoError(t, f.Format(out, newTestRecord()))
+
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &fields))
+	assert.Equal(t, map[string]interface{}{
+		"status":  float64(200),
+		"ip":      "127.0.0.1",
+		"took_ms": 12.5,
+		"backend": "http://10.0.0.1:8080",
+		"retries": float64(1),
+	}, fields)
+
+	_, err = JSONTemplate(map[string]string{"status": "unknown"})
+	assert.Error(t, err)
+	_, err = JSONTemplate(nil)
+	assert.Error(t, err)
+	_, err = Logfmt("code", "unknown")
+	assert.Error(t, err)
+}
@@ -0,0 +1,149 @@
+package trace
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// redacted replaces the redacted values
+const redacted = "[REDACTED]"
+
+// DefaultRedactedHeaders are the headers always redacted in the captured headers
+var DefaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// redactHeaders replaces the values of the redacted headers
+func (t *Tracer) redactHeaders(h http.Header) http.Header {
+	for name, values := range h {
+		if !t.redactedHeaders[name] {
+			continue
+		}
+		for i := range values {
+			values[i] = redacted
+		}
+	}
+	return h
+}
+
+// redactBody replaces the values of the redacted fields of a JSON or form body
+func (t *Tracer) redactBody(body string, h http.Header) string {
+	if body == "" {
+		return body
+	}
+	mt := mediaType(h)
+	switch {
+	case len(t.redactedJSON) > 0 && (mt == "application/json" || strings.HasSuffix(mt, "+json")):
+		return redactJSON(body, t.redactedJSON)
+	case len(t.redactedForm) > 0 && mt == "application/x-www-form-urlencoded":
+		return redactForm(body, t.redactedForm)
+	}
+	return body
+}
+
+// redactForm replaces the values of the fields in a form body, keeping the order of the fields
+func redactForm(body string, fields map[string]bool) string {
+	pairs := strings.Split(body, "&")
+	for i, pair := range pairs {
+		key := pair
+		if j := strings.IndexByte(pair, '='); j >= 0 {
+			key = pair[:j]
+		}
+		if name, err := url.QueryUnescape(key); err == nil && fields[strings.ToLower(name)] {
+			pairs[i] = key + "=" + url.QueryEscape(redacted)
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// redactJSON replaces the values of the fields of the objects at any depth in a JSON body, keeping its
+// formatting. The body may be truncated, a redacted value cut by the truncation is removed up to the end.
+func redactJSON(body string, fields map[string]bool) string {
+	var b strings.Builder
+	last := 0
+	for i := 0; i < len(body); i++ {
+		if body[i] != '"' {
+			continue
+		}
+		end, ok := skipString(body, i)
+		if !ok {
+			break
+		}
+		key := body[i+1 : end-1]
+		i = end - 1
+
+		// a key is a string followed by a colon
+		colon := skipSpaces(body, end)
+		if colon >= len(body) || body[colon] != ':' || !fields[strings.ToLower(key)] {
+			continue
+		}
+		start := skipSpaces(body, colon+1)
+		if start >= len(body) {
+			break
+		}
+		valueEnd, ok := skipValue(body, start)
+		b.WriteString(body[last:start])
+		b.WriteString(`"` + redacted + `"`)
+		if !ok {
+			return b.String()
+		}
+		last = valueEnd
+		i = valueEnd - 1
+	}
+	b.WriteString(body[last:])
+	return b.String()
+}
+
+// skipString returns the index following the string starting at i, ok is false if the string is not terminated
+func skipString(s string, i int) (int, bool) {
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '"':
+			return j + 1, true
+		}
+	}
+	return len(s), false
+}
+
+// skipValue returns the index following the value starting at i, ok is false if the value is not terminated
+func skipValue(s string, i int) (int, bool) {
+	switch s[i] {
+	case '"':
+		return skipString(s, i)
+	case '{', '[':
+		depth := 0
+		for j := i; j < len(s); j++ {
+			switch s[j] {
+			case '"':
+				end, ok := skipString(s, j)
+				if !ok {
+					return len(s), false
+				}
+				j = end - 1
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return j + 1, true
+				}
+			}
+		}
+		return len(s), false
+	}
+	for j := i; j < len(s); j++ {
+		switch s[j] {
+		case ',', '}', ']', ' ', '\t', '\n', '\r':
+			return j, true
+		}
+	}
+	return len(s), false
+}
+
+func skipSpaces(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r') {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,81 @@
+package trace
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactJSON(t *testing.T) {
+	fields := map[string]bool{"password": true, "token": true, "card": true}
+
+	testCases := []struct {
+		desc     string
+		body     string
+		expected string
+	}{
+		{
+			desc:     "string",
+			body:     `{"user": "bob", "password": "s3cr\"et"}`,
+			expected: `{"user": "bob", "password": "[REDACTED]"}`,
+		},
+		{
+			desc:     "scalars",
+			body:     `{"token":12345,"card":null,"ok":true}`,
+			expected: `{"token":"[REDACTED]","card":"[REDACTED]","ok":true}`,
+		},
+		{
+			desc:     "nested object",
+			body:     `{"payment": {"card": {"number": "4111", "cvc": "123"}, "amount": 10}}`,
+			expected: `{"payment": {"card": "[REDACTED]", "amount": 10}}`,
+		},
+		{
+			desc:     "array of objects",
+			body:     `[{"Token": "a"}, {"TOKEN": ["b", "c"]}]`,
+			expected: `[{"Token": "[REDACTED]"}, {"TOKEN": "[REDACTED]"}]`,
+		},
+		{
+			desc:     "string value looking like a key",
+			body:     `{"note": "password", "text": "\"password\": 1"}`,
+			expected: `{"note": "password", "text": "\"password\": 1"}`,
+		},
+		{
+			desc:     "truncated in the value",
+			body:     `{"user": "bob", "password": "s3cr`,
+			expected: `{"user": "bob", "password": "[REDACTED]"`,
+		},
+		{
+			desc:     "truncated in a nested value",
+			body:     `{"card": {"number": "41`,
+			expected: `{"card": "[REDACTED]"`,
+		},
+		{
+			desc:     "truncated before the value",
+			body:     `{"user": "bob", "password": `,
+			expected: `{"user": "bob", "password": `,
+		},
+		{
+			desc:     "not json",
+			body:     `password=secret`,
+			expected: `password=secret`,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, test.expected, redactJSON(test.body, fields))
+		})
+	}
+}
+
+func TestRedactForm(t *testing.T) {
+	fields := map[string]bool{"password": true, "api_key": true}
+
+	assert.Equal(t, "user=bob&password=%5BREDACTED%5D&Api%5Fkey=%5BREDACTED%5D&a=1",
+		redactForm("user=bob&password=secret&Api%5Fkey=k&a=1", fields))
+	assert.Equal(t, "password=%5BREDACTED%5D&flag", redactForm("password&flag", fields))
+	assert.Equal(t, "user=bob&passw", redactForm("user=bob&passw", fields))
+}
@@ -0,0 +1,325 @@
+package trace
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mailgun/timetools"
+	"github.com/vulcand/oxy/logging"
+)
+
+// backupTimeFormat is the format of the time suffix of the rotated files
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// rotateRetryDelay is the delay before retrying a failed rotation, the records are appended to the current file meanwhile
+const rotateRetryDelay = time.Minute
+
+// RotateOption is a functional option setter for RotatingFile
+type RotateOption func(*RotatingFile) error
+
+// MaxSize rotates the file before it grows over n bytes, it defaults to 0 which disables the size based rotation.
+func MaxSize(n int64) RotateOption {
+	return func(f *RotatingFile) error {
+		if n < 0 {
+			return fmt.Errorf("max size should not be negative, got %v", n)
+		}
+		f.maxSize = n
+		return nil
+	}
+}
+
+// RotateEvery rotates the file when it is older than d, it defaults to 0 which disables the time based rotation.
+func RotateEvery(d time.Duration) RotateOption {
+	return func(f *RotatingFile) error {
+		if d < 0 {
+			return fmt.Errorf("rotation period should not be negative, got %v", d)
+		}
+		f.every = d
+		return nil
+	}
+}
+
+// MaxBackups sets the number of rotated files kept, the oldest are removed.
+// It defaults to 0 which keeps all the rotated files.
+func MaxBackups(n int) RotateOption {
+	return func(f *RotatingFile) error {
+		if n < 0 {
+			return fmt.Errorf("max backups should not be negative, got %v", n)
+		}
+		f.maxBackups = n
+		return nil
+	}
+}
+
+// ReopenOn reopens the file when the process receives one of the signals, e.g. syscall.SIGHUP
+// sent by logrotate after it moved the file.
+func ReopenOn(sigs ...os.Signal) RotateOption {
+	return func(f *RotatingFile) error {
+		if len(sigs) == 0 {
+			return fmt.Errorf("at least one signal is required")
+		}
+		f.signals = sigs
+		return nil
+	}
+}
+
+// RotateClock sets the clock of the time based rotation and of the names of the rotated files
+func RotateClock(clock timetools.TimeProvider) RotateOption {
+	return func(f *RotatingFile) error {
+		f.clock = clock
+		return nil
+	}
+}
+
+// RotateLogger defines the logger reporting the failed rotations.
+//
+// It defaults to logging.Default().
+func RotateLogger(l logging.Logger) RotateOption {
+	return func(f *RotatingFile) error {
+		f.log = l
+		return nil
+	}
+}
+
+// RotatingFile is an io.Writer appending to a file and rotating it by size or by age, the rotated files
+// are renamed with a time suffix, e.g. access.log.2006-01-02T15-04-05.000
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	every      time.Duration
+	maxBackups int
+	signals    []os.Signal
+	clock      timetools.TimeProvider
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	// closed is set by Close, file is also nil after a failed rotation or reopening
+	closed bool
+	// retryAt delays the next rotation after a failed one
+	retryAt time.Time
+
+	sigCh    chan os.Signal
+	done     chan struct{}
+	stopOnce sync.Once
+
+	log logging.Logger
+}
+
+// NewRotatingFile opens the file at path for appending, creating it if needed
+func NewRotatingFile(path string, opts ...RotateOption) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:  path,
+		clock: &timetools.RealTime{},
+		log:   logging.Default(),
+	}
+	for _, o := range opts {
+		if err := o(f); err != nil {
+			return nil, err
+		}
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	if len(f.signals) > 0 {
+		f.sigCh = make(chan os.Signal, 1)
+		f.done = make(chan struct{})
+		signal.Notify(f.sigCh, f.signals...)
+		go f.handleSignals(f.sigCh, f.done)
+	}
+	return f, nil
+}
+
+// Write writes p to the file, rotating it first if needed.
+// A failed rotation is reported to the logger and p is written to the file currently open.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.shouldRotate(int64(len(p))) {
+		if err := f.rotate(); err != nil {
+			f.retryAt = f.clock.UtcNow().Add(rotateRetryDelay)
+			logging.Error(f.log, "vulcand/oxy/trace: failed to rotate the file",
+				logging.String("path", f.path), logging.Err(err))
+			if f.file == nil {
+				return 0, err
+			}
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the file now
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return os.ErrClosed
+	}
+	if f.file == nil {
+		return f.open()
+	}
+	return f.rotate()
+}
+
+// Reopen closes and reopens the file at path, e.g. after it was moved by an external tool
+func (f *RotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return os.ErrClosed
+	}
+	if f.file != nil {
+		if err := f.file.Close(); err != nil {
+			return err
+		}
+		f.file = nil
+	}
+	return f.open()
+}
+
+// Close stops the signal handling and closes the file
+func (f *RotatingFile) Close() error {
+	if f.sigCh != nil {
+		f.stopOnce.Do(func() {
+			signal.Stop(f.sigCh)
+			close(f.done)
+		})
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) handleSignals(sigCh <-chan os.Signal, done <-chan struct{}) {
+	for {
+		select {
+		case <-sigCh:
+			f.Reopen()
+		case <-done:
+			return
+		}
+	}
+}
+
+func (f *RotatingFile) shouldRotate(n int64) bool {
+	if f.clock.UtcNow().Before(f.retryAt) {
+		return false
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+n > f.maxSize {
+		return true
+	}
+	return f.every > 0 && f.clock.UtcNow().Sub(f.openedAt) >= f.every
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = f.clock.UtcNow()
+	return nil
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	stamp := f.clock.UtcNow().Format(backupTimeFormat)
+	backup := f.path + "." + stamp
+	for i := 1; fileExists(backup); i++ {
+		backup = fmt.Sprintf("%v.%v.%v", f.path, stamp, i)
+	}
+	// a file removed by an external tool has nothing to back up, the writer still needs a file
+	if err := os.Rename(f.path, backup); err != nil && !os.IsNotExist(err) {
+		if openErr := f.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	return f.removeBackups()
+}
+
+// removeBackups removes the oldest rotated files over maxBackups
+func (f *RotatingFile) removeBackups() error {
+	if f.maxBackups == 0 {
+		return nil
+	}
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, m := range matches {
+		if _, err := time.Parse(backupTimeFormat, backupTime(f.path, m)); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	if len(backups) <= f.maxBackups {
+		return nil
+	}
+	// the time suffixes sort in chronological order
+	sort.Strings(backups)
+	for _, b := range backups[:len(backups)-f.maxBackups] {
+		if err := os.Remove(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backupTime returns the time suffix of a rotated file, without the counter of the files rotated in the same millisecond
+func backupTime(path, backup string) string {
+	suffix := strings.TrimPrefix(backup, path+".")
+	if len(suffix) > len(backupTimeFormat) {
+		suffix = suffix[:len(backupTimeFormat)]
+	}
+	return suffix
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,205 @@
+package trace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/logging"
+	"github.com/vulcand/oxy/testutils"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestRotatingFileSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oxy-trace")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "access.log")
+	clock := testutils.GetClock()
+	f, err := NewRotatingFile(path, MaxSize(10), RotateClock(clock))
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = f.Write([]byte("first\n"))
+	require.NoError(t, err)
+	_, err = f.Write([]byte("second\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "second\n", readFile(t, path))
+	assert.Equal(t, "first\n", readFile(t, path+".2012-03-04T05-06-07.000"))
+
+	// the files rotated in the same millisecond are not overwritten
+	_, err = f.Write([]byte("third\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "second\n", readFile(t, path+".2012-03-04T05-06-07.000.1"))
+}
+
+func TestRotatingFileSizeRemoved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oxy-trace")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "access.log")
+	clock := testutils.GetClock()
+	f, err := NewRotatingFile(path, MaxSize(10), RotateClock(clock))
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = f.Write([]byte("first\n"))
+	require.NoError(t, err)
+
+	// the file is removed by an external tool before the rotation
+	require.NoError(t, os.Remove(path))
+
+	_, err = f.Write([]byte("second\n"))
+	require.NoError(t, err)
+	_, err = f.Write([]byte("third\n"))
+	require.NoError(t, err)
+
+	// the writer survived the rotation of the removed file and keeps rotating
+	assert.Equal(t, "third\n", readFile(t, path))
+	assert.Equal(t, "second\n", readFile(t, path+".2012-03-04T05-06-07.000"))
+}
+
+func TestRotatingFileRenameFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oxy-trace")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// the name of the rotated file is too long, the rename fails even for root
+	path := filepath.Join(dir, strings.Repeat("a", 240))
+	clock := testutils.GetClock()
+	out := &bytes.Buffer{}
+	f, err := NewRotatingFile(path, MaxSize(10), RotateClock(clock), RotateLogger(logging.Std(log.New(out, "", 0), logging.InfoLevel)))
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = f.Write([]byte("first\n"))
+	require.NoError(t, err)
+	n, err := f.Write([]byte("second\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 7, n)
+	assert.Contains(t, out.String(), "failed to rotate the file")
+
+	// the records are appended to the current file until the rotation is retried
+	out.Reset()
+	_, err = f.Write([]byte("third\n"))
+	require.NoError(t, err)
+	assert.Empty(t, out.String())
+	assert.Equal(t, "first\nsecond\nthird\n", readFile(t, path))
+
+	clock.CurrentTime = clock.CurrentTime.Add(rotateRetryDelay)
+	_, err = f.Write([]byte("fourth\n"))
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "failed to rotate the file")
+	assert.Equal(t, "first\nsecond\nthird\nfourth\n", readFile(t, path))
+}
+
+func TestRotatingFileCleanupFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oxy-trace")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "access.log")
+	// an old backup that can not be removed
+	old := path + ".2000-01-01T00-00-00.000"
+	require.NoError(t, os.Mkdir(old, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(old, "file"), nil, 0644))
+
+	clock := testutils.GetClock()
+	out := &bytes.Buffer{}
+	f, err := NewRotatingFile(path, MaxSize(10), MaxBackups(1), RotateClock(clock), RotateLogger(logging.Std(log.New(out, "", 0), logging.InfoLevel)))
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = f.Write([]byte("first\n"))
+	require.NoError(t, err)
+	_, err = f.Write([]byte("second\n"))
+	require.NoError(t, err)
+
+	assert.Contains(t, out.String(), "failed to rotate the file")
+	assert.Equal(t, "second\n", readFile(t, path))
+	assert.Equal(t, "first\n", readFile(t, path+".2012-03-04T05-06-07.000"))
+}
+
+func TestRotatingFileAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oxy-trace")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "access.log")
+	clock := testutils.GetClock()
+	f, err := NewRotatingFile(path, RotateEvery(time.Hour), MaxBackups(2), RotateClock(clock))
+	require.NoError(t, err)
+	defer f.Close()
+
+	for _, line := range []string{"a\n", "b\n", "c\n", "d\n"} {
+		_, err = f.Write([]byte(line))
+		require.NoError(t, err)
+		clock.CurrentTime = clock.CurrentTime.Add(time.Hour)
+	}
+
+	// the oldest backup was removed
+	backups, err := filepath.Glob(path + ".*")
+	require.NoError(t, err)
+	assert.Equal(t, []string{path + ".2012-03-04T07-06-07.000", path + ".2012-03-04T08-06-07.000"}, backups)
+	assert.Equal(t, "b\n", readFile(t, backups[0]))
+	assert.Equal(t, "c\n", readFile(t, backups[1]))
+	assert.Equal(t, "d\n", readFile(t, path))
+}
+
+func TestRotatingFileReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oxy-trace")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "access.log")
+	f, err := NewRotatingFile(path)
+	require.NoError(t, err)
+
+	_, err = f.Write([]byte("before\n"))
+	require.NoError(t, err)
+
+	// the file is moved by an external tool
+	require.NoError(t, os.Rename(path, path+".1"))
+	require.NoError(t, f.Reopen())
+
+	_, err = f.Write([]byte("after\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "before\n", readFile(t, path+".1"))
+	assert.Equal(t, "after\n", readFile(t, path))
+
+	require.NoError(t, f.Close())
+	_, err = f.Write([]byte("closed\n"))
+	assert.Error(t, err)
+	assert.Error(t, f.Reopen())
+}
+
+func TestRotatingFileOptions(t *testing.T) {
+	_, err := NewRotatingFile("access.log", MaxSize(-1))
+	assert.Error(t, err)
+	_, err = NewRotatingFile("access.log", RotateEvery(-time.Second))
+	assert.Error(t, err)
+	_, err = NewRotatingFile("access.log", MaxBackups(-1))
+	assert.Error(t, err)
+	_, err = NewRotatingFile("access.log", ReopenOn())
+	assert.Error(t, err)
+	_, err = NewRotatingFile(filepath.Join("does", "not", "exist", "access.log"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,40 @@
+//go:build !windows
+// +build !windows
+
+package trace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFileReopenOnSignal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oxy-trace")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "access.log")
+	f, err := NewRotatingFile(path, ReopenOn(syscall.SIGHUP))
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = f.Write([]byte("before\n"))
+	require.NoError(t, err)
+
+	require.NoError(t, os.Rename(path, path+".1"))
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+
+	// the file is created again when it is reopened
+	assert.Eventually(t, func() bool { return fileExists(path) }, time.Second, 10*time.Millisecond)
+
+	_, err = f.Write([]byte("after\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "after\n", readFile(t, path))
+}
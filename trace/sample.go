@@ -0,0 +1,106 @@
+package trace
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// Sampler decides which records are written
+type Sampler interface {
+	Sample(r *Record) bool
+}
+
+// SamplerOption is a functional option setter for RateSampler
+type SamplerOption func(*RateSampler) error
+
+// SuccessRate sets the ratio of the records written for the codes below 400, it defaults to 1.
+func SuccessRate(rate float64) SamplerOption {
+	return func(s *RateSampler) error {
+		return setRate(&s.success, rate)
+	}
+}
+
+// ClientErrorRate sets the ratio of the records written for the 4xx codes, it defaults to 1.
+func ClientErrorRate(rate float64) SamplerOption {
+	return func(s *RateSampler) error {
+		return setRate(&s.clientErrors, rate)
+	}
+}
+
+// ErrorRate sets the ratio of the records written for the 5xx codes and the failed upstream requests,
+// it defaults to 1.
+func ErrorRate(rate float64) SamplerOption {
+	return func(s *RateSampler) error {
+		return setRate(&s.errors, rate)
+	}
+}
+
+// SlowThreshold writes the records of the requests taking at least d whatever their code,
+// it defaults to 0 which disables the threshold.
+func SlowThreshold(d time.Duration) SamplerOption {
+	return func(s *RateSampler) error {
+		s.slow = d
+		return nil
+	}
+}
+
+func setRate(dst *float64, rate float64) error {
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("rate should be between 0 and 1, got %v", rate)
+	}
+	*dst = rate
+	return nil
+}
+
+// RateSampler samples the records by class of response code, e.g. to write all the errors,
+// 1% of the successful requests and all the slow requests:
+//
+//	trace.NewSampler(trace.SuccessRate(0.01), trace.SlowThreshold(time.Second))
+type RateSampler struct {
+	success      float64
+	clientErrors float64
+	errors       float64
+	slow         time.Duration
+
+	random func() float64
+}
+
+// NewSampler returns a new RateSampler, it writes all the records by default
+func NewSampler(opts ...SamplerOption) (*RateSampler, error) {
+	s := &RateSampler{
+		success:      1,
+		clientErrors: 1,
+		errors:       1,
+		random:       rand.Float64,
+	}
+	for _, o := range opts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Sample tells if the record is written
+func (s *RateSampler) Sample(r *Record) bool {
+	if s.slow > 0 && time.Duration(r.Response.Roundtrip*float64(time.Millisecond)) >= s.slow {
+		return true
+	}
+
+	rate := s.success
+	switch {
+	case r.Response.Code >= http.StatusInternalServerError || r.Response.Error != "":
+		rate = s.errors
+	case r.Response.Code >= http.StatusBadRequest:
+		rate = s.clientErrors
+	}
+	switch rate {
+	case 0:
+		return false
+	case 1:
+		return true
+	}
+	return s.random() < rate
+}
@@ -0,0 +1,96 @@
+package trace
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateSampler(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		opts     []SamplerOption
+		random   float64
+		record   Record
+		expected bool
+	}{
+		{
+			desc:     "all by default",
+			record:   Record{Response: Response{Code: 200}},
+			expected: true,
+		},
+		{
+			desc:     "success sampled in",
+			opts:     []SamplerOption{SuccessRate(0.01)},
+			random:   0.005,
+			record:   Record{Response: Response{Code: 200}},
+			expected: true,
+		},
+		{
+			desc:     "success sampled out",
+			opts:     []SamplerOption{SuccessRate(0.01)},
+			random:   0.5,
+			record:   Record{Response: Response{Code: 304}},
+			expected: false,
+		},
+		{
+			desc:     "errors always written",
+			opts:     []SamplerOption{SuccessRate(0.01)},
+			random:   0.5,
+			record:   Record{Response: Response{Code: 502}},
+			expected: true,
+		},
+		{
+			desc:     "failed request is an error",
+			opts:     []SamplerOption{SuccessRate(0), ClientErrorRate(0)},
+			record:   Record{Response: Response{Code: 499, Error: "context canceled"}},
+			expected: true,
+		},
+		{
+			desc:     "client errors",
+			opts:     []SamplerOption{ClientErrorRate(0)},
+			record:   Record{Response: Response{Code: 404}},
+			expected: false,
+		},
+		{
+			desc:     "server errors",
+			opts:     []SamplerOption{ErrorRate(0)},
+			record:   Record{Response: Response{Code: 500}},
+			expected: false,
+		},
+		{
+			desc:     "slow request always written",
+			opts:     []SamplerOption{SuccessRate(0), SlowThreshold(time.Second)},
+			record:   Record{Response: Response{Code: 200, Roundtrip: 1500}},
+			expected: true,
+		},
+		{
+			desc:     "fast request sampled",
+			opts:     []SamplerOption{SuccessRate(0), SlowThreshold(time.Second)},
+			record:   Record{Response: Response{Code: 200, Roundtrip: 500}},
+			expected: false,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := NewSampler(test.opts...)
+			require.NoError(t, err)
+			s.random = func() float64 { return test.random }
+
+			assert.Equal(t, test.expected, s.Sample(&test.record))
+		})
+	}
+}
+
+func TestRateSamplerInvalidRate(t *testing.T) {
+	_, err := NewSampler(SuccessRate(1.5))
+	assert.Error(t, err)
+	_, err = NewSampler(ErrorRate(-1))
+	assert.Error(t, err)
+}
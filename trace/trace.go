@@ -3,14 +3,15 @@ package trace
 
 import (
 	"crypto/tls"
-	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/vulcand/oxy/logging"
 	"github.com/vulcand/oxy/utils"
 )
 
@@ -41,15 +42,93 @@ func ResponseHeaders(headers ...string) Option {
 	}
 }
 
-// Tracer records request and response emitting JSON structured data to the output
+// Format sets the format of the records, it defaults to JSON.
+func Format(f Formatter) Option {
+	return func(t *Tracer) error {
+		if f == nil {
+			return fmt.Errorf("formatter should not be nil")
+		}
+		t.formatter = f
+		return nil
+	}
+}
+
+// Sample sets the sampler deciding which records are written, all the records are written by default.
+func Sample(s Sampler) Option {
+	return func(t *Tracer) error {
+		t.sampler = s
+		return nil
+	}
+}
+
+// CaptureBodies captures the first limit bytes of the request and response bodies with one of the content types,
+// DefaultCaptureContentTypes are captured when no content type is given. The request body is captured as it is
+// read by the next handler and the encoded response bodies, e.g. gzip, are not captured.
+func CaptureBodies(limit int, contentTypes ...string) Option {
+	return func(t *Tracer) error {
+		if limit <= 0 {
+			return fmt.Errorf("capture limit should be positive, got %v", limit)
+		}
+		if len(contentTypes) == 0 {
+			contentTypes = DefaultCaptureContentTypes
+		}
+		t.captureLimit = limit
+		t.captureTypes = nil
+		for _, ct := range contentTypes {
+			t.captureTypes = append(t.captureTypes, strings.ToLower(ct))
+		}
+		return nil
+	}
+}
+
+// RedactHeaders redacts the values of the captured headers, in addition to DefaultRedactedHeaders
+func RedactHeaders(names ...string) Option {
+	return func(t *Tracer) error {
+		for _, name := range names {
+			t.redactedHeaders[http.CanonicalHeaderKey(name)] = true
+		}
+		return nil
+	}
+}
+
+// RedactJSONFields redacts the values of the fields at any depth of the captured JSON bodies,
+// the names are case insensitive
+func RedactJSONFields(names ...string) Option {
+	return func(t *Tracer) error {
+		for _, name := range names {
+			t.redactedJSON[strings.ToLower(name)] = true
+		}
+		return nil
+	}
+}
+
+// RedactFormFields redacts the values of the fields of the captured form bodies, the names are case insensitive
+func RedactFormFields(names ...string) Option {
+	return func(t *Tracer) error {
+		for _, name := range names {
+			t.redactedForm[strings.ToLower(name)] = true
+		}
+		return nil
+	}
+}
+
+// Tracer records request and response emitting structured data to the output
 type Tracer struct {
 	errHandler  utils.ErrorHandler
 	next        http.Handler
 	reqHeaders  []string
 	respHeaders []string
 	writer      io.Writer
+	formatter   Formatter
+	sampler     Sampler
 
-	log *log.Logger
+	captureLimit    int
+	captureTypes    []string
+	redactedHeaders map[string]bool
+	redactedJSON    map[string]bool
+	redactedForm    map[string]bool
+
+	log logging.Logger
 }
 
 // New creates a new Tracer middleware that emits all the request/response information in structured format
@@ -57,10 +136,18 @@ type Tracer struct {
 // see RequestHeaders and ResponseHeaders options for details.
 func New(next http.Handler, writer io.Writer, opts ...Option) (*Tracer, error) {
 	t := &Tracer{
-		writer: writer,
-		next:   next,
+		writer:    writer,
+		next:      next,
+		formatter: JSON,
+
+		redactedHeaders: make(map[string]bool),
+		redactedJSON:    make(map[string]bool),
+		redactedForm:    make(map[string]bool),
 
-		log: log.StandardLogger(),
+		log: logging.Default(),
+	}
+	for _, name := range DefaultRedactedHeaders {
+		t.redactedHeaders[name] = true
 	}
 	for _, o := range opts {
 		if err := o(t); err != nil {
@@ -75,8 +162,8 @@ func New(next http.Handler, writer io.Writer, opts ...Option) (*Tracer, error) {
 
 // Logger defines the logger the tracer will use.
 //
-// It defaults to logrus.StandardLogger(), the global logger used by logrus.
-func Logger(l *log.Logger) Option {
+// It defaults to logging.Default().
+func Logger(l logging.Logger) Option {
 	return func(t *Tracer) error {
 		t.log = l
 		return nil
@@ -85,33 +172,89 @@ func Logger(l *log.Logger) Option {
 
 func (t *Tracer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	start := time.Now()
+	ctx, errRecord := utils.ContextWithErrorRecord(req.Context())
+	ctx, upstream := utils.ContextWithUpstreamRecord(ctx)
+	outReq := req.WithContext(ctx)
+
+	var reqBody, respBody *bodyCapture
+	if t.captureLimit > 0 {
+		if req.Body != nil && req.Body != http.NoBody && t.capturable(req.Header) {
+			reqBody = &bodyCapture{limit: t.captureLimit}
+			outReq.Body = &captureReader{ReadCloser: req.Body, capture: reqBody}
+		}
+		respBody = &bodyCapture{limit: t.captureLimit}
+		w = &captureWriter{ResponseWriter: w, tracer: t, capture: respBody}
+	}
+
 	pw := utils.NewProxyWriterWithLogger(w, t.log)
-	t.next.ServeHTTP(pw, req)
+	t.next.ServeHTTP(pw, outReq)
 
-	l := t.newRecord(req, pw, time.Since(start))
-	if err := json.NewEncoder(t.writer).Encode(l); err != nil {
-		t.log.Errorf("Failed to marshal request: %v", err)
+	l := t.newRecord(req, pw, start, time.Since(start))
+	body, truncated := reqBody.body()
+	l.Request.Body, l.Request.BodyTruncated = t.redactBody(body, req.Header), truncated
+	body, truncated = respBody.body()
+	l.Response.Body, l.Response.BodyTruncated = t.redactBody(body, pw.Header()), truncated
+	if ue := errRecord.Get(); ue != nil {
+		l.Response.Error = ue.Error()
+		l.Response.ErrorKind = string(ue.Kind)
+	}
+	if backend := upstream.Backend(); backend != "" {
+		l.Upstream = &Upstream{
+			Backend:  backend,
+			Duration: float64(upstream.Duration()) / float64(time.Millisecond),
+			Retries:  upstream.Retries(),
+		}
+	}
+	if t.sampler != nil && !t.sampler.Sample(l) {
+		return
+	}
+	if err := t.formatter.Format(t.writer, l); err != nil {
+		logging.Error(utils.RequestLogger(t.log, req), "Failed to marshal request", logging.Err(err))
 	}
 }
 
-func (t *Tracer) newRecord(req *http.Request, pw *utils.ProxyWriter, diff time.Duration) *Record {
+func (t *Tracer) newRecord(req *http.Request, pw *utils.ProxyWriter, start time.Time, diff time.Duration) *Record {
 	return &Record{
+		RequestID: requestID(req, pw.Header()),
+		Time:      start.UTC(),
 		Request: Request{
 			Method:    req.Method,
 			URL:       req.URL.String(),
+			Proto:     req.Proto,
+			ClientIP:  clientIP(req),
+			UserAgent: req.UserAgent(),
+			Referer:   req.Referer(),
 			TLS:       newTLS(req),
 			BodyBytes: bodyBytes(req.Header),
-			Headers:   captureHeaders(req.Header, t.reqHeaders),
+			Headers:   t.redactHeaders(captureHeaders(req.Header, t.reqHeaders)),
 		},
 		Response: Response{
-			Code:      pw.StatusCode(),
-			BodyBytes: bodyBytes(pw.Header()),
-			Roundtrip: float64(diff) / float64(time.Millisecond),
-			Headers:   captureHeaders(pw.Header(), t.respHeaders),
+			Code:         pw.StatusCode(),
+			BodyBytes:    responseBodyBytes(pw),
+			BytesWritten: pw.GetLength(),
+			Roundtrip:    float64(diff) / float64(time.Millisecond),
+			Headers:      t.redactHeaders(captureHeaders(pw.Header(), t.respHeaders)),
 		},
 	}
 }
 
+// clientIP returns the IP address of the client connection
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// requestID returns the ID of the request, the response header holds it when it was set by an inner middleware
+func requestID(req *http.Request, respHeader http.Header) string {
+	if id := utils.RequestID(req); id != "" {
+		return id
+	}
+	return respHeader.Get(utils.RequestIDHeader)
+}
+
 func newTLS(req *http.Request) *TLS {
 	if req.TLS == nil {
 		return nil
@@ -143,25 +286,48 @@ func captureHeaders(in http.Header, headers []string) http.Header {
 
 // Record represents a structured request and response record
 type Record struct {
-	Request  Request  `json:"request"`
-	Response Response `json:"response"`
+	RequestID string    `json:"request_id,omitempty"` // RequestID - optional ID of the request, see the requestid package
+	Time      time.Time `json:"time"`                 // Time - time the request was received
+	Request   Request   `json:"request"`
+	Response  Response  `json:"response"`
+	Upstream  *Upstream `json:"upstream,omitempty"` // Upstream - optional upstream record, will be recorded if the request was forwarded
 }
 
 // Request contains information about an HTTP request
 type Request struct {
-	Method    string      `json:"method"`            // Method - request method
-	BodyBytes int64       `json:"body_bytes"`        // BodyBytes - size of request body in bytes
-	URL       string      `json:"url"`               // URL - Request URL
-	Headers   http.Header `json:"headers,omitempty"` // Headers - optional request headers, will be recorded if configured
-	TLS       *TLS        `json:"tls,omitempty"`     // TLS - optional TLS record, will be recorded if it's a TLS connection
+	Method    string      `json:"method"`               // Method - request method
+	BodyBytes int64       `json:"body_bytes"`           // BodyBytes - size of request body in bytes
+	URL       string      `json:"url"`                  // URL - Request URL
+	Proto     string      `json:"proto,omitempty"`      // Proto - protocol of the request, e.g. HTTP/1.1
+	ClientIP  string      `json:"client_ip,omitempty"`  // ClientIP - IP address of the client connection
+	UserAgent string      `json:"user_agent,omitempty"` // UserAgent - optional User-Agent header
+	Referer   string      `json:"referer,omitempty"`    // Referer - optional Referer header
+	Headers   http.Header `json:"headers,omitempty"`    // Headers - optional request headers, will be recorded if configured
+	TLS       *TLS        `json:"tls,omitempty"`        // TLS - optional TLS record, will be recorded if it's a TLS connection
+
+	Body          string `json:"body,omitempty"`           // Body - optional redacted start of the body, will be recorded if configured
+	BodyTruncated bool   `json:"body_truncated,omitempty"` // BodyTruncated tells if the captured body is cut at the capture limit
 }
 
 // Response contains information about HTTP response
 type Response struct {
-	Code      int         `json:"code"`              // Code - response status code
-	Roundtrip float64     `json:"roundtrip"`         // Roundtrip - round trip time in milliseconds
-	Headers   http.Header `json:"headers,omitempty"` // Headers - optional headers, will be recorded if configured
-	BodyBytes int64       `json:"body_bytes"`        // BodyBytes - size of response body in bytes
+	Code         int         `json:"code"`                 // Code - response status code
+	Roundtrip    float64     `json:"roundtrip"`            // Roundtrip - total time in milliseconds, see Upstream.Duration for the time spent upstream
+	Headers      http.Header `json:"headers,omitempty"`    // Headers - optional headers, will be recorded if configured
+	BodyBytes    int64       `json:"body_bytes"`           // BodyBytes - size of response body in bytes
+	BytesWritten int64       `json:"bytes_written"`        // BytesWritten - bytes actually written to the client
+	Error        string      `json:"error,omitempty"`      // Error - optional error that caused the request to fail
+	ErrorKind    string      `json:"error_kind,omitempty"` // ErrorKind - optional classification of the error, e.g. connection_refused
+
+	Body          string `json:"body,omitempty"`           // Body - optional redacted start of the body, will be recorded if configured
+	BodyTruncated bool   `json:"body_truncated,omitempty"` // BodyTruncated tells if the captured body is cut at the capture limit
+}
+
+// Upstream contains information about the round trips to the backends
+type Upstream struct {
+	Backend  string  `json:"backend"`  // Backend - URL of the backend of the last round trip
+	Duration float64 `json:"duration"` // Duration - time spent in the round trips in milliseconds
+	Retries  int     `json:"retries"`  // Retries - number of retries of the request
 }
 
 // TLS contains information about this TLS connection
@@ -218,6 +384,15 @@ func csToString(cs uint16) string {
 	return fmt.Sprintf("unknown: %x", cs)
 }
 
+// responseBodyBytes returns the size of the response body, the bytes written are counted when the length
+// is not known in advance, e.g. for compressed or chunked responses
+func responseBodyBytes(pw *utils.ProxyWriter) int64 {
+	if length, err := strconv.ParseInt(pw.Header().Get("Content-Length"), 10, 0); err == nil {
+		return length
+	}
+	return pw.GetLength()
+}
+
 func bodyBytes(h http.Header) int64 {
 	length := h.Get("Content-Length")
 	if length == "" {
@@ -6,13 +6,20 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/buffer"
+	"github.com/vulcand/oxy/compress"
+	"github.com/vulcand/oxy/forward"
+	"github.com/vulcand/oxy/roundrobin"
 	"github.com/vulcand/oxy/testutils"
 	"github.com/vulcand/oxy/utils"
 )
@@ -45,6 +52,32 @@ func TestTraceSimple(t *testing.T) {
 	assert.EqualValues(t, 5, r.Response.BodyBytes)
 }
 
+func TestTraceCompressedBodyBytes(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(strings.Repeat("hello", 1000)))
+	})
+
+	compressor, err := compress.New(handler, compress.Encodings(compress.Gzip))
+	require.NoError(t, err)
+
+	trace := &bytes.Buffer{}
+	tr, err := New(compressor, trace)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(tr)
+	defer srv.Close()
+
+	re, body, err := testutils.Get(srv.URL, testutils.Header("Accept-Encoding", "gzip"))
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", re.Header.Get("Content-Encoding"))
+
+	var r *Record
+	require.NoError(t, json.Unmarshal(trace.Bytes(), &r))
+
+	// the size of the compressed body sent to the client is recorded
+	assert.EqualValues(t, len(body), r.Response.BodyBytes)
+}
+
 func TestTraceCaptureHeaders(t *testing.T) {
 	respHeaders := http.Header{
 		"X-Re-1": []string{"6", "7"},
@@ -109,3 +142,340 @@ func TestTraceTLS(t *testing.T) {
 	require.NoError(t, json.Unmarshal(trace.Bytes(), &r))
 	assert.Equal(t, versionToString(state.Version), r.Request.TLS.Version)
 }
+
+func TestTraceErrorKind(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		utils.DefaultHandler.ServeHTTP(w, req, &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "backend"}})
+	})
+
+	trace := &bytes.Buffer{}
+	tr, err := New(handler, trace)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(tr)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, re.StatusCode)
+
+	var r *Record
+	require.NoError(t, json.Unmarshal(trace.Bytes(), &r))
+	assert.Equal(t, http.StatusBadGateway, r.Response.Code)
+	assert.Equal(t, "dns_failure", r.Response.ErrorKind)
+	assert.Contains(t, r.Response.Error, "no such host")
+}
+
+func TestTraceRequestID(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set(utils.RequestIDHeader, "from-response")
+		w.Write([]byte("hello"))
+	})
+
+	trace := &bytes.Buffer{}
+	tr, err := New(handler, trace)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		desc     string
+		ctxID    string
+		expected string
+	}{
+		{
+			desc:     "from the context",
+			ctxID:    "from-context",
+			expected: "from-context",
+		},
+		{
+			desc:     "from the response header",
+			expected: "from-response",
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			trace.Reset()
+
+			req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+			if test.ctxID != "" {
+				req = req.WithContext(utils.ContextWithRequestID(req.Context(), test.ctxID))
+			}
+			tr.ServeHTTP(httptest.NewRecorder(), req)
+
+			var r *Record
+			require.NoError(t, json.Unmarshal(trace.Bytes(), &r))
+			assert.Equal(t, test.expected, r.RequestID)
+		})
+	}
+}
+
+func TestTraceClientFields(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("hello"))
+	})
+
+	trace := &bytes.Buffer{}
+	tr, err := New(handler, trace)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(tr)
+	defer srv.Close()
+
+	_, _, err = testutils.Get(srv.URL+"/hello",
+		testutils.Header("User-Agent", "oxy-test"), testutils.Header("Referer", "http://example.com"))
+	require.Error(t, err)
+
+	var r *Record
+	require.NoError(t, json.Unmarshal(trace.Bytes(), &r))
+
+	assert.Equal(t, "127.0.0.1", r.Request.ClientIP)
+	assert.Equal(t, "oxy-test", r.Request.UserAgent)
+	assert.Equal(t, "http://example.com", r.Request.Referer)
+	assert.Equal(t, "HTTP/1.1", r.Request.Proto)
+	assert.False(t, r.Time.IsZero())
+	assert.Nil(t, r.Upstream)
+
+	// the announced length differs from the bytes actually written
+	assert.EqualValues(t, 100, r.Response.BodyBytes)
+	assert.EqualValues(t, 5, r.Response.BytesWritten)
+}
+
+func TestTraceUpstream(t *testing.T) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	fwd, err := forward.New()
+	require.NoError(t, err)
+
+	lb, err := roundrobin.New(fwd)
+	require.NoError(t, err)
+	require.NoError(t, lb.UpsertServer(testutils.ParseURI("http://localhost:64321")))
+	require.NoError(t, lb.UpsertServer(testutils.ParseURI(srv.URL)))
+
+	st, err := buffer.New(lb, buffer.Retry(`IsNetworkError() && Attempts() <= 2`))
+	require.NoError(t, err)
+
+	trace := &bytes.Buffer{}
+	tr, err := New(st, trace)
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(tr)
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+
+	var r *Record
+	require.NoError(t, json.Unmarshal(trace.Bytes(), &r))
+
+	require.NotNil(t, r.Upstream)
+	assert.Equal(t, srv.URL, r.Upstream.Backend)
+	assert.Equal(t, 1, r.Upstream.Retries)
+	assert.NotZero(t, r.Upstream.Duration)
+	assert.True(t, r.Upstream.Duration <= r.Response.Roundtrip)
+}
+
+func TestTraceFormat(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	trace := &bytes.Buffer{}
+	tr, err := New(handler, trace, Format(CommonLog))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(tr)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL + "/hello")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+
+	assert.Regexp(t, `^127\.0\.0\.1 - - \[[^\]]+\] "GET /hello HTTP/1\.1" 200 5\n$`, trace.String())
+
+	_, err = New(handler, trace, Format(nil))
+	assert.Error(t, err)
+}
+
+func TestTraceSample(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/error" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("hello"))
+	})
+
+	sampler, err := NewSampler(SuccessRate(0))
+	require.NoError(t, err)
+
+	trace := &bytes.Buffer{}
+	tr, err := New(handler, trace, Sample(sampler))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(tr)
+	defer srv.Close()
+
+	for _, path := range []string{"/hello", "/error", "/hello"} {
+		_, _, err = testutils.Get(srv.URL + path)
+		require.NoError(t, err)
+	}
+
+	var r *Record
+	require.NoError(t, json.Unmarshal(trace.Bytes(), &r))
+	assert.Equal(t, "/error", r.Request.URL)
+}
+
+func TestTraceCaptureBodies(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.Equal(t, `{"user": "bob", "password": "secret"}`, string(body))
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(`{"token": "abcdef", "expires": 3600, "scope": "read write"}`))
+	})
+
+	trace := &bytes.Buffer{}
+	tr, err := New(handler, trace, CaptureBodies(40), RedactJSONFields("password", "token"))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(tr)
+	defer srv.Close()
+
+	re, _, err := testutils.Post(srv.URL,
+		testutils.Header("Content-Type", "application/json"),
+		testutils.Body(`{"user": "bob", "password": "secret"}`))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+
+	var r *Record
+	require.NoError(t, json.Unmarshal(trace.Bytes(), &r))
+
+	assert.Equal(t, `{"user": "bob", "password": "[REDACTED]"}`, r.Request.Body)
+	assert.False(t, r.Request.BodyTruncated)
+	assert.Equal(t, `{"token": "[REDACTED]", "expires": 3600, "sc`, r.Response.Body)
+	assert.True(t, r.Response.BodyTruncated)
+	assert.NotContains(t, trace.String(), "secret")
+	assert.NotContains(t, trace.String(), "abcdef")
+}
+
+func TestTraceCaptureBodiesContentTypes(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+
+		w.Header().Set("Content-Type", req.URL.Query().Get("type"))
+		w.Write([]byte("user=bob&password=secret"))
+	})
+
+	compressor, err := compress.New(handler, compress.Encodings(compress.Gzip), compress.MinSize(1))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		desc        string
+		contentType string
+		encoding    string
+		request     string
+		response    string
+	}{
+		{
+			desc:        "form",
+			contentType: "application/x-www-form-urlencoded",
+			request:     "user=bob&password=%5BREDACTED%5D",
+			response:    "user=bob&password=%5BREDACTED%5D",
+		},
+		{
+			desc:        "text",
+			contentType: "text/plain",
+			request:     "user=bob&password=secret",
+			response:    "user=bob&password=secret",
+		},
+		{
+			desc:        "binary",
+			contentType: "application/octet-stream",
+		},
+		{
+			desc:        "compressed",
+			contentType: "text/plain",
+			encoding:    "gzip",
+			request:     "user=bob&password=secret",
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			trace := &bytes.Buffer{}
+			tr, err := New(compressor, trace, CaptureBodies(100), RedactFormFields("password"))
+			require.NoError(t, err)
+
+			srv := httptest.NewServer(tr)
+			defer srv.Close()
+
+			opts := []testutils.ReqOption{
+				testutils.Method(http.MethodPost),
+				testutils.Header("Content-Type", test.contentType),
+				testutils.Body("user=bob&password=secret"),
+			}
+			if test.encoding != "" {
+				opts = append(opts, testutils.Header("Accept-Encoding", test.encoding))
+			} else {
+				// the http client asks for gzip by default
+				opts = append(opts, testutils.Header("Accept-Encoding", "identity"))
+			}
+			re, _, err := testutils.MakeRequest(srv.URL+"?type="+url.QueryEscape(test.contentType), opts...)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, re.StatusCode)
+
+			var r *Record
+			require.NoError(t, json.Unmarshal(trace.Bytes(), &r))
+			assert.Equal(t, test.request, r.Request.Body)
+			assert.Equal(t, test.response, r.Response.Body)
+		})
+	}
+}
+
+func TestTraceRedactHeaders(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Set-Cookie", "session=abc")
+		w.Header().Set("X-Api-Key", "key")
+		w.Write([]byte("hello"))
+	})
+
+	trace := &bytes.Buffer{}
+	tr, err := New(handler, trace,
+		RequestHeaders("Authorization", "Cookie", "X-Partner"), ResponseHeaders("Set-Cookie", "X-Api-Key"),
+		RedactHeaders("x-api-key"))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(tr)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL,
+		testutils.Header("Authorization", "Bearer token"),
+		testutils.Header("Cookie", "session=abc"),
+		testutils.Header("X-Partner", "acme"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, re.StatusCode)
+
+	var r *Record
+	require.NoError(t, json.Unmarshal(trace.Bytes(), &r))
+	assert.Equal(t, http.Header{
+		"Authorization": {"[REDACTED]"},
+		"Cookie":        {"[REDACTED]"},
+		"X-Partner":     {"acme"},
+	}, r.Request.Headers)
+	assert.Equal(t, http.Header{
+		"Set-Cookie": {"[REDACTED]"},
+		"X-Api-Key":  {"[REDACTED]"},
+	}, r.Response.Headers)
+
+	_, err = New(handler, trace, CaptureBodies(0))
+	assert.Error(t, err)
+}
@@ -0,0 +1,65 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracer of the oxy middlewares
+const instrumentationName = "github.com/vulcand/oxy"
+
+type propagatorsKey struct{}
+
+func contextWithPropagators(ctx context.Context, p propagation.TextMapPropagator) context.Context {
+	return context.WithValue(ctx, propagatorsKey{}, p)
+}
+
+// StartSpan starts a child span of the span of the context with the tracer provider of that span.
+// The returned span is a no-op span when the request is not traced, the middlewares can call it unconditionally.
+func StartSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	parent := trace.SpanFromContext(ctx)
+	if !parent.SpanContext().IsValid() {
+		return ctx, parent
+	}
+	return parent.TracerProvider().Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// Inject writes the span context of the context in the header for the upstream, with the propagators
+// of the tracing middleware or else the global propagators, see otel.GetTextMapPropagator
+func Inject(ctx context.Context, h http.Header) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return
+	}
+	p, ok := ctx.Value(propagatorsKey{}).(propagation.TextMapPropagator)
+	if !ok {
+		p = otel.GetTextMapPropagator()
+	}
+	// the propagation headers of the client must not be sent next to the injected ones,
+	// the upstream would otherwise parent onto the span of the client
+	for _, f := range p.Fields() {
+		h.Del(f)
+		for _, r := range relatedFields[strings.ToLower(f)] {
+			h.Del(r)
+		}
+	}
+	p.Inject(ctx, propagation.HeaderCarrier(h))
+}
+
+// relatedFields lists the headers read by the extractors but missing from the fields of the propagators,
+// e.g. the B3 extractor prefers the single b3 header to the X-B3-* headers it injects
+var relatedFields = map[string][]string{
+	"b3":           {"x-b3-traceid", "x-b3-spanid", "x-b3-sampled", "x-b3-flags", "x-b3-parentspanid"},
+	"x-b3-traceid": {"b3", "x-b3-parentspanid"},
+}
+
+// SetError records the error on the span and sets the status of the span to error
+func SetError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,57 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+func TestStartSpanNotTraced(t *testing.T) {
+	ctx, span := StartSpan(context.Background(), "child")
+	defer span.End()
+
+	assert.False(t, span.IsRecording())
+	assert.False(t, span.SpanContext().IsValid())
+	assert.Equal(t, context.Background(), ctx)
+}
+
+func TestInject(t *testing.T) {
+	tp, exporter := newTracerProvider()
+
+	ctx, span := tp.Tracer("test").Start(context.Background(), "parent")
+	span.End()
+	require.Len(t, exporter.GetSpans(), 1)
+	traceID := span.SpanContext().TraceID().String()
+
+	// without a tracing middleware nor span nothing is injected
+	h := http.Header{}
+	Inject(context.Background(), h)
+	assert.Empty(t, h)
+
+	h = http.Header{}
+	Inject(contextWithPropagators(ctx, DefaultPropagators()), h)
+	assert.Contains(t, h.Get("Traceparent"), traceID)
+	assert.Equal(t, traceID, h.Get("X-B3-Traceid"))
+	assert.Equal(t, span.SpanContext().SpanID().String(), h.Get("X-B3-Spanid"))
+
+	// the propagation headers of the client are replaced
+	h = http.Header{
+		"B3":                {"80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1"},
+		"X-B3-Parentspanid": {"05e3ac9a4f6e3b90"},
+		"Traceparent":       {"00-80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-01"},
+	}
+	Inject(contextWithPropagators(ctx, DefaultPropagators()), h)
+	assert.Empty(t, h.Get("B3"))
+	assert.Empty(t, h.Get("X-B3-Parentspanid"))
+	assert.Contains(t, h.Get("Traceparent"), traceID)
+	assert.Equal(t, traceID, h.Get("X-B3-Traceid"))
+
+	h = http.Header{}
+	Inject(contextWithPropagators(ctx, propagation.TraceContext{}), h)
+	assert.Contains(t, h.Get("Traceparent"), traceID)
+	assert.Empty(t, h.Get("X-B3-Traceid"))
+}
@@ -0,0 +1,176 @@
+/*
+Package tracing provides http.Handler middleware starting an OpenTelemetry server span per request.
+
+The span context of the client is extracted from the W3C traceparent/tracestate and B3 headers, the oxy
+middlewares handling the request start child spans (rate limiting decision, balancer selection, retry attempts,
+upstream round trips and websocket sessions) and the forwarder propagates the span context upstream.
+The middlewares create no span when the request is not traced.
+
+Examples of a tracing middleware:
+
+	// uses the global tracer provider and the default propagators
+	tracing.New(handler)
+
+	// exports the spans with a custom provider and only propagates the W3C headers
+	tracing.New(handler,
+		tracing.TracerProvider(provider),
+		tracing.Propagators(propagation.TraceContext{}))
+*/
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/vulcand/oxy/logging"
+	"github.com/vulcand/oxy/utils"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/semconv/v1.17.0/httpconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option is a functional option setter for Tracing
+type Option func(*Tracing) error
+
+// TracerProvider sets the provider of the tracer, it defaults to the global provider, see otel.GetTracerProvider.
+func TracerProvider(tp trace.TracerProvider) Option {
+	return func(t *Tracing) error {
+		if tp == nil {
+			return fmt.Errorf("tracer provider should not be nil")
+		}
+		t.provider = tp
+		return nil
+	}
+}
+
+// Propagators sets the propagators extracting the span context of the client and injecting it upstream,
+// it defaults to DefaultPropagators().
+func Propagators(p propagation.TextMapPropagator) Option {
+	return func(t *Tracing) error {
+		if p == nil {
+			return fmt.Errorf("propagators should not be nil")
+		}
+		t.propagators = p
+		return nil
+	}
+}
+
+// SpanNameFormatter sets the function naming the server spans, it defaults to "HTTP <method>".
+func SpanNameFormatter(fn func(*http.Request) string) Option {
+	return func(t *Tracing) error {
+		t.spanName = fn
+		return nil
+	}
+}
+
+// Logger defines the logger the tracing middleware will use.
+//
+// It defaults to logging.Default().
+func Logger(l logging.Logger) Option {
+	return func(t *Tracing) error {
+		t.log = l
+		return nil
+	}
+}
+
+// DefaultPropagators returns the propagators of the W3C trace context, the W3C baggage and the B3 headers,
+// the B3 headers are extracted in the single and multiple header encodings and injected in the multiple one.
+func DefaultPropagators() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+		b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)),
+	)
+}
+
+// Tracing is a middleware starting a server span per request
+type Tracing struct {
+	next        http.Handler
+	provider    trace.TracerProvider
+	tracer      trace.Tracer
+	propagators propagation.TextMapPropagator
+	spanName    func(*http.Request) string
+
+	log logging.Logger
+}
+
+// New returns a new tracing middleware
+func New(next http.Handler, opts ...Option) (*Tracing, error) {
+	t := &Tracing{
+		next:     next,
+		spanName: defaultSpanName,
+		log:      logging.Default(),
+	}
+	for _, o := range opts {
+		if err := o(t); err != nil {
+			return nil, err
+		}
+	}
+	if t.provider == nil {
+		t.provider = otel.GetTracerProvider()
+	}
+	if t.propagators == nil {
+		t.propagators = DefaultPropagators()
+	}
+	t.tracer = t.provider.Tracer(instrumentationName)
+	return t, nil
+}
+
+// Wrap sets the next handler to be called by tracing handler.
+func (t *Tracing) Wrap(next http.Handler) {
+	t.next = next
+}
+
+func (t *Tracing) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if t.log.Enabled(logging.DebugLevel) {
+		logger := utils.RequestLogger(t.log, req).With(utils.RequestField(req))
+		logging.Debug(logger, "vulcand/oxy/tracing: begin ServeHttp on request")
+		defer logging.Debug(logger, "vulcand/oxy/tracing: completed ServeHttp on request")
+	}
+
+	ctx := t.propagators.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	ctx = contextWithPropagators(ctx, t.propagators)
+	ctx, errRecord := utils.ContextWithErrorRecord(ctx)
+	ctx, span := t.tracer.Start(ctx, t.spanName(req),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(httpconv.ServerRequest("", req)...))
+	defer span.End()
+
+	if id := utils.RequestID(req); id != "" {
+		span.SetAttributes(RequestIDKey.String(id))
+	}
+
+	pw := utils.NewProxyWriterWithLogger(w, t.log)
+	t.next.ServeHTTP(pw, req.WithContext(ctx))
+
+	code := pw.StatusCode()
+	span.SetAttributes(semconv.HTTPStatusCode(code), semconv.HTTPResponseContentLength(int(pw.GetLength())))
+	if ue := errRecord.Get(); ue != nil {
+		span.SetAttributes(ErrorKindKey.String(string(ue.Kind)))
+		span.RecordError(ue)
+		span.SetStatus(codes.Error, ue.Error())
+		return
+	}
+	span.SetStatus(httpconv.ServerStatus(code))
+}
+
+func defaultSpanName(req *http.Request) string {
+	return "HTTP " + req.Method
+}
+
+// Attributes set by the oxy middlewares on their spans
+const (
+	RequestIDKey = attribute.Key("oxy.request_id")
+	ErrorKindKey = attribute.Key("oxy.error_kind")
+	BackendKey   = attribute.Key("oxy.backend")
+	StickyKey    = attribute.Key("oxy.sticky")
+	AttemptKey   = attribute.Key("oxy.attempt")
+	RetryKey     = attribute.Key("oxy.retry")
+	SourceKey    = attribute.Key("oxy.ratelimit.source")
+	AllowedKey   = attribute.Key("oxy.ratelimit.allowed")
+)
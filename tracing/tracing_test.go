@@ -0,0 +1,161 @@
+package tracing
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vulcand/oxy/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTracerProvider() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)), exporter
+}
+
+func attributeValue(attrs []attribute.KeyValue, key attribute.Key) attribute.Value {
+	for _, kv := range attrs {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracingServerSpan(t *testing.T) {
+	tp, exporter := newTracerProvider()
+
+	var childCtx trace.SpanContext
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, span := StartSpan(req.Context(), "child")
+		childCtx = span.SpanContext()
+		span.End()
+		w.Write([]byte("hello"))
+	})
+
+	tr, err := New(handler, TracerProvider(tp))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req = req.WithContext(utils.ContextWithRequestID(req.Context(), "abc"))
+	rw := httptest.NewRecorder()
+	tr.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusOK, rw.Code)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+
+	child, server := spans[0], spans[1]
+	assert.Equal(t, "child", child.Name)
+	assert.Equal(t, server.SpanContext.SpanID(), child.Parent.SpanID())
+	assert.Equal(t, childCtx.SpanID(), child.SpanContext.SpanID())
+
+	assert.Equal(t, "HTTP GET", server.Name)
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind)
+	assert.False(t, server.Parent.IsValid())
+	assert.EqualValues(t, http.StatusOK, attributeValue(server.Attributes, "http.status_code").AsInt64())
+	assert.Equal(t, "abc", attributeValue(server.Attributes, RequestIDKey).AsString())
+	assert.Equal(t, codes.Unset, server.Status.Code)
+}
+
+func TestTracingExtractsParent(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	testCases := []struct {
+		desc    string
+		headers map[string]string
+	}{
+		{
+			desc:    "W3C trace context",
+			headers: map[string]string{"Traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		},
+		{
+			desc:    "B3 single header",
+			headers: map[string]string{"B3": "4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1"},
+		},
+		{
+			desc: "B3 multiple headers",
+			headers: map[string]string{
+				"X-B3-Traceid": "4bf92f3577b34da6a3ce929d0e0e4736",
+				"X-B3-Spanid":  "00f067aa0ba902b7",
+				"X-B3-Sampled": "1",
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			tp, exporter := newTracerProvider()
+			tr, err := New(handler, TracerProvider(tp))
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range test.headers {
+				req.Header.Set(k, v)
+			}
+			tr.ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := exporter.GetSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext.TraceID().String())
+			assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent.SpanID().String())
+			assert.True(t, spans[0].Parent.IsRemote())
+		})
+	}
+}
+
+func TestTracingErrors(t *testing.T) {
+	tp, exporter := newTracerProvider()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/refused" {
+			err := errors.New("connection refused")
+			utils.RecordError(req, &utils.UpstreamError{Kind: utils.ErrorKindConnectionRefused, Err: err})
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	tr, err := New(handler, TracerProvider(tp), SpanNameFormatter(func(req *http.Request) string {
+		return req.Method + " " + req.URL.Path
+	}))
+	require.NoError(t, err)
+
+	tr.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/refused", nil))
+	tr.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/unavailable", nil))
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+
+	assert.Equal(t, "GET /refused", spans[0].Name)
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Equal(t, "connection_refused", attributeValue(spans[0].Attributes, ErrorKindKey).AsString())
+	require.Len(t, spans[0].Events, 1)
+	assert.Equal(t, "exception", spans[0].Events[0].Name)
+
+	assert.Equal(t, "GET /unavailable", spans[1].Name)
+	assert.Equal(t, codes.Error, spans[1].Status.Code)
+	assert.EqualValues(t, http.StatusServiceUnavailable, attributeValue(spans[1].Attributes, "http.status_code").AsInt64())
+}
+
+func TestTracingOptions(t *testing.T) {
+	_, err := New(nil, TracerProvider(nil))
+	assert.Error(t, err)
+
+	_, err = New(nil, Propagators(nil))
+	assert.Error(t, err)
+}
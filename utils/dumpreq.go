@@ -7,6 +7,8 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/url"
+
+	"github.com/vulcand/oxy/logging"
 )
 
 // SerializableHttpRequest serializable HTTP request
@@ -62,3 +64,10 @@ func (s *SerializableHttpRequest) ToJson() string {
 func DumpHttpRequest(req *http.Request) string {
 	return Clone(req).ToJson()
 }
+
+// RequestField returns the log field "request" holding the JSON dump of the request, computed only when written
+func RequestField(req *http.Request) logging.Field {
+	return logging.Lazy("request", func() interface{} {
+		return DumpHttpRequest(req)
+	})
+}
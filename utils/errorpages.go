@@ -0,0 +1,218 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/vulcand/oxy/logging"
+)
+
+// PageFormat is the format of an error page
+type PageFormat string
+
+// Page formats
+const (
+	PageFormatText PageFormat = "text"
+	PageFormatHTML PageFormat = "html"
+	PageFormatJSON PageFormat = "json"
+)
+
+func (f PageFormat) contentType() string {
+	switch f {
+	case PageFormatHTML:
+		return "text/html; charset=utf-8"
+	case PageFormatJSON:
+		return "application/json"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// ErrorPage is a custom error page rendered by ErrorPages
+type ErrorPage struct {
+	// StatusCode is the status code the page is used for, 0 means any status code
+	StatusCode int
+	// Format is the format of the page, the page is selected based on the Accept request header
+	Format PageFormat
+	// Template is a Go template executed with ErrorPageData. HTML pages use html/template
+	// and have their data escaped, other formats use text/template.
+	Template string
+}
+
+// ErrorPageData is the data passed to the error page templates
+type ErrorPageData struct {
+	StatusCode int
+	StatusText string
+	Kind       ErrorKind
+	Error      string
+}
+
+// ErrorPagesOption is a functional option setter for ErrorPages
+type ErrorPagesOption func(*ErrorPages) error
+
+// Pages adds custom error pages
+func Pages(pages ...ErrorPage) ErrorPagesOption {
+	return func(e *ErrorPages) error {
+		for _, p := range pages {
+			if err := e.addPage(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// ErrorPagesLogger defines the logger the error pages handler will use.
+//
+// It defaults to logging.Default().
+func ErrorPagesLogger(l logging.Logger) ErrorPagesOption {
+	return func(e *ErrorPages) error {
+		e.log = l
+		return nil
+	}
+}
+
+// ErrorPages is an ErrorHandler that classifies errors into ErrorKind, records the classification
+// in the request context (see ContextWithErrorRecord) and renders an error page in the format requested
+// by the client: JSON, HTML or plain text.
+type ErrorPages struct {
+	pages map[pageKey]executor
+
+	log logging.Logger
+}
+
+type pageKey struct {
+	code   int
+	format PageFormat
+}
+
+type executor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// NewErrorPages creates a new ErrorPages handler
+func NewErrorPages(opts ...ErrorPagesOption) (*ErrorPages, error) {
+	e := &ErrorPages{
+		pages: make(map[pageKey]executor),
+		log:   logging.Default(),
+	}
+	for _, o := range opts {
+		if err := o(e); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func (e *ErrorPages) addPage(p ErrorPage) error {
+	var t executor
+	var err error
+	switch p.Format {
+	case PageFormatHTML:
+		t, err = htmltemplate.New(strconv.Itoa(p.StatusCode)).Parse(p.Template)
+	case PageFormatJSON, PageFormatText:
+		t, err = texttemplate.New(strconv.Itoa(p.StatusCode)).Parse(p.Template)
+	default:
+		return fmt.Errorf("unsupported page format: %q", p.Format)
+	}
+	if err != nil {
+		return err
+	}
+	e.pages[pageKey{code: p.StatusCode, format: p.Format}] = t
+	return nil
+}
+
+func (e *ErrorPages) ServeHTTP(w http.ResponseWriter, req *http.Request, err error) {
+	ue := ClassifyError(err)
+	RecordError(req, ue)
+
+	data := ErrorPageData{
+		StatusCode: ue.StatusCode(),
+		StatusText: statusText(ue.StatusCode()),
+		Kind:       ue.Kind,
+		Error:      ue.Error(),
+	}
+	if e.log.Enabled(logging.DebugLevel) {
+		logging.Debug(RequestLogger(e.log, req), "vulcand/oxy/utils: error page served",
+			logging.Int("code", data.StatusCode), logging.String("kind", string(data.Kind)), logging.Err(err))
+	}
+
+	format := negotiateFormat(req)
+	body, errRender := e.render(format, data)
+	if errRender != nil {
+		logging.Error(RequestLogger(e.log, req), "vulcand/oxy/utils: failed to render error page", logging.Err(errRender))
+		format = PageFormatText
+		body = []byte(data.StatusText)
+	}
+
+	w.Header().Set("Content-Type", format.contentType())
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(data.StatusCode)
+	w.Write(body)
+}
+
+func (e *ErrorPages) render(format PageFormat, data ErrorPageData) ([]byte, error) {
+	t, ok := e.pages[pageKey{code: data.StatusCode, format: format}]
+	if !ok {
+		t, ok = e.pages[pageKey{format: format}]
+	}
+	if !ok {
+		return defaultPage(format, data)
+	}
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var defaultHTMLPage = htmltemplate.Must(htmltemplate.New("default").Parse(
+	`<html><head><title>{{.StatusCode}} {{.StatusText}}</title></head><body><h1>{{.StatusCode}} {{.StatusText}}</h1></body></html>`))
+
+func defaultPage(format PageFormat, data ErrorPageData) ([]byte, error) {
+	switch format {
+	case PageFormatJSON:
+		return json.Marshal(struct {
+			Status  int       `json:"status"`
+			Message string    `json:"message"`
+			Kind    ErrorKind `json:"kind"`
+		}{Status: data.StatusCode, Message: data.StatusText, Kind: data.Kind})
+	case PageFormatHTML:
+		buf := &bytes.Buffer{}
+		if err := defaultHTMLPage.Execute(buf, data); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return []byte(data.StatusText), nil
+}
+
+// negotiateFormat picks the page format from the Accept request header, it does not
+// weight the media ranges and picks the first supported one.
+func negotiateFormat(req *http.Request) PageFormat {
+	if req == nil {
+		return PageFormatText
+	}
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		switch {
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			return PageFormatJSON
+		case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+			return PageFormatHTML
+		case mediaType == "text/plain":
+			return PageFormatText
+		}
+	}
+	return PageFormatText
+}
@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorPagesDefault(t *testing.T) {
+	e, err := NewErrorPages()
+	require.NoError(t, err)
+
+	testCases := []struct {
+		desc        string
+		accept      string
+		contentType string
+		body        string
+	}{
+		{
+			desc:        "text",
+			contentType: "text/plain; charset=utf-8",
+			body:        "Bad Gateway",
+		},
+		{
+			desc:        "json",
+			accept:      "application/json",
+			contentType: "application/json",
+			body:        `{"status":502,"message":"Bad Gateway","kind":"backend_reset"}`,
+		},
+		{
+			desc:        "html",
+			accept:      "text/html,application/xhtml+xml;q=0.9",
+			contentType: "text/html; charset=utf-8",
+			body:        "<html><head><title>502 Bad Gateway</title></head><body><h1>502 Bad Gateway</h1></body></html>",
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept", test.accept)
+			w := httptest.NewRecorder()
+
+			e.ServeHTTP(w, req, io.EOF)
+
+			assert.Equal(t, http.StatusBadGateway, w.Code)
+			assert.Equal(t, test.contentType, w.Header().Get("Content-Type"))
+			assert.Equal(t, test.body, w.Body.String())
+		})
+	}
+}
+
+func TestErrorPagesCustom(t *testing.T) {
+	e, err := NewErrorPages(Pages(
+		ErrorPage{Format: PageFormatHTML, Template: `<p>{{.StatusCode}}: {{.Error}}</p>`},
+		ErrorPage{StatusCode: http.StatusTooManyRequests, Format: PageFormatHTML, Template: `<p>slow down</p>`},
+		ErrorPage{Format: PageFormatJSON, Template: `{"code":{{.StatusCode}},"kind":"{{.Kind}}"}`},
+	))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html")
+
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req, &UpstreamError{Kind: ErrorKindDNS, Err: &testError{msg: "<no such host>"}})
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, "<p>502: &lt;no such host&gt;</p>", w.Body.String())
+
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, req, &UpstreamError{Kind: ErrorKindRateLimited})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "<p>slow down</p>", w.Body.String())
+
+	req.Header.Set("Accept", "application/problem+json")
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, req, &UpstreamError{Kind: ErrorKindTimeout})
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Equal(t, `{"code":504,"kind":"timeout"}`, w.Body.String())
+}
+
+func TestErrorPagesRecordsError(t *testing.T) {
+	e, err := NewErrorPages()
+	require.NoError(t, err)
+
+	ctx, record := ContextWithErrorRecord(httptest.NewRequest(http.MethodGet, "/", nil).Context())
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+
+	e.ServeHTTP(httptest.NewRecorder(), req, io.ErrUnexpectedEOF)
+	require.NotNil(t, record.Get())
+	assert.Equal(t, ErrorKindBackendReset, record.Get().Kind)
+}
+
+func TestErrorPagesBadTemplate(t *testing.T) {
+	_, err := NewErrorPages(Pages(ErrorPage{Format: PageFormatHTML, Template: `{{.StatusCode`}))
+	assert.Error(t, err)
+
+	_, err = NewErrorPages(Pages(ErrorPage{Format: "xml", Template: `<error/>`}))
+	assert.Error(t, err)
+}
+
+type testError struct {
+	msg string
+}
+
+func (e *testError) Error() string {
+	return e.msg
+}
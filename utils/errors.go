@@ -0,0 +1,229 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/mailgun/multibuf"
+)
+
+// ErrorKind classifies the failure of a proxied request
+type ErrorKind string
+
+// Error kinds
+const (
+	ErrorKindUnknown               ErrorKind = "unknown"
+	ErrorKindNetwork               ErrorKind = "network"
+	ErrorKindDNS                   ErrorKind = "dns_failure"
+	ErrorKindConnectionRefused     ErrorKind = "connection_refused"
+	ErrorKindTLSHandshake          ErrorKind = "tls_handshake"
+	ErrorKindTimeout               ErrorKind = "timeout"
+	ErrorKindResponseHeaderTimeout ErrorKind = "response_header_timeout"
+	ErrorKindDialTimeout           ErrorKind = "dial_timeout"
+	ErrorKindTLSHandshakeTimeout   ErrorKind = "tls_handshake_timeout"
+	ErrorKindIdleTimeout           ErrorKind = "idle_timeout"
+	ErrorKindRequestTimeout        ErrorKind = "request_timeout"
+	ErrorKindBackendReset          ErrorKind = "backend_reset"
+	ErrorKindBodyTooLarge          ErrorKind = "body_too_large"
+	ErrorKindResponseTooLarge      ErrorKind = "response_too_large"
+	ErrorKindBadRequest            ErrorKind = "bad_request"
+	ErrorKindRateLimited           ErrorKind = "rate_limited"
+	ErrorKindClientClosed          ErrorKind = "client_closed"
+	ErrorKindDiskQuotaExceeded     ErrorKind = "disk_quota_exceeded"
+	ErrorKindTooManyWaiters        ErrorKind = "too_many_waiters"
+)
+
+// StatusCode returns the HTTP status code sent to the client for this kind of error
+func (k ErrorKind) StatusCode() int {
+	switch k {
+	case ErrorKindNetwork, ErrorKindDNS, ErrorKindConnectionRefused, ErrorKindTLSHandshake, ErrorKindBackendReset,
+		ErrorKindResponseTooLarge:
+		return http.StatusBadGateway
+	case ErrorKindTimeout, ErrorKindResponseHeaderTimeout, ErrorKindDialTimeout,
+		ErrorKindTLSHandshakeTimeout, ErrorKindIdleTimeout, ErrorKindRequestTimeout:
+		return http.StatusGatewayTimeout
+	case ErrorKindBodyTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case ErrorKindBadRequest:
+		return http.StatusBadRequest
+	case ErrorKindRateLimited:
+		return http.StatusTooManyRequests
+	case ErrorKindClientClosed:
+		return StatusClientClosedRequest
+	case ErrorKindDiskQuotaExceeded, ErrorKindTooManyWaiters:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// ClassifiedError is implemented by errors that know their own kind, e.g. the rate limiter errors
+type ClassifiedError interface {
+	error
+	ErrorKind() ErrorKind
+}
+
+// UpstreamError is an error that occurred while proxying a request along with its classification
+type UpstreamError struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *UpstreamError) Error() string {
+	if e.Err == nil {
+		return string(e.Kind)
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+// StatusCode returns the HTTP status code sent to the client for this error
+func (e *UpstreamError) StatusCode() int {
+	return e.Kind.StatusCode()
+}
+
+// ClassifyError wraps the error into an UpstreamError classifying the cause of the failure.
+// It returns nil if err is nil.
+func ClassifyError(err error) *UpstreamError {
+	if err == nil {
+		return nil
+	}
+	var ue *UpstreamError
+	if errors.As(err, &ue) {
+		return ue
+	}
+	return &UpstreamError{Kind: classify(err), Err: err}
+}
+
+func classify(err error) ErrorKind {
+	var ce ClassifiedError
+	if errors.As(err, &ce) {
+		return ce.ErrorKind()
+	}
+
+	var maxSizeErr *multibuf.MaxSizeReachedError
+	if errors.As(err, &maxSizeErr) {
+		return ErrorKindBodyTooLarge
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return ErrorKindClientClosed
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		if isResponseHeaderTimeout(err) {
+			return ErrorKindResponseHeaderTimeout
+		}
+		return ErrorKindTimeout
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ErrorKindDNS
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return ErrorKindConnectionRefused
+	}
+
+	if isTLSError(err) {
+		return ErrorKindTLSHandshake
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrorKindBackendReset
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			if isResponseHeaderTimeout(err) {
+				return ErrorKindResponseHeaderTimeout
+			}
+			return ErrorKindTimeout
+		}
+		return ErrorKindNetwork
+	}
+
+	return ErrorKindUnknown
+}
+
+// isResponseHeaderTimeout detects the http.Transport ResponseHeaderTimeout error.
+// net/http returns it as an unexported type with no sentinel to match, its message is the only way to tell it
+// from the other timeouts.
+func isResponseHeaderTimeout(err error) bool {
+	return strings.Contains(err.Error(), "awaiting response headers")
+}
+
+// isTLSError detects the failures of the TLS handshake with the backend: a backend not speaking TLS,
+// a certificate failing the verification, or a TLS alert sent or received, which crypto/tls wraps
+// in a *net.OpError whose operation is "remote error" or "local error".
+func isTLSError(err error) bool {
+	var recordErr tls.RecordHeaderError
+	var verificationErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var rootsErr x509.SystemRootsError
+	if errors.As(err, &recordErr) || errors.As(err, &verificationErr) || errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) || errors.As(err, &rootsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && (opErr.Op == "remote error" || opErr.Op == "local error")
+}
+
+type errorRecordKey struct{}
+
+// ErrorRecord holds the classified error of a proxied request, it is shared through the
+// request context so that the middlewares wrapping the error handler (e.g. trace or metrics)
+// can learn why a request failed.
+type ErrorRecord struct {
+	mu  sync.Mutex
+	err *UpstreamError
+}
+
+// Set records the error
+func (r *ErrorRecord) Set(err *UpstreamError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+}
+
+// Get returns the recorded error, or nil if no error was recorded
+func (r *ErrorRecord) Get() *UpstreamError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// ContextWithErrorRecord returns a copy of the context holding a new ErrorRecord,
+// an existing record is reused so the outermost middleware sees the errors of all the inner ones.
+func ContextWithErrorRecord(ctx context.Context) (context.Context, *ErrorRecord) {
+	if r, ok := ctx.Value(errorRecordKey{}).(*ErrorRecord); ok {
+		return ctx, r
+	}
+	r := &ErrorRecord{}
+	return context.WithValue(ctx, errorRecordKey{}, r), r
+}
+
+// RecordError stores the classified error in the ErrorRecord of the request context, if any
+func RecordError(req *http.Request, err *UpstreamError) {
+	if req == nil || err == nil {
+		return
+	}
+	if r, ok := req.Context().Value(errorRecordKey{}).(*ErrorRecord); ok {
+		r.Set(err)
+	}
+}
@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mailgun/multibuf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type kindError struct{}
+
+func (kindError) Error() string        { return "slow down" }
+func (kindError) ErrorKind() ErrorKind { return ErrorKindRateLimited }
+
+type timeoutError struct{ msg string }
+
+func (e timeoutError) Error() string   { return e.msg }
+func (e timeoutError) Timeout() bool   { return true }
+func (e timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		err      error
+		expected ErrorKind
+		code     int
+	}{
+		{
+			desc:     "unknown",
+			err:      errors.New("boom"),
+			expected: ErrorKindUnknown,
+			code:     http.StatusInternalServerError,
+		},
+		{
+			desc:     "dns",
+			err:      &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "backend"}},
+			expected: ErrorKindDNS,
+			code:     http.StatusBadGateway,
+		},
+		{
+			desc:     "response header timeout",
+			err:      timeoutError{msg: "net/http: timeout awaiting response headers"},
+			expected: ErrorKindResponseHeaderTimeout,
+			code:     http.StatusGatewayTimeout,
+		},
+		{
+			desc:     "timeout",
+			err:      &net.OpError{Op: "dial", Err: timeoutError{msg: "i/o timeout"}},
+			expected: ErrorKindTimeout,
+			code:     http.StatusGatewayTimeout,
+		},
+		{
+			desc:     "deadline",
+			err:      fmt.Errorf("wrapped: %w", context.DeadlineExceeded),
+			expected: ErrorKindTimeout,
+			code:     http.StatusGatewayTimeout,
+		},
+		{
+			desc:     "backend reset",
+			err:      io.EOF,
+			expected: ErrorKindBackendReset,
+			code:     http.StatusBadGateway,
+		},
+		{
+			desc:     "client closed",
+			err:      context.Canceled,
+			expected: ErrorKindClientClosed,
+			code:     StatusClientClosedRequest,
+		},
+		{
+			desc:     "body too large",
+			err:      &multibuf.MaxSizeReachedError{MaxSize: 10},
+			expected: ErrorKindBodyTooLarge,
+			code:     http.StatusRequestEntityTooLarge,
+		},
+		{
+			desc:     "classified error",
+			err:      fmt.Errorf("wrapped: %w", kindError{}),
+			expected: ErrorKindRateLimited,
+			code:     http.StatusTooManyRequests,
+		},
+		{
+			desc:     "tls",
+			err:      &net.OpError{Op: "remote error", Err: errors.New("tls: bad certificate")},
+			expected: ErrorKindTLSHandshake,
+			code:     http.StatusBadGateway,
+		},
+		{
+			desc:     "tls local alert",
+			err:      fmt.Errorf("wrapped: %w", &net.OpError{Op: "local error", Err: errors.New("tls: unexpected message")}),
+			expected: ErrorKindTLSHandshake,
+			code:     http.StatusBadGateway,
+		},
+		{
+			desc:     "tls record header",
+			err:      tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"},
+			expected: ErrorKindTLSHandshake,
+			code:     http.StatusBadGateway,
+		},
+		{
+			desc:     "tls certificate verification",
+			err:      &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}},
+			expected: ErrorKindTLSHandshake,
+			code:     http.StatusBadGateway,
+		},
+		{
+			desc:     "x509 hostname",
+			err:      fmt.Errorf("wrapped: %w", x509.HostnameError{Host: "backend"}),
+			expected: ErrorKindTLSHandshake,
+			code:     http.StatusBadGateway,
+		},
+		{
+			desc:     "tls in the message only",
+			err:      errors.New("tls: the backend said hello"),
+			expected: ErrorKindUnknown,
+			code:     http.StatusInternalServerError,
+		},
+		{
+			desc:     "already classified",
+			err:      fmt.Errorf("wrapped: %w", &UpstreamError{Kind: ErrorKindDNS}),
+			expected: ErrorKindDNS,
+			code:     http.StatusBadGateway,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			ue := ClassifyError(test.err)
+			require.NotNil(t, ue)
+			assert.Equal(t, test.expected, ue.Kind)
+			assert.Equal(t, test.code, ue.StatusCode())
+		})
+	}
+
+	assert.Nil(t, ClassifyError(nil))
+}
+
+func TestClassifyConnectionRefused(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Close()
+
+	request, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	_, err = http.DefaultTransport.RoundTrip(request)
+	require.Error(t, err)
+
+	assert.Equal(t, ErrorKindConnectionRefused, ClassifyError(err).Kind)
+}
+
+func TestClassifyResponseHeaderTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	request, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	_, err = (&http.Transport{ResponseHeaderTimeout: 10 * time.Millisecond}).RoundTrip(request)
+	require.Error(t, err)
+
+	assert.Equal(t, ErrorKindResponseHeaderTimeout, ClassifyError(err).Kind)
+}
+
+func TestErrorRecord(t *testing.T) {
+	ctx, record := ContextWithErrorRecord(context.Background())
+	assert.Nil(t, record.Get())
+
+	// nested middlewares share the same record
+	ctx2, record2 := ContextWithErrorRecord(ctx)
+	assert.Equal(t, record, record2)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx2)
+	RecordError(req, ClassifyError(io.EOF))
+	require.NotNil(t, record.Get())
+	assert.Equal(t, ErrorKindBackendReset, record.Get().Kind)
+
+	// no record in the context
+	RecordError(httptest.NewRequest(http.MethodGet, "/", nil), ClassifyError(io.EOF))
+	RecordError(nil, ClassifyError(io.EOF))
+}
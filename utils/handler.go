@@ -6,7 +6,7 @@ import (
 	"net"
 	"net/http"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/vulcand/oxy/logging"
 )
 
 // StatusClientClosedRequest non-standard HTTP status code for client disconnection
@@ -24,7 +24,10 @@ type ErrorHandler interface {
 var DefaultHandler ErrorHandler = &StdHandler{}
 
 // StdHandler Standard error handler
-type StdHandler struct{}
+type StdHandler struct {
+	// Log is the logger of the handler, it defaults to logging.Default()
+	Log logging.Logger
+}
 
 func (e *StdHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, err error) {
 	statusCode := http.StatusInternalServerError
@@ -41,9 +44,18 @@ func (e *StdHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, err err
 		statusCode = StatusClientClosedRequest
 	}
 
+	RecordError(req, ClassifyError(err))
+
 	w.WriteHeader(statusCode)
 	w.Write([]byte(statusText(statusCode)))
-	log.Debugf("'%d %s' caused by: %v", statusCode, statusText(statusCode), err)
+	l := e.Log
+	if l == nil {
+		l = logging.Default()
+	}
+	if l.Enabled(logging.DebugLevel) {
+		logging.Debug(RequestLogger(l, req), "vulcand/oxy/utils: error served",
+			logging.Int("code", statusCode), logging.Err(err))
+	}
 }
 
 func statusText(statusCode int) string {
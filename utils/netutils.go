@@ -9,7 +9,7 @@ import (
 	"net/url"
 	"reflect"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/vulcand/oxy/logging"
 )
 
 // ProxyWriter calls recorder, used to debug logs
@@ -18,16 +18,16 @@ type ProxyWriter struct {
 	code   int
 	length int64
 
-	log *log.Logger
+	log logging.Logger
 }
 
 // NewProxyWriter creates a new ProxyWriter
 func NewProxyWriter(w http.ResponseWriter) *ProxyWriter {
-	return NewProxyWriterWithLogger(w, log.StandardLogger())
+	return NewProxyWriterWithLogger(w, logging.Default())
 }
 
 // NewProxyWriterWithLogger creates a new ProxyWriter
-func NewProxyWriterWithLogger(w http.ResponseWriter, l *log.Logger) *ProxyWriter {
+func NewProxyWriterWithLogger(w http.ResponseWriter, l logging.Logger) *ProxyWriter {
 	return &ProxyWriter{
 		w:   w,
 		log: l,
@@ -78,7 +78,8 @@ func (p *ProxyWriter) CloseNotify() <-chan bool {
 	if cn, ok := p.w.(http.CloseNotifier); ok {
 		return cn.CloseNotify()
 	}
-	p.log.Debugf("Upstream ResponseWriter of type %v does not implement http.CloseNotifier. Returning dummy channel.", reflect.TypeOf(p.w))
+	logging.Debug(p.log, "vulcand/oxy/utils: upstream ResponseWriter does not implement http.CloseNotifier, returning dummy channel",
+		logging.Any("type", reflect.TypeOf(p.w)))
 	return make(<-chan bool)
 }
 
@@ -87,7 +88,8 @@ func (p *ProxyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if hi, ok := p.w.(http.Hijacker); ok {
 		return hi.Hijack()
 	}
-	p.log.Debugf("Upstream ResponseWriter of type %v does not implement http.Hijacker. Returning dummy channel.", reflect.TypeOf(p.w))
+	logging.Debug(p.log, "vulcand/oxy/utils: upstream ResponseWriter does not implement http.Hijacker",
+		logging.Any("type", reflect.TypeOf(p.w)))
 	return nil, nil, fmt.Errorf("the response writer that was wrapped in this proxy, does not implement http.Hijacker. It is of type: %v", reflect.TypeOf(p.w))
 }
 
@@ -131,7 +133,8 @@ func (b *BufferWriter) CloseNotify() <-chan bool {
 	if cn, ok := b.W.(http.CloseNotifier); ok {
 		return cn.CloseNotify()
 	}
-	log.Warningf("Upstream ResponseWriter of type %v does not implement http.CloseNotifier. Returning dummy channel.", reflect.TypeOf(b.W))
+	logging.Warn(logging.Default(), "vulcand/oxy/utils: upstream ResponseWriter does not implement http.CloseNotifier, returning dummy channel",
+		logging.Any("type", reflect.TypeOf(b.W)))
 	return make(<-chan bool)
 }
 
@@ -140,7 +143,8 @@ func (b *BufferWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if hi, ok := b.W.(http.Hijacker); ok {
 		return hi.Hijack()
 	}
-	log.Debugf("Upstream ResponseWriter of type %v does not implement http.Hijacker. Returning dummy channel.", reflect.TypeOf(b.W))
+	logging.Debug(logging.Default(), "vulcand/oxy/utils: upstream ResponseWriter does not implement http.Hijacker",
+		logging.Any("type", reflect.TypeOf(b.W)))
 	return nil, nil, fmt.Errorf("the response writer that was wrapped in this proxy, does not implement http.Hijacker. It is of type: %v", reflect.TypeOf(b.W))
 }
 
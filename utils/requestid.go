@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/vulcand/oxy/logging"
+)
+
+// RequestIDHeader is the default header carrying the request ID
+const RequestIDHeader = "X-Request-Id"
+
+// RequestIDField is the name of the log field holding the request ID
+const RequestIDField = "request_id"
+
+type requestIDKey struct{}
+
+// requestID is the ID of the request and the header carrying it
+type requestID struct {
+	header string
+	id     string
+}
+
+// ContextWithRequestID returns a copy of the context holding the request ID carried by the X-Request-Id header
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return ContextWithRequestIDHeader(ctx, RequestIDHeader, id)
+}
+
+// ContextWithRequestIDHeader returns a copy of the context holding the request ID and the header carrying it
+func ContextWithRequestIDHeader(ctx context.Context, header, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID{header: http.CanonicalHeaderKey(header), id: id})
+}
+
+// RequestIDFromContext returns the request ID stored in the context, or an empty string
+func RequestIDFromContext(ctx context.Context) string {
+	r, _ := ctx.Value(requestIDKey{}).(requestID)
+	return r.id
+}
+
+// RequestIDHeaderFromContext returns the header carrying the request ID stored in the context, or an empty string
+func RequestIDHeaderFromContext(ctx context.Context) string {
+	r, _ := ctx.Value(requestIDKey{}).(requestID)
+	return r.header
+}
+
+// RequestID returns the ID of the request, from its context or else from its X-Request-Id header
+func RequestID(req *http.Request) string {
+	if req == nil {
+		return ""
+	}
+	if id := RequestIDFromContext(req.Context()); id != "" {
+		return id
+	}
+	return req.Header.Get(RequestIDHeader)
+}
+
+// RequestLogger returns the logger adding the ID of the request, if any, and the fields stored in its context
+// to its lines, so that the log lines of all the middlewares handling a request can be correlated
+func RequestLogger(l logging.Logger, req *http.Request) logging.Logger {
+	if req == nil {
+		return l
+	}
+	l = logging.FromContext(req.Context(), l)
+	if id := RequestID(req); id != "" {
+		return l.With(logging.String(RequestIDField, id))
+	}
+	return l
+}
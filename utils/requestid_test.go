@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vulcand/oxy/logging"
+)
+
+func TestRequestID(t *testing.T) {
+	assert.Equal(t, "", RequestIDFromContext(context.Background()))
+	assert.Equal(t, "abc", RequestIDFromContext(ContextWithRequestID(context.Background(), "abc")))
+	assert.Equal(t, RequestIDHeader, RequestIDHeaderFromContext(ContextWithRequestID(context.Background(), "abc")))
+
+	ctx := ContextWithRequestIDHeader(context.Background(), "x-correlation-id", "abc")
+	assert.Equal(t, "abc", RequestIDFromContext(ctx))
+	assert.Equal(t, "X-Correlation-Id", RequestIDHeaderFromContext(ctx))
+	assert.Equal(t, "", RequestIDHeaderFromContext(context.Background()))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.Equal(t, "", RequestID(req))
+
+	req.Header.Set(RequestIDHeader, "from-header")
+	assert.Equal(t, "from-header", RequestID(req))
+
+	req = req.WithContext(ContextWithRequestID(req.Context(), "from-context"))
+	assert.Equal(t, "from-context", RequestID(req))
+	assert.Equal(t, "", RequestID(nil))
+}
+
+func TestRequestLogger(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := logging.Std(log.New(out, "", 0), logging.InfoLevel)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	logging.Info(RequestLogger(l, req), "without ID")
+	assert.NotContains(t, out.String(), RequestIDField)
+
+	out.Reset()
+	req = req.WithContext(ContextWithRequestID(req.Context(), "abc"))
+	req = req.WithContext(logging.ContextWithFields(req.Context(), logging.String("tenant", "acme")))
+	logging.Info(RequestLogger(l, req), "with ID")
+	assert.Contains(t, out.String(), `request_id=abc`)
+	assert.Contains(t, out.String(), `tenant=acme`)
+}
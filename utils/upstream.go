@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type upstreamRecordKey struct{}
+
+// UpstreamRecord holds the upstream round trips of a proxied request, it is shared through the
+// request context so that the middlewares wrapping the forwarder (e.g. trace) can learn which backend
+// served the request, how many times it was retried and how long was spent upstream.
+type UpstreamRecord struct {
+	mu       sync.Mutex
+	backend  string
+	duration time.Duration
+	retries  int
+}
+
+// AddRoundTrip records a round trip to the backend, the durations of the retried round trips add up
+func (r *UpstreamRecord) AddRoundTrip(backend string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.backend = backend
+	r.duration += d
+}
+
+// AddRetry records a retry of the request
+func (r *UpstreamRecord) AddRetry() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retries++
+}
+
+// Backend returns the backend of the last round trip, or an empty string if the request was not forwarded
+func (r *UpstreamRecord) Backend() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.backend
+}
+
+// Duration returns the time spent in the round trips
+func (r *UpstreamRecord) Duration() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.duration
+}
+
+// Retries returns the number of retries
+func (r *UpstreamRecord) Retries() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.retries
+}
+
+// ContextWithUpstreamRecord returns a copy of the context holding a new UpstreamRecord,
+// an existing record is reused so the outermost middleware sees the round trips of all the inner ones.
+func ContextWithUpstreamRecord(ctx context.Context) (context.Context, *UpstreamRecord) {
+	if r, ok := ctx.Value(upstreamRecordKey{}).(*UpstreamRecord); ok {
+		return ctx, r
+	}
+	r := &UpstreamRecord{}
+	return context.WithValue(ctx, upstreamRecordKey{}, r), r
+}
+
+// RecordRoundTrip stores the round trip in the UpstreamRecord of the request context, if any
+func RecordRoundTrip(req *http.Request, backend string, d time.Duration) {
+	if req == nil {
+		return
+	}
+	if r, ok := req.Context().Value(upstreamRecordKey{}).(*UpstreamRecord); ok {
+		r.AddRoundTrip(backend, d)
+	}
+}
+
+// RecordRetry counts a retry in the UpstreamRecord of the request context, if any
+func RecordRetry(req *http.Request) {
+	if req == nil {
+		return
+	}
+	if r, ok := req.Context().Value(upstreamRecordKey{}).(*UpstreamRecord); ok {
+		r.AddRetry()
+	}
+}
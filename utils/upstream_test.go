@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpstreamRecord(t *testing.T) {
+	ctx, record := ContextWithUpstreamRecord(context.Background())
+	assert.Equal(t, "", record.Backend())
+	assert.Equal(t, time.Duration(0), record.Duration())
+	assert.Equal(t, 0, record.Retries())
+
+	// nested middlewares share the same record
+	ctx2, record2 := ContextWithUpstreamRecord(ctx)
+	assert.Equal(t, record, record2)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx2)
+	RecordRoundTrip(req, "http://a", time.Second)
+	RecordRetry(req)
+	RecordRoundTrip(req, "http://b", 2*time.Second)
+
+	assert.Equal(t, "http://b", record.Backend())
+	assert.Equal(t, 3*time.Second, record.Duration())
+	assert.Equal(t, 1, record.Retries())
+
+	// no record in the context
+	RecordRoundTrip(httptest.NewRequest(http.MethodGet, "/", nil), "http://a", time.Second)
+	RecordRetry(httptest.NewRequest(http.MethodGet, "/", nil))
+	RecordRoundTrip(nil, "http://a", time.Second)
+	RecordRetry(nil)
+}